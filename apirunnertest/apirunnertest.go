@@ -0,0 +1,186 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apirunnertest provides a scriptable http client for unit testing apirunner suites and
+// extensions without a server. Set it as RunConfig.HttpClient.
+package apirunnertest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is a network error that reports itself as a timeout, like that of a client whose timeout expired
+var ErrTimeout error = timeoutError{}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "mock request timed out" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// A scripted response. The response (or Err, if set) is returned after Delay, unless the request's context
+// is done first, in which case the context's error is returned.
+type Response struct {
+	StatusCode int
+	Body       string
+	Header     http.Header
+	Delay      time.Duration
+	Err        error
+}
+
+// JSON returns a response with 'body' as its JSON payload
+func JSON(statusCode int, body string) Response {
+	return Response{
+		StatusCode: statusCode,
+		Body:       body,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+	}
+}
+
+// Error returns a response that fails with 'err' (e.g. ErrTimeout) instead of returning a response
+func Error(err error) Response {
+	return Response{Err: err}
+}
+
+// Repeat returns 'n' copies of 'response', e.g. to script a number of failures before a success
+func Repeat(response Response, n int) []Response {
+	responses := make([]Response, n)
+	for i := range responses {
+		responses[i] = response
+	}
+	return responses
+}
+
+// Client is an http client returning a scripted sequence of responses, one per request, and recording the
+// requests it receives. Once the sequence is exhausted, it returns the default response if one is set and
+// fails the request otherwise. It's safe for concurrent use.
+type Client struct {
+	mu         sync.Mutex
+	script     []Response
+	next       int
+	defaultSet bool
+	defaultRes Response
+	requests   []*http.Request
+	bodies     []string
+}
+
+// NewClient returns a client returning 'responses' in order
+func NewClient(responses ...Response) *Client {
+	return &Client{script: responses}
+}
+
+// Static returns a client returning 'response' for every request
+func Static(response Response) *Client {
+	return NewClient().WithDefault(response)
+}
+
+// Then appends 'responses' to the client's sequence
+func (c *Client) Then(responses ...Response) *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.script = append(c.script, responses...)
+	return c
+}
+
+// WithDefault sets the response returned once the sequence is exhausted
+func (c *Client) WithDefault(response Response) *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaultSet = true
+	c.defaultRes = response
+	return c
+}
+
+// Do returns the next scripted response for 'req'
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	c.bodies = append(c.bodies, string(body))
+	var response Response
+	switch {
+	case c.next < len(c.script):
+		response = c.script[c.next]
+		c.next++
+	case c.defaultSet:
+		response = c.defaultRes
+	default:
+		c.mu.Unlock()
+		return nil, fmt.Errorf("unexpected request %s %s, the mock client's responses are exhausted", req.Method, req.URL)
+	}
+	c.mu.Unlock()
+
+	if response.Delay > 0 {
+		timer := time.NewTimer(response.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if response.Err != nil {
+		return nil, response.Err
+	}
+	header := response.Header
+	if header == nil {
+		header = http.Header{}
+	}
+	statusCode := response.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode: statusCode,
+		Header:     header.Clone(),
+		Body:       io.NopCloser(strings.NewReader(response.Body)),
+		Request:    req,
+	}, nil
+}
+
+// Requests returns the requests the client received, in order
+func (c *Client) Requests() []*http.Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*http.Request(nil), c.requests...)
+}
+
+// RequestBodies returns the bodies of the requests the client received, in order
+func (c *Client) RequestBodies() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.bodies...)
+}
+
+// Remaining returns the number of scripted responses not yet returned
+func (c *Client) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.script) - c.next
+}
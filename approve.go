@@ -0,0 +1,219 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Placeholder for an expected body to be recorded in approval mode
+const recordPlaceholder = "__record__"
+
+// Strings that look like generated ids, replaced by a nonEmpty matcher when recording bodies
+var generatedIdRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Returns true if the expected body of 'test' should be recorded in approval mode
+func needsApproval(test TestSpec) bool {
+	if test.Exec != nil || test.ExpectedResponse.BodyFile != "" || test.ExpectedResponse.BodyEmpty || test.ExpectedResponse.FromSpecExample != "" {
+		return false
+	}
+	return test.ExpectedResponse.Body == nil || test.ExpectedResponse.Body == recordPlaceholder
+}
+
+// Returns a copy of the response body 'v' to record as an expected body. String values matching a
+// template var from an earlier test (e.g. the id of a created resource) are replaced by the var,
+// and timestamps and UUIDs by matchers.
+func approvedBody(v interface{}, extractedFields map[string]interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			res[k] = approvedBody(child, extractedFields)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, child := range val {
+			res[i] = approvedBody(child, extractedFields)
+		}
+		return res
+	case string:
+		if varName := templateVarWithValue(val, extractedFields); varName != "" {
+			return fmt.Sprintf("{{ %s }}", varName)
+		}
+		if _, err := time.Parse(time.RFC3339, val); err == nil {
+			return "{{ date:RFC3339 }}"
+		}
+		if generatedIdRegex.MatchString(val) {
+			return "{{ nonEmpty }}"
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+// Returns the (alphabetically first) template var, excluding headers, cookies and request values, whose value is
+// 's', or "" if there's none. Short values are ignored since they're likely to match by coincidence.
+func templateVarWithValue(s string, extractedFields map[string]interface{}) string {
+	if len(s) < 6 {
+		return ""
+	}
+	names := make([]string, 0)
+	for name, value := range extractedFields {
+		if strings.Contains(name, ".header.") || strings.Contains(name, ".cookie.") || strings.Contains(name, ".request.") || strings.Contains(name, ".response.") {
+			continue
+		}
+		if value == s {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// Writes the recorded expected bodies keyed by test name into the test file 'testFilename',
+// preserving the order of its fields
+func writeApprovedBodies(testFilename string, bodies map[string]interface{}) error {
+	contents, err := os.ReadFile(testFilename)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading test file %s", testFilename))
+	}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	root, err := decodeOrdered(decoder)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error parsing test file %s", testFilename))
+	}
+	suite, ok := root.(*orderedObject)
+	if !ok {
+		return fmt.Errorf("invalid test file %s", testFilename)
+	}
+	tests, _ := suite.values["tests"].([]interface{})
+	for _, t := range tests {
+		test, ok := t.(*orderedObject)
+		if !ok {
+			continue
+		}
+		name, _ := test.values["name"].(string)
+		body, ok := bodies[name]
+		if !ok {
+			continue
+		}
+		expectedResponse, ok := test.values["expectedResponse"].(*orderedObject)
+		if !ok {
+			expectedResponse = &orderedObject{values: make(map[string]interface{})}
+			test.set("expectedResponse", expectedResponse)
+		}
+		expectedResponse.set("body", body)
+	}
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	err = encoder.Encode(suite)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error encoding test file %s", testFilename))
+	}
+	return os.WriteFile(testFilename, out.Bytes(), 0644)
+}
+
+// A JSON object that keeps the order of its keys
+type orderedObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (obj *orderedObject) set(key string, value interface{}) {
+	if _, ok := obj.values[key]; !ok {
+		obj.keys = append(obj.keys, key)
+	}
+	obj.values[key] = value
+}
+
+func (obj *orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, key := range obj.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		err := encoder.Encode(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		err = encoder.Encode(obj.values[key])
+		if err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Decodes the next JSON value from 'decoder', decoding objects as *orderedObject
+func decodeOrdered(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		obj := &orderedObject{values: make(map[string]interface{})}
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ := keyToken.(string)
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			obj.set(key, value)
+		}
+		_, err = decoder.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := make([]interface{}, 0)
+		for decoder.More() {
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err = decoder.Token()
+		return arr, err
+	default:
+		return token, nil
+	}
+}
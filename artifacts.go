@@ -0,0 +1,173 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Headers whose values are replaced in failure artifacts, which are typically uploaded by CI
+var redactedArtifactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// Replaces characters that aren't safe in file names
+var artifactNameReplacer = strings.NewReplacer("/", "_", "\\", "_", ":", "_", " ", "_")
+
+// Returns the directory the failure artifacts of the run are written to (see RunConfig.ArtifactsDir)
+func (config RunConfig) artifactsRunDir() string {
+	return filepath.Join(config.ArtifactsDir, config.runId)
+}
+
+// Writes the artifacts of the failed 'result' of 'test' to a directory of its own: its resolved request
+// (request.http), an equivalent curl command (curl.sh), the response (response.json), the template vars
+// at the time (variables.json) and its errors (errors.txt)
+func (suite TestSuite) writeFailureArtifacts(result TestResult, fields map[string]interface{}) error {
+	suiteName := artifactNameReplacer.Replace(strings.TrimLeft(filepath.ToSlash(filepath.Clean(suite.fileName)), "./"))
+	dir := filepath.Join(suite.config.artifactsRunDir(), suiteName, artifactNameReplacer.Replace(result.Name))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing failure artifacts of '%s'", result.Name))
+	}
+	files := map[string][]byte{
+		"errors.txt": []byte(strings.Join(result.Errors, "\n") + "\n"),
+	}
+	if result.RequestUrl != "" {
+		headers := redactedHeaders(result.RequestHeaders)
+		files["request.http"] = []byte(requestText(result.RequestMethod, result.RequestUrl, headers, result.RequestBody))
+		files["curl.sh"] = []byte(curlCommand(result.RequestMethod, result.RequestUrl, headers, result.RequestBody) + "\n")
+	}
+	if result.Response != nil {
+		files["response.json"], err = json.MarshalIndent(result.Response, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error encoding response of '%s'", result.Name))
+		}
+	}
+	files["variables.json"], err = json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error encoding template vars of '%s'", result.Name))
+	}
+	for name, contents := range files {
+		err = os.WriteFile(filepath.Join(dir, name), contents, 0644)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error writing failure artifacts of '%s'", result.Name))
+		}
+	}
+	return nil
+}
+
+// Returns a copy of 'headers' with the values of redactedArtifactHeaders replaced
+func redactedHeaders(headers http.Header) http.Header {
+	redacted := headers.Clone()
+	for _, name := range redactedArtifactHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "REDACTED")
+		}
+	}
+	return redacted
+}
+
+// Returns the names of 'headers' in alphabetical order
+func sortedHeaderNames(headers http.Header) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Formats a request like an HTTP message
+func requestText(method string, url string, headers http.Header, body string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s\n", method, url)
+	for _, name := range sortedHeaderNames(headers) {
+		for _, value := range headers[name] {
+			fmt.Fprintf(&sb, "%s: %s\n", name, value)
+		}
+	}
+	if body != "" {
+		fmt.Fprintf(&sb, "\n%s\n", body)
+	}
+	return sb.String()
+}
+
+// Returns a curl command sending a request
+func curlCommand(method string, url string, headers http.Header, body string) string {
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	args := []string{"curl", "-X", method, quote(url)}
+	for _, name := range sortedHeaderNames(headers) {
+		for _, value := range headers[name] {
+			args = append(args, "-H", quote(name+": "+value))
+		}
+	}
+	if body != "" {
+		args = append(args, "--data-raw", quote(body))
+	}
+	return strings.Join(args, " ")
+}
+
+// Zips the failure artifacts of the run into '<ArtifactsDir>/<run id>.zip'. Returns the zip's path, or ""
+// if no test failed.
+func zipFailureArtifacts(config RunConfig) (string, error) {
+	runDir := config.artifactsRunDir()
+	if _, err := os.Stat(runDir); os.IsNotExist(err) {
+		return "", nil
+	}
+	zipPath := runDir + ".zip"
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		return "", errors.Wrap(err, "error writing failure artifacts")
+	}
+	defer zipFile.Close()
+	archive := zip.NewWriter(zipFile)
+	err = filepath.Walk(runDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(config.ArtifactsDir, path)
+		if err != nil {
+			return err
+		}
+		w, err := archive.Create(filepath.ToSlash(name))
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "error writing failure artifacts")
+	}
+	return zipPath, nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Refresh tokens slightly before they expire to avoid racing the IdP
+const tokenExpiryLeeway = 10 * time.Second
+
+// Token-based auth configuration. A token is fetched from TokenUrl once per run,
+// cached across suites and attached to every request.
+type TokenAuthConfig struct {
+	TokenUrl       string            `json:"tokenUrl"`
+	Method         string            `json:"method"`
+	Headers        map[string]string `json:"headers"`
+	Body           interface{}       `json:"body"`
+	TokenField     string            `json:"tokenField"`
+	ExpiresInField string            `json:"expiresInField"`
+	Header         string            `json:"header"`
+	Prefix         *string           `json:"prefix"`
+}
+
+// Thread-safe cache of the current auth token, shared by all suites in a run
+type tokenCache struct {
+	config    TokenAuthConfig
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func newTokenCache(config TokenAuthConfig) *tokenCache {
+	return &tokenCache{
+		config: config,
+	}
+}
+
+// Returns the cached token, fetching a new one if none is cached or the cached one expired
+func (cache *tokenCache) get(client HttpClient) (string, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.token != "" && (cache.expiresAt.IsZero() || time.Now().Before(cache.expiresAt)) {
+		return cache.token, nil
+	}
+	return cache.fetch(client)
+}
+
+// Discards 'staleToken' and fetches a new one. If another caller already refreshed the token, that one is returned instead.
+func (cache *tokenCache) refresh(client HttpClient, staleToken string) (string, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.token != "" && cache.token != staleToken {
+		return cache.token, nil
+	}
+	return cache.fetch(client)
+}
+
+func (cache *tokenCache) fetch(client HttpClient) (string, error) {
+	method := cache.config.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	var body io.Reader
+	if cache.config.Body != nil {
+		bodyBytes, err := json.Marshal(cache.config.Body)
+		if err != nil {
+			return "", errors.Wrap(err, "invalid auth token request body")
+		}
+		body = bytes.NewReader(bodyBytes)
+	}
+	req, err := http.NewRequest(method, cache.config.TokenUrl, body)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to create auth token request")
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range cache.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "error requesting auth token")
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading auth token response")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("auth token request returned http %d: %s", resp.StatusCode, string(respBytes))
+	}
+	var respBody map[string]interface{}
+	err = json.Unmarshal(respBytes, &respBody)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid auth token response")
+	}
+
+	tokenField := cache.config.TokenField
+	if tokenField == "" {
+		tokenField = "access_token"
+	}
+	token, ok := flatten(respBody, "", 0)[tokenField].(string)
+	if !ok || token == "" {
+		return "", fmt.Errorf("auth token response missing field '%s'", tokenField)
+	}
+
+	cache.token = token
+	cache.expiresAt = time.Time{}
+	expiresInField := cache.config.ExpiresInField
+	if expiresInField == "" {
+		expiresInField = "expires_in"
+	}
+	if expiresIn, ok := flatten(respBody, "", 0)[expiresInField].(float64); ok {
+		cache.expiresAt = time.Now().Add(time.Duration(expiresIn)*time.Second - tokenExpiryLeeway)
+	}
+	return token, nil
+}
+
+// Returns the name of the header the auth token is sent in
+func (cache *tokenCache) headerName() string {
+	if cache.config.Header == "" {
+		return "Authorization"
+	}
+	return cache.config.Header
+}
+
+// Sets the auth header for 'token' on 'req'
+func (cache *tokenCache) apply(req *http.Request, token string) {
+	header := cache.headerName()
+	prefix := "Bearer "
+	if cache.config.Prefix != nil {
+		prefix = *cache.config.Prefix
+	}
+	req.Header.Set(header, prefix+token)
+}
+
+// Makes 'req' using 'client' with the cached auth token. Tokens are requested using 'tokenClient'.
+// If the server rejects the token with a 401, the token is refreshed and the original request is re-issued once.
+// Token requests and the re-issued request are recorded against 'budget' ('req' itself already is).
+func (cache *tokenCache) do(client HttpClient, tokenClient HttpClient, budget *requestBudget, req *http.Request) (*http.Response, error) {
+	tokenClient = budget.client(tokenClient)
+	token, err := cache.get(tokenClient)
+	if err != nil {
+		return nil, err
+	}
+	cache.apply(req, token)
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// Token was rejected, refresh it and retry once
+	retryReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retryReq.Body, err = req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+	}
+	token, err = cache.refresh(tokenClient, token)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	cache.apply(retryReq, token)
+	return budget.client(client).Do(retryReq)
+}
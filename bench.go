@@ -0,0 +1,232 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options for benchmarking a single test
+type BenchOptions struct {
+	// Name of the test to benchmark (case-insensitive)
+	Test string
+	// Number of times to execute the test (default 100)
+	Requests int
+	// Number of concurrent executions (default 1)
+	Concurrency int
+}
+
+// Results of benchmarking a test
+type BenchResult struct {
+	Test         string
+	TestFilename string
+	Requests     int
+	Failed       int
+	// Duration of each execution, sorted ascending
+	Durations []time.Duration
+	Elapsed   time.Duration
+	// Distinct errors of failed executions and how often each occurred
+	Errors map[string]int
+}
+
+// ErrorRate returns the fraction of executions that failed
+func (result BenchResult) ErrorRate() float64 {
+	if result.Requests == 0 {
+		return 0
+	}
+	return float64(result.Failed) / float64(result.Requests)
+}
+
+// Percentile returns the duration below which 'p' percent of executions completed
+func (result BenchResult) Percentile(p float64) time.Duration {
+	if len(result.Durations) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(result.Durations)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return result.Durations[i]
+}
+
+// Mean returns the mean duration of all executions
+func (result BenchResult) Mean() time.Duration {
+	if len(result.Durations) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range result.Durations {
+		total += d
+	}
+	return total / time.Duration(len(result.Durations))
+}
+
+// Report returns a printable summary of the latency distribution and error rate
+func (result BenchResult) Report() string {
+	var report strings.Builder
+	fmt.Fprintf(&report, "\nBenchmark '%s' (%s):\n", result.Test, result.TestFilename)
+	fmt.Fprintf(&report, "Requests: %d\nFailed: %d (%.2f%%)\nDuration: %s\nThroughput: %.2f req/s\n",
+		result.Requests, result.Failed, result.ErrorRate()*100, result.Elapsed, float64(result.Requests)/result.Elapsed.Seconds())
+	if len(result.Durations) > 0 {
+		fmt.Fprintf(&report, "Latency: min %s, mean %s, p50 %s, p90 %s, p95 %s, p99 %s, max %s\n",
+			result.Durations[0], result.Mean(), result.Percentile(50), result.Percentile(90), result.Percentile(95), result.Percentile(99), result.Durations[len(result.Durations)-1])
+	}
+	errs := make([]string, 0, len(result.Errors))
+	for err := range result.Errors {
+		errs = append(errs, err)
+	}
+	sort.Slice(errs, func(i, j int) bool { return result.Errors[errs[i]] > result.Errors[errs[j]] })
+	for _, err := range errs {
+		report.WriteString(fmt.Sprintf("\t%dx %s\n", result.Errors[err], fmt.Sprintf(ErrorString, err)))
+	}
+	return report.String()
+}
+
+// Bench executes the test named in 'options' (from the test files in 'testDir' whose names match
+// 'testFilenameMatchRegex') repeatedly and concurrently, returning its latency distribution and error rate.
+// The suite's setup steps and the tests preceding the benchmarked test are run once beforehand so that it
+// can reference their template vars, and its teardown steps are run afterwards.
+func Bench(runConfigFilename string, testDir string, testFilenameMatchRegex *regexp.Regexp, options BenchOptions) (BenchResult, error) {
+	config, err := loadRunConfig(nil, runConfigFilename)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	if options.Requests <= 0 {
+		options.Requests = 100
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+	// Keep a connection per worker alive between requests
+	transport := TransportConfig{}
+	if config.Transport != nil {
+		transport = *config.Transport
+	}
+	if transport.MaxIdleConnsPerHost == 0 {
+		transport.MaxIdleConnsPerHost = options.Concurrency
+	}
+	config.Transport = &transport
+
+	testFile, err := findTest(testDir, testFilenameMatchRegex, options.Test)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	testSuite, closeSuite, err := newTestSuite(config, testFile)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	defer closeSuite()
+
+	// Run setup steps and preceding tests
+	extractedFields := testSuite.initialFields()
+	if !testSuite.config.DryRun {
+		setupFailures := testSuite.executeSteps(testSuite.spec.Setup, "setup", extractedFields, true)
+		if len(setupFailures) > 0 {
+			return BenchResult{}, fmt.Errorf("setup failed:\n%s", setupFailures[0].Result())
+		}
+		defer testSuite.executeSteps(testSuite.spec.Teardown, "teardown", extractedFields, false)
+	}
+	var benchTest TestSpec
+	for _, test := range testSuite.spec.Tests {
+		if strings.EqualFold(test.Name, options.Test) {
+			benchTest = test
+			break
+		}
+		result := testSuite.executeSpec(test, extractedFields)
+		if !result.Passed && !result.Skipped {
+			return BenchResult{}, fmt.Errorf("test '%s' preceding '%s' failed:\n%s", test.Name, options.Test, result.Result())
+		}
+	}
+
+	// Execute the test concurrently, each execution with its own copy of the template vars
+	result := BenchResult{
+		Test:         benchTest.Name,
+		TestFilename: testFile,
+		Requests:     options.Requests,
+		Durations:    make([]time.Duration, 0, options.Requests),
+		Errors:       make(map[string]int),
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	remaining := make(chan struct{}, options.Requests)
+	for i := 0; i < options.Requests; i++ {
+		remaining <- struct{}{}
+	}
+	close(remaining)
+	start := time.Now()
+	for i := 0; i < options.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range remaining {
+				fields := make(map[string]interface{}, len(extractedFields))
+				for k, v := range extractedFields {
+					fields[k] = v
+				}
+				testResult := testSuite.executeSpec(benchTest, fields)
+				mutex.Lock()
+				result.Durations = append(result.Durations, testResult.Duration)
+				if !testResult.Passed {
+					result.Failed++
+					// The full response payload differs between executions, so leave it out when grouping errors
+					for _, err := range testResult.Errors {
+						if !strings.HasPrefix(err, "Full response payload from server") {
+							result.Errors[err]++
+						}
+					}
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+	sort.Slice(result.Durations, func(i, j int) bool { return result.Durations[i] < result.Durations[j] })
+	return result, nil
+}
+
+// Returns the test file in 'testDir' defining the test named 'testName'
+func findTest(testDir string, testFilenameMatchRegex *regexp.Regexp, testName string) (string, error) {
+	testFiles, err := findTestFiles(nil, testDir, testFilenameMatchRegex)
+	if err != nil {
+		return "", err
+	}
+	matches := make([]string, 0)
+	for _, testFile := range testFiles {
+		suiteSpec, err := loadSuiteSpec(nil, testFile)
+		if err != nil {
+			return "", err
+		}
+		for _, test := range suiteSpec.Tests {
+			if strings.EqualFold(test.Name, testName) {
+				matches = append(matches, testFile)
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no test named '%s' found", testName)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("test '%s' is defined in multiple files (%s), narrow down the test files to search", testName, strings.Join(matches, ", "))
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Limits on the requests a run may make, protecting shared (or production-like) environments from runaway suites
+type BudgetConfig struct {
+	// Maximum number of requests sent by the run (0 for no limit)
+	MaxRequests int `json:"maxRequests"`
+	// Maximum number of requests with a destructive method sent by the run (0 for no limit)
+	MaxDestructiveRequests int `json:"maxDestructiveRequests"`
+	// Methods counted as destructive, DELETE and PUT if not specified
+	DestructiveMethods []string `json:"destructiveMethods"`
+	// Skip tests with "destructive": "true" metadata (set on the test or its suite), and refuse to send
+	// methods other than GET, HEAD and OPTIONS from tests without allowUnsafeMethods
+	ReadOnly bool `json:"readOnly"`
+}
+
+func (config BudgetConfig) validate() error {
+	if config.MaxRequests < 0 {
+		return fmt.Errorf("invalid budget.maxRequests %d, must be positive (or 0 for no limit)", config.MaxRequests)
+	}
+	if config.MaxDestructiveRequests < 0 {
+		return fmt.Errorf("invalid budget.maxDestructiveRequests %d, must be positive (or 0 for no limit)", config.MaxDestructiveRequests)
+	}
+	return nil
+}
+
+func (config BudgetConfig) isDestructive(method string) bool {
+	methods := config.DestructiveMethods
+	if len(methods) == 0 {
+		methods = []string{"DELETE", "PUT"}
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if a test with 'metadata' is skipped by a read-only run
+func skippedByReadOnly(config *BudgetConfig, metadata map[string]string) bool {
+	return config != nil && config.ReadOnly && strings.EqualFold(metadata["destructive"], "true")
+}
+
+// Requests spent by a run against its BudgetConfig. Shared by all suites of a run (and by tests
+// abandoned after a timeout), so it's safe for concurrent use.
+type requestBudget struct {
+	config      BudgetConfig
+	mutex       sync.Mutex
+	requests    int
+	destructive int
+	// Error for the first request refused, if any
+	exceeded error
+}
+
+func newRequestBudget(config BudgetConfig) *requestBudget {
+	return &requestBudget{config: config}
+}
+
+// Records a request with 'method', returning an error instead if it would exceed the budget. Once
+// exceeded, all further requests are refused.
+func (budget *requestBudget) spend(method string) error {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	if budget.exceeded != nil {
+		return budget.exceeded
+	}
+	if budget.config.MaxRequests > 0 && budget.requests >= budget.config.MaxRequests {
+		budget.exceeded = fmt.Errorf("request budget exceeded: run is limited to %d request(s) (budget.maxRequests)", budget.config.MaxRequests)
+		return budget.exceeded
+	}
+	destructive := budget.config.isDestructive(method)
+	if destructive && budget.config.MaxDestructiveRequests > 0 && budget.destructive >= budget.config.MaxDestructiveRequests {
+		budget.exceeded = fmt.Errorf("request budget exceeded: run is limited to %d destructive request(s) (budget.maxDestructiveRequests), refused %s", budget.config.MaxDestructiveRequests, strings.ToUpper(method))
+		return budget.exceeded
+	}
+	budget.requests++
+	if destructive {
+		budget.destructive++
+	}
+	return nil
+}
+
+// Returns 'client' with the requests it sends recorded against the budget (see spend), or 'client' itself if
+// the run has no budget
+func (budget *requestBudget) client(client HttpClient) HttpClient {
+	if budget == nil {
+		return client
+	}
+	return budgetedHttpClient{client: client, budget: budget}
+}
+
+type budgetedHttpClient struct {
+	client HttpClient
+	budget *requestBudget
+}
+
+func (c budgetedHttpClient) Do(req *http.Request) (*http.Response, error) {
+	err := c.budget.spend(req.Method)
+	if err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+// Returns the error for the first request refused, or nil if the budget hasn't been exceeded
+func (budget *requestBudget) err() error {
+	if budget == nil {
+		return nil
+	}
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	return budget.exceeded
+}
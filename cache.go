@@ -0,0 +1,140 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Results of tests that passed in earlier runs, keyed by the hash of their inputs (see resultCacheKey).
+// Tests whose inputs are unchanged are skipped, restoring the template vars they memoized when they passed.
+type resultCache struct {
+	filename string
+	// If set, tests aren't skipped but passing tests are still recorded
+	refresh bool
+	mutex   sync.Mutex
+	entries map[string]resultCacheEntry
+}
+
+type resultCacheEntry struct {
+	// Test file and name of the test, for readability of the cache file
+	Test string `json:"test"`
+	// Template vars memoized by the test (those prefixed with its name)
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// Loads the cache in 'filename', which doesn't need to exist yet
+func loadResultCache(filename string, refresh bool) (*resultCache, error) {
+	cache := &resultCache{filename: filename, refresh: refresh, entries: make(map[string]resultCacheEntry)}
+	contents, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading cache file %s", filename))
+	}
+	err = json.Unmarshal(contents, &cache.entries)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("invalid cache file %s (delete it to start over)", filename))
+	}
+	return cache, nil
+}
+
+func (cache *resultCache) lookup(key string) (resultCacheEntry, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.refresh {
+		return resultCacheEntry{}, false
+	}
+	entry, ok := cache.entries[key]
+	return entry, ok
+}
+
+func (cache *resultCache) record(key string, entry resultCacheEntry) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.entries[key] = entry
+}
+
+func (cache *resultCache) save() error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	contents, err := json.MarshalIndent(cache.entries, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "error encoding cache")
+	}
+	err = os.WriteFile(cache.filename, contents, 0644)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing cache file %s", cache.filename))
+	}
+	return nil
+}
+
+// Returns the hash of the inputs of 'test': its spec, its resolved request 'req' (except headers from config,
+// which may vary per run, e.g. {{ run.id }}) and the target environment (base url and profile)
+func (suite TestSuite) resultCacheKey(test TestSpec, req *http.Request) (string, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return "", err
+	}
+	configHeaders := make(map[string]bool, len(suite.config.CustomHeaders))
+	for name := range suite.config.CustomHeaders {
+		configHeaders[http.CanonicalHeaderKey(name)] = true
+	}
+	headers := make([]string, 0, len(req.Header))
+	for name, values := range req.Header {
+		if configHeaders[name] {
+			continue
+		}
+		headers = append(headers, name+": "+strings.Join(values, ","))
+	}
+	sort.Strings(headers)
+	inputs, err := json.Marshal(map[string]interface{}{
+		"file":    suite.fileName,
+		"test":    test,
+		"method":  req.Method,
+		"url":     req.URL.String(),
+		"headers": headers,
+		"body":    string(body),
+		"baseUrl": suite.config.BaseUrl,
+		"profile": suite.config.Profile,
+	})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(inputs)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Returns the template vars memoized by the test named 'testName'
+func testFields(testName string, extractedFields map[string]interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for k, v := range extractedFields {
+		if strings.HasPrefix(k, testName+".") {
+			fields[k] = v
+		}
+	}
+	return fields
+}
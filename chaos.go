@@ -0,0 +1,229 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const faultIdHeader = "X-Apirunner-Fault-Id"
+
+// Fault to inject into a test's request by routing it through a local proxy. Latency is added before
+// the request is forwarded (or faulted). If Reset is set the connection is reset, otherwise if Response
+// is set it is returned instead of forwarding the request to the target. If Times is set only the
+// first Times attempts are faulted, so retries of the http client can be exercised.
+type Fault struct {
+	LatencyMs int           `json:"latencyMs"`
+	Reset     bool          `json:"reset"`
+	Response  *StubResponse `json:"response"`
+	Times     int           `json:"times"`
+}
+
+type faultTarget struct {
+	fault    Fault
+	target   *url.URL
+	attempts int
+}
+
+// Local proxy sitting between the runner and the target for tests that specify a Fault
+type chaosProxy struct {
+	server   *http.Server
+	listener net.Listener
+	proxy    *httputil.ReverseProxy
+	mutex    sync.Mutex
+	nextId   int
+	targets  map[string]*faultTarget
+}
+
+func startChaosProxy(fsys fs.FS, config *TransportConfig) (*chaosProxy, error) {
+	transport, err := newTransport(fsys, config)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to start chaos proxy")
+	}
+	chaos := &chaosProxy{
+		listener: listener,
+		targets:  make(map[string]*faultTarget),
+	}
+	chaos.proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(chaos.target(r.In.Header.Get(faultIdHeader)).target)
+			r.Out.URL.Path = r.In.URL.Path
+			r.Out.URL.RawPath = r.In.URL.RawPath
+			r.Out.URL.RawQuery = r.In.URL.RawQuery
+			r.Out.Header.Del(faultIdHeader)
+		},
+		Transport: transport,
+	}
+	chaos.server = &http.Server{
+		Handler:           chaos,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go chaos.server.Serve(listener)
+	return chaos, nil
+}
+
+func (chaos *chaosProxy) close() {
+	chaos.server.Close()
+}
+
+func (chaos *chaosProxy) target(id string) *faultTarget {
+	chaos.mutex.Lock()
+	defer chaos.mutex.Unlock()
+	return chaos.targets[id]
+}
+
+// Context key of the id of the fault to apply to a request
+type faultIdKey struct{}
+
+// Returns a copy of 'req' that is routed through the proxy (when made with a client returned by
+// chaos.client), which applies 'fault' before forwarding it to its destination. The request's url is left
+// as is so that it's signed and its cookies are handled for the target. The returned func must be called
+// once the request's test is done.
+func (chaos *chaosProxy) route(req *http.Request, fault Fault) (*http.Request, func()) {
+	chaos.mutex.Lock()
+	chaos.nextId++
+	id := strconv.Itoa(chaos.nextId)
+	chaos.targets[id] = &faultTarget{fault: fault}
+	chaos.mutex.Unlock()
+
+	release := func() {
+		chaos.mutex.Lock()
+		delete(chaos.targets, id)
+		chaos.mutex.Unlock()
+	}
+	return req.WithContext(context.WithValue(req.Context(), faultIdKey{}, id)), release
+}
+
+// Returns an HttpClient that sends requests routed by chaos.route through the proxy using 'client'.
+// The proxy is swapped in at the transport of http.Clients, below their cookie jar. Other clients are
+// passed a copy of the request addressed to the proxy.
+func (chaos *chaosProxy) client(client HttpClient) HttpClient {
+	return chaosHttpClient{client, chaos}
+}
+
+type chaosHttpClient struct {
+	client HttpClient
+	chaos  *chaosProxy
+}
+
+func (c chaosHttpClient) Do(req *http.Request) (*http.Response, error) {
+	if _, ok := req.Context().Value(faultIdKey{}).(string); !ok {
+		return c.client.Do(req)
+	}
+	if httpClient, ok := c.client.(*http.Client); ok {
+		proxied := *httpClient
+		proxied.Transport = chaosTransport{httpClient.Transport, c.chaos}
+		return proxied.Do(req)
+	}
+	return c.client.Do(c.chaos.proxyRequest(req))
+}
+
+// RoundTripper that sends requests routed by chaos.route through the proxy using 'transport'
+// (http.DefaultTransport if nil)
+type chaosTransport struct {
+	transport http.RoundTripper
+	chaos     *chaosProxy
+}
+
+func (t chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if _, ok := req.Context().Value(faultIdKey{}).(string); !ok {
+		return transport.RoundTrip(req)
+	}
+	return transport.RoundTrip(t.chaos.proxyRequest(req))
+}
+
+// Returns a copy of 'req', which must have been routed by chaos.route, addressed to the proxy. The
+// proxy forwards it to the scheme and host of 'req'.
+func (chaos *chaosProxy) proxyRequest(req *http.Request) *http.Request {
+	id := req.Context().Value(faultIdKey{}).(string)
+	chaos.mutex.Lock()
+	if target, ok := chaos.targets[id]; ok {
+		target.target = &url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host}
+	}
+	chaos.mutex.Unlock()
+
+	proxied := req.Clone(req.Context())
+	proxied.URL.Scheme = "http"
+	proxied.URL.Host = chaos.listener.Addr().String()
+	proxied.Host = ""
+	proxied.Header.Set(faultIdHeader, id)
+	return proxied
+}
+
+func (chaos *chaosProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	chaos.mutex.Lock()
+	target, ok := chaos.targets[r.Header.Get(faultIdHeader)]
+	faulted := false
+	if ok {
+		target.attempts++
+		faulted = target.fault.Times <= 0 || target.attempts <= target.fault.Times
+	}
+	chaos.mutex.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown fault id '%s'", r.Header.Get(faultIdHeader)), http.StatusBadGateway)
+		return
+	}
+
+	if faulted {
+		fault := target.fault
+		if fault.LatencyMs > 0 {
+			time.Sleep(time.Duration(fault.LatencyMs) * time.Millisecond)
+		}
+		if fault.Reset {
+			resetConnection(w)
+			return
+		}
+		if fault.Response != nil {
+			fault.Response.write(w)
+			return
+		}
+	}
+	chaos.proxy.ServeHTTP(w, r)
+}
+
+// Closes the underlying connection of 'w' with a TCP RST instead of a response
+func resetConnection(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	utf8Bom    = []byte{0xEF, 0xBB, 0xBF}
+	utf16LeBom = []byte{0xFF, 0xFE}
+	utf16BeBom = []byte{0xFE, 0xFF}
+)
+
+// Returns 'body' as UTF-8 without a byte order mark, decoding UTF-16 bodies (detected by their BOM, the
+// charset in 'contentType' or, for JSON, the zero bytes of its ASCII first character per RFC 4627).
+// Bodies in other encodings (and binary bodies, e.g. protobuf) are returned unchanged.
+func normalizeCharset(contentType string, body []byte) []byte {
+	switch {
+	case bytes.HasPrefix(body, utf8Bom):
+		return body[len(utf8Bom):]
+	case bytes.HasPrefix(body, utf16LeBom):
+		return decodeUtf16(body[len(utf16LeBom):], binary.LittleEndian)
+	case bytes.HasPrefix(body, utf16BeBom):
+		return decodeUtf16(body[len(utf16BeBom):], binary.BigEndian)
+	}
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	switch strings.ToLower(params["charset"]) {
+	case "utf-16le":
+		return decodeUtf16(body, binary.LittleEndian)
+	case "utf-16be":
+		return decodeUtf16(body, binary.BigEndian)
+	case "utf-16":
+		// UTF-16 without a BOM is big endian unless sniffed otherwise
+		if len(body) >= 2 && body[0] != 0 && body[1] == 0 {
+			return decodeUtf16(body, binary.LittleEndian)
+		}
+		return decodeUtf16(body, binary.BigEndian)
+	}
+	if (contentType == "" || strings.HasSuffix(mediaType, "json")) && len(body) >= 2 {
+		if body[0] != 0 && body[1] == 0 {
+			return decodeUtf16(body, binary.LittleEndian)
+		}
+		if body[0] == 0 && body[1] != 0 {
+			return decodeUtf16(body, binary.BigEndian)
+		}
+	}
+	return body
+}
+
+func decodeUtf16(body []byte, order binary.ByteOrder) []byte {
+	units := make([]uint16, 0, len(body)/2)
+	for i := 0; i+1 < len(body); i += 2 {
+		units = append(units, order.Uint16(body[i:]))
+	}
+	return []byte(string(utf16.Decode(units)))
+}
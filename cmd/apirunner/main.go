@@ -15,24 +15,195 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"syscall"
 
 	"github.com/warrant-dev/apirunner"
 )
 
 func main() {
-	if len(os.Args) < 2 || len(os.Args) > 4 {
+	dryRun := flag.Bool("dry-run", false, "don't send requests, only assert on the requests that would be sent")
+	out := flag.String("out", "", "file to write exports to (defaults to stdout)")
+	report := flag.String("report", "", "file to write a JSON report of the results to")
+	profile := flag.String("profile", "", "name of the config profile recorded in reports (defaults to the config file's name without extension)")
+	approve := flag.Bool("approve", false, "record the response bodies of passing tests without an expected body (or with \"__record__\") into their test files")
+	failOn := flag.String("fail-on", "", "only fail on failures of tests with at least this severity metadata, e.g. severity>=high (lower severity failures are warnings)")
+	metadata := flag.String("metadata", "", "only run tests with these comma separated metadata key=value pairs, e.g. owner=payments,severity=high")
+	noCache := flag.Bool("no-cache", false, "run tests even if they passed with the same inputs before (see cacheFile in config)")
+	htmlReport := flag.String("html", "", "file to write a self-contained HTML report of the results to")
+	tap := flag.String("tap", "", "file to write the results to in TAP format, or - for stdout (the console output is then written to stderr)")
+	readOnly := flag.Bool("read-only", false, "skip tests with \"destructive\": \"true\" metadata and refuse to send methods other than GET, HEAD and OPTIONS")
+	artifacts := flag.String("artifacts", "", "directory to write the request, response, template vars and curl command of each failed test to, zipped per run")
+	maxDiffs := flag.Int("max-diffs", 0, "number of response body differences per test tolerated as warnings (overrides maxDiffs in config, allowedDiffCount in tests overrides it)")
+	exportVars := flag.String("export-vars", "", "file to write template vars to at the end of the run, as JSON or as KEY=value lines if it ends in .env (overrides variableExport in config)")
+	exportVarsPattern := flag.String("export-vars-pattern", "", "regex the names of template vars written to -export-vars must match, e.g. ^createUser\\.")
+	plan := flag.Bool("plan", false, "print the execution order, dependencies and skipped tests without running anything")
+	flag.Parse()
+	args := flag.Args()
+
+	// apirunner export <format> <testDir> [testNameMatchRegex] [configFile]
+	if len(args) > 0 && args[0] == "export" {
+		if len(args) < 3 {
+			fmt.Printf("Invalid args")
+			os.Exit(1)
+		}
+		export(args[1], args[2:], *out)
+		os.Exit(0)
+	}
+
+	// apirunner import <format> <file> <testDir>
+	if len(args) > 0 && args[0] == "import" {
+		if len(args) != 4 {
+			fmt.Printf("Invalid args")
+			os.Exit(1)
+		}
+		importTests(args[1], args[2], args[3])
+		os.Exit(0)
+	}
+
+	// apirunner bench -run <testName> [-n requests] [-c concurrency] <testDir> [testNameMatchRegex] [configFile]
+	if len(args) > 0 && args[0] == "bench" {
+		bench(args[1:])
+		os.Exit(0)
+	}
+
+	// apirunner soak -duration <duration> [-budget percent] [-interval duration] <testDir> [testNameMatchRegex] [configFile]
+	if len(args) > 0 && args[0] == "soak" {
+		if !soak(args[1:]) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// apirunner diff [-slower ratio] [-slower-min ms] <oldReport> <newReport>
+	if len(args) > 0 && args[0] == "diff" {
+		if !diffReports(args[1:]) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// apirunner migrate [-dry-run] <testDir> [testNameMatchRegex]
+	if len(args) > 0 && args[0] == "migrate" {
+		migrate(args[1:])
+		os.Exit(0)
+	}
+
+	// apirunner gc [-dry-run] <gcFile> [configFile]
+	if len(args) > 0 && args[0] == "gc" {
+		if !gc(args[1:]) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// apirunner doctor [configFile]
+	if len(args) > 0 && args[0] == "doctor" {
+		if !doctor(args[1:]) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	configFile, testDir, testFilenameMatchRegex := parseTestArgs(args)
+	metadataFilter, err := apirunner.ParseMetadataFilter(*metadata)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	failOnSeverity := ""
+	if *failOn != "" {
+		failOnSeverity, err = apirunner.ParseFailOn(*failOn)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if *plan {
+		executionPlan, err := apirunner.Plan(configFile, testDir, testFilenameMatchRegex, apirunner.RunOptions{
+			Metadata: metadataFilter,
+			ReadOnly: *readOnly,
+		})
+		if err != nil {
+			fmt.Printf("Error planning tests: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(executionPlan.Report())
+		os.Exit(0)
+	}
+	var variableExport *apirunner.VariableExportConfig
+	if *exportVars != "" {
+		variableExport = &apirunner.VariableExportConfig{File: *exportVars, Pattern: *exportVarsPattern}
+	}
+	var tapOut io.Writer
+	var tapFile *os.File
+	var output io.Writer = os.Stdout
+	if *tap == "-" {
+		// Keep stdout for TAP consumers
+		tapOut = os.Stdout
+		output = os.Stderr
+	} else if *tap != "" {
+		tapFile, err = os.Create(*tap)
+		if err != nil {
+			fmt.Printf("Error creating TAP file: %v\n", err)
+			os.Exit(1)
+		}
+		tapOut = tapFile
+	}
+	// Cancel the run on Ctrl-C or SIGTERM (e.g. a CI timeout), still printing its summary. A second
+	// signal exits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	passed, err := apirunner.RunWithContext(ctx, configFile, testDir, testFilenameMatchRegex, apirunner.RunOptions{
+		DryRun:         *dryRun,
+		Metadata:       metadataFilter,
+		FailOnSeverity: failOnSeverity,
+		Approve:        *approve,
+		ReportFile:     *report,
+		Profile:        *profile,
+		ReadOnly:       *readOnly,
+		Tap:            tapOut,
+		HtmlReportFile: *htmlReport,
+		NoCache:        *noCache,
+		Output:         output,
+		ArtifactsDir:   *artifacts,
+		MaxDiffs:       *maxDiffs,
+		VariableExport: variableExport,
+	})
+	if tapFile != nil {
+		tapFile.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(output, "Error executing tests: %v\n", err)
+		os.Exit(1)
+	}
+	if !passed {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Parses '<testDir> [testNameMatchRegex] [configFile]' args
+func parseTestArgs(args []string) (string, string, *regexp.Regexp) {
+	if len(args) < 1 || len(args) > 3 {
 		fmt.Printf("Invalid args")
 		os.Exit(1)
 	}
-	testDir := os.Args[1]
+	testDir := args[0]
 	testFilenameMatchRegex := regexp.MustCompile(".*")
-	if len(os.Args) > 2 {
+	if len(args) > 1 {
 		var err error
-		testFilenameMatchRegex, err = regexp.Compile(os.Args[2])
+		testFilenameMatchRegex, err = regexp.Compile(args[1])
 		if err != nil {
 			fmt.Printf("Invalid test name match regex: %v\n", err)
 			os.Exit(1)
@@ -40,17 +211,217 @@ func main() {
 	}
 
 	configFile := filepath.Join(testDir, "apirunner.conf")
-	if len(os.Args) == 4 {
+	if len(args) == 3 {
 		// If configFile passed in, use that
-		configFile = os.Args[3]
+		configFile = args[2]
+	}
+	return configFile, testDir, testFilenameMatchRegex
+}
+
+// Exports the test files in 'args' to 'format', writing to 'outFile' or stdout
+func export(format string, args []string, outFile string) {
+	configFile, testDir, testFilenameMatchRegex := parseTestArgs(args)
+	var out io.Writer = os.Stdout
+	if outFile != "" {
+		file, err := os.Create(outFile)
+		if err != nil {
+			fmt.Printf("Error creating %s: %v\n", outFile, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	var err error
+	switch format {
+	case "k6":
+		err = apirunner.ExportK6(configFile, testDir, testFilenameMatchRegex, out)
+	case "postman":
+		err = apirunner.ExportPostman(configFile, testDir, testFilenameMatchRegex, out)
+	case "insomnia":
+		err = apirunner.ExportInsomnia(configFile, testDir, testFilenameMatchRegex, out)
+	default:
+		err = fmt.Errorf("unsupported export format '%s'", format)
 	}
-	passed, err := apirunner.Run(configFile, testDir, testFilenameMatchRegex)
 	if err != nil {
-		fmt.Printf("Error executing tests: %v\n", err)
+		fmt.Printf("Error exporting tests: %v\n", err)
 		os.Exit(1)
 	}
-	if !passed {
+}
+
+// Imports the tests in 'file' of type 'format' into test files in 'testDir'
+func importTests(format string, file string, testDir string) {
+	var written []string
+	var err error
+	switch format {
+	case "insomnia":
+		written, err = apirunner.ImportInsomnia(file, testDir)
+	default:
+		err = fmt.Errorf("unsupported import format '%s'", format)
+	}
+	for _, path := range written {
+		fmt.Printf("Wrote '%s'\n", path)
+	}
+	if err != nil {
+		fmt.Printf("Error importing tests: %v\n", err)
 		os.Exit(1)
 	}
-	os.Exit(0)
+}
+
+// Benchmarks a single test and prints its latency distribution and error rate
+func bench(args []string) {
+	benchFlags := flag.NewFlagSet("bench", flag.ExitOnError)
+	testName := benchFlags.String("run", "", "name of the test to benchmark")
+	requests := benchFlags.Int("n", 100, "number of times to execute the test")
+	concurrency := benchFlags.Int("c", 1, "number of concurrent executions")
+	benchFlags.Parse(args)
+	if *testName == "" {
+		fmt.Printf("Invalid args, -run is required")
+		os.Exit(1)
+	}
+
+	configFile, testDir, testFilenameMatchRegex := parseTestArgs(benchFlags.Args())
+	result, err := apirunner.Bench(configFile, testDir, testFilenameMatchRegex, apirunner.BenchOptions{
+		Test:        *testName,
+		Requests:    *requests,
+		Concurrency: *concurrency,
+	})
+	if err != nil {
+		fmt.Printf("Error running benchmark: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(result.Report())
+}
+
+// Loops test suites for a duration and returns true if the error rate stayed within the failure budget
+func soak(args []string) bool {
+	soakFlags := flag.NewFlagSet("soak", flag.ExitOnError)
+	duration := soakFlags.Duration("duration", 0, "how long to keep looping the test suites for, e.g. 30m")
+	budget := soakFlags.Float64("budget", 0, "maximum percentage of failed tests, e.g. 0.5")
+	interval := soakFlags.Duration("interval", 0, "pause between iterations")
+	soakFlags.Parse(args)
+	if *duration <= 0 {
+		fmt.Printf("Invalid args, -duration is required")
+		os.Exit(1)
+	}
+
+	configFile, testDir, testFilenameMatchRegex := parseTestArgs(soakFlags.Args())
+	result, err := apirunner.Soak(configFile, testDir, testFilenameMatchRegex, apirunner.SoakOptions{
+		Duration:      *duration,
+		FailureBudget: *budget / 100,
+		Interval:      *interval,
+	})
+	if err != nil {
+		fmt.Printf("Error running soak: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(result.Report())
+	return result.WithinBudget()
+}
+
+// Rewrites test files to the latest format version and prints notes on changes in behavior
+func migrate(args []string) {
+	migrateFlags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := migrateFlags.Bool("dry-run", false, "only print the migrations without rewriting any test files")
+	migrateFlags.Parse(args)
+	if migrateFlags.NArg() < 1 || migrateFlags.NArg() > 2 {
+		fmt.Printf("Invalid args")
+		os.Exit(1)
+	}
+
+	testFilenameMatchRegex := regexp.MustCompile(".*")
+	if migrateFlags.NArg() == 2 {
+		var err error
+		testFilenameMatchRegex, err = regexp.Compile(migrateFlags.Arg(1))
+		if err != nil {
+			fmt.Printf("Invalid test name match regex: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	results, err := apirunner.Migrate(migrateFlags.Arg(0), testFilenameMatchRegex, apirunner.MigrateOptions{DryRun: *dryRun})
+	verb := "Migrated"
+	if *dryRun {
+		verb = "Would migrate"
+	}
+	for _, result := range results {
+		fmt.Printf("%s '%s' from version %d to %d\n", verb, result.TestFilename, result.FromVersion, result.ToVersion)
+		for _, note := range result.Notes {
+			fmt.Printf("\t%s\n", note)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error migrating test files: %v\n", err)
+		os.Exit(1)
+	}
+	if len(results) == 0 {
+		fmt.Println("All test files are up to date")
+	}
+}
+
+// Deletes leftover test resources and returns false if any couldn't be listed or deleted
+func gc(args []string) bool {
+	gcFlags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := gcFlags.Bool("dry-run", false, "only list the resources that would be deleted")
+	gcFlags.Parse(args)
+	if gcFlags.NArg() < 1 || gcFlags.NArg() > 2 {
+		fmt.Printf("Invalid args")
+		os.Exit(1)
+	}
+
+	gcFile := gcFlags.Arg(0)
+	configFile := filepath.Join(filepath.Dir(gcFile), "apirunner.conf")
+	if gcFlags.NArg() == 2 {
+		configFile = gcFlags.Arg(1)
+	}
+	result, err := apirunner.GC(configFile, gcFile, apirunner.GCOptions{DryRun: *dryRun})
+	if err != nil {
+		fmt.Printf("Error running gc: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(result.Report())
+	return len(result.Errors) == 0
+}
+
+// Diagnoses the environment targeted by a config file and returns false if any check failed
+func doctor(args []string) bool {
+	if len(args) > 1 {
+		fmt.Printf("Invalid args")
+		os.Exit(1)
+	}
+	configFile := "apirunner.conf"
+	if len(args) == 1 {
+		configFile = args[0]
+	}
+	result := apirunner.Doctor(configFile)
+	fmt.Print(result.Report())
+	return result.Passed()
+}
+
+// Compares two JSON reports and returns false if any test is newly failing
+func diffReports(args []string) bool {
+	diffFlags := flag.NewFlagSet("diff", flag.ExitOnError)
+	slowerRatio := diffFlags.Float64("slower", 1.5, "minimum ratio of new to old duration for a test to count as significantly slower")
+	slowerMinMs := diffFlags.Float64("slower-min", 100, "minimum increase in duration (in ms) for a test to count as significantly slower")
+	diffFlags.Parse(args)
+	if diffFlags.NArg() != 2 {
+		fmt.Printf("Invalid args")
+		os.Exit(1)
+	}
+
+	oldReport, err := apirunner.LoadRunReport(diffFlags.Arg(0))
+	if err != nil {
+		fmt.Printf("Error loading report: %v\n", err)
+		os.Exit(1)
+	}
+	newReport, err := apirunner.LoadRunReport(diffFlags.Arg(1))
+	if err != nil {
+		fmt.Printf("Error loading report: %v\n", err)
+		os.Exit(1)
+	}
+	diff := apirunner.DiffRunReports(oldReport, newReport, apirunner.ReportDiffOptions{
+		SlowerRatio: *slowerRatio,
+		SlowerMinMs: *slowerMinMs,
+	})
+	fmt.Print(diff.Report())
+	return !diff.Regressed()
 }
@@ -0,0 +1,107 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// A parsed and validated suite with its regexes compiled, ready to be executed
+type compiledSuite struct {
+	fileName      string
+	spec          TestSuiteSpec
+	ignoredFields []ignoredField
+	// Extraction regexes keyed by their source
+	extractionRegexes map[string]*regexp.Regexp
+}
+
+// Parses and validates the suite in 'testFilename', compiling its ignored fields and checking its
+// extraction regexes and assert expressions so that invalid suites are reported before any request is made
+func compileSuite(fsys fs.FS, testFilename string) (compiledSuite, error) {
+	spec, err := loadSuiteSpec(fsys, testFilename)
+	if err != nil {
+		return compiledSuite{}, err
+	}
+	ignoredFields, err := parseIgnoredFields(spec.IgnoredFields)
+	if err != nil {
+		return compiledSuite{}, errors.Wrap(err, fmt.Sprintf("invalid ignoredFields in %s", testFilename))
+	}
+	extractionRegexes := make(map[string]*regexp.Regexp)
+	for _, test := range spec.Tests {
+		for varName, extraction := range test.Extract {
+			if extraction.Regex == "" {
+				continue
+			}
+			if _, ok := extractionRegexes[extraction.Regex]; ok {
+				continue
+			}
+			extractionRegexes[extraction.Regex], err = regexp.Compile(extraction.Regex)
+			if err != nil {
+				return compiledSuite{}, errors.Wrap(err, fmt.Sprintf("invalid regex for '%s' in test '%s' of %s", varName, test.Name, testFilename))
+			}
+		}
+		for _, assertion := range append(append([]string{}, test.Assert...), test.SoftAssert...) {
+			_, err = parseExpr(assertion)
+			if err != nil {
+				return compiledSuite{}, errors.Wrap(err, fmt.Sprintf("invalid assertion '%s' in test '%s' of %s", assertion, test.Name, testFilename))
+			}
+		}
+		_, err = parseIgnoredFields(test.ExpectedResponse.SoftFields)
+		if err != nil {
+			return compiledSuite{}, errors.Wrap(err, fmt.Sprintf("invalid softFields in test '%s' of %s", test.Name, testFilename))
+		}
+		for profile, override := range test.ExpectedResponseOverrides {
+			_, err = parseIgnoredFields(override.SoftFields)
+			if err != nil {
+				return compiledSuite{}, errors.Wrap(err, fmt.Sprintf("invalid softFields in '%s' override of test '%s' of %s", profile, test.Name, testFilename))
+			}
+		}
+	}
+	return compiledSuite{
+		fileName:          testFilename,
+		spec:              spec,
+		ignoredFields:     ignoredFields,
+		extractionRegexes: extractionRegexes,
+	}, nil
+}
+
+// Compiles all suites in 'testFiles' in parallel, returning the compiled suites in the same order
+// and an error for each suite that failed to compile
+func compileSuites(fsys fs.FS, testFiles []string) ([]compiledSuite, []error) {
+	suites := make([]compiledSuite, len(testFiles))
+	suiteErrors := make([]error, len(testFiles))
+	var wg sync.WaitGroup
+	for i, testFile := range testFiles {
+		wg.Add(1)
+		go func(i int, testFile string) {
+			defer wg.Done()
+			suites[i], suiteErrors[i] = compileSuite(fsys, testFile)
+		}(i, testFile)
+	}
+	wg.Wait()
+
+	errs := make([]error, 0)
+	for _, err := range suiteErrors {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return suites, errs
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+)
+
+// Returns the conditional request headers (If-None-Match and If-Modified-Since) for revalidating the
+// response of the earlier test 'testName', based on its (memoized) ETag and Last-Modified headers.
+func conditionalHeaders(testName string, extractedFields map[string]interface{}) (map[string]string, error) {
+	headers := make(map[string]string)
+	if etag, ok := extractedFields[testName+".header.Etag"]; ok {
+		headers["If-None-Match"] = fmt.Sprint(etag)
+	}
+	if lastModified, ok := extractedFields[testName+".header.Last-Modified"]; ok {
+		headers["If-Modified-Since"] = fmt.Sprint(lastModified)
+	}
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("response of test '%s' has no ETag or Last-Modified header to make a conditional request on", testName)
+	}
+	return headers, nil
+}
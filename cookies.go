@@ -0,0 +1,71 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"net/http"
+	"time"
+)
+
+// Returns the cookies set by a response (via Set-Cookie), keyed by name, with their value and attributes,
+// e.g. for asserting on security attributes: {"value": "abc", "secure": true, "httpOnly": true, "sameSite": "Strict", ...}
+func responseCookies(resp *http.Response) map[string]interface{} {
+	cookies := make(map[string]interface{})
+	for _, cookie := range resp.Cookies() {
+		expires := ""
+		if !cookie.Expires.IsZero() {
+			expires = cookie.Expires.UTC().Format(time.RFC3339)
+		}
+		cookies[cookie.Name] = map[string]interface{}{
+			"name":     cookie.Name,
+			"value":    cookie.Value,
+			"domain":   cookie.Domain,
+			"path":     cookie.Path,
+			"expires":  expires,
+			"maxAge":   float64(cookie.MaxAge),
+			"secure":   cookie.Secure,
+			"httpOnly": cookie.HttpOnly,
+			"sameSite": sameSiteName(cookie.SameSite),
+		}
+	}
+	return cookies
+}
+
+// Returns the template vars for the cookies set by a response: the cookie's value keyed by its name
+// (e.g. 'session') and each of its attributes keyed by the name and attribute (e.g. 'session.httpOnly').
+func cookieFields(cookies map[string]interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for name, cookie := range cookies {
+		attributes := cookie.(map[string]interface{})
+		fields[name] = attributes["value"]
+		for attribute, value := range attributes {
+			fields[name+"."+attribute] = value
+		}
+	}
+	return fields
+}
+
+func sameSiteName(sameSite http.SameSite) string {
+	switch sameSite {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	default:
+		return ""
+	}
+}
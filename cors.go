@@ -0,0 +1,78 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CORS preflight to send instead of a test's request: an OPTIONS request to the test's url from Origin,
+// announcing the Method and Headers of the actual request. The Access-Control-Allow-* headers of the response
+// must allow the origin, method and headers.
+type Preflight struct {
+	Origin  string   `json:"origin"`
+	Method  string   `json:"method"`
+	Headers []string `json:"headers"`
+}
+
+// Sets the preflight headers on 'req', templating the origin
+func (suite TestSuite) setPreflightHeaders(req *http.Request, preflight Preflight, extractedFields map[string]interface{}) error {
+	origin, err := suite.templateRequestValue(preflight.Origin, extractedFields)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", preflight.Method)
+	if len(preflight.Headers) > 0 {
+		req.Header.Set("Access-Control-Request-Headers", strings.Join(preflight.Headers, ", "))
+	}
+	return nil
+}
+
+// Returns why the preflight response 'resp' to 'req' doesn't allow the preflight's origin, method or headers
+func preflightMismatches(preflight Preflight, req *http.Request, resp *http.Response) []string {
+	mismatches := make([]string, 0)
+	origin := req.Header.Get("Origin")
+	if allowOrigin := resp.Header.Get("Access-Control-Allow-Origin"); allowOrigin != origin && allowOrigin != "*" {
+		mismatches = append(mismatches, fmt.Sprintf("Expected Access-Control-Allow-Origin to allow origin %s but got '%s'", origin, allowOrigin))
+	}
+	allowMethods := resp.Header.Values("Access-Control-Allow-Methods")
+	if !corsListContains(allowMethods, preflight.Method, false) {
+		mismatches = append(mismatches, fmt.Sprintf("Expected Access-Control-Allow-Methods to allow method %s but got '%s'", preflight.Method, strings.Join(allowMethods, ", ")))
+	}
+	allowHeaders := resp.Header.Values("Access-Control-Allow-Headers")
+	for _, header := range preflight.Headers {
+		if !corsListContains(allowHeaders, header, true) {
+			mismatches = append(mismatches, fmt.Sprintf("Expected Access-Control-Allow-Headers to allow header %s but got '%s'", header, strings.Join(allowHeaders, ", ")))
+		}
+	}
+	return mismatches
+}
+
+// Returns whether the comma-separated lists of header values 'values' contain 'value' or the wildcard '*'.
+// Methods are case-sensitive, header names aren't.
+func corsListContains(values []string, value string, ignoreCase bool) bool {
+	for _, list := range values {
+		for _, item := range strings.Split(list, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || item == value || (ignoreCase && strings.EqualFold(item, value)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tracking of deprecated response fields to drive their sunsetting. Responses containing any of Fields
+// (specified like ignoredFields, e.g. "legacyId" or "items.*.oldStatus") are reported as soft failures of
+// their test, or fail it if Fail is set, and all occurrences are summarized at the end of the run.
+type DeprecationConfig struct {
+	Fields []string `json:"fields"`
+	Fail   bool     `json:"fail"`
+}
+
+// Returns the (dotted) paths of all fields of 'v' matching one of 'fields'
+func findFields(v interface{}, fields []ignoredField, path []string) []string {
+	found := make([]string, 0)
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			childPath := append(path[:len(path):len(path)], k)
+			if isIgnored(fields, childPath) {
+				found = append(found, strings.Join(childPath, "."))
+				continue
+			}
+			found = append(found, findFields(val[k], fields, childPath)...)
+		}
+	case []interface{}:
+		for i, child := range val {
+			found = append(found, findFields(child, fields, append(path[:len(path):len(path)], strconv.Itoa(i)))...)
+		}
+	}
+	return found
+}
+
+// Prints the deprecated fields found in responses during a run, with the tests whose responses contained them
+func printDeprecatedFields(out io.Writer, results []TestSuiteResult) {
+	testsByField := make(map[string][]string)
+	for _, result := range results {
+		for _, tests := range [][]TestResult{result.Passed, result.Failed, result.Quarantined, result.Warnings} {
+			for _, test := range tests {
+				for _, field := range test.DeprecatedFields {
+					testsByField[field] = append(testsByField[field], fmt.Sprintf("'%s' %s", result.TestFilename, test.Name))
+				}
+			}
+		}
+	}
+	if len(testsByField) == 0 {
+		return
+	}
+	fields := make([]string, 0, len(testsByField))
+	for field := range testsByField {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	fmt.Fprintf(out, "\n* Deprecated fields in responses:\n")
+	for _, field := range fields {
+		fmt.Fprintf(out, "%s (%d occurrence(s)):\n", field, len(testsByField[field]))
+		for _, test := range testsByField[field] {
+			fmt.Fprintf(out, "\t%s\n", test)
+		}
+	}
+}
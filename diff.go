@@ -0,0 +1,243 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of differences between an actual and expected value
+type DifferenceKind string
+
+const (
+	// The values differ
+	DifferenceChanged DifferenceKind = "changed"
+	// The values are of different JSON types
+	DifferenceType DifferenceKind = "type"
+	// An expected field or array element is missing
+	DifferenceMissing DifferenceKind = "missing"
+	// A field or array element is present but not expected
+	DifferenceUnexpected DifferenceKind = "unexpected"
+	// A matcher didn't match the actual value
+	DifferenceMatcher DifferenceKind = "matcher"
+)
+
+// A difference between an actual and expected (JSON) value
+type Difference struct {
+	// Path of the value, e.g. "map[users].slice[0].map[id]" ("" for the root). Failure messages list
+	// differences ordered by path, so they read the same from run to run
+	Path     string
+	Kind     DifferenceKind
+	Expected interface{}
+	Actual   interface{}
+	// Description of the mismatch, for matcher differences
+	Message string
+}
+
+// String returns a description of the difference prefixed with its path
+func (diff Difference) String() string {
+	return fmt.Sprintf("%s: %s", pathOrRoot(diff.Path), diff.describe())
+}
+
+func (diff Difference) describe() string {
+	switch diff.Kind {
+	case DifferenceMatcher:
+		return diff.Message
+	case DifferenceMissing:
+		if diff.Expected == nil {
+			return "field is missing but expected null"
+		}
+		return fmt.Sprintf("field is missing but expected %v", diff.Expected)
+	case DifferenceUnexpected:
+		if diff.Actual == nil {
+			return "field is null but expected it to be absent"
+		}
+		return fmt.Sprintf("unexpected field with value %v", diff.Actual)
+	case DifferenceType:
+		if diff.Expected == nil {
+			return fmt.Sprintf("expected null but got %v", diff.Actual)
+		}
+		if diff.Actual == nil {
+			return fmt.Sprintf("field is null but expected %v", diff.Expected)
+		}
+		return fmt.Sprintf("expected %s %v but got %s %v", jsonTypeName(diff.Expected), diff.Expected, jsonTypeName(diff.Actual), diff.Actual)
+	default:
+		return fmt.Sprintf("%v != %v", diff.Actual, diff.Expected)
+	}
+}
+
+// Returns a difference for a matcher at 'path' that didn't match
+func matcherDifference(path string, message string) Difference {
+	return Difference{Path: path, Kind: DifferenceMatcher, Message: message}
+}
+
+// Returns the descriptions of 'diffs'
+func differenceStrings(diffs []Difference) []string {
+	res := make([]string, 0, len(diffs))
+	for _, diff := range diffs {
+		res = append(res, diff.String())
+	}
+	return res
+}
+
+// Sorts 'diffs' by path (see comparePaths), keeping the order of differences at the same path, so
+// failure messages are the same from run to run
+func sortDifferences(diffs []Difference) {
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return comparePaths(diffs[i].Path, diffs[j].Path) < 0
+	})
+}
+
+// Compares two difference paths segment by segment: object keys alphabetically, array indexes
+// numerically (so "slice[2]" comes before "slice[10]") and parents before their children
+func comparePaths(a string, b string) int {
+	segmentsA, segmentsB := pathSegments(a), pathSegments(b)
+	for i := 0; i < len(segmentsA) && i < len(segmentsB); i++ {
+		// Only "slice[...]" segments parse as indexes, "map[...]" ones compare as strings
+		indexA, errA := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(segmentsA[i], "slice["), "]"))
+		indexB, errB := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(segmentsB[i], "slice["), "]"))
+		if errA == nil && errB == nil {
+			if indexA != indexB {
+				return indexA - indexB
+			}
+		} else if c := strings.Compare(segmentsA[i], segmentsB[i]); c != 0 {
+			return c
+		}
+	}
+	return len(segmentsA) - len(segmentsB)
+}
+
+// Splits a difference path into its "map[key]" and "slice[index]" segments
+func pathSegments(path string) []string {
+	segments := make([]string, 0)
+	for path != "" {
+		// Keys may contain dots, so segments end at the next "]." (or the end of the path)
+		end := strings.Index(path, "].")
+		if end < 0 {
+			segments = append(segments, path)
+			break
+		}
+		segments = append(segments, path[:end+1])
+		path = path[end+2:]
+	}
+	return segments
+}
+
+// Deep compares the (JSON) values 'actual' and 'expected', returning their differences ordered by path
+func diffValues(actual interface{}, expected interface{}, path string) []Difference {
+	diffs := make([]Difference, 0)
+	switch expectedVal := expected.(type) {
+	case map[string]interface{}:
+		actualMap, ok := actual.(map[string]interface{})
+		if !ok {
+			return append(diffs, Difference{Path: path, Kind: DifferenceType, Expected: expected, Actual: actual})
+		}
+		keys := make([]string, 0, len(expectedVal)+len(actualMap))
+		for k := range expectedVal {
+			keys = append(keys, k)
+		}
+		for k := range actualMap {
+			if _, ok := expectedVal[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			childPath := fmt.Sprintf("%smap[%s]", pathPrefix(path), k)
+			expectedChild, expectedPresent := expectedVal[k]
+			actualChild, actualPresent := actualMap[k]
+			switch {
+			case !actualPresent:
+				diffs = append(diffs, Difference{Path: childPath, Kind: DifferenceMissing, Expected: expectedChild})
+			case !expectedPresent:
+				diffs = append(diffs, Difference{Path: childPath, Kind: DifferenceUnexpected, Actual: actualChild})
+			default:
+				diffs = append(diffs, diffValues(actualChild, expectedChild, childPath)...)
+			}
+		}
+		return diffs
+	case []interface{}:
+		actualSlice, ok := actual.([]interface{})
+		if !ok {
+			return append(diffs, Difference{Path: path, Kind: DifferenceType, Expected: expected, Actual: actual})
+		}
+		for i := 0; i < len(expectedVal) || i < len(actualSlice); i++ {
+			childPath := fmt.Sprintf("%sslice[%d]", pathPrefix(path), i)
+			switch {
+			case i >= len(actualSlice):
+				diffs = append(diffs, Difference{Path: childPath, Kind: DifferenceMissing, Expected: expectedVal[i]})
+			case i >= len(expectedVal):
+				diffs = append(diffs, Difference{Path: childPath, Kind: DifferenceUnexpected, Actual: actualSlice[i]})
+			default:
+				diffs = append(diffs, diffValues(actualSlice[i], expectedVal[i], childPath)...)
+			}
+		}
+		return diffs
+	default:
+		if jsonTypeName(actual) != jsonTypeName(expected) {
+			return append(diffs, Difference{Path: path, Kind: DifferenceType, Expected: expected, Actual: actual})
+		}
+		if actualNum, ok := jsonNumber(actual); ok {
+			actual = actualNum
+			expected, _ = jsonNumber(expected)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			diffs = append(diffs, Difference{Path: path, Kind: DifferenceChanged, Expected: expected, Actual: actual})
+		}
+		return diffs
+	}
+}
+
+// Returns the JSON type of 'v'
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, float32, int, int64, int32:
+		return "number"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// Returns the number 'v' as a float64, or false if it isn't a number
+func jsonNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxClockSkewMs = 30000
+	// Timeout of each of the doctor's network checks
+	doctorTimeout = 10 * time.Second
+	// Certificates expiring sooner than this are reported as warnings
+	certExpiryWarning = 14 * 24 * time.Hour
+)
+
+// Options for `apirunner doctor`
+type DoctorConfig struct {
+	// Request checking that the configured credentials are accepted (expects a 2xx response)
+	Probe *Request `json:"probe"`
+	// Maximum difference between the local clock and the server's in milliseconds (default 30000)
+	MaxClockSkewMs int `json:"maxClockSkewMs"`
+}
+
+// Outcome of a doctor check
+type DoctorStatus string
+
+const (
+	DoctorOk      DoctorStatus = "ok"
+	DoctorWarning DoctorStatus = "warning"
+	DoctorFailed  DoctorStatus = "failed"
+	DoctorSkipped DoctorStatus = "skipped"
+)
+
+// Result of a single doctor check, with a hint on how to fix it if it didn't pass
+type DoctorCheck struct {
+	Name    string
+	Status  DoctorStatus
+	Message string
+	Hint    string
+}
+
+// Results of `apirunner doctor`
+type DoctorResult struct {
+	Checks []DoctorCheck
+}
+
+// Passed returns false if any check failed (warnings don't fail the diagnosis)
+func (result DoctorResult) Passed() bool {
+	for _, check := range result.Checks {
+		if check.Status == DoctorFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// Report returns a human-readable summary of the checks
+func (result DoctorResult) Report() string {
+	var report strings.Builder
+	for _, check := range result.Checks {
+		status := strings.ToUpper(string(check.Status))
+		switch check.Status {
+		case DoctorFailed:
+			status = fmt.Sprintf(ErrorString, status)
+		case DoctorWarning:
+			status = fmt.Sprintf(WarningString, status)
+		}
+		fmt.Fprintf(&report, "%s %s: %s\n", status, check.Name, check.Message)
+		if check.Hint != "" {
+			fmt.Fprintf(&report, "\t%s\n", check.Hint)
+		}
+	}
+	return report.String()
+}
+
+// Doctor diagnoses problems with the environment the RunConfig in 'runConfigFilename' targets: invalid config,
+// DNS and TLS connectivity to its base url, rejected credentials (if a doctor.probe request is configured)
+// and clock skew. Checks that depend on a failed check are skipped.
+func Doctor(runConfigFilename string) DoctorResult {
+	result := DoctorResult{Checks: make([]DoctorCheck, 0)}
+	check := func(name string, status DoctorStatus, message string, hint string) {
+		result.Checks = append(result.Checks, DoctorCheck{Name: name, Status: status, Message: message, Hint: hint})
+	}
+	skipRest := func(failed string, names ...string) DoctorResult {
+		for _, name := range names {
+			check(name, DoctorSkipped, fmt.Sprintf("%s check failed", failed), "")
+		}
+		return result
+	}
+
+	// Config
+	config, err := loadRunConfig(nil, runConfigFilename)
+	if err == nil {
+		// Nothing is recorded or traced while diagnosing
+		config.Pact = nil
+		config.Tracing = nil
+		var closeRun func()
+		config, closeRun, err = prepareRun(config)
+		if err == nil {
+			defer closeRun()
+		}
+	}
+	if err != nil {
+		check("config", DoctorFailed, err.Error(), fmt.Sprintf("Fix %s", runConfigFilename))
+		return skipRest("config", "dns", "tls", "http", "auth", "clock")
+	}
+	suite, closeSuite, err := prepareTestSuite(config, compiledSuite{fileName: "doctor"})
+	if err != nil {
+		check("config", DoctorFailed, err.Error(), fmt.Sprintf("Fix %s", runConfigFilename))
+		return skipRest("config", "dns", "tls", "http", "auth", "clock")
+	}
+	defer closeSuite()
+	baseUrl, err := url.Parse(config.BaseUrl)
+	if err != nil || baseUrl.Host == "" {
+		check("config", DoctorFailed, fmt.Sprintf("invalid baseUrl '%s'", config.BaseUrl), fmt.Sprintf("Set baseUrl in %s to an absolute url, e.g. https://api.example.com", runConfigFilename))
+		return skipRest("config", "dns", "tls", "http", "auth", "clock")
+	}
+	if field := unknownConfigField(runConfigFilename); field != "" {
+		check("config", DoctorWarning, fmt.Sprintf("loaded %s, which has unknown field %s", runConfigFilename, field), "Check the field's name for typos, it's ignored")
+	} else {
+		check("config", DoctorOk, fmt.Sprintf("loaded %s", runConfigFilename), "")
+	}
+
+	// DNS & TLS (checked directly unless requests are sent via a proxy)
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	if config.Transport != nil && config.Transport.Proxy != "" {
+		check("dns", DoctorSkipped, fmt.Sprintf("requests are sent via proxy %s", config.Transport.Proxy), "")
+		check("tls", DoctorSkipped, fmt.Sprintf("requests are sent via proxy %s", config.Transport.Proxy), "")
+	} else {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, baseUrl.Hostname())
+		if err != nil {
+			check("dns", DoctorFailed, err.Error(), "Check the host in baseUrl and that you're on the right network (e.g. VPN)")
+			return skipRest("dns", "tls", "http", "auth", "clock")
+		}
+		check("dns", DoctorOk, fmt.Sprintf("%s resolves to %s", baseUrl.Hostname(), strings.Join(addrs, ", ")), "")
+		if baseUrl.Scheme == "https" {
+			status, message, hint := checkTls(ctx, config, baseUrl)
+			check("tls", status, message, hint)
+			if status == DoctorFailed {
+				return skipRest("tls", "http", "auth", "clock")
+			}
+		} else {
+			check("tls", DoctorSkipped, "baseUrl isn't https", "")
+		}
+	}
+
+	// HTTP
+	start := time.Now()
+	resp, _, err := suite.sendRequest(TestSpec{Name: "doctor", Request: Request{Method: http.MethodGet}}, map[string]interface{}{})
+	if err != nil {
+		check("http", DoctorFailed, err.Error(), "Check that the API is running and reachable at baseUrl")
+		return skipRest("http", "auth", "clock")
+	}
+	if resp.StatusCode >= 500 {
+		check("http", DoctorWarning, fmt.Sprintf("GET %s responded with http %d", config.BaseUrl, resp.StatusCode), "The API (or a load balancer in front of it) may be unhealthy")
+	} else {
+		check("http", DoctorOk, fmt.Sprintf("GET %s responded with http %d in %s", config.BaseUrl, resp.StatusCode, time.Since(start).Round(time.Millisecond)), "")
+	}
+
+	// Auth
+	if config.Doctor == nil || config.Doctor.Probe == nil {
+		check("auth", DoctorSkipped, "no doctor.probe request configured", "")
+	} else {
+		probe := *config.Doctor.Probe
+		if probe.Method == "" {
+			probe.Method = http.MethodGet
+		}
+		probeResp, body, err := suite.sendRequest(TestSpec{Name: "probe", Request: probe}, map[string]interface{}{})
+		switch {
+		case err != nil:
+			check("auth", DoctorFailed, err.Error(), "Check the auth config (e.g. the token endpoint and its credentials)")
+		case probeResp.StatusCode == http.StatusUnauthorized || probeResp.StatusCode == http.StatusForbidden:
+			check("auth", DoctorFailed, fmt.Sprintf("probe %s %s was rejected with http %d: %s", probe.Method, probe.Url, probeResp.StatusCode, truncate(string(body), 200)), "Check the credentials in auth or headers, they may be expired or for another environment")
+		case probeResp.StatusCode < 200 || probeResp.StatusCode > 299:
+			check("auth", DoctorFailed, fmt.Sprintf("probe %s %s responded with http %d: %s", probe.Method, probe.Url, probeResp.StatusCode, truncate(string(body), 200)), "Check the probe request in doctor.probe")
+		default:
+			check("auth", DoctorOk, fmt.Sprintf("probe %s %s responded with http %d", probe.Method, probe.Url, probeResp.StatusCode), "")
+		}
+	}
+
+	// Clock
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		check("clock", DoctorSkipped, "response has no Date header", "")
+		return result
+	}
+	maxSkew := time.Duration(defaultMaxClockSkewMs) * time.Millisecond
+	if config.Doctor != nil && config.Doctor.MaxClockSkewMs > 0 {
+		maxSkew = time.Duration(config.Doctor.MaxClockSkewMs) * time.Millisecond
+	}
+	// The Date header has a resolution of a second, so compare it to the time the request was sent
+	skew := start.Truncate(time.Second).Sub(serverTime)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxSkew {
+		check("clock", DoctorFailed, fmt.Sprintf("local clock is %s off the server's", skew.Round(time.Second)), "Sync the local clock (e.g. via NTP), skew breaks signed requests, token expiry and testTime")
+	} else {
+		check("clock", DoctorOk, fmt.Sprintf("local clock is within %s of the server's", maxSkew), "")
+	}
+	return result
+}
+
+// Returns the status of a TLS handshake with the host of 'baseUrl' using the config's transport
+func checkTls(ctx context.Context, config RunConfig, baseUrl *url.URL) (DoctorStatus, string, string) {
+	transport, err := newTransport(config.FS, config.Transport)
+	if err != nil {
+		return DoctorFailed, err.Error(), "Fix transport in config"
+	}
+	tlsConfig := &tls.Config{}
+	if transport.TLSClientConfig != nil {
+		tlsConfig = transport.TLSClientConfig.Clone()
+	}
+	tlsConfig.ServerName = baseUrl.Hostname()
+	port := baseUrl.Port()
+	if port == "" {
+		port = "443"
+	}
+	dialer := tls.Dialer{Config: tlsConfig}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(baseUrl.Hostname(), port))
+	if err != nil {
+		if strings.Contains(err.Error(), "x509") || strings.Contains(err.Error(), "certificate") {
+			return DoctorFailed, err.Error(), "Set transport.caFile to the CA that signed the server's certificate (or transport.insecureSkipVerify for local environments)"
+		}
+		return DoctorFailed, err.Error(), "Check that the API is running and reachable at baseUrl (e.g. firewall rules)"
+	}
+	defer conn.Close()
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) > 0 && time.Until(certs[0].NotAfter) < certExpiryWarning {
+		return DoctorWarning, fmt.Sprintf("certificate of %s expires %s", baseUrl.Hostname(), certs[0].NotAfter.Format(time.RFC3339)), "Renew the server's certificate"
+	}
+	return DoctorOk, fmt.Sprintf("handshake with %s succeeded", baseUrl.Host), ""
+}
+
+// Returns the first field of the config in 'runConfigFilename' that isn't part of RunConfig, or "" if there's none
+func unknownConfigField(runConfigFilename string) string {
+	contents, err := os.ReadFile(runConfigFilename)
+	if err != nil {
+		return ""
+	}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	var config RunConfig
+	err = decoder.Decode(&config)
+	if field, found := strings.CutPrefix(fmt.Sprint(err), "json: unknown field "); found {
+		return field
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Returns an error describing the first JSON object in 'contents' with a duplicate key and where it
+// is (e.g. 'tests[1].expectedResponse.body.id'), or nil if there's none. encoding/json silently keeps
+// only the last value of a duplicate key, dropping whatever the others asserted. Expects 'contents' to
+// already be valid JSON.
+func findDuplicateKey(contents []byte) error {
+	return findDuplicateKeyIn(json.NewDecoder(bytes.NewReader(contents)), "")
+}
+
+func findDuplicateKeyIn(decoder *json.Decoder, path string) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil
+	}
+	switch token {
+	case json.Delim('{'):
+		keys := make(map[string]bool)
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil
+			}
+			key, _ := keyToken.(string)
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			if keys[key] {
+				return fmt.Errorf("duplicate key '%s'", keyPath)
+			}
+			keys[key] = true
+			err = findDuplicateKeyIn(decoder, keyPath)
+			if err != nil {
+				return err
+			}
+		}
+		// Closing '}'
+		_, _ = decoder.Token()
+	case json.Delim('['):
+		for i := 0; decoder.More(); i++ {
+			err = findDuplicateKeyIn(decoder, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+		// Closing ']'
+		_, _ = decoder.Token()
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Sets the process environment variables 'env' (see RunConfig.Env and TestSuiteSpec.Env), returning a
+// func that restores their previous values (or unsets them)
+func setProcessEnv(env map[string]string) (func(), error) {
+	type previousValue struct {
+		value string
+		set   bool
+	}
+	previous := make(map[string]previousValue, len(env))
+	restore := func() {
+		for name, prev := range previous {
+			if prev.set {
+				os.Setenv(name, prev.value)
+			} else {
+				os.Unsetenv(name)
+			}
+		}
+	}
+	for name, value := range env {
+		prevValue, set := os.LookupEnv(name)
+		err := os.Setenv(name, value)
+		if err != nil {
+			restore()
+			return nil, errors.Wrap(err, fmt.Sprintf("error setting environment variable '%s'", name))
+		}
+		previous[name] = previousValue{prevValue, set}
+	}
+	return restore, nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Shell command run as a setup/teardown step or in place of a test's request. Args, Env and Dir are
+// templated. Dir defaults to the test file's directory. Trimmed stdout is memoized as 'name.stdout'
+// (and its fields as 'name.field' if it's a JSON object), the exit code as 'name.exitCode'.
+// Instead of a command, a step can create a fixture using Factory (see RunConfig.Factories) with
+// Overrides of its request body, memoizing the fixture's id as 'name.id'.
+type ExecStep struct {
+	Name      string                 `json:"name"`
+	Command   string                 `json:"command"`
+	Args      []string               `json:"args"`
+	Dir       string                 `json:"dir"`
+	Env       map[string]string      `json:"env"`
+	ExitCode  int                    `json:"exitCode"`
+	Factory   string                 `json:"factory"`
+	Overrides map[string]interface{} `json:"overrides"`
+}
+
+// Runs each of 'steps' and returns the results of those that failed. Stops at the first failure if 'stopOnFailure' is set.
+func (suite TestSuite) executeSteps(steps []ExecStep, defaultName string, extractedFields map[string]interface{}, stopOnFailure bool) []TestResult {
+	failed := make([]TestResult, 0)
+	for i, step := range steps {
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("%s%d", defaultName, i+1)
+		}
+		result := suite.executeExec(step, extractedFields)
+		if result.Passed {
+			continue
+		}
+		failed = append(failed, result)
+		if stopOnFailure {
+			break
+		}
+	}
+	return failed
+}
+
+func (suite TestSuite) executeExec(step ExecStep, extractedFields map[string]interface{}) TestResult {
+	start := time.Now()
+	if step.Factory != "" {
+		id, err := suite.createFixture(step.Factory, step.Overrides, extractedFields)
+		if err != nil {
+			return Failed(step.Name, []string{err.Error()}, time.Since(start))
+		}
+		extractedFields[step.Name+".id"] = id
+		return Passed(step.Name, time.Since(start))
+	}
+	cmd, err := suite.buildCommand(step, extractedFields)
+	if err != nil {
+		return Failed(step.Name, []string{err.Error()}, time.Since(start))
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	exitCode := 0
+	err = cmd.Run()
+	if err != nil && suite.config.runContext().Err() != nil {
+		// The command was killed because the run was cancelled or the suite's deadline passed
+		result := Failed(step.Name, []string{fmt.Sprintf("Error running '%s': %v", step.Command, err)}, time.Since(start))
+		result.aborted = true
+		return result
+	}
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return Failed(step.Name, []string{fmt.Sprintf("Error running '%s': %v", step.Command, err)}, time.Since(start))
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	output := strings.TrimSpace(stdout.String())
+	extractedFields[step.Name+".stdout"] = output
+	extractedFields[step.Name+".exitCode"] = exitCode
+	var obj map[string]interface{}
+	if json.Unmarshal([]byte(output), &obj) == nil {
+		for k, v := range flatten(obj, step.Name, 0) {
+			extractedFields[k] = v
+		}
+	}
+
+	if exitCode != step.ExitCode {
+		testErrors := []string{fmt.Sprintf("Expected exit code %d but got %d", step.ExitCode, exitCode)}
+		if errOutput := strings.TrimSpace(stderr.String()); errOutput != "" {
+			testErrors = append(testErrors, fmt.Sprintf("stderr: %s", errOutput))
+		}
+		return Failed(step.Name, testErrors, time.Since(start))
+	}
+	return Passed(step.Name, time.Since(start))
+}
+
+func (suite TestSuite) buildCommand(step ExecStep, extractedFields map[string]interface{}) (*exec.Cmd, error) {
+	if step.Command == "" {
+		return nil, fmt.Errorf("exec step '%s' has no command", step.Name)
+	}
+	args := make([]string, 0, len(step.Args))
+	for _, arg := range step.Args {
+		processedArg, err := templateReplace(arg, extractedFields)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error templating args of '%s'", step.Name))
+		}
+		args = append(args, processedArg)
+	}
+	cmd := exec.CommandContext(suite.config.runContext(), step.Command, args...)
+	dir, err := templateReplace(step.Dir, extractedFields)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error templating dir of '%s'", step.Name))
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(suite.fileName), dir)
+	}
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	for k, v := range step.Env {
+		processedVal, err := templateReplace(v, extractedFields)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error templating env of '%s'", step.Name))
+		}
+		cmd.Env = append(cmd.Env, k+"="+processedVal)
+	}
+	return cmd, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+)
+
+// JS equivalent of flatten, used by exported scripts to memoize response fields under the same names
+const jsFlatten = `function flatten(obj, prefix, level, out) {
+    const key = (k) => (level === 0 && prefix !== '' ? prefix + '.' + k : k);
+    if (Array.isArray(obj)) {
+        obj.forEach((val, i) => {
+            if (val !== null && typeof val === 'object') {
+                const sep = Array.isArray(val) ? '' : '.';
+                Object.entries(flatten(val, prefix, level + 1, {})).forEach(([k, v]) => { out[key('[' + i + ']' + sep + k)] = v; });
+            } else {
+                out[key(String(i))] = val;
+            }
+        });
+    } else if (obj !== null && typeof obj === 'object') {
+        Object.entries(obj).forEach(([name, val]) => {
+            if (val !== null && typeof val === 'object') {
+                const sep = Array.isArray(val) ? '' : '.';
+                Object.entries(flatten(val, prefix, level + 1, {})).forEach(([k, v]) => { out[key(name + sep + k)] = v; });
+            } else {
+                out[key(name)] = val;
+            }
+        });
+    }
+    return out;
+}
+`
+
+// Returns 's' as a JS string literal
+func jsString(s string) string {
+	return jsValue(s)
+}
+
+// Returns 'v' as a JS literal (JSON is valid JS)
+func jsValue(v interface{}) string {
+	if m, ok := v.(map[string]string); ok && m == nil {
+		return "{}"
+	}
+	// Marshalling strings and string maps can't fail
+	bytes, _ := json.Marshal(v)
+	return string(bytes)
+}
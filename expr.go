@@ -0,0 +1,590 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A small expression language used by test assertions, e.g. "response.body.count > 0 && response.status == 200".
+// Supports number, string, boolean and null literals, variable paths (a.b[0].c), the operators
+// || && ! == != < <= > >= + - * / % and the functions len(), contains(), lower() and upper().
+
+type exprTokenKind int
+
+const (
+	tokenEOF exprTokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOp
+)
+
+type exprToken struct {
+	kind  exprTokenKind
+	value string
+}
+
+func tokenizeExpr(s string) ([]exprToken, error) {
+	tokens := make([]exprToken, 0)
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, exprToken{tokenNumber, string(runes[start:i])})
+		case c == '"' || c == '\'':
+			quote := c
+			i++
+			var sb strings.Builder
+			for i < len(runes) && runes[i] != quote {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in expression '%s'", s)
+			}
+			i++
+			tokens = append(tokens, exprToken{tokenString, sb.String()})
+		case unicode.IsLetter(c) || c == '_' || c == '$':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, exprToken{tokenIdent, string(runes[start:i])})
+		default:
+			if i+1 < len(runes) {
+				twoCharOp := string(runes[i : i+2])
+				switch twoCharOp {
+				case "&&", "||", "==", "!=", "<=", ">=":
+					tokens = append(tokens, exprToken{tokenOp, twoCharOp})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("+-*/%<>!()[].,", c) {
+				return nil, fmt.Errorf("unexpected character '%c' in expression '%s'", c, s)
+			}
+			tokens = append(tokens, exprToken{tokenOp, string(c)})
+			i++
+		}
+	}
+	return append(tokens, exprToken{tokenEOF, ""}), nil
+}
+
+// Parsed expression node
+type exprNode interface {
+	eval(env exprEnv) (interface{}, error)
+}
+
+// Resolves variable paths referenced by expressions. 'roots' holds structured values (e.g. "response"),
+// any other path is looked up by its full dotted name in 'vars'.
+type exprEnv struct {
+	roots map[string]interface{}
+	vars  map[string]interface{}
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+type pathNode struct {
+	segments []string
+}
+
+type indexNode struct {
+	target exprNode
+	index  exprNode
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	source string
+}
+
+// Parses 's' into an expression tree
+func parseExpr(s string) (exprNode, error) {
+	tokens, err := tokenizeExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	parser := &exprParser{tokens: tokens, source: s}
+	node, err := parser.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if parser.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected '%s' in expression '%s'", parser.peek().value, s)
+	}
+	return node, nil
+}
+
+// Evaluates the expression 's' in 'env'
+func evalExpr(s string, env exprEnv) (interface{}, error) {
+	node, err := parseExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	return node.eval(env)
+}
+
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *exprParser) expect(op string) error {
+	token := p.next()
+	if token.kind != tokenOp || token.value != op {
+		return fmt.Errorf("expected '%s' in expression '%s'", op, p.source)
+	}
+	return nil
+}
+
+func (p *exprParser) parseBinary(minPrecedence int) (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token := p.peek()
+		precedence, ok := binaryPrecedence[token.value]
+		if token.kind != tokenOp || !ok || precedence <= minPrecedence {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(precedence)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{token.value, left, right}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	token := p.peek()
+	if token.kind == tokenOp && (token.value == "!" || token.value == "-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{token.value, operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token := p.peek()
+		if token.kind != tokenOp {
+			return node, nil
+		}
+		switch token.value {
+		case ".":
+			p.next()
+			field := p.next()
+			if field.kind != tokenIdent && field.kind != tokenNumber {
+				return nil, fmt.Errorf("expected field name after '.' in expression '%s'", p.source)
+			}
+			// Extend plain paths so that they can be looked up by their full dotted name
+			if path, ok := node.(pathNode); ok {
+				node = pathNode{append(append([]string{}, path.segments...), field.value)}
+			} else {
+				node = indexNode{node, literalNode{field.value}}
+			}
+		case "[":
+			p.next()
+			index, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			err = p.expect("]")
+			if err != nil {
+				return nil, err
+			}
+			node = indexNode{node, index}
+		default:
+			return node, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	token := p.next()
+	switch token.kind {
+	case tokenNumber:
+		f, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' in expression '%s'", token.value, p.source)
+		}
+		return literalNode{f}, nil
+	case tokenString:
+		return literalNode{token.value}, nil
+	case tokenIdent:
+		switch token.value {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "null":
+			return literalNode{nil}, nil
+		}
+		if p.peek().kind == tokenOp && p.peek().value == "(" {
+			p.next()
+			args := make([]exprNode, 0)
+			for !(p.peek().kind == tokenOp && p.peek().value == ")") {
+				arg, err := p.parseBinary(0)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				if p.peek().kind == tokenOp && p.peek().value == "," {
+					p.next()
+				} else {
+					break
+				}
+			}
+			err := p.expect(")")
+			if err != nil {
+				return nil, err
+			}
+			return callNode{token.value, args}, nil
+		}
+		return pathNode{[]string{token.value}}, nil
+	case tokenOp:
+		if token.value == "(" {
+			node, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			err = p.expect(")")
+			if err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected '%s' in expression '%s'", token.value, p.source)
+}
+
+func (n literalNode) eval(env exprEnv) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n pathNode) eval(env exprEnv) (interface{}, error) {
+	if root, ok := env.roots[n.segments[0]]; ok {
+		val := root
+		for _, segment := range n.segments[1:] {
+			var err error
+			val, err = indexValue(val, segment)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return val, nil
+	}
+	name := strings.Join(n.segments, ".")
+	val, ok := lookupField(env.vars, name)
+	if !ok {
+		return nil, fmt.Errorf("unknown variable '%s'", name)
+	}
+	return val, nil
+}
+
+func (n indexNode) eval(env exprEnv) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return indexValue(target, index)
+}
+
+// Returns the field or element 'index' of 'val'. Missing object fields evaluate to null.
+func indexValue(val interface{}, index interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		return v[fmt.Sprint(index)], nil
+	case []interface{}:
+		var i int
+		switch idx := index.(type) {
+		case float64:
+			i = int(idx)
+		case string:
+			var err error
+			i, err = strconv.Atoi(idx)
+			if err != nil {
+				return nil, fmt.Errorf("invalid array index '%s'", idx)
+			}
+		default:
+			return nil, fmt.Errorf("invalid array index '%v'", index)
+		}
+		if i < 0 || i >= len(v) {
+			return nil, fmt.Errorf("array index %d out of range", i)
+		}
+		return v[i], nil
+	default:
+		return nil, fmt.Errorf("cannot access '%v' of %v", index, val)
+	}
+}
+
+func (n unaryNode) eval(env exprEnv) (interface{}, error) {
+	operand, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !truthy(operand), nil
+	}
+	num, ok := toNumber(operand)
+	if !ok {
+		return nil, fmt.Errorf("cannot negate %v", operand)
+	}
+	return -num, nil
+}
+
+func (n binaryNode) eval(env exprEnv) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	// Short-circuit logical operators
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return truthy(right), nil
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return truthy(right), nil
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return exprEqual(left, right), nil
+	case "!=":
+		return !exprEqual(left, right), nil
+	}
+
+	leftNum, leftIsNum := toNumber(left)
+	rightNum, rightIsNum := toNumber(right)
+	if n.op == "+" && (!leftIsNum || !rightIsNum) {
+		return fmt.Sprint(left) + fmt.Sprint(right), nil
+	}
+	if !leftIsNum || !rightIsNum {
+		leftStr, leftIsStr := left.(string)
+		rightStr, rightIsStr := right.(string)
+		if leftIsStr && rightIsStr {
+			switch n.op {
+			case "<":
+				return leftStr < rightStr, nil
+			case "<=":
+				return leftStr <= rightStr, nil
+			case ">":
+				return leftStr > rightStr, nil
+			case ">=":
+				return leftStr >= rightStr, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid operands for '%s': %v, %v", n.op, left, right)
+	}
+	switch n.op {
+	case "<":
+		return leftNum < rightNum, nil
+	case "<=":
+		return leftNum <= rightNum, nil
+	case ">":
+		return leftNum > rightNum, nil
+	case ">=":
+		return leftNum >= rightNum, nil
+	case "+":
+		return leftNum + rightNum, nil
+	case "-":
+		return leftNum - rightNum, nil
+	case "*":
+		return leftNum * rightNum, nil
+	case "/":
+		if rightNum == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return leftNum / rightNum, nil
+	case "%":
+		if rightNum == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(leftNum, rightNum), nil
+	}
+	return nil, fmt.Errorf("unknown operator '%s'", n.op)
+}
+
+func (n callNode) eval(env exprEnv) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, argNode := range n.args {
+		arg, err := argNode.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+	switch n.name {
+	case "len":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("len() takes 1 argument")
+		}
+		switch v := args[0].(type) {
+		case string:
+			return float64(len(v)), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("len() of %v", args[0])
+	case "contains":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("contains() takes 2 arguments")
+		}
+		switch v := args[0].(type) {
+		case string:
+			return strings.Contains(v, fmt.Sprint(args[1])), nil
+		case []interface{}:
+			for _, elem := range v {
+				if exprEqual(elem, args[1]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			_, ok := v[fmt.Sprint(args[1])]
+			return ok, nil
+		}
+		return nil, fmt.Errorf("contains() of %v", args[0])
+	case "lower", "upper":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() takes 1 argument", n.name)
+		}
+		if n.name == "lower" {
+			return strings.ToLower(fmt.Sprint(args[0])), nil
+		}
+		return strings.ToUpper(fmt.Sprint(args[0])), nil
+	}
+	return nil, fmt.Errorf("unknown function '%s'", n.name)
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func exprEqual(left interface{}, right interface{}) bool {
+	leftNum, leftIsNum := toNumber(left)
+	rightNum, rightIsNum := toNumber(right)
+	if leftIsNum && rightIsNum {
+		return leftNum == rightNum
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func truthy(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case float64:
+		return val != 0
+	case string:
+		return val != ""
+	case []interface{}:
+		return len(val) > 0
+	case map[string]interface{}:
+		return len(val) > 0
+	}
+	return true
+}
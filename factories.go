@@ -0,0 +1,243 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Named request template for creating a fixture (e.g. a user or org), instantiated by tests via
+// "{{ factory.user({"plan": "pro"}) }}" or by setup steps. The request's body is merged with the given
+// overrides and the created fixture's id is read from IdField (a dotted path, default "id") of the response
+// body. Instead of a full request, a factory can refer to an OpenAPI operation (OperationId) of the run's
+// openApiSpec for its method, url and body (its request example), each of which Request can override.
+type Factory struct {
+	OperationId string  `json:"operationId"`
+	Request     Request `json:"request"`
+	IdField     string  `json:"idField"`
+}
+
+// Factory instantiations of the form "{{ factory.name(overrides) }}", overrides being an optional JSON object
+var factoryRegex = regexp.MustCompile(`{{\s*factory\.([a-zA-Z0-9]+)\((.*?)\)\s*}}`)
+
+// Returns the config's factories with those referring to OpenAPI operations resolved to their requests
+func resolveFactories(config RunConfig) (map[string]Factory, error) {
+	var operations map[string]openApiOperation
+	factories := make(map[string]Factory, len(config.Factories))
+	for name, factory := range config.Factories {
+		if factory.OperationId != "" {
+			if operations == nil {
+				if config.OpenApiSpec == "" {
+					return nil, fmt.Errorf("factory '%s' refers to an OpenAPI operation but no openApiSpec is configured", name)
+				}
+				var err error
+				operations, err = loadOpenApiOperations(config.FS, config.OpenApiSpec)
+				if err != nil {
+					return nil, err
+				}
+			}
+			operation, ok := operations[factory.OperationId]
+			if !ok {
+				return nil, fmt.Errorf("factory '%s' refers to unknown OpenAPI operation '%s'", name, factory.OperationId)
+			}
+			if factory.Request.Method == "" {
+				factory.Request.Method = operation.Method
+			}
+			if factory.Request.Url == "" {
+				if strings.Contains(operation.Path, "{") {
+					return nil, fmt.Errorf("factory '%s' refers to operation '%s' with path parameters, its request.url must be set", name, factory.OperationId)
+				}
+				factory.Request.Url = operation.Path
+			}
+			if factory.Request.Body == nil {
+				factory.Request.Body = operation.Body
+			}
+		}
+		if factory.Request.Method == "" || factory.Request.Url == "" {
+			return nil, fmt.Errorf("factory '%s' must have a request method and url", name)
+		}
+		if factory.IdField == "" {
+			factory.IdField = "id"
+		}
+		factories[name] = factory
+	}
+	return factories, nil
+}
+
+// Fixtures instantiated by a request while it's built, which are replaced by placeholders until the request is
+// about to be sent, so that no fixtures are created for requests that are only previewed, skipped or refused
+type pendingFixtures struct {
+	fixtures []pendingFixture
+}
+
+type pendingFixture struct {
+	// Stands in for the fixture's id in the request, e.g. "factory-user-0"
+	placeholder string
+	factory     string
+	overrides   map[string]interface{}
+}
+
+// Replaces all factory instantiations in 's' with the ids of the fixtures they create, or with placeholders
+// if the suite's fixtures are pending (see pendingFixtures)
+func (suite TestSuite) replaceFactories(s string, extractedFields map[string]interface{}) (string, error) {
+	var err error
+	replaced := factoryRegex.ReplaceAllStringFunc(s, func(match string) string {
+		if err != nil {
+			return match
+		}
+		groups := factoryRegex.FindStringSubmatch(match)
+		var overrides map[string]interface{}
+		overrides, err = parseFactoryOverrides(groups[2])
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("invalid overrides for factory '%s'", groups[1]))
+			return match
+		}
+		if pending := suite.pendingFixtures; pending != nil {
+			if _, ok := suite.config.factories[groups[1]]; !ok {
+				err = fmt.Errorf("unknown factory '%s'", groups[1])
+				return match
+			}
+			placeholder := fmt.Sprintf("factory-%s-%d", groups[1], len(pending.fixtures))
+			pending.fixtures = append(pending.fixtures, pendingFixture{placeholder: placeholder, factory: groups[1], overrides: overrides})
+			return placeholder
+		}
+		var id string
+		id, err = suite.createFixture(groups[1], overrides, extractedFields)
+		return id
+	})
+	if err != nil {
+		return s, err
+	}
+	return replaced, nil
+}
+
+// Parses the overrides of a factory instantiation, which are escaped if it's in a (marshaled) JSON string
+func parseFactoryOverrides(s string) (map[string]interface{}, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	var overrides map[string]interface{}
+	err := json.Unmarshal([]byte(s), &overrides)
+	if err == nil {
+		return overrides, nil
+	}
+	unquoted, unquoteErr := strconv.Unquote(`"` + s + `"`)
+	if unquoteErr != nil || json.Unmarshal([]byte(unquoted), &overrides) != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// Creates a fixture using the factory 'name' with 'overrides' merged into its request body and returns its id.
+// The id and the full response are memoized as 'factory.name.id' and 'factory.name.response'.
+func (suite TestSuite) createFixture(name string, overrides map[string]interface{}, extractedFields map[string]interface{}) (string, error) {
+	factory, ok := suite.config.factories[name]
+	if !ok {
+		return "", fmt.Errorf("unknown factory '%s'", name)
+	}
+	if suite.config.DryRun {
+		return "", fmt.Errorf("factory '%s' can't create fixtures in dry-run mode", name)
+	}
+	request := factory.Request
+	if len(overrides) > 0 {
+		body, _ := request.Body.(map[string]interface{})
+		request.Body = mergeOverrides(body, overrides)
+	}
+	req, err := suite.buildRequest(TestSpec{Name: "factory." + name, Request: request}, extractedFields)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error building request of factory '%s'", name))
+	}
+	resp, err := suite.doRequest(req)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error making request of factory '%s'", name))
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error reading response of factory '%s'", name))
+	}
+	body = normalizeCharset(resp.Header.Get("Content-Type"), body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("factory '%s' got http %d: %s", name, resp.StatusCode, string(body))
+	}
+	response := responseObject(resp, body)
+	id, ok := lookupField(map[string]interface{}{"body": response["body"]}, "body."+factory.IdField)
+	if !ok || id == nil {
+		return "", fmt.Errorf("response of factory '%s' has no '%s' field: %s", name, factory.IdField, string(body))
+	}
+	extractedFields["factory."+name+".id"] = id
+	extractedFields["factory."+name+".response"] = response
+	// Ids are formatted like template values
+	return fmt.Sprint(id), nil
+}
+
+// Creates the fixtures pending for 'req' and returns a copy of it with their ids in place of their placeholders
+func (suite TestSuite) createPendingFixtures(req *http.Request, pending *pendingFixtures, extractedFields map[string]interface{}) (*http.Request, error) {
+	replacements := make([]string, 0, 2*len(pending.fixtures))
+	for _, fixture := range pending.fixtures {
+		id, err := suite.createFixture(fixture.factory, fixture.overrides, extractedFields)
+		if err != nil {
+			return nil, err
+		}
+		replacements = append(replacements, fixture.placeholder, id)
+	}
+	replacer := strings.NewReplacer(replacements...)
+	var body io.Reader = http.NoBody
+	if req.Body != http.NoBody {
+		bodyBytes, err := readRequestBody(req)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading request body")
+		}
+		body = bytes.NewBufferString(replacer.Replace(string(bodyBytes)))
+	}
+	created, err := http.NewRequestWithContext(req.Context(), req.Method, replacer.Replace(req.URL.String()), body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create request: %v", err)
+	}
+	for k, values := range req.Header {
+		for _, v := range values {
+			created.Header[k] = append(created.Header[k], replacer.Replace(v))
+		}
+	}
+	return created, nil
+}
+
+// Returns a copy of 'body' with 'overrides' (recursively) merged into it
+func mergeOverrides(body map[string]interface{}, overrides map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(body)+len(overrides))
+	for k, v := range body {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		bodyObj, bodyIsObj := merged[k].(map[string]interface{})
+		overrideObj, overrideIsObj := v.(map[string]interface{})
+		if bodyIsObj && overrideIsObj {
+			merged[k] = mergeOverrides(bodyObj, overrideObj)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// FailureCategory classifies why a test failed, for machine consumption of results
+type FailureCategory string
+
+const (
+	// The response had an unexpected status code, redirect count or final url
+	FailureStatusMismatch FailureCategory = "status-mismatch"
+	// The response body (or an assertion or callback on it) didn't match what was expected
+	FailureBodyDiff FailureCategory = "body-diff"
+	// The response headers or content type didn't match what was expected
+	FailureHeaderDiff FailureCategory = "header-diff"
+	// A template in the request or expected response couldn't be evaluated
+	FailureTemplateError FailureCategory = "template-error"
+	// The request couldn't be sent, or its response couldn't be read or wasn't the expected JSON (e.g. an
+	// HTML error page from a load balancer)
+	FailureTransportError FailureCategory = "transport-error"
+	// The request or the test ran out of time
+	FailureTimeout FailureCategory = "timeout"
+	// The response or an expected payload couldn't be parsed
+	FailureParseError FailureCategory = "parse-error"
+	// A dry run request didn't match the expected request
+	FailureRequestDiff FailureCategory = "request-diff"
+	// An exec step failed
+	FailureExecError FailureCategory = "exec-error"
+)
+
+// Returns the category of the transport error 'err'
+func transportFailureCategory(err error) FailureCategory {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return FailureTimeout
+	}
+	return FailureTransportError
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Feature flags that are on in the target environment, for skipping tests that require flags that are off
+// (see TestSpec.RequiresFlags)
+type FlagsConfig struct {
+	Enabled []string `json:"enabled"`
+	// Request discovering the flags that are on (in addition to Enabled), whose response body is either an
+	// array of flag names or an object of flag names to booleans. Not sent in dry-run mode.
+	Endpoint *Request `json:"endpoint"`
+	// Dotted path of the flags in the endpoint's response body (omit if the body is the flags)
+	Path string `json:"path"`
+}
+
+// Returns the flags that are on per 'config', fetching them from its flags endpoint if configured
+func resolveFlags(config RunConfig) (map[string]bool, error) {
+	flags := make(map[string]bool)
+	for _, flag := range config.Flags.Enabled {
+		flags[flag] = true
+	}
+	if config.Flags.Endpoint == nil || config.DryRun {
+		return flags, nil
+	}
+	// Resolved flags are set first so preparing the suite doesn't fetch them again
+	config.activeFlags = flags
+	suite, closeSuite, err := prepareTestSuite(config, compiledSuite{fileName: "flags"})
+	if err != nil {
+		return nil, err
+	}
+	defer closeSuite()
+	fetched, err := suite.fetchFlags(*config.Flags)
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching flags")
+	}
+	for _, flag := range fetched {
+		flags[flag] = true
+	}
+	return flags, nil
+}
+
+// Returns the flags that are on per the response of the flags endpoint
+func (suite TestSuite) fetchFlags(config FlagsConfig) ([]string, error) {
+	request := *config.Endpoint
+	if request.Method == "" {
+		request.Method = http.MethodGet
+	}
+	resp, body, err := suite.sendRequest(TestSpec{Name: "flags", Request: request}, map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("got http %d: %s", resp.StatusCode, string(body))
+	}
+	var parsed interface{}
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing flags response")
+	}
+	if config.Path != "" {
+		parsed, _ = lookupField(map[string]interface{}{"body": parsed}, "body."+config.Path)
+	}
+	flags := make([]string, 0)
+	switch parsed := parsed.(type) {
+	case []interface{}:
+		for _, flag := range parsed {
+			if name, ok := flag.(string); ok {
+				flags = append(flags, name)
+			}
+		}
+	case map[string]interface{}:
+		for name, on := range parsed {
+			if on == true {
+				flags = append(flags, name)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("flags response has no array or object of flags at '%s'", config.Path)
+	}
+	return flags, nil
+}
+
+// Returns the reason 'test' is skipped because one of its RequiresFlags is off (or, for "!flag", on), if any
+func (suite TestSuite) flagSkipReason(test TestSpec) string {
+	for _, required := range test.RequiresFlags {
+		if flag, negated := strings.CutPrefix(required, "!"); negated {
+			if suite.config.activeFlags[flag] {
+				return fmt.Sprintf("flag '%s' is on", flag)
+			}
+		} else if !suite.config.activeFlags[required] {
+			return fmt.Sprintf("flag '%s' is off", required)
+		}
+	}
+	return ""
+}
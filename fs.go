@@ -0,0 +1,52 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Reads the file 'name' from 'fsys', or from the OS filesystem if nil (see RunConfig.FS)
+func readFile(fsys fs.FS, name string) ([]byte, error) {
+	if fsys == nil {
+		return os.ReadFile(name)
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+// Returns an error if the file 'name' doesn't exist in 'fsys', or in the OS filesystem if nil
+func statFile(fsys fs.FS, name string) error {
+	if fsys == nil {
+		_, err := os.Stat(name)
+		return err
+	}
+	_, err := fs.Stat(fsys, name)
+	return err
+}
+
+// Returns the path of 'name' relative to the directory of the test file 'testFilename' (unless absolute).
+// Paths in an fs.FS are always slash-separated and relative to its root.
+func relativeToTestFile(fsys fs.FS, testFilename string, name string) string {
+	if fsys != nil {
+		return path.Join(path.Dir(testFilename), name)
+	}
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(testFilename), name)
+}
@@ -0,0 +1,222 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Spec of a garbage-collection sweep that deletes test resources left behind by failed runs. Only resources
+// whose name starts with Prefix (e.g. "apirunner-") are deleted, a resource type can override the prefix.
+type GCSpec struct {
+	Prefix    string       `json:"prefix"`
+	Resources []GCResource `json:"resources"`
+}
+
+// A type of resource to sweep. List is the request listing the resources, with Items the dotted path of the
+// array of resources in its response body ("" if the body is the array) and NameField the field of each
+// resource holding its name (default "name"). Delete is the request deleting a resource and is templated
+// with the resource's fields, e.g. "/users/{{ item.id }}".
+type GCResource struct {
+	Name      string  `json:"name"`
+	List      Request `json:"list"`
+	Items     string  `json:"items"`
+	NameField string  `json:"nameField"`
+	Prefix    string  `json:"prefix"`
+	Delete    Request `json:"delete"`
+}
+
+// Options for a garbage-collection sweep
+type GCOptions struct {
+	// Only list the resources that would be deleted
+	DryRun bool
+}
+
+// Results of a garbage-collection sweep
+type GCResult struct {
+	// Resources deleted (or that would be deleted in dry-run mode), as '<resource type> <name>'
+	Deleted []string
+	// Errors listing or deleting resources
+	Errors []string
+	DryRun bool
+}
+
+// Report returns a human-readable summary of the sweep
+func (result GCResult) Report() string {
+	var report strings.Builder
+	verb := "Deleted"
+	if result.DryRun {
+		verb = "Would delete"
+	}
+	fmt.Fprintf(&report, "\n%s %d resource(s):\n", verb, len(result.Deleted))
+	for _, deleted := range result.Deleted {
+		fmt.Fprintf(&report, "\t%s\n", deleted)
+	}
+	if len(result.Errors) > 0 {
+		fmt.Fprintf(&report, "Errors:\n")
+		for _, err := range result.Errors {
+			fmt.Fprintf(&report, "\t%s\n", err)
+		}
+	}
+	return report.String()
+}
+
+// GC deletes the leftover test resources described by the GCSpec in 'gcFilename', sending its requests like
+// tests (with the base url, headers and auth of the RunConfig in 'runConfigFilename'). Errors listing or
+// deleting resources don't stop the sweep and are included in the result.
+func GC(runConfigFilename string, gcFilename string, options GCOptions) (GCResult, error) {
+	config, err := loadRunConfig(nil, runConfigFilename)
+	if err != nil {
+		return GCResult{}, err
+	}
+	// Nothing is recorded or traced while sweeping
+	config.Pact = nil
+	config.Tracing = nil
+	spec, err := loadGCSpec(gcFilename)
+	if err != nil {
+		return GCResult{}, err
+	}
+	suite, closeSuite, err := prepareTestSuite(config, compiledSuite{fileName: gcFilename})
+	if err != nil {
+		return GCResult{}, err
+	}
+	defer closeSuite()
+
+	result := GCResult{Deleted: make([]string, 0), Errors: make([]string, 0), DryRun: options.DryRun}
+	for _, resource := range spec.Resources {
+		items, err := suite.listGCResources(resource)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", resource.Name, err))
+			continue
+		}
+		prefix := spec.Prefix
+		if resource.Prefix != "" {
+			prefix = resource.Prefix
+		}
+		for _, item := range items {
+			name, ok := item[resource.NameField].(string)
+			if !ok || !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if !options.DryRun {
+				err = suite.deleteGCResource(resource, item)
+				if err != nil {
+					result.Errors = append(result.Errors, fmt.Sprintf("%s %s: %v", resource.Name, name, err))
+					continue
+				}
+			}
+			result.Deleted = append(result.Deleted, fmt.Sprintf("%s %s", resource.Name, name))
+		}
+	}
+	return result, nil
+}
+
+func loadGCSpec(gcFilename string) (GCSpec, error) {
+	contents, err := os.ReadFile(gcFilename)
+	if err != nil {
+		return GCSpec{}, errors.Wrap(err, fmt.Sprintf("invalid gc file: %s", gcFilename))
+	}
+	var spec GCSpec
+	err = json.Unmarshal(contents, &spec)
+	if err != nil {
+		return GCSpec{}, errors.Wrap(err, fmt.Sprintf("invalid gc file: %s", gcFilename))
+	}
+	for i, resource := range spec.Resources {
+		if resource.Name == "" || resource.List.Url == "" || resource.Delete.Url == "" {
+			return GCSpec{}, fmt.Errorf("resource %d of gc file %s must have a name, list and delete request", i+1, gcFilename)
+		}
+		// Never sweep resources without a naming convention, which would delete everything
+		if spec.Prefix == "" && resource.Prefix == "" {
+			return GCSpec{}, fmt.Errorf("resource '%s' of gc file %s has no prefix", resource.Name, gcFilename)
+		}
+		if resource.List.Method == "" {
+			spec.Resources[i].List.Method = http.MethodGet
+		}
+		if resource.Delete.Method == "" {
+			spec.Resources[i].Delete.Method = http.MethodDelete
+		}
+		if resource.NameField == "" {
+			spec.Resources[i].NameField = "name"
+		}
+	}
+	return spec, nil
+}
+
+// Returns the resources listed by the resource type's list request
+func (suite TestSuite) listGCResources(resource GCResource) ([]map[string]interface{}, error) {
+	resp, body, err := suite.sendRequest(TestSpec{Name: resource.Name, Request: resource.List}, map[string]interface{}{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing resources")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("error listing resources, got http %d: %s", resp.StatusCode, string(body))
+	}
+	var listed interface{}
+	err = json.Unmarshal(body, &listed)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing list response")
+	}
+	if resource.Items != "" {
+		listed, _ = lookupField(map[string]interface{}{"body": listed}, "body."+resource.Items)
+	}
+	array, ok := listed.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("list response has no array of resources at '%s'", resource.Items)
+	}
+	items := make([]map[string]interface{}, 0, len(array))
+	for _, element := range array {
+		if item, ok := element.(map[string]interface{}); ok {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// Deletes 'item' using the resource type's delete request. Resources that are already gone count as deleted.
+func (suite TestSuite) deleteGCResource(resource GCResource, item map[string]interface{}) error {
+	resp, body, err := suite.sendRequest(TestSpec{Name: resource.Name, Request: resource.Delete}, map[string]interface{}{"item": item})
+	if err != nil {
+		return errors.Wrap(err, "error deleting resource")
+	}
+	if (resp.StatusCode < 200 || resp.StatusCode > 299) && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("error deleting resource, got http %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// Builds and sends the request of 'test' (outside of a test run), returning the response and its body
+func (suite TestSuite) sendRequest(test TestSpec, extractedFields map[string]interface{}) (*http.Response, []byte, error) {
+	req, err := suite.buildRequest(test, extractedFields)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := suite.doRequest(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, normalizeCharset(resp.Header.Get("Content-Type"), body), nil
+}
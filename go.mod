@@ -1,8 +1,5 @@
 module github.com/warrant-dev/apirunner
 
-go 1.23
+go 1.24
 
-require (
-	github.com/go-test/deep v1.1.1
-	github.com/pkg/errors v0.9.1
-)
+require github.com/pkg/errors v0.9.1
@@ -0,0 +1,208 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RunWithT executes all test files in 'testDir' like Run under go test, e.g. from a TestApi(t *testing.T). Each
+// test file runs as a subtest named after its path relative to 'testDir', with a nested subtest per test case
+// that fails via t.Errorf with the test case's failures (or is skipped). Failed setup and teardown steps fail
+// the file's subtest. With -run, only the selected test files are executed, and all their test cases run
+// (later tests may depend on the template vars of earlier ones) but only the selected ones are reported.
+// Failures of quarantined tests skip their subtest. The runner's own output is only printed with -v.
+func RunWithT(t *testing.T, runConfigFilename string, testDir string) {
+	t.Helper()
+	RunWithTOptions(t, runConfigFilename, testDir, RunOptions{})
+}
+
+// RunFSWithT is like RunWithT, but loads the config file and test files from 'fsys' (see RunConfig.FS)
+func RunFSWithT(t *testing.T, fsys fs.FS, runConfigFilename string, testDir string) {
+	t.Helper()
+	RunWithTOptions(t, runConfigFilename, testDir, RunOptions{FS: fsys})
+}
+
+// RunWithTOptions is like RunWithT with the FS, Output, ErrOutput, Hooks, Profile and FailOnSeverity
+// 'options' (other options are ignored). Like in Run, failures of quarantined tests (see RunConfig.Quarantine)
+// skip their subtest instead of failing it, and failures below FailOnSeverity are logged as warnings. The run
+// is canceled with t's context and limited by the config's timeouts: the subtests of test files that aren't
+// executed because the run was canceled, exceeded its budget or timed out (see TimeoutConfig) are skipped.
+func RunWithTOptions(t *testing.T, runConfigFilename string, testDir string, options RunOptions) {
+	t.Helper()
+	config, err := loadRunConfig(options.FS, runConfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ctx = t.Context()
+	if options.FS != nil {
+		config.FS = options.FS
+	}
+	if !testing.Verbose() {
+		config.Output = io.Discard
+	}
+	if options.Output != nil {
+		config.Output = options.Output
+	}
+	if options.ErrOutput != nil {
+		config.ErrOutput = options.ErrOutput
+	}
+	if options.Hooks != nil {
+		config.Hooks = options.Hooks
+	}
+	if options.Profile != "" {
+		config.Profile = options.Profile
+	}
+	config.Profile = profileName(config.Profile, runConfigFilename)
+	if options.FailOnSeverity != "" && severityRank(options.FailOnSeverity) < 0 {
+		t.Fatalf("invalid severity '%s', must be one of %s", options.FailOnSeverity, strings.Join(severities, ", "))
+	}
+	config, closeRun, err := prepareRun(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeRun()
+	testFiles, err := findOrderedTestFiles(config, testDir, regexp.MustCompile(`.*`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites, err := compileTestFiles(config, testFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var quarantine []QuarantineEntry
+	if config.Quarantine != "" {
+		quarantine, err = loadQuarantine(config.FS, config.Quarantine)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Failures of a test are gated like at the end of Run, but as soon as the test completes
+	gate := func(testFilename string, result TestResult) string {
+		if result.Passed || result.Skipped {
+			return ""
+		}
+		results := []TestSuiteResult{{TestFilename: testFilename, Failed: []TestResult{result}}}
+		applyQuarantine(io.Discard, quarantine, testDir, results)
+		applySeverityGate(options.FailOnSeverity, results)
+		switch {
+		case len(results[0].Quarantined) > 0:
+			return TestStatusQuarantined
+		case len(results[0].Warnings) > 0:
+			return TestStatusWarning
+		}
+		return TestStatusFailed
+	}
+
+	start := time.Now()
+	var run RunMetadata
+	if config.Hooks != nil {
+		run = newRunMetadata(config, runConfigFilename, testDir, config.Profile, start)
+		config.Hooks.runStart(RunStartEvent{Run: run, TestFiles: testFiles})
+	}
+	config.runDeadline = runDeadline(config, start)
+	// Why the remaining test files aren't executed, like in Run
+	stopReason := ""
+	results := make([]TestSuiteResult, 0, len(suites))
+	for _, suite := range suites {
+		name, err := filepath.Rel(testDir, suite.fileName)
+		if err != nil {
+			name = suite.fileName
+		}
+		t.Run(name, func(t *testing.T) {
+			if stopReason != "" {
+				t.Skipf("not run: %s", stopReason)
+			}
+			// Report each test case as it completes, including those running concurrently
+			var mutex sync.Mutex
+			reported := make(map[string]bool)
+			suiteConfig := config
+			hooks := Hooks{}
+			if config.Hooks != nil {
+				hooks = *config.Hooks
+			}
+			hooks.OnTestEnd = func(event TestEndEvent) {
+				config.Hooks.testEnd(event)
+				mutex.Lock()
+				reported[event.Result.Name] = true
+				mutex.Unlock()
+				t.Run(event.Result.Name, func(t *testing.T) {
+					reportResult(t, event.Result, gate(suite.fileName, event.Result))
+				})
+			}
+			suiteConfig.Hooks = &hooks
+			result, err := executeCompiledSuite(suiteConfig, suite, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, result)
+			if err := config.budget.err(); err != nil {
+				stopReason = err.Error()
+			} else if config.runContext().Err() != nil {
+				stopReason = "run canceled"
+			} else if result.TimedOut && stopsAfterTimeout(config) {
+				stopReason = fmt.Sprintf("'%s' timed out", name)
+			}
+			// Failures of setup and teardown steps (and of the suite's duration) aren't test cases
+			for _, failure := range result.Failed {
+				if reported[failure.Name] {
+					continue
+				}
+				if status := gate(suite.fileName, failure); status != TestStatusFailed {
+					t.Logf("%s failed (%s):\n%s", failure.Name, status, strings.Join(failure.Errors, "\n"))
+				} else {
+					t.Errorf("%s failed:\n%s", failure.Name, strings.Join(failure.Errors, "\n"))
+				}
+			}
+		})
+	}
+	if config.Hooks != nil {
+		applyQuarantine(config.output(), quarantine, testDir, results)
+		applySeverityGate(options.FailOnSeverity, results)
+		passed := true
+		for _, result := range results {
+			passed = passed && len(result.Failed) == 0
+		}
+		config.Hooks.runEnd(RunEndEvent{Report: newRunReport(run, testDir, results, time.Since(start)), Passed: passed})
+	}
+}
+
+// Fails, skips or logs on 't' per the test case result 'result' with the gated 'status' of its failures
+func reportResult(t *testing.T, result TestResult, status string) {
+	t.Helper()
+	if result.Skipped {
+		t.Skip(result.SkipReason)
+	}
+	if result.Passed {
+		return
+	}
+	failures := strings.Join(append([]string{fmt.Sprintf("[%s]", result.Category)}, result.Errors...), "\n")
+	switch status {
+	case TestStatusQuarantined:
+		t.Skipf("quarantined failure of %s:\n%s", result.Name, failures)
+	case TestStatusWarning:
+		t.Logf("warning (failure below the failOn severity) of %s:\n%s", result.Name, failures)
+	default:
+		t.Errorf("%s", failures)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Results of the suites in a directory (and its subdirectories)
+type resultGroup struct {
+	name    string
+	groups  []*resultGroup
+	suites  []TestSuiteResult
+	passed  int
+	failed  int
+	skipped int
+}
+
+// Groups 'results' by their test file's directory relative to 'testDir', in order of first appearance
+func groupResults(testDir string, results []TestSuiteResult) *resultGroup {
+	root := &resultGroup{name: filepath.Clean(testDir)}
+	for _, result := range results {
+		group := root
+		group.add(result)
+		dir, err := filepath.Rel(testDir, filepath.Dir(result.TestFilename))
+		if err != nil || dir == "." {
+			group.suites = append(group.suites, result)
+			continue
+		}
+		path := ""
+		for _, segment := range strings.Split(dir, string(filepath.Separator)) {
+			path = filepath.Join(path, segment)
+			group = group.child(path)
+			group.add(result)
+		}
+		group.suites = append(group.suites, result)
+	}
+	return root
+}
+
+func (group *resultGroup) add(result TestSuiteResult) {
+	group.passed += len(result.Passed)
+	group.failed += len(result.Failed)
+	group.skipped += len(result.Skipped)
+}
+
+// Returns the subgroup named 'name', creating it if it doesn't exist
+func (group *resultGroup) child(name string) *resultGroup {
+	for _, child := range group.groups {
+		if child.name == name {
+			return child
+		}
+	}
+	child := &resultGroup{name: name}
+	group.groups = append(group.groups, child)
+	return child
+}
+
+// Prints the group's subtotals followed by those of its suites (with the details of failed tests) and subgroups
+func (group *resultGroup) print(out io.Writer, depth int) {
+	indent := strings.Repeat("  ", depth)
+	fmt.Fprintf(out, "%s%s/ (passed: %d, failed: %d, skipped: %d)\n", indent, group.name, group.passed, group.failed, group.skipped)
+	for _, suite := range group.suites {
+		fmt.Fprintf(out, "%s  %s (passed: %d, failed: %d, skipped: %d)\n", indent, filepath.Base(suite.TestFilename), len(suite.Passed), len(suite.Failed), len(suite.Skipped))
+		for _, failed := range suite.Failed {
+			fmt.Fprint(out, failed.Result())
+		}
+	}
+	for _, child := range group.groups {
+		child.print(out, depth+1)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"io"
+	"net/http"
+)
+
+// Lifecycle hooks called as a run progresses, so embedders can add custom metrics, logging or notifications
+// without changing the runner. Any of them may be nil. Hooks of tests running concurrently (see
+// TestSpec.DependsOn) are called concurrently.
+type Hooks struct {
+	// Called once the run's test files are found and validated, before any of them is executed
+	OnRunStart func(event RunStartEvent)
+	// Called before a test file's setup steps
+	OnSuiteStart func(event SuiteStartEvent)
+	// Called after a test file's teardown steps
+	OnSuiteEnd func(event SuiteEndEvent)
+	// Called before each test case, including those that end up skipped
+	OnTestStart func(event TestStartEvent)
+	// Called after each test case, with its result
+	OnTestEnd func(event TestEndEvent)
+	// Called once all test files were executed and the run's results reported (not if the run fails with an error)
+	OnRunEnd func(event RunEndEvent)
+}
+
+type RunStartEvent struct {
+	Run RunMetadata
+	// Test files to execute, in order
+	TestFiles []string
+}
+
+type SuiteStartEvent struct {
+	TestFile string
+}
+
+type SuiteEndEvent struct {
+	TestFile string
+	Result   TestSuiteResult
+}
+
+type TestStartEvent struct {
+	TestFile string
+	// Name the test case's result is reported under (see "matrix" and "repeat")
+	Name string
+	Spec TestSpec
+}
+
+type TestEndEvent struct {
+	TestFile string
+	// Result of the test case, including the request sent with its templates resolved (RequestMethod,
+	// RequestUrl, RequestHeaders and RequestBody) and the response received (Response)
+	Result TestResult
+}
+
+type RunEndEvent struct {
+	Report RunReport
+	// Whether the run passed
+	Passed bool
+}
+
+func (hooks *Hooks) runStart(event RunStartEvent) {
+	if hooks != nil && hooks.OnRunStart != nil {
+		hooks.OnRunStart(event)
+	}
+}
+
+func (hooks *Hooks) suiteStart(event SuiteStartEvent) {
+	if hooks != nil && hooks.OnSuiteStart != nil {
+		hooks.OnSuiteStart(event)
+	}
+}
+
+func (hooks *Hooks) suiteEnd(event SuiteEndEvent) {
+	if hooks != nil && hooks.OnSuiteEnd != nil {
+		hooks.OnSuiteEnd(event)
+	}
+}
+
+func (hooks *Hooks) testStart(event TestStartEvent) {
+	if hooks != nil && hooks.OnTestStart != nil {
+		hooks.OnTestStart(event)
+	}
+}
+
+func (hooks *Hooks) testEnd(event TestEndEvent) {
+	if hooks != nil && hooks.OnTestEnd != nil {
+		hooks.OnTestEnd(event)
+	}
+}
+
+func (hooks *Hooks) runEnd(event RunEndEvent) {
+	if hooks != nil && hooks.OnRunEnd != nil {
+		hooks.OnRunEnd(event)
+	}
+}
+
+// Returns the body of 'req' without consuming it (nil if it has none or can't be read again)
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.GetBody == nil {
+		return nil, nil
+	}
+	bodyReader, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(bodyReader)
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Self-contained (no external assets) page rendering a RunReport. Failed tests are expanded by default.
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{"metadata": formatMetadata}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>apirunner report {{ .Run.StartTime.Format "2006-01-02 15:04" }}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+.summary span { display: inline-block; margin-right: 1.5em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #ddd; vertical-align: top; }
+th { background: #f5f5f5; }
+.status { font-weight: bold; text-transform: uppercase; font-size: 0.85em; }
+.passed { color: #1a7f37; }
+.failed { color: #cf222e; }
+.skipped, .quarantined, .warning { color: #9a6700; }
+summary { cursor: pointer; }
+pre { background: #f6f8fa; padding: 0.6em; white-space: pre-wrap; word-break: break-word; margin: 0.3em 0; }
+.muted { color: #666; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>apirunner report</h1>
+<p class="muted">Run {{ .Run.Id }} started {{ .Run.StartTime.Format "2006-01-02 15:04:05 MST" }}{{ with .Run.Profile }}, profile {{ . }}{{ end }}{{ with .Run.GitSha }}, commit {{ . }}{{ end }}, took {{ .DurationMs }}ms</p>
+<p class="summary">
+<span>Total: {{ .Summary.Total }}</span>
+<span class="passed">Passed: {{ .Summary.Passed }}</span>
+<span class="failed">Failed: {{ .Summary.Failed }}</span>
+<span class="skipped">Skipped: {{ .Summary.Skipped }}</span>
+<span class="quarantined">Quarantined: {{ .Summary.Quarantined }}</span>
+<span class="warning">Warnings: {{ .Summary.Warnings }}</span>
+</p>
+{{ range .Suites }}
+<h2>{{ .TestFile }} <span class="muted">{{ printf "%.0f" .DurationMs }}ms{{ if .TimedOut }}, timed out{{ end }}</span></h2>
+<table>
+<tr><th>Test</th><th>Status</th><th>Duration</th><th>Request</th></tr>
+{{ range .Tests }}
+<tr>
+<td>{{ if or .Errors .SoftFailures }}<details{{ if eq .Status "failed" }} open{{ end }}><summary>{{ .Name }}</summary>{{ with .Category }}<div class="muted">{{ . }}</div>{{ end }}{{ with .Metadata }}<div class="muted">{{ metadata . }}</div>{{ end }}{{ range .Errors }}<pre>{{ . }}</pre>{{ end }}{{ range .SoftFailures }}<pre>Soft failure: {{ . }}</pre>{{ end }}</details>{{ else }}{{ .Name }}{{ end }}</td>
+<td class="status {{ .Status }}">{{ .Status }}{{ with .SkipReason }} <span class="muted">({{ . }})</span>{{ end }}</td>
+<td>{{ printf "%.1f" .DurationMs }}ms{{ if .Slow }} <span class="warning">slow</span>{{ end }}</td>
+<td>{{ if .Url }}<code>{{ .Method }} {{ .Url }}</code>{{ end }}</td>
+</tr>
+{{ end }}
+</table>
+{{ end }}
+</body>
+</html>
+`))
+
+// Writes 'report' to 'htmlFilename' as a single static HTML page
+func writeHtmlReport(report RunReport, htmlFilename string) error {
+	htmlFile, err := os.Create(htmlFilename)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing HTML report %s", htmlFilename))
+	}
+	defer htmlFile.Close()
+	err = htmlReportTemplate.Execute(htmlFile, report)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing HTML report %s", htmlFilename))
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Auto-injection of a fresh idempotency key header. By default a single key is generated per request
+// and reused if the request is re-issued. If PerRetry is set, every attempt gets a new key.
+type IdempotencyKeyConfig struct {
+	Header   string   `json:"header"`
+	Methods  []string `json:"methods"`
+	PerRetry bool     `json:"perRetry"`
+}
+
+func (config IdempotencyKeyConfig) headerName() string {
+	if config.Header == "" {
+		return "Idempotency-Key"
+	}
+	return config.Header
+}
+
+// Returns true if an idempotency key should be sent for requests using 'method'
+func (config IdempotencyKeyConfig) appliesTo(method string) bool {
+	methods := config.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// HttpClient that sets a new idempotency key on every attempt and records the last key sent
+type idempotencyHttpClient struct {
+	client  HttpClient
+	config  IdempotencyKeyConfig
+	lastKey *string
+}
+
+func (c idempotencyHttpClient) Do(req *http.Request) (*http.Response, error) {
+	if c.config.appliesTo(req.Method) {
+		key := newUUID()
+		req.Header.Set(c.config.headerName(), key)
+		*c.lastKey = key
+	}
+	return c.client.Do(req)
+}
+
+// Returns a random (version 4) UUID
+func newUUID() string {
+	var uuid [16]byte
+	_, err := rand.Read(uuid[:])
+	if err != nil {
+		panic(err)
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An entry of a suite's ignoredFields. A bare field name (e.g. "createdAt") is ignored at any depth,
+// while a dotted path (e.g. "user.createdAt" or "items.*.id") is only ignored at that path from the
+// root of the compared object. '*' matches any field name or array index.
+type ignoredField struct {
+	name string
+	path []string
+}
+
+// Parses the entries of a suite's ignoredFields
+func parseIgnoredFields(fields []string) ([]ignoredField, error) {
+	res := make([]ignoredField, 0, len(fields))
+	for _, field := range fields {
+		if !strings.Contains(field, ".") {
+			if field == "" {
+				return nil, fmt.Errorf("empty ignored field")
+			}
+			res = append(res, ignoredField{name: field})
+			continue
+		}
+		path := strings.Split(field, ".")
+		for _, part := range path {
+			if part == "" {
+				return nil, fmt.Errorf("invalid ignored field path '%s'", field)
+			}
+		}
+		res = append(res, ignoredField{path: path})
+	}
+	return res, nil
+}
+
+// Returns true if the field at 'path' is ignored
+func (field ignoredField) matches(path []string) bool {
+	if field.path == nil {
+		return path[len(path)-1] == field.name
+	}
+	if len(path) != len(field.path) {
+		return false
+	}
+	for i, part := range field.path {
+		if part != "*" && part != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a copy of 'v' with all object fields matching one of 'ignoredFields' removed
+func removeIgnoredFields(v interface{}, ignoredFields []ignoredField, path []string) interface{} {
+	if len(ignoredFields) == 0 {
+		return v
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			childPath := append(path[:len(path):len(path)], k)
+			if isIgnored(ignoredFields, childPath) {
+				continue
+			}
+			res[k] = removeIgnoredFields(child, ignoredFields, childPath)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, child := range val {
+			res[i] = removeIgnoredFields(child, ignoredFields, append(path[:len(path):len(path)], strconv.Itoa(i)))
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+func isIgnored(ignoredFields []ignoredField, path []string) bool {
+	for _, field := range ignoredFields {
+		if field.matches(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,352 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const insomniaWorkspaceId = "wrk_apirunner"
+
+// A resource (workspace, environment, request group or request) in an Insomnia v4 export
+type insomniaResource struct {
+	Id       string                 `json:"_id"`
+	Type     string                 `json:"_type"`
+	ParentId string                 `json:"parentId"`
+	Name     string                 `json:"name"`
+	Method   string                 `json:"method,omitempty"`
+	Url      string                 `json:"url,omitempty"`
+	Body     *insomniaBody          `json:"body,omitempty"`
+	Headers  []insomniaHeader       `json:"headers,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+type insomniaBody struct {
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+}
+
+type insomniaHeader struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+type insomniaExport struct {
+	Type         string             `json:"_type"`
+	ExportFormat int                `json:"__export_format"`
+	ExportSource string             `json:"__export_source"`
+	Resources    []insomniaResource `json:"resources"`
+}
+
+// Minimal suite and test specs written by ImportInsomnia, leaving out unset fields
+type importedSuiteSpec struct {
+	Tests []importedTestSpec `json:"tests"`
+}
+
+type importedTestSpec struct {
+	Name    string          `json:"name"`
+	Request importedRequest `json:"request"`
+}
+
+type importedRequest struct {
+	Method  string            `json:"method"`
+	BaseUrl string            `json:"baseUrl,omitempty"`
+	Url     string            `json:"url"`
+	Body    interface{}       `json:"body,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+var (
+	// Matches apirunner template vars to convert them to Insomnia environment variables
+	insomniaExportTemplateRegex = regexp.MustCompile(`{{\s*([^\s|{}]+)`)
+	// Matches Insomnia environment variables to convert them to apirunner template vars
+	insomniaImportTemplateRegex = regexp.MustCompile(`{{\s*_\.([^\s|{}]+)`)
+	// Matches a leading base url environment variable in Insomnia request urls
+	insomniaBaseUrlRegex = regexp.MustCompile(`^{{\s*_\.(baseUrl|base_url)\s*}}`)
+	// Matches a header value referencing a custom header from the environment
+	insomniaCustomHeaderRegex = regexp.MustCompile(`^{{\s*_\.headers\.[^\s|{}]+\s*}}$`)
+)
+
+// ExportInsomnia converts the test files in 'testDir' whose names match 'testFilenameMatchRegex' into an
+// Insomnia (v4) export written to 'out', with a request group per suite and a request per test. The base
+// url and custom headers from config become environment variables (baseUrl and headers) and template vars
+// become environment variables of the same name.
+func ExportInsomnia(runConfigFilename string, testDir string, testFilenameMatchRegex *regexp.Regexp, out io.Writer) error {
+	config, err := loadRunConfig(nil, runConfigFilename)
+	if err != nil {
+		return err
+	}
+	testFiles, err := findOrderedTestFiles(config, testDir, testFilenameMatchRegex)
+	if err != nil {
+		return err
+	}
+
+	export := insomniaExport{
+		Type:         "export",
+		ExportFormat: 4,
+		ExportSource: "apirunner",
+		Resources: []insomniaResource{
+			{Id: insomniaWorkspaceId, Type: "workspace", Name: filepath.Base(filepath.Clean(testDir))},
+			{Id: "env_apirunner", Type: "environment", ParentId: insomniaWorkspaceId, Name: "Base Environment", Data: map[string]interface{}{"baseUrl": config.BaseUrl}},
+		},
+	}
+	if len(config.CustomHeaders) > 0 {
+		export.Resources[1].Data["headers"] = config.CustomHeaders
+	}
+	for i, testFile := range testFiles {
+		suiteSpec, err := loadSuiteSpec(config.FS, testFile)
+		if err != nil {
+			return err
+		}
+		if suiteSpec.Skip {
+			continue
+		}
+		groupId := fmt.Sprintf("fld_%d", i+1)
+		export.Resources = append(export.Resources, insomniaResource{Id: groupId, Type: "request_group", ParentId: insomniaWorkspaceId, Name: filepath.Base(testFile)})
+		for j, test := range suiteSpec.Tests {
+			if test.Skip || test.Exec != nil {
+				continue
+			}
+			resource, err := insomniaRequest(config, suiteSpec, test)
+			if err != nil {
+				return err
+			}
+			resource.Id = fmt.Sprintf("req_%d_%d", i+1, j+1)
+			resource.ParentId = groupId
+			export.Resources = append(export.Resources, resource)
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(export)
+	if err != nil {
+		return errors.Wrap(err, "error writing insomnia export")
+	}
+	return nil
+}
+
+func insomniaRequest(config RunConfig, suiteSpec TestSuiteSpec, test TestSpec) (insomniaResource, error) {
+	baseUrl := "{{ _.baseUrl }}"
+	if test.Request.BaseUrl != "" {
+		baseUrl = test.Request.BaseUrl
+	} else if suiteSpec.BaseUrl != "" {
+		baseUrl = suiteSpec.BaseUrl
+	}
+	resource := insomniaResource{
+		Type:    "request",
+		Name:    test.Name,
+		Method:  test.Request.Method,
+		Url:     baseUrl + insomniaTemplate(test.Request.Url),
+		Headers: make([]insomniaHeader, 0),
+	}
+	headers := make(map[string]string)
+	for k := range config.CustomHeaders {
+		headers[k] = fmt.Sprintf("{{ _.headers.%s }}", k)
+	}
+	for k, v := range test.Request.Headers {
+		headers[k] = insomniaTemplate(v)
+	}
+	headerNames := make([]string, 0, len(headers))
+	for headerName := range headers {
+		headerNames = append(headerNames, headerName)
+	}
+	sort.Strings(headerNames)
+	for _, headerName := range headerNames {
+		resource.Headers = append(resource.Headers, insomniaHeader{Name: headerName, Value: headers[headerName]})
+	}
+	if test.Request.Body != nil {
+		body, err := requestBodyTemplate(test)
+		if err != nil {
+			return insomniaResource{}, errors.Wrap(err, fmt.Sprintf("invalid request body for test '%s'", test.Name))
+		}
+		resource.Body = &insomniaBody{Text: insomniaTemplate(body)}
+		if _, ok := test.Request.Body.(string); !ok {
+			resource.Body.MimeType = "application/json"
+		}
+	}
+	return resource, nil
+}
+
+// Converts apirunner template vars in 's' to Insomnia environment variables, e.g. "{{ createUser.userId }}" to "{{ _.createUser.userId }}"
+func insomniaTemplate(s string) string {
+	return insomniaExportTemplateRegex.ReplaceAllString(s, "{{ _.$1")
+}
+
+// ImportInsomnia converts the requests in the Insomnia (v4) export 'exportFilename' into test files written
+// to 'testDir', one per request group (requests outside of a group go in a file named after the workspace).
+// Existing files are never overwritten. Returns the paths of the files written.
+func ImportInsomnia(exportFilename string, testDir string) ([]string, error) {
+	contents, err := os.ReadFile(exportFilename)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading insomnia export %s", exportFilename))
+	}
+	var export insomniaExport
+	err = json.Unmarshal(contents, &export)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error parsing insomnia export %s", exportFilename))
+	}
+	if export.Type != "export" || export.ExportFormat != 4 {
+		return nil, fmt.Errorf("unsupported insomnia export %s, expected export format 4", exportFilename)
+	}
+
+	// Group requests into suites by their parent workspace or request group
+	names := make(map[string]string)
+	for _, resource := range export.Resources {
+		names[resource.Id] = resource.Name
+	}
+	suiteIds := make([]string, 0)
+	suites := make(map[string]*importedSuiteSpec)
+	testNames := make(map[string]map[string]bool)
+	for _, resource := range export.Resources {
+		if resource.Type != "request" {
+			continue
+		}
+		suite, ok := suites[resource.ParentId]
+		if !ok {
+			suite = &importedSuiteSpec{Tests: make([]importedTestSpec, 0)}
+			suites[resource.ParentId] = suite
+			suiteIds = append(suiteIds, resource.ParentId)
+			testNames[resource.ParentId] = make(map[string]bool)
+		}
+		test := importInsomniaRequest(resource)
+		test.Name = uniqueTestName(testName(resource.Name), testNames[resource.ParentId])
+		suite.Tests = append(suite.Tests, test)
+	}
+
+	err = os.MkdirAll(testDir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error creating %s", testDir))
+	}
+	written := make([]string, 0, len(suiteIds))
+	for _, suiteId := range suiteIds {
+		suiteContents, err := json.MarshalIndent(suites[suiteId], "", "    ")
+		if err != nil {
+			return written, errors.Wrap(err, "error marshaling imported suite")
+		}
+		path := filepath.Join(testDir, suiteFileName(names[suiteId]))
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return written, errors.Wrap(err, fmt.Sprintf("error creating %s", path))
+		}
+		_, err = file.Write(append(suiteContents, '\n'))
+		file.Close()
+		if err != nil {
+			return written, errors.Wrap(err, fmt.Sprintf("error writing %s", path))
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+func importInsomniaRequest(resource insomniaResource) importedTestSpec {
+	request := importedRequest{
+		Method: strings.ToUpper(resource.Method),
+		Url:    insomniaImportTemplateRegex.ReplaceAllString(resource.Url, "{{ $1"),
+	}
+	if request.Method == "" {
+		request.Method = "GET"
+	}
+	// Requests against the base url are relative, anything else keeps its own base url
+	if loc := insomniaBaseUrlRegex.FindStringIndex(resource.Url); loc != nil {
+		request.Url = insomniaImportTemplateRegex.ReplaceAllString(resource.Url[loc[1]:], "{{ $1")
+	} else if parsedUrl, err := url.Parse(request.Url); err == nil && parsedUrl.Scheme != "" && parsedUrl.Host != "" {
+		request.BaseUrl = parsedUrl.Scheme + "://" + parsedUrl.Host
+		request.Url = strings.TrimPrefix(request.Url, request.BaseUrl)
+	}
+	for _, header := range resource.Headers {
+		// Custom headers are set via config
+		if header.Disabled || header.Name == "" || insomniaCustomHeaderRegex.MatchString(header.Value) {
+			continue
+		}
+		if request.Headers == nil {
+			request.Headers = make(map[string]string)
+		}
+		request.Headers[header.Name] = insomniaImportTemplateRegex.ReplaceAllString(header.Value, "{{ $1")
+	}
+	if resource.Body != nil && resource.Body.Text != "" {
+		text := insomniaImportTemplateRegex.ReplaceAllString(resource.Body.Text, "{{ $1")
+		var jsonBody interface{}
+		if strings.Contains(resource.Body.MimeType, "json") && json.Unmarshal([]byte(text), &jsonBody) == nil {
+			request.Body = jsonBody
+		} else {
+			request.Body = text
+		}
+	}
+	return importedTestSpec{Request: request}
+}
+
+// Converts a free-form request name into a valid (alphanumeric) test name, e.g. "Get user by id" to "getUserById"
+func testName(name string) string {
+	var res strings.Builder
+	upperNext := false
+	for _, r := range name {
+		if r > unicode.MaxASCII || (!unicode.IsLetter(r) && !unicode.IsDigit(r)) {
+			upperNext = res.Len() > 0
+			continue
+		}
+		if res.Len() == 0 {
+			r = unicode.ToLower(r)
+		} else if upperNext {
+			r = unicode.ToUpper(r)
+		}
+		upperNext = false
+		res.WriteRune(r)
+	}
+	if res.Len() == 0 {
+		return "request"
+	}
+	return res.String()
+}
+
+// Returns 'name', suffixed with a number if it's already in 'taken', and marks it as taken
+func uniqueTestName(name string, taken map[string]bool) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	taken[unique] = true
+	return unique
+}
+
+// Converts a request group or workspace name into a test file name
+func suiteFileName(name string) string {
+	name = strings.TrimSuffix(name, ".json")
+	var res strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_') {
+			res.WriteRune(r)
+		} else if res.Len() > 0 && !strings.HasSuffix(res.String(), "_") {
+			res.WriteRune('_')
+		}
+	}
+	fileName := strings.Trim(res.String(), "_")
+	if fileName == "" {
+		fileName = "insomnia"
+	}
+	return fileName + ".json"
+}
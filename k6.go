@@ -0,0 +1,140 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Helpers shared by all generated k6 scripts. tpl() replaces "{{ var | transform }}" templates like
+// templateReplace and memoize() stores response fields under the same names as flatten.
+const k6Helpers = `import http from 'k6/http';
+import { check, group } from 'k6';
+import encoding from 'k6/encoding';
+import crypto from 'k6/crypto';
+
+const transforms = {
+    lower: (s) => s.toLowerCase(),
+    upper: (s) => s.toUpperCase(),
+    trim: (s) => s.trim(),
+    urlencode: (s) => encodeURIComponent(s),
+    base64: (s) => encoding.b64encode(s),
+    sha256: (s) => crypto.sha256(s, 'hex'),
+};
+
+function tpl(s, vars) {
+    return s.replace(/{{\s*([^\s|{}]+)((\s*\|\s*[a-zA-Z0-9]+)*)\s*}}/g, (match, name, pipes) => {
+        if (!(name in vars)) {
+            throw new Error("missing template value for var: '" + name + "'");
+        }
+        let value = String(vars[name]);
+        pipes.split('|').slice(1).forEach((transform) => {
+            value = transforms[transform.trim()](value);
+        });
+        return value;
+    });
+}
+
+` + jsFlatten + `
+function memoize(vars, name, res) {
+    Object.entries(res.headers).forEach(([k, v]) => { vars[name + '.header.' + k] = v; });
+    try {
+        Object.assign(vars, flatten(res.json(), name, 0, {}));
+    } catch (e) {
+        // Non-JSON response
+    }
+}
+`
+
+// ExportK6 converts the test files in 'testDir' whose names match 'testFilenameMatchRegex' into a k6
+// script written to 'out'. Each suite becomes a group of requests with a status check per test.
+// Tests that are skipped or run exec steps are left out. The base url can be overridden at runtime via
+// the BASE_URL environment variable.
+func ExportK6(runConfigFilename string, testDir string, testFilenameMatchRegex *regexp.Regexp, out io.Writer) error {
+	config, err := loadRunConfig(nil, runConfigFilename)
+	if err != nil {
+		return err
+	}
+	testFiles, err := findOrderedTestFiles(config, testDir, testFilenameMatchRegex)
+	if err != nil {
+		return err
+	}
+
+	var script strings.Builder
+	script.WriteString(k6Helpers)
+	fmt.Fprintf(&script, "\nconst BASE_URL = __ENV.BASE_URL || %s;\n", jsString(config.BaseUrl))
+	fmt.Fprintf(&script, "const HEADERS = %s;\n", jsValue(config.CustomHeaders))
+	script.WriteString("\nexport default function () {\n")
+	for _, testFile := range testFiles {
+		suiteSpec, err := loadSuiteSpec(config.FS, testFile)
+		if err != nil {
+			return err
+		}
+		if suiteSpec.Skip {
+			continue
+		}
+		err = writeK6Group(&script, config, testFile, suiteSpec)
+		if err != nil {
+			return err
+		}
+	}
+	script.WriteString("}\n")
+
+	_, err = io.WriteString(out, script.String())
+	if err != nil {
+		return errors.Wrap(err, "error writing k6 script")
+	}
+	return nil
+}
+
+func writeK6Group(script *strings.Builder, config RunConfig, testFile string, suiteSpec TestSuiteSpec) error {
+	fmt.Fprintf(script, "    group(%s, function () {\n", jsString(testFile))
+	script.WriteString("        const vars = {};\n")
+	script.WriteString("        let res;\n")
+	for _, test := range suiteSpec.Tests {
+		if test.Skip || test.Exec != nil {
+			continue
+		}
+
+		baseUrl := "BASE_URL"
+		if test.Request.BaseUrl != "" {
+			baseUrl = jsString(test.Request.BaseUrl)
+		} else if suiteSpec.BaseUrl != "" {
+			baseUrl = jsString(suiteSpec.BaseUrl)
+		}
+		body, err := requestBodyTemplate(test)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("invalid request body for test '%s'", test.Name))
+		}
+		headers := "HEADERS"
+		if len(test.Request.Headers) > 0 {
+			headers = fmt.Sprintf("Object.assign({}, HEADERS, JSON.parse(tpl(%s, vars)))", jsString(jsValue(test.Request.Headers)))
+		}
+		expectedStatusCode := expectedStatusCode(config, test)
+
+		fmt.Fprintf(script, "\n        // %s\n", test.Name)
+		fmt.Fprintf(script, "        res = http.request(%s, %s + tpl(%s, vars), tpl(%s, vars), { headers: %s });\n",
+			jsString(test.Request.Method), baseUrl, jsString(test.Request.Url), jsString(body), headers)
+		fmt.Fprintf(script, "        check(res, { %s: (r) => r.status === %d });\n", jsString(fmt.Sprintf("%s status is %d", test.Name, expectedStatusCode)), expectedStatusCode)
+		fmt.Fprintf(script, "        memoize(vars, %s, res);\n", jsString(test.Name))
+	}
+	script.WriteString("    });\n")
+	return nil
+}
@@ -0,0 +1,478 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Matchers are string values of the form "{{ name }}" or "{{ name:args }}" in expected bodies that
+// validate the corresponding field of the response instead of comparing it verbatim
+var matcherRegex = regexp.MustCompile(`^{{\s*([a-zA-Z]+)\s*(?::\s*(.*?))?\s*}}$`)
+
+// A matcher returns a description of the mismatch if 'actual' doesn't match, "" otherwise.
+// 'present' is false if the field is missing from the response entirely.
+type matcher func(actual interface{}, present bool) string
+
+// Matcher constructors keyed by matcher name. Each is passed the matcher's args (if any).
+var matchers = map[string]func(args string) (matcher, error){
+	"null": func(args string) (matcher, error) {
+		return func(actual interface{}, present bool) string {
+			if !present {
+				return "expected null but field is missing"
+			}
+			if actual != nil {
+				return fmt.Sprintf("expected null but got %v", actual)
+			}
+			return ""
+		}, nil
+	},
+	"absent": func(args string) (matcher, error) {
+		return func(actual interface{}, present bool) string {
+			if present && actual == nil {
+				return "expected field to be absent but it is null"
+			}
+			if present {
+				return fmt.Sprintf("expected field to be absent but got %v", actual)
+			}
+			return ""
+		}, nil
+	},
+	"date": func(args string) (matcher, error) {
+		// Name of the layout in failure messages, RFC3339 by default
+		name := args
+		if name == "" {
+			name = "RFC3339"
+		}
+		layout := name
+		if namedLayout, ok := dateLayouts[name]; ok {
+			layout = namedLayout
+		}
+		return func(actual interface{}, present bool) string {
+			if s, ok := actual.(string); !ok {
+				return fmt.Sprintf("expected date formatted as %s but got %v", name, actual)
+			} else if _, err := time.Parse(layout, s); err != nil {
+				return fmt.Sprintf("expected date formatted as %s but got %s", name, s)
+			}
+			return ""
+		}, nil
+	},
+	"dateWithin": func(args string) (matcher, error) {
+		// Format: "<duration> of now"
+		durationStr, reference, found := strings.Cut(args, " of ")
+		if !found || strings.TrimSpace(reference) != "now" {
+			return nil, fmt.Errorf("expected format '<duration> of now'")
+		}
+		within, err := time.ParseDuration(strings.TrimSpace(durationStr))
+		if err != nil {
+			return nil, err
+		}
+		return func(actual interface{}, present bool) string {
+			s, ok := actual.(string)
+			if !ok {
+				return fmt.Sprintf("expected date within %s of now but got %v", within, actual)
+			}
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return fmt.Sprintf("expected RFC3339 date within %s of now but got %s", within, s)
+			}
+			if diff := time.Since(t).Abs(); diff > within {
+				return fmt.Sprintf("expected date within %s of now but %s is %s away", within, s, diff.Round(time.Millisecond))
+			}
+			return ""
+		}, nil
+	},
+	"equalsIgnoreCase": func(args string) (matcher, error) {
+		return func(actual interface{}, present bool) string {
+			if s, ok := actual.(string); !ok || !strings.EqualFold(s, args) {
+				return fmt.Sprintf("expected %s (ignoring case) but got %v", args, actual)
+			}
+			return ""
+		}, nil
+	},
+	"equalsTrimmed": func(args string) (matcher, error) {
+		return func(actual interface{}, present bool) string {
+			if s, ok := actual.(string); !ok || strings.TrimSpace(s) != args {
+				return fmt.Sprintf("expected %s (ignoring surrounding whitespace) but got %v", args, actual)
+			}
+			return ""
+		}, nil
+	},
+	"nonEmpty": func(args string) (matcher, error) {
+		return func(actual interface{}, present bool) string {
+			if !present || actual == nil || actual == "" {
+				return fmt.Sprintf("expected non-empty value but got %v", actual)
+			}
+			if arr, ok := actual.([]interface{}); ok && len(arr) == 0 {
+				return "expected non-empty array but got []"
+			}
+			if obj, ok := actual.(map[string]interface{}); ok && len(obj) == 0 {
+				return "expected non-empty object but got {}"
+			}
+			return ""
+		}, nil
+	},
+	"present": func(args string) (matcher, error) {
+		return func(actual interface{}, present bool) string {
+			if !present {
+				return "expected field to be present but it is missing"
+			}
+			return ""
+		}, nil
+	},
+}
+
+// Named layouts accepted by the date matcher. Any other arg is used as a Go time layout.
+var dateLayouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+// Returns the matcher represented by 'v', or false if 'v' isn't a matcher
+func parseMatcher(v interface{}) (matcher, bool, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, false, nil
+	}
+	match := matcherRegex.FindStringSubmatch(s)
+	if match == nil {
+		return nil, false, nil
+	}
+	newMatcher, ok := matchers[match[1]]
+	if !ok {
+		p, ok := pluginMatcher(match[1])
+		if !ok {
+			return nil, false, nil
+		}
+		return p.matcher(match[1], match[2]), true, nil
+	}
+	m, err := newMatcher(match[2])
+	if err != nil {
+		return nil, true, fmt.Errorf("invalid matcher '%s': %v", s, err)
+	}
+	return m, true, nil
+}
+
+// Array matchers are objects with '$' keys placed where an array is expected:
+//   - "$items": shape every element of the array must match, e.g. {"$items": {"id": "{{ nonEmpty }}"}}
+//   - "$sortedBy": field the elements must be sorted by, with "$order" "asc" (default) or "desc"
+const (
+	itemsMatcherKey    = "$items"
+	sortedByMatcherKey = "$sortedBy"
+	orderMatcherKey    = "$order"
+)
+
+// Returns true if 'v' is an array matcher
+func isArrayMatcher(v interface{}) bool {
+	obj, ok := v.(map[string]interface{})
+	if !ok || len(obj) == 0 {
+		return false
+	}
+	for k := range obj {
+		if k != itemsMatcherKey && k != sortedByMatcherKey && k != orderMatcherKey {
+			return false
+		}
+	}
+	return true
+}
+
+// Compares an element of an array to what remains of its "$items" shape once matchers are applied
+type itemComparison func(elem interface{}, shape interface{}, path string) []Difference
+
+// Returns an itemComparison like the comparison of whole response bodies: template vars in the shape are
+// replaced with 'extractedFields', 'ignoredFields' (at their path from the root of the body) are excluded
+// and strings are normalized per 'stringComparison'
+func compareItems(extractedFields map[string]interface{}, ignoredFields []ignoredField, stringComparison StringComparison) itemComparison {
+	return func(elem interface{}, shape interface{}, path string) []Difference {
+		shapeBytes, err := json.Marshal(shape)
+		if err != nil {
+			return []Difference{matcherDifference(path, fmt.Sprintf("invalid $items shape: %v", err))}
+		}
+		shapeStr, err := templateReplace(string(shapeBytes), extractedFields)
+		if err != nil {
+			return []Difference{matcherDifference(path, err.Error())}
+		}
+		var processedShape interface{}
+		err = json.Unmarshal([]byte(shapeStr), &processedShape)
+		if err != nil {
+			return []Difference{matcherDifference(path, fmt.Sprintf("invalid $items shape: %v", err))}
+		}
+		fieldPath := ignoredFieldPath(path)
+		actual := removeIgnoredFields(subset(elem, processedShape), ignoredFields, fieldPath)
+		expected := removeIgnoredFields(processedShape, ignoredFields, fieldPath)
+		return diffValues(stringComparison.normalizeAll(actual), stringComparison.normalizeAll(expected), path)
+	}
+}
+
+// Converts a difference path (e.g. "map[items].slice[0]") to an ignored field path (e.g. ["items", "0"])
+func ignoredFieldPath(path string) []string {
+	res := make([]string, 0)
+	for _, segment := range pathSegments(path) {
+		segment = strings.TrimSuffix(segment, "]")
+		segment = strings.TrimPrefix(strings.TrimPrefix(segment, "map["), "slice[")
+		res = append(res, segment)
+	}
+	return res
+}
+
+// Returns diffs for each way the array 'actual' doesn't match the array matcher 'arrayMatcher'
+func matchArray(actual interface{}, arrayMatcher map[string]interface{}, path string, compare itemComparison) []Difference {
+	arr, ok := actual.([]interface{})
+	if !ok {
+		return []Difference{matcherDifference(path, fmt.Sprintf("expected array but got %v", actual))}
+	}
+	diffs := make([]Difference, 0)
+	if shape, ok := arrayMatcher[itemsMatcherKey]; ok {
+		diffs = append(diffs, matchItems(arr, shape, path, compare)...)
+	}
+	if sortedBy, ok := arrayMatcher[sortedByMatcherKey]; ok {
+		order, _ := arrayMatcher[orderMatcherKey].(string)
+		diffs = append(diffs, matchSorted(arr, fmt.Sprint(sortedBy), order, path)...)
+	}
+	sortDifferences(diffs)
+	return diffs
+}
+
+// Returns diffs for each element of 'arr' that doesn't match 'shape' per 'compare'. Fields of elements
+// that aren't in 'shape' are ignored.
+func matchItems(arr []interface{}, shape interface{}, path string, compare itemComparison) []Difference {
+	diffs := make([]Difference, 0)
+	for i, elem := range arr {
+		elemPath := fmt.Sprintf("%sslice[%d]", pathPrefix(path), i)
+		matchedElem, matchedShape, elemDiffs := applyMatchers(elem, shape, elemPath, compare)
+		diffs = append(diffs, elemDiffs...)
+		diffs = append(diffs, compare(matchedElem, matchedShape, elemPath)...)
+	}
+	return diffs
+}
+
+// Returns a diff if the elements of 'arr' aren't sorted by the (dotted path) field 'sortedBy' in 'order'
+func matchSorted(arr []interface{}, sortedBy string, order string, path string) []Difference {
+	if order != "" && order != "asc" && order != "desc" {
+		return []Difference{matcherDifference(path, fmt.Sprintf("invalid sort order '%s', must be 'asc' or 'desc'", order))}
+	}
+	for i := 1; i < len(arr); i++ {
+		prev, err := fieldValue(arr[i-1], sortedBy)
+		if err != nil {
+			return []Difference{matcherDifference(fmt.Sprintf("%sslice[%d]", pathPrefix(path), i-1), err.Error())}
+		}
+		curr, err := fieldValue(arr[i], sortedBy)
+		if err != nil {
+			return []Difference{matcherDifference(fmt.Sprintf("%sslice[%d]", pathPrefix(path), i), err.Error())}
+		}
+		cmp, err := compareValues(prev, curr)
+		if err != nil {
+			return []Difference{matcherDifference(fmt.Sprintf("%sslice[%d]", pathPrefix(path), i), err.Error())}
+		}
+		if (order == "desc" && cmp < 0) || (order != "desc" && cmp > 0) {
+			if order == "" {
+				order = "asc"
+			}
+			return []Difference{matcherDifference(fmt.Sprintf("%sslice[%d]", pathPrefix(path), i), fmt.Sprintf("expected array sorted by '%s' %s but %v comes after %v", sortedBy, order, curr, prev))}
+		}
+	}
+	return nil
+}
+
+// Returns the value of the (dotted path) field 'field' of 'v'
+func fieldValue(v interface{}, field string) (interface{}, error) {
+	for _, segment := range strings.Split(field, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected object with field '%s' but got %v", field, v)
+		}
+		v, ok = obj[segment]
+		if !ok {
+			return nil, fmt.Errorf("missing field '%s'", field)
+		}
+	}
+	return v, nil
+}
+
+// Returns -1, 0 or 1 if 'a' is less than, equal to or greater than 'b'. Both must be numbers or strings.
+func compareValues(a interface{}, b interface{}) (int, error) {
+	switch aVal := a.(type) {
+	case float64:
+		if bVal, ok := b.(float64); ok {
+			switch {
+			case aVal < bVal:
+				return -1, nil
+			case aVal > bVal:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if bVal, ok := b.(string); ok {
+			return strings.Compare(aVal, bVal), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %v and %v", a, b)
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "<root>"
+	}
+	return path
+}
+
+// Evaluates all matchers in 'expected' against the corresponding fields of 'actual'. Returns copies of
+// 'actual' and 'expected' with matched fields removed (so they're excluded from further comparison)
+// along with a diff for each field that didn't match. Elements of "$items" array matchers are compared per 'compare'.
+func applyMatchers(actual interface{}, expected interface{}, path string, compare itemComparison) (interface{}, interface{}, []Difference) {
+	diffs := make([]Difference, 0)
+	switch expectedVal := expected.(type) {
+	case map[string]interface{}:
+		actualMap, ok := actual.(map[string]interface{})
+		if !ok {
+			return actual, expected, diffs
+		}
+		actualCopy := make(map[string]interface{}, len(actualMap))
+		for k, v := range actualMap {
+			actualCopy[k] = v
+		}
+		expectedCopy := make(map[string]interface{}, len(expectedVal))
+		for k, v := range expectedVal {
+			childPath := fmt.Sprintf("%smap[%s]", pathPrefix(path), k)
+			actualChild, present := actualMap[k]
+			m, isMatcher, err := parseMatcher(v)
+			if err != nil {
+				diffs = append(diffs, matcherDifference(childPath, err.Error()))
+				delete(actualCopy, k)
+				continue
+			}
+			if isMatcher {
+				if mismatch := m(actualChild, present); mismatch != "" {
+					diffs = append(diffs, matcherDifference(childPath, mismatch))
+				}
+				delete(actualCopy, k)
+				continue
+			}
+			if isArrayMatcher(v) {
+				if !present {
+					diffs = append(diffs, matcherDifference(childPath, "expected array but field is missing"))
+				} else {
+					diffs = append(diffs, matchArray(actualChild, v.(map[string]interface{}), childPath, compare)...)
+				}
+				delete(actualCopy, k)
+				continue
+			}
+			if present {
+				var childDiffs []Difference
+				actualCopy[k], expectedCopy[k], childDiffs = applyMatchers(actualChild, v, childPath, compare)
+				diffs = append(diffs, childDiffs...)
+			} else {
+				expectedCopy[k] = v
+			}
+		}
+		return actualCopy, expectedCopy, diffs
+	case []interface{}:
+		actualSlice, ok := actual.([]interface{})
+		if !ok {
+			return actual, expected, diffs
+		}
+		actualCopy := make([]interface{}, len(actualSlice))
+		copy(actualCopy, actualSlice)
+		expectedCopy := make([]interface{}, len(expectedVal))
+		for i, v := range expectedVal {
+			childPath := fmt.Sprintf("%sslice[%d]", pathPrefix(path), i)
+			var actualChild interface{}
+			present := i < len(actualSlice)
+			if present {
+				actualChild = actualSlice[i]
+			}
+			m, isMatcher, err := parseMatcher(v)
+			if err != nil {
+				diffs = append(diffs, matcherDifference(childPath, err.Error()))
+				isMatcher = true
+			} else if isMatcher {
+				if mismatch := m(actualChild, present); mismatch != "" {
+					diffs = append(diffs, matcherDifference(childPath, mismatch))
+				}
+			}
+			if isMatcher {
+				// Blank out the element in both so indexes stay aligned
+				if present {
+					actualCopy[i] = nil
+				}
+				expectedCopy[i] = nil
+				continue
+			}
+			if present {
+				var childDiffs []Difference
+				actualCopy[i], expectedCopy[i], childDiffs = applyMatchers(actualChild, v, childPath, compare)
+				diffs = append(diffs, childDiffs...)
+			} else {
+				expectedCopy[i] = v
+			}
+		}
+		return actualCopy, expectedCopy, diffs
+	default:
+		return actual, expected, diffs
+	}
+}
+
+// Returns 's' normalized according to the configured string comparison options
+func (options StringComparison) normalize(s string) string {
+	if options.TrimSpace {
+		s = strings.TrimSpace(s)
+	}
+	if options.IgnoreCase {
+		s = strings.ToLower(s)
+	}
+	return s
+}
+
+// Returns a copy of 'v' with all string values normalized according to the configured string comparison options
+func (options StringComparison) normalizeAll(v interface{}) interface{} {
+	if !options.IgnoreCase && !options.TrimSpace {
+		return v
+	}
+	switch val := v.(type) {
+	case string:
+		return options.normalize(val)
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			res[k] = options.normalizeAll(child)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, child := range val {
+			res[i] = options.normalizeAll(child)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+func pathPrefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return path + "."
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Returns the metadata of 'test': the suite's metadata overridden by the test's own
+func (suite TestSuite) testMetadata(test TestSpec) map[string]string {
+	if len(suite.spec.Metadata) == 0 && len(test.Metadata) == 0 {
+		return nil
+	}
+	metadata := make(map[string]string, len(suite.spec.Metadata)+len(test.Metadata))
+	for k, v := range suite.spec.Metadata {
+		metadata[k] = v
+	}
+	for k, v := range test.Metadata {
+		metadata[k] = v
+	}
+	return metadata
+}
+
+// Returns true if 'metadata' has all key/value pairs of 'filter'
+func matchesMetadata(metadata map[string]string, filter map[string]string) bool {
+	for k, v := range filter {
+		if metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Parses a comma separated list of key=value pairs, e.g. "owner=payments,severity=high"
+func ParseMetadataFilter(s string) (map[string]string, error) {
+	filter := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, v, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid metadata filter '%s', expected key=value", pair)
+		}
+		filter[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return filter, nil
+}
+
+// Returns 'metadata' formatted as "k1: v1, k2: v2" sorted by key
+func formatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", k, metadata[k]))
+	}
+	return strings.Join(pairs, ", ")
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Latest version of the test file format. Test files without a version are version 1. Older versions
+// are still parsed and executed as before, 'apirunner migrate' rewrites them to the latest version.
+//
+// Version 2: requests without a body are sent without one (version 1 sends an empty JSON object).
+const currentSuiteVersion = 2
+
+// Returns the format version of the suite's test file
+func (spec TestSuiteSpec) version() int {
+	if spec.Version == 0 {
+		return 1
+	}
+	return spec.Version
+}
+
+// Migrations of a test file from each version (the key) to the next. They return notes on the changes
+// that could change a test's behavior.
+var suiteMigrations = map[int]func(suite *orderedObject) []string{
+	1: migrateSuiteV1,
+}
+
+// Makes the empty JSON object bodies sent by requests without a body explicit, except for GET and HEAD
+// requests, for which a body has no meaning
+func migrateSuiteV1(suite *orderedObject) []string {
+	notes := make([]string, 0)
+	tests, _ := suite.values["tests"].([]interface{})
+	for _, t := range tests {
+		test, ok := t.(*orderedObject)
+		if !ok || test.values["exec"] != nil || test.values["preflight"] != nil {
+			continue
+		}
+		request, ok := test.values["request"].(*orderedObject)
+		if !ok {
+			continue
+		}
+		if _, ok := request.values["body"]; ok {
+			continue
+		}
+		method, _ := request.values["method"].(string)
+		if strings.EqualFold(method, "GET") || strings.EqualFold(method, "HEAD") {
+			notes = append(notes, fmt.Sprintf("%s request of test '%s' no longer sends an empty JSON object as its body", strings.ToUpper(method), test.values["name"]))
+			continue
+		}
+		request.set("body", &orderedObject{values: make(map[string]interface{})})
+	}
+	return notes
+}
+
+// Options for migrating test files
+type MigrateOptions struct {
+	// Only report the migrations without rewriting any test files
+	DryRun bool
+}
+
+// Migration of a test file to the latest format version
+type MigrationResult struct {
+	TestFilename string
+	FromVersion  int
+	ToVersion    int
+	// Changes that could change the behavior of tests
+	Notes []string
+}
+
+// Migrate rewrites the test files in 'testDir' whose names match 'testFilenameMatchRegex' to the latest
+// format version, preserving the behavior of their tests where possible (see MigrationResult.Notes)
+// and the order of their fields. Returns the migrations of the test files that weren't up to date.
+func Migrate(testDir string, testFilenameMatchRegex *regexp.Regexp, options MigrateOptions) ([]MigrationResult, error) {
+	testFiles, err := findTestFiles(nil, testDir, testFilenameMatchRegex)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]MigrationResult, 0)
+	for _, testFile := range testFiles {
+		result, migrated, err := migrateSuite(testFile, options.DryRun)
+		if err != nil {
+			return results, err
+		}
+		if migrated {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// Migrates the test file 'testFilename' to the latest format version, returning false if it's up to date
+func migrateSuite(testFilename string, dryRun bool) (MigrationResult, bool, error) {
+	spec, err := loadSuiteSpec(nil, testFilename)
+	if err != nil {
+		return MigrationResult{}, false, err
+	}
+	result := MigrationResult{
+		TestFilename: testFilename,
+		FromVersion:  spec.version(),
+		ToVersion:    currentSuiteVersion,
+		Notes:        make([]string, 0),
+	}
+	if result.FromVersion == currentSuiteVersion {
+		return result, false, nil
+	}
+
+	contents, err := os.ReadFile(testFilename)
+	if err != nil {
+		return MigrationResult{}, false, errors.Wrap(err, fmt.Sprintf("error reading test file %s", testFilename))
+	}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	root, err := decodeOrdered(decoder)
+	if err != nil {
+		return MigrationResult{}, false, errors.Wrap(err, fmt.Sprintf("error parsing test file %s", testFilename))
+	}
+	suite, ok := root.(*orderedObject)
+	if !ok {
+		return MigrationResult{}, false, fmt.Errorf("invalid test file %s", testFilename)
+	}
+	for version := result.FromVersion; version < currentSuiteVersion; version++ {
+		result.Notes = append(result.Notes, suiteMigrations[version](suite)...)
+	}
+	// The version goes first
+	migrated := &orderedObject{keys: []string{"version"}, values: map[string]interface{}{"version": currentSuiteVersion}}
+	for _, key := range suite.keys {
+		if key != "version" {
+			migrated.set(key, suite.values[key])
+		}
+	}
+	if dryRun {
+		return result, true, nil
+	}
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	err = encoder.Encode(migrated)
+	if err != nil {
+		return MigrationResult{}, false, errors.Wrap(err, fmt.Sprintf("error encoding test file %s", testFilename))
+	}
+	err = os.WriteFile(testFilename, out.Bytes(), 0644)
+	if err != nil {
+		return MigrationResult{}, false, errors.Wrap(err, fmt.Sprintf("error writing test file %s", testFilename))
+	}
+	return result, true, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A response example from an OpenAPI spec
+type openApiExample struct {
+	StatusCode int
+	Body       interface{}
+}
+
+// Loads the response examples in the OpenAPI 3 (JSON) spec 'specFilename', keyed by '<operationId>_<status>'
+// and '<operationId>_<status>_<exampleName>'. '<operationId>_<status>' refers to the response's example,
+// or its first named example (in alphabetical order) if there's none.
+func loadOpenApiExamples(fsys fs.FS, specFilename string) (map[string]openApiExample, error) {
+	contents, err := readFile(fsys, specFilename)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading openApiSpec %s", specFilename))
+	}
+	var spec map[string]interface{}
+	err = json.Unmarshal(contents, &spec)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error parsing openApiSpec %s, only JSON specs are supported", specFilename))
+	}
+
+	examples := make(map[string]openApiExample)
+	paths, _ := spec["paths"].(map[string]interface{})
+	for _, pathItem := range paths {
+		operations, _ := pathItem.(map[string]interface{})
+		for _, operation := range operations {
+			op, _ := operation.(map[string]interface{})
+			operationId, _ := op["operationId"].(string)
+			if operationId == "" {
+				continue
+			}
+			responses, _ := op["responses"].(map[string]interface{})
+			for status, response := range responses {
+				statusCode, err := strconv.Atoi(status)
+				if err != nil {
+					// e.g. "default" or "2XX"
+					continue
+				}
+				key := fmt.Sprintf("%s_%s", operationId, status)
+				resp, _ := resolveOpenApiRef(spec, response).(map[string]interface{})
+				respExamples := responseExamples(spec, resp)
+				names := make([]string, 0, len(respExamples))
+				for name, body := range respExamples {
+					if name != "" {
+						examples[key+"_"+name] = openApiExample{statusCode, body}
+						names = append(names, name)
+					}
+				}
+				if body, ok := respExamples[""]; ok {
+					examples[key] = openApiExample{statusCode, body}
+				} else if len(names) > 0 {
+					sort.Strings(names)
+					examples[key] = openApiExample{statusCode, respExamples[names[0]]}
+				}
+			}
+		}
+	}
+	return examples, nil
+}
+
+// An operation from an OpenAPI spec, with the example of its request body (if any)
+type openApiOperation struct {
+	Method string
+	Path   string
+	Body   interface{}
+}
+
+// Loads the operations in the OpenAPI 3 (JSON) spec 'specFilename', keyed by operationId
+func loadOpenApiOperations(fsys fs.FS, specFilename string) (map[string]openApiOperation, error) {
+	contents, err := readFile(fsys, specFilename)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading openApiSpec %s", specFilename))
+	}
+	var spec map[string]interface{}
+	err = json.Unmarshal(contents, &spec)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error parsing openApiSpec %s, only JSON specs are supported", specFilename))
+	}
+
+	operations := make(map[string]openApiOperation)
+	paths, _ := spec["paths"].(map[string]interface{})
+	for path, pathItem := range paths {
+		pathOperations, _ := pathItem.(map[string]interface{})
+		for method, operation := range pathOperations {
+			op, _ := operation.(map[string]interface{})
+			operationId, _ := op["operationId"].(string)
+			if operationId == "" {
+				continue
+			}
+			var body interface{}
+			requestBody, _ := resolveOpenApiRef(spec, op["requestBody"]).(map[string]interface{})
+			bodyExamples := responseExamples(spec, requestBody)
+			if example, ok := bodyExamples[""]; ok {
+				body = example
+			} else if len(bodyExamples) > 0 {
+				names := make([]string, 0, len(bodyExamples))
+				for name := range bodyExamples {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				body = bodyExamples[names[0]]
+			}
+			operations[operationId] = openApiOperation{strings.ToUpper(method), path, body}
+		}
+	}
+	return operations, nil
+}
+
+// Returns the examples of a response's (or request body's) content (preferring JSON media types), keyed by name ("" for its example)
+func responseExamples(spec map[string]interface{}, response map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{})
+	content, _ := response["content"].(map[string]interface{})
+	mediaTypes := make([]string, 0, len(content))
+	for mediaType := range content {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	sort.Slice(mediaTypes, func(i, j int) bool {
+		iJson, jJson := strings.Contains(mediaTypes[i], "json"), strings.Contains(mediaTypes[j], "json")
+		if iJson != jJson {
+			return iJson
+		}
+		return mediaTypes[i] < mediaTypes[j]
+	})
+	for _, mediaType := range mediaTypes {
+		media, _ := content[mediaType].(map[string]interface{})
+		if example, ok := media["example"]; ok {
+			res[""] = example
+		}
+		namedExamples, _ := media["examples"].(map[string]interface{})
+		for name, example := range namedExamples {
+			if ex, ok := resolveOpenApiRef(spec, example).(map[string]interface{}); ok {
+				if value, ok := ex["value"]; ok {
+					res[name] = value
+				}
+			}
+		}
+		if len(res) > 0 {
+			break
+		}
+	}
+	return res
+}
+
+// Resolves a local '$ref' (e.g. "#/components/examples/user") in 'v', if it is one
+func resolveOpenApiRef(spec map[string]interface{}, v interface{}) interface{} {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	ref, ok := obj["$ref"].(string)
+	if !ok || !strings.HasPrefix(ref, "#/") {
+		return v
+	}
+	var res interface{} = spec
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := res.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		res = m[part]
+	}
+	return res
+}
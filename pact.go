@@ -0,0 +1,168 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Config for emitting a Pact (v2) contract file from the request/response pairs of passing tests.
+// The file is written to Dir (default "pacts") as '<consumer>-<provider>.json' at the end of a run.
+type PactConfig struct {
+	Consumer string `json:"consumer"`
+	Provider string `json:"provider"`
+	Dir      string `json:"dir"`
+}
+
+type pactParticipant struct {
+	Name string `json:"name"`
+}
+
+type pactRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    interface{}       `json:"body,omitempty"`
+}
+
+type pactResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    interface{}       `json:"body,omitempty"`
+}
+
+type pactInteraction struct {
+	Description string       `json:"description"`
+	Request     pactRequest  `json:"request"`
+	Response    pactResponse `json:"response"`
+}
+
+type pactFile struct {
+	Consumer     pactParticipant        `json:"consumer"`
+	Provider     pactParticipant        `json:"provider"`
+	Interactions []pactInteraction      `json:"interactions"`
+	Metadata     map[string]interface{} `json:"metadata"`
+}
+
+// Collects interactions across all suites in a run
+type pactRecorder struct {
+	config       PactConfig
+	mutex        sync.Mutex
+	interactions []pactInteraction
+}
+
+func newPactRecorder(config PactConfig) (*pactRecorder, error) {
+	if config.Consumer == "" || config.Provider == "" {
+		return nil, fmt.Errorf("pact requires a consumer and provider")
+	}
+	return &pactRecorder{
+		config:       config,
+		interactions: make([]pactInteraction, 0),
+	}, nil
+}
+
+// Records the request made for 'test' and the response received. Only headers specified by the
+// test (not custom headers from config, which typically hold credentials) and Content-Type are recorded.
+func (recorder *pactRecorder) record(suiteName string, test TestSpec, req *http.Request, resp *http.Response, body []byte) {
+	interaction := pactInteraction{
+		Description: fmt.Sprintf("%s %s", suiteName, test.Name),
+		Request: pactRequest{
+			Method:  req.Method,
+			Path:    req.URL.Path,
+			Query:   req.URL.RawQuery,
+			Headers: make(map[string]string),
+		},
+		Response: pactResponse{
+			Status:  resp.StatusCode,
+			Headers: make(map[string]string),
+			Body:    pactBody(body),
+		},
+	}
+	for headerName := range test.Request.Headers {
+		interaction.Request.Headers[http.CanonicalHeaderKey(headerName)] = req.Header.Get(headerName)
+	}
+	if test.Request.Body != nil && req.GetBody != nil {
+		if bodyReader, err := req.GetBody(); err == nil {
+			if reqBody, err := io.ReadAll(bodyReader); err == nil {
+				interaction.Request.Body = pactBody(reqBody)
+			}
+		}
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		interaction.Response.Headers["Content-Type"] = contentType
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.interactions = append(recorder.interactions, interaction)
+}
+
+// Writes the pact file, returning its path
+func (recorder *pactRecorder) write() (string, error) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	pact := pactFile{
+		Consumer:     pactParticipant{recorder.config.Consumer},
+		Provider:     pactParticipant{recorder.config.Provider},
+		Interactions: recorder.interactions,
+		Metadata: map[string]interface{}{
+			"pactSpecification": map[string]string{"version": "2.0.0"},
+		},
+	}
+	contents, err := json.MarshalIndent(pact, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling pact file")
+	}
+	dir := recorder.config.Dir
+	if dir == "" {
+		dir = "pacts"
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating pact dir")
+	}
+	path := filepath.Join(dir, pactFileName(recorder.config.Consumer)+"-"+pactFileName(recorder.config.Provider)+".json")
+	err = os.WriteFile(path, contents, 0644)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error writing pact file %s", path))
+	}
+	return path, nil
+}
+
+// Returns a JSON body as its parsed value, anything else as a string
+func pactBody(body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+	var parsed interface{}
+	if json.Unmarshal(body, &parsed) == nil {
+		return parsed
+	}
+	return string(body)
+}
+
+func pactFileName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", "_"))
+}
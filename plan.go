@@ -0,0 +1,225 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// What a run would do, resolved without sending any request or running any command (see Plan). Test
+// files are executed one after another, and the tests of each file in order unless they run
+// concurrently (see TestSpec.DependsOn).
+type ExecutionPlan struct {
+	Suites []SuitePlan
+}
+
+// Planned execution of a test file
+type SuitePlan struct {
+	FileName string
+	// Names of the file's setup and teardown steps
+	Setup    []string
+	Teardown []string
+	// Whether the whole file is skipped
+	Skipped bool
+	// Maximum number of tests executed at once (0 if the file's tests run sequentially)
+	MaxParallel int
+	Tests       []TestPlan
+}
+
+// Planned execution of a test case
+type TestPlan struct {
+	// Name the case's result is reported under (see "matrix" and "repeat")
+	Name string
+	// Request as written in the test file, with its templates unresolved (empty for "exec" tests)
+	Method string
+	Url    string
+	// Earlier tests the test declares it depends on or whose extracted fields or responses it uses
+	DependsOn []string
+	// Depth of the test in its file's dependency graph, tests of the same batch running at once (0 if
+	// the file's tests run sequentially)
+	Batch int
+	// Whether the test's failure skips the rest of its file
+	Critical bool
+	// Flags the test requires that are only known once the flags endpoint is fetched at run time
+	RequiresFlags []string
+	// Reason the test would be skipped or filtered out, if it would be
+	SkipReason string
+}
+
+// Plan resolves the order in which RunWithOptions would execute the test files in 'testDir' and their
+// tests, which tests depend on which, and which tests would be skipped or filtered out by 'options'
+func Plan(runConfigFilename string, testDir string, testFilenameMatchRegex *regexp.Regexp, options RunOptions) (ExecutionPlan, error) {
+	config, err := loadRunConfig(nil, runConfigFilename)
+	if err != nil {
+		return ExecutionPlan{}, err
+	}
+	if options.ReadOnly {
+		if config.Budget == nil {
+			config.Budget = &BudgetConfig{}
+		}
+		config.Budget.ReadOnly = true
+	}
+	config.metadataFilter = options.Metadata
+	// Only flags enabled in the config are known without sending a request
+	flagsKnown := config.Flags == nil || config.Flags.Endpoint == nil
+	config.activeFlags = make(map[string]bool)
+	if config.Flags != nil {
+		for _, flag := range config.Flags.Enabled {
+			config.activeFlags[flag] = true
+		}
+	}
+
+	testFiles, err := findOrderedTestFiles(config, testDir, testFilenameMatchRegex)
+	if err != nil {
+		return ExecutionPlan{}, err
+	}
+	suites, err := compileTestFiles(config, testFiles)
+	if err != nil {
+		return ExecutionPlan{}, err
+	}
+	plan := ExecutionPlan{Suites: make([]SuitePlan, 0, len(suites))}
+	for _, compiled := range suites {
+		suite := TestSuite{spec: compiled.spec, config: config, fileName: compiled.fileName}
+		suitePlan := SuitePlan{FileName: compiled.fileName, Skipped: compiled.spec.Skip}
+		for i, step := range compiled.spec.Setup {
+			suitePlan.Setup = append(suitePlan.Setup, stepName(step, "setup", i))
+		}
+		for i, step := range compiled.spec.Teardown {
+			suitePlan.Teardown = append(suitePlan.Teardown, stepName(step, "teardown", i))
+		}
+		if suite.schedulesConcurrently() {
+			suitePlan.MaxParallel = compiled.spec.maxParallel()
+		}
+		graph := suite.dependencyGraph()
+		batches := make([]int, len(compiled.spec.Tests))
+		for i, test := range compiled.spec.Tests {
+			dependsOn := make([]string, 0, len(graph[i]))
+			batches[i] = 1
+			for _, dependency := range graph[i] {
+				dependsOn = append(dependsOn, compiled.spec.Tests[dependency].Name)
+				batches[i] = max(batches[i], batches[dependency]+1)
+			}
+			skipReason := suite.planSkipReason(test, flagsKnown)
+			var requiresFlags []string
+			if !flagsKnown && skipReason == "" {
+				requiresFlags = test.RequiresFlags
+			}
+			for _, testCase := range testCases(test) {
+				testPlan := TestPlan{
+					Name:          testCase.name,
+					DependsOn:     dependsOn,
+					Critical:      test.Critical,
+					RequiresFlags: requiresFlags,
+					SkipReason:    skipReason,
+				}
+				if suitePlan.MaxParallel > 0 {
+					testPlan.Batch = batches[i]
+				}
+				if test.Exec == nil {
+					testPlan.Method = test.Request.Method
+					testPlan.Url = test.Request.Url
+				}
+				suitePlan.Tests = append(suitePlan.Tests, testPlan)
+			}
+		}
+		plan.Suites = append(plan.Suites, suitePlan)
+	}
+	return plan, nil
+}
+
+// Returns the name the result of the 'i'th of a suite's 'kind' ("setup" or "teardown") steps is reported under
+func stepName(step ExecStep, kind string, i int) string {
+	if step.Name != "" {
+		return step.Name
+	}
+	return fmt.Sprintf("%s%d", kind, i+1)
+}
+
+// Returns the reason 'test' would be skipped before sending its request (mirroring executeSpec), if
+// known without sending any request. Skips depending on earlier results (e.g. of critical tests) aren't known.
+func (suite TestSuite) planSkipReason(test TestSpec, flagsKnown bool) string {
+	metadata := suite.testMetadata(test)
+	if suite.spec.Skip {
+		return "test file skipped"
+	}
+	if test.Skip {
+		return "skipped"
+	}
+	if !matchesMetadata(metadata, suite.config.metadataFilter) {
+		return "filtered out by metadata"
+	}
+	if flagsKnown {
+		if reason := suite.flagSkipReason(test); reason != "" {
+			return reason
+		}
+	}
+	if skippedByReadOnly(suite.config.Budget, metadata) {
+		return "destructive test in read-only run"
+	}
+	return ""
+}
+
+// Returns a human-readable description of the plan
+func (plan ExecutionPlan) Report() string {
+	var sb strings.Builder
+	total, skipped := 0, 0
+	for i, suite := range plan.Suites {
+		fmt.Fprintf(&sb, "%d. %s", i+1, suite.FileName)
+		if suite.Skipped {
+			sb.WriteString(" (skipped)")
+		} else if suite.MaxParallel > 0 {
+			fmt.Fprintf(&sb, " (concurrent, at most %d tests at once)", suite.MaxParallel)
+		}
+		sb.WriteString("\n")
+		if len(suite.Setup) > 0 {
+			fmt.Fprintf(&sb, "   setup: %s\n", strings.Join(suite.Setup, ", "))
+		}
+		for _, test := range suite.Tests {
+			total++
+			line := "   - " + test.Name
+			if test.Method != "" || test.Url != "" {
+				line += fmt.Sprintf(": %s %s", test.Method, test.Url)
+			}
+			var notes []string
+			if test.Batch > 0 {
+				notes = append(notes, fmt.Sprintf("batch %d", test.Batch))
+			}
+			if test.Critical {
+				notes = append(notes, "critical")
+			}
+			if len(test.DependsOn) > 0 {
+				notes = append(notes, "depends on "+strings.Join(test.DependsOn, ", "))
+			}
+			if len(test.RequiresFlags) > 0 {
+				notes = append(notes, "requires flags "+strings.Join(test.RequiresFlags, ", "))
+			}
+			if test.SkipReason != "" {
+				skipped++
+				notes = append(notes, "SKIPPED: "+test.SkipReason)
+			}
+			if len(notes) > 0 {
+				line += " (" + strings.Join(notes, "; ") + ")"
+			}
+			sb.WriteString(line + "\n")
+		}
+		if len(suite.Teardown) > 0 {
+			fmt.Fprintf(&sb, "   teardown: %s\n", strings.Join(suite.Teardown, ", "))
+		}
+	}
+	fmt.Fprintf(&sb, "\n%d test file(s), %d test(s) to run, %d skipped\n", len(plan.Suites), total-skipped, skipped)
+	return sb.String()
+}
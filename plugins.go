@@ -0,0 +1,351 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Plugins are external executables providing custom matchers, template transforms and auth, so apirunner
+// can be extended without writing Go. Each plugin is started once per run and speaks JSON-RPC 2.0 over its
+// stdin/stdout, one message per line:
+//   - "initialize" returns the plugin's capabilities, e.g. {"matchers": ["sku"], "transforms": ["hmac"], "auth": true}
+//   - "match" {"name", "args", "actual", "present"} returns {"mismatch": "..."} ("" if the value matches)
+//   - "transform" {"name", "value"} returns {"value": "..."}
+//   - "authenticate" {"method", "url"} returns {"headers": {...}} to set on the request
+//
+// Instead of a Command, a plugin can be a WebAssembly module (compiled for WASI). apirunner doesn't embed a
+// WebAssembly runtime: such a plugin is still an external command, WasmRuntimeCommand (wasmtime by default,
+// which must be installed), that runs the module sandboxed. Its Env is passed to the module via --env flags
+// rather than inherited from the environment.
+type PluginConfig struct {
+	Name               string            `json:"name"`
+	Command            string            `json:"command"`
+	Args               []string          `json:"args"`
+	Env                map[string]string `json:"env"`
+	Wasm               string            `json:"wasm"`
+	WasmRuntimeCommand []string          `json:"wasmRuntimeCommand"`
+}
+
+var defaultWasmRuntimeCommand = []string{"wasmtime", "run"}
+
+// Returns the command (and its args) that runs the plugin
+func (config PluginConfig) command() (string, []string, error) {
+	if config.Name == "" || (config.Command == "") == (config.Wasm == "") {
+		return "", nil, fmt.Errorf("plugins require a name and either a command or a wasm module")
+	}
+	if config.Wasm == "" {
+		return config.Command, config.Args, nil
+	}
+	runtime := config.WasmRuntimeCommand
+	if len(runtime) == 0 {
+		runtime = defaultWasmRuntimeCommand
+	}
+	args := append([]string{}, runtime[1:]...)
+	envNames := make([]string, 0, len(config.Env))
+	for k := range config.Env {
+		envNames = append(envNames, k)
+	}
+	sort.Strings(envNames)
+	for _, k := range envNames {
+		args = append(args, "--env", k+"="+config.Env[k])
+	}
+	args = append(args, config.Wasm)
+	return runtime[0], append(args, config.Args...), nil
+}
+
+// How long to wait for a plugin to exit after closing its stdin before killing it
+const pluginShutdownTimeout = 2 * time.Second
+
+type pluginCapabilities struct {
+	Matchers   []string `json:"matchers"`
+	Transforms []string `json:"transforms"`
+	Auth       bool     `json:"auth"`
+}
+
+// A running plugin process. Calls are serialized.
+type plugin struct {
+	name         string
+	cmd          *exec.Cmd
+	stdin        io.WriteCloser
+	stdout       *bufio.Reader
+	mutex        sync.Mutex
+	nextId       int
+	capabilities pluginCapabilities
+}
+
+type rpcRequest struct {
+	JsonRpc string      `json:"jsonrpc"`
+	Id      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	Id     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Starts the plugin in 'config', forwarding its stderr to 'errOut', and fetches its capabilities
+func startPlugin(config PluginConfig, errOut io.Writer) (*plugin, error) {
+	command, args, err := config.command()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := exec.LookPath(command); err != nil && config.Wasm != "" {
+		return nil, fmt.Errorf("wasm plugin '%s' requires the WASI runtime '%s' to be installed (see wasmRuntimeCommand)", config.Name, command)
+	}
+	cmd := exec.Command(command, args...)
+	cmd.Env = os.Environ()
+	if config.Wasm == "" {
+		for k, v := range config.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+	cmd.Stderr = errOut
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error starting plugin '%s'", config.Name))
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error starting plugin '%s'", config.Name))
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error starting plugin '%s'", config.Name))
+	}
+	p := &plugin{
+		name:   config.Name,
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}
+	err = p.call("initialize", nil, &p.capabilities)
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Calls 'method' of the plugin with 'params', decoding its result into 'result'
+func (p *plugin) call(method string, params interface{}, result interface{}) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.nextId++
+	request, err := json.Marshal(rpcRequest{JsonRpc: "2.0", Id: p.nextId, Method: method, Params: params})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error encoding '%s' request to plugin '%s'", method, p.name))
+	}
+	_, err = p.stdin.Write(append(request, '\n'))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error calling '%s' of plugin '%s'", method, p.name))
+	}
+	for {
+		line, err := p.stdout.ReadBytes('\n')
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error reading '%s' response of plugin '%s'", method, p.name))
+		}
+		var response rpcResponse
+		err = json.Unmarshal(line, &response)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("invalid '%s' response of plugin '%s'", method, p.name))
+		}
+		// Skip responses to earlier (abandoned) calls
+		if response.Id != p.nextId {
+			continue
+		}
+		if response.Error != nil {
+			return fmt.Errorf("plugin '%s' failed '%s': %s", p.name, method, response.Error.Message)
+		}
+		if result == nil || len(response.Result) == 0 {
+			return nil
+		}
+		err = json.Unmarshal(response.Result, result)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("invalid '%s' result of plugin '%s'", method, p.name))
+		}
+		return nil
+	}
+}
+
+// Stops the plugin, killing it if it doesn't exit after its stdin is closed
+func (p *plugin) close() {
+	p.stdin.Close()
+	exited := make(chan struct{})
+	go func() {
+		p.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(pluginShutdownTimeout):
+		p.cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// Returns a matcher that has the plugin evaluate its matcher 'name' with 'args'
+func (p *plugin) matcher(name string, args string) matcher {
+	return func(actual interface{}, present bool) string {
+		var result struct {
+			Mismatch string `json:"mismatch"`
+		}
+		err := p.call("match", map[string]interface{}{"name": name, "args": args, "actual": actual, "present": present}, &result)
+		if err != nil {
+			return err.Error()
+		}
+		return result.Mismatch
+	}
+}
+
+// Returns 'value' transformed by the plugin's transform 'name'
+func (p *plugin) transform(name string, value string) (string, error) {
+	var result struct {
+		Value string `json:"value"`
+	}
+	err := p.call("transform", map[string]interface{}{"name": name, "value": value}, &result)
+	return result.Value, err
+}
+
+// Matchers and transforms of the plugins of the current run keyed by name. Expected bodies and templates
+// aren't evaluated in the context of a run, so they're looked up here after the built-in ones.
+var pluginRegistry = struct {
+	sync.RWMutex
+	matchers   map[string]*plugin
+	transforms map[string]*plugin
+}{
+	matchers:   make(map[string]*plugin),
+	transforms: make(map[string]*plugin),
+}
+
+// Returns the plugin providing the matcher 'name', if any
+func pluginMatcher(name string) (*plugin, bool) {
+	pluginRegistry.RLock()
+	defer pluginRegistry.RUnlock()
+	p, ok := pluginRegistry.matchers[name]
+	return p, ok
+}
+
+// Returns the plugin providing the template transform 'name', if any
+func pluginTransform(name string) (*plugin, bool) {
+	pluginRegistry.RLock()
+	defer pluginRegistry.RUnlock()
+	p, ok := pluginRegistry.transforms[name]
+	return p, ok
+}
+
+// Starts the plugins in 'configs' (see startPlugin) and registers their matchers and transforms. The
+// returned func unregisters and stops them.
+func startPlugins(configs []PluginConfig, errOut io.Writer) (_ []*plugin, _ func(), err error) {
+	plugins := make([]*plugin, 0, len(configs))
+	registeredMatchers := make([]string, 0)
+	registeredTransforms := make([]string, 0)
+	closePlugins := func() {
+		pluginRegistry.Lock()
+		for _, name := range registeredMatchers {
+			delete(pluginRegistry.matchers, name)
+		}
+		for _, name := range registeredTransforms {
+			delete(pluginRegistry.transforms, name)
+		}
+		pluginRegistry.Unlock()
+		for _, p := range plugins {
+			p.close()
+		}
+	}
+	defer func() {
+		if err != nil {
+			closePlugins()
+		}
+	}()
+
+	names := make(map[string]bool)
+	for _, config := range configs {
+		if names[config.Name] {
+			return nil, nil, fmt.Errorf("duplicate plugin '%s'", config.Name)
+		}
+		names[config.Name] = true
+		p, err := startPlugin(config, errOut)
+		if err != nil {
+			return nil, nil, err
+		}
+		plugins = append(plugins, p)
+
+		pluginRegistry.Lock()
+		for _, name := range p.capabilities.Matchers {
+			if _, ok := matchers[name]; ok || pluginRegistry.matchers[name] != nil {
+				pluginRegistry.Unlock()
+				return nil, nil, fmt.Errorf("matcher '%s' of plugin '%s' is already defined", name, p.name)
+			}
+			pluginRegistry.matchers[name] = p
+			registeredMatchers = append(registeredMatchers, name)
+		}
+		for _, name := range p.capabilities.Transforms {
+			if _, ok := templateTransforms[name]; ok || pluginRegistry.transforms[name] != nil {
+				pluginRegistry.Unlock()
+				return nil, nil, fmt.Errorf("template transform '%s' of plugin '%s' is already defined", name, p.name)
+			}
+			pluginRegistry.transforms[name] = p
+			registeredTransforms = append(registeredTransforms, name)
+		}
+		pluginRegistry.Unlock()
+	}
+	return plugins, closePlugins, nil
+}
+
+// HttpClient that sets the headers returned by auth plugins on every request
+type pluginAuthHttpClient struct {
+	client  HttpClient
+	plugins []*plugin
+}
+
+func (c pluginAuthHttpClient) Do(req *http.Request) (*http.Response, error) {
+	for _, p := range c.plugins {
+		if !p.capabilities.Auth {
+			continue
+		}
+		var result struct {
+			Headers map[string]string `json:"headers"`
+		}
+		err := p.call("authenticate", map[string]interface{}{"method": req.Method, "url": req.URL.String()}, &result)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range result.Headers {
+			req.Header.Set(k, v)
+		}
+	}
+	return c.client.Do(req)
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config for running tests against a Kubernetes service through a kubectl port-forward established
+// for the duration of a run. The baseUrl is pointed at the forwarded local port (plus BasePath).
+// LocalPort defaults to a random free port and TimeoutMs (time to wait for the forward) to 10s.
+type PortForwardConfig struct {
+	Kubectl   string `json:"kubectl"`
+	Context   string `json:"context"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	Port      int    `json:"port"`
+	LocalPort int    `json:"localPort"`
+	Scheme    string `json:"scheme"`
+	BasePath  string `json:"basePath"`
+	TimeoutMs int    `json:"timeoutMs"`
+}
+
+// Matches kubectl's "Forwarding from 127.0.0.1:54321 -> 8080" output
+var forwardingFromRegex = regexp.MustCompile(`Forwarding from (127\.0\.0\.1|\[::1\]):(\d+) ->`)
+
+type portForward struct {
+	cmd       *exec.Cmd
+	localPort int
+}
+
+// Starts 'kubectl port-forward' and waits until it's ready to accept connections
+func startPortForward(config PortForwardConfig) (*portForward, error) {
+	if config.Service == "" || config.Port == 0 {
+		return nil, fmt.Errorf("portForward requires a service and port")
+	}
+	kubectl := config.Kubectl
+	if kubectl == "" {
+		kubectl = "kubectl"
+	}
+	args := []string{"port-forward"}
+	if config.Context != "" {
+		args = append(args, "--context", config.Context)
+	}
+	if config.Namespace != "" {
+		args = append(args, "--namespace", config.Namespace)
+	}
+	localPort := ""
+	if config.LocalPort != 0 {
+		localPort = strconv.Itoa(config.LocalPort)
+	}
+	args = append(args, "svc/"+config.Service, fmt.Sprintf("%s:%d", localPort, config.Port))
+
+	cmd := exec.Command(kubectl, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to start port-forward")
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to start port-forward")
+	}
+
+	// Wait for kubectl to report the forwarded local port
+	ready := make(chan int, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if match := forwardingFromRegex.FindStringSubmatch(scanner.Text()); match != nil {
+				port, _ := strconv.Atoi(match[2])
+				ready <- port
+				break
+			}
+		}
+		close(ready)
+		// Keep draining output so kubectl never blocks on a full pipe
+		for scanner.Scan() {
+		}
+	}()
+	timeout := 10 * time.Second
+	if config.TimeoutMs > 0 {
+		timeout = time.Duration(config.TimeoutMs) * time.Millisecond
+	}
+	select {
+	case port, ok := <-ready:
+		if ok {
+			return &portForward{cmd, port}, nil
+		}
+		cmd.Wait()
+		return nil, fmt.Errorf("port-forward to svc/%s exited: %s", config.Service, strings.TrimSpace(stderr.String()))
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("port-forward to svc/%s not ready within %s", config.Service, timeout)
+	}
+}
+
+func (forward *portForward) close() {
+	forward.cmd.Process.Kill()
+	forward.cmd.Wait()
+}
+
+// Returns the base url for requests through the port-forward
+func (forward *portForward) baseUrl(config PortForwardConfig) string {
+	scheme := config.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://127.0.0.1:%d%s", scheme, forward.localPort, config.BasePath)
+}
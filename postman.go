@@ -0,0 +1,219 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// Collection-level test script memoizing response fields of every request as collection variables
+// named like apirunner template vars, e.g. {{createUser.userId}}
+const postmanMemoizeScript = jsFlatten + `
+const name = pm.info.requestName;
+pm.response.headers.each((header) => { pm.collectionVariables.set(name + '.header.' + header.key, header.value); });
+try {
+    Object.entries(flatten(pm.response.json(), name, 0, {})).forEach(([k, v]) => { pm.collectionVariables.set(k, v); });
+} catch (e) {
+    // Non-JSON response
+}
+`
+
+type postmanCollection struct {
+	Info     postmanInfo       `json:"info"`
+	Variable []postmanKeyValue `json:"variable"`
+	Event    []postmanEvent    `json:"event,omitempty"`
+	Item     []postmanItem     `json:"item"`
+}
+
+type postmanInfo struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+type postmanKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type postmanEvent struct {
+	Listen string        `json:"listen"`
+	Script postmanScript `json:"script"`
+}
+
+type postmanScript struct {
+	Type string   `json:"type"`
+	Exec []string `json:"exec"`
+}
+
+// A folder (suite) if Item is set, otherwise a request (test)
+type postmanItem struct {
+	Name    string          `json:"name"`
+	Item    []postmanItem   `json:"item,omitempty"`
+	Request *postmanRequest `json:"request,omitempty"`
+	Event   []postmanEvent  `json:"event,omitempty"`
+}
+
+type postmanRequest struct {
+	Method string            `json:"method"`
+	Header []postmanKeyValue `json:"header"`
+	Url    postmanUrl        `json:"url"`
+	Body   *postmanBody      `json:"body,omitempty"`
+}
+
+type postmanUrl struct {
+	Raw string `json:"raw"`
+}
+
+type postmanBody struct {
+	Mode    string                 `json:"mode"`
+	Raw     string                 `json:"raw"`
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+// Matches apirunner template vars to convert them to Postman's "{{name}}" syntax
+var postmanTemplateRegex = regexp.MustCompile(`{{\s*([^\s|{}]+)(\s*\|\s*[a-zA-Z0-9]+)*\s*}}`)
+
+// ExportPostman converts the test files in 'testDir' whose names match 'testFilenameMatchRegex' into a
+// Postman (v2.1) collection written to 'out', with a folder per suite and a request per test. The base url
+// and custom headers from config become collection variables, and each request checks the expected status
+// code (and content type, if set). Postman variables don't support transforms, so any are dropped.
+func ExportPostman(runConfigFilename string, testDir string, testFilenameMatchRegex *regexp.Regexp, out io.Writer) error {
+	config, err := loadRunConfig(nil, runConfigFilename)
+	if err != nil {
+		return err
+	}
+	testFiles, err := findOrderedTestFiles(config, testDir, testFilenameMatchRegex)
+	if err != nil {
+		return err
+	}
+
+	collection := postmanCollection{
+		Info: postmanInfo{
+			Name:   filepath.Base(filepath.Clean(testDir)),
+			Schema: postmanSchema,
+		},
+		Variable: []postmanKeyValue{{Key: "baseUrl", Value: config.BaseUrl}},
+		Event: []postmanEvent{
+			{Listen: "test", Script: postmanScript{Type: "text/javascript", Exec: strings.Split(postmanMemoizeScript, "\n")}},
+		},
+		Item: make([]postmanItem, 0),
+	}
+	headerNames := make([]string, 0, len(config.CustomHeaders))
+	for headerName := range config.CustomHeaders {
+		headerNames = append(headerNames, headerName)
+	}
+	sort.Strings(headerNames)
+	for _, headerName := range headerNames {
+		collection.Variable = append(collection.Variable, postmanKeyValue{Key: "header." + headerName, Value: config.CustomHeaders[headerName]})
+	}
+
+	for _, testFile := range testFiles {
+		suiteSpec, err := loadSuiteSpec(config.FS, testFile)
+		if err != nil {
+			return err
+		}
+		if suiteSpec.Skip {
+			continue
+		}
+		folder := postmanItem{
+			Name: testFile,
+			Item: make([]postmanItem, 0),
+		}
+		for _, test := range suiteSpec.Tests {
+			if test.Skip || test.Exec != nil {
+				continue
+			}
+			item, err := postmanTestItem(config, headerNames, suiteSpec, test)
+			if err != nil {
+				return err
+			}
+			folder.Item = append(folder.Item, item)
+		}
+		collection.Item = append(collection.Item, folder)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(collection)
+	if err != nil {
+		return errors.Wrap(err, "error writing postman collection")
+	}
+	return nil
+}
+
+func postmanTestItem(config RunConfig, customHeaderNames []string, suiteSpec TestSuiteSpec, test TestSpec) (postmanItem, error) {
+	baseUrl := "{{baseUrl}}"
+	if test.Request.BaseUrl != "" {
+		baseUrl = test.Request.BaseUrl
+	} else if suiteSpec.BaseUrl != "" {
+		baseUrl = suiteSpec.BaseUrl
+	}
+	request := postmanRequest{
+		Method: test.Request.Method,
+		Header: make([]postmanKeyValue, 0),
+		Url:    postmanUrl{Raw: postmanTemplate(baseUrl + test.Request.Url)},
+	}
+	for _, headerName := range customHeaderNames {
+		request.Header = append(request.Header, postmanKeyValue{Key: headerName, Value: "{{header." + headerName + "}}"})
+	}
+	testHeaderNames := make([]string, 0, len(test.Request.Headers))
+	for headerName := range test.Request.Headers {
+		testHeaderNames = append(testHeaderNames, headerName)
+	}
+	sort.Strings(testHeaderNames)
+	for _, headerName := range testHeaderNames {
+		request.Header = append(request.Header, postmanKeyValue{Key: headerName, Value: postmanTemplate(test.Request.Headers[headerName])})
+	}
+	if test.Request.Body != nil {
+		body, err := requestBodyTemplate(test)
+		if err != nil {
+			return postmanItem{}, errors.Wrap(err, fmt.Sprintf("invalid request body for test '%s'", test.Name))
+		}
+		request.Body = &postmanBody{Mode: "raw", Raw: postmanTemplate(body)}
+		if _, ok := test.Request.Body.(string); !ok {
+			request.Body.Options = map[string]interface{}{"raw": map[string]string{"language": "json"}}
+		}
+	}
+
+	statusCode := expectedStatusCode(config, test)
+	testScript := []string{
+		fmt.Sprintf("pm.test(%s, () => { pm.response.to.have.status(%d); });", jsString(fmt.Sprintf("status is %d", statusCode)), statusCode),
+	}
+	if contentType := test.ExpectedResponse.ContentType; contentType != "" {
+		testScript = append(testScript, fmt.Sprintf("pm.test(%s, () => { pm.expect(pm.response.headers.get('Content-Type')).to.include(%s); });", jsString("content type is "+contentType), jsString(contentType)))
+	}
+	return postmanItem{
+		Name:    test.Name,
+		Request: &request,
+		Event: []postmanEvent{
+			{Listen: "test", Script: postmanScript{Type: "text/javascript", Exec: testScript}},
+		},
+	}, nil
+}
+
+// Converts apirunner template vars in 's' to Postman variables, e.g. "{{ createUser.userId | lower }}" to "{{createUser.userId}}"
+func postmanTemplate(s string) string {
+	return postmanTemplateRegex.ReplaceAllString(s, "{{$1}}")
+}
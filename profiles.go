@@ -0,0 +1,78 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Returns the name of the active config profile: 'profile' if set, else the config file's name without extension
+func profileName(profile string, runConfigFilename string) string {
+	if profile != "" {
+		return profile
+	}
+	return strings.TrimSuffix(filepath.Base(runConfigFilename), filepath.Ext(runConfigFilename))
+}
+
+// Returns 'test' with its expected response overridden by its ExpectedResponseOverrides for 'profile', if any
+func (test TestSpec) withProfileOverrides(profile string) TestSpec {
+	override, ok := test.ExpectedResponseOverrides[profile]
+	if !ok {
+		return test
+	}
+	test.ExpectedResponse = test.ExpectedResponse.merge(override)
+	return test
+}
+
+// Returns 'expected' with the fields set in 'override' replacing its own. Headers are overridden
+// individually, while the expected body (Body, BodyFile, FromSpecExample or BodyEmpty) is replaced as a whole.
+func (expected ExpectedResponse) merge(override ExpectedResponse) ExpectedResponse {
+	if override.StatusCode != 0 {
+		expected.StatusCode = override.StatusCode
+	}
+	if override.Body != nil || override.BodyFile != "" || override.FromSpecExample != "" || override.BodyEmpty {
+		expected.Body = override.Body
+		expected.BodyFile = override.BodyFile
+		expected.FromSpecExample = override.FromSpecExample
+		expected.BodyEmpty = override.BodyEmpty
+	}
+	if len(override.Headers) > 0 {
+		headers := make(map[string]string, len(expected.Headers)+len(override.Headers))
+		for k, v := range expected.Headers {
+			headers[k] = v
+		}
+		for k, v := range override.Headers {
+			headers[k] = v
+		}
+		expected.Headers = headers
+	}
+	if override.Redirects != nil {
+		expected.Redirects = override.Redirects
+	}
+	if override.FinalUrl != "" {
+		expected.FinalUrl = override.FinalUrl
+	}
+	if override.ContentType != "" {
+		expected.ContentType = override.ContentType
+	}
+	if override.ProtoMessage != "" {
+		expected.ProtoMessage = override.ProtoMessage
+	}
+	if override.SoftFields != nil {
+		expected.SoftFields = override.SoftFields
+	}
+	return expected
+}
@@ -0,0 +1,457 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"math"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Protobuf wire types
+const (
+	wireVarint     = 0
+	wireFixed64    = 1
+	wireBytes      = 2
+	wireStartGroup = 3
+	wireEndGroup   = 4
+	wireFixed32    = 5
+)
+
+// FieldDescriptorProto types
+const (
+	protoTypeDouble   = 1
+	protoTypeFloat    = 2
+	protoTypeInt64    = 3
+	protoTypeUint64   = 4
+	protoTypeInt32    = 5
+	protoTypeFixed64  = 6
+	protoTypeFixed32  = 7
+	protoTypeBool     = 8
+	protoTypeString   = 9
+	protoTypeGroup    = 10
+	protoTypeMessage  = 11
+	protoTypeBytes    = 12
+	protoTypeUint32   = 13
+	protoTypeEnum     = 14
+	protoTypeSfixed32 = 15
+	protoTypeSfixed64 = 16
+	protoTypeSint32   = 17
+	protoTypeSint64   = 18
+)
+
+const protoLabelRepeated = 3
+
+// Message and enum types loaded from a FileDescriptorSet (protoc --descriptor_set_out), keyed by fully qualified name
+type protoRegistry struct {
+	messages map[string]*protoMessage
+	enums    map[string]map[int64]string
+}
+
+type protoMessage struct {
+	name     string
+	fields   map[uint64]*protoField
+	mapEntry bool
+}
+
+type protoField struct {
+	name     string
+	jsonName string
+	number   uint64
+	label    uint64
+	typ      uint64
+	typeName string
+}
+
+// A decoded protobuf field: wire type and either its varint/fixed value or its bytes
+type protoWireField struct {
+	number   uint64
+	wireType uint64
+	value    uint64
+	bytes    []byte
+}
+
+// Returns true if 'contentType' is a protobuf media type
+func isProtobufContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/x-protobuf" || mediaType == "application/protobuf" || mediaType == "application/vnd.google.protobuf"
+}
+
+// Returns the message type named by the 'messageType' or 'proto' parameter of 'contentType', if any
+func protobufMessageType(contentType string) string {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if messageType, ok := params["messagetype"]; ok {
+		return messageType
+	}
+	return params["proto"]
+}
+
+func loadProtoRegistry(fsys fs.FS, descriptorSetFilename string) (*protoRegistry, error) {
+	data, err := readFile(fsys, descriptorSetFilename)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading proto descriptor set %s", descriptorSetFilename))
+	}
+	registry := &protoRegistry{
+		messages: make(map[string]*protoMessage),
+		enums:    make(map[string]map[int64]string),
+	}
+	fields, err := decodeWireFields(data)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("invalid proto descriptor set %s", descriptorSetFilename))
+	}
+	for _, file := range fields {
+		// FileDescriptorSet.file
+		if file.number != 1 || file.wireType != wireBytes {
+			continue
+		}
+		err = registry.addFile(file.bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid proto descriptor set %s", descriptorSetFilename))
+		}
+	}
+	return registry, nil
+}
+
+func (registry *protoRegistry) addFile(data []byte) error {
+	fields, err := decodeWireFields(data)
+	if err != nil {
+		return err
+	}
+	pkg := ""
+	for _, field := range fields {
+		// FileDescriptorProto.package
+		if field.number == 2 && field.wireType == wireBytes {
+			pkg = string(field.bytes)
+		}
+	}
+	for _, field := range fields {
+		var err error
+		switch {
+		// FileDescriptorProto.message_type
+		case field.number == 4 && field.wireType == wireBytes:
+			err = registry.addMessage(pkg, field.bytes)
+		// FileDescriptorProto.enum_type
+		case field.number == 5 && field.wireType == wireBytes:
+			err = registry.addEnum(pkg, field.bytes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (registry *protoRegistry) addMessage(scope string, data []byte) error {
+	fields, err := decodeWireFields(data)
+	if err != nil {
+		return err
+	}
+	message := &protoMessage{fields: make(map[uint64]*protoField)}
+	for _, field := range fields {
+		if field.number == 1 && field.wireType == wireBytes {
+			message.name = qualifiedProtoName(scope, string(field.bytes))
+		}
+	}
+	for _, field := range fields {
+		if field.wireType != wireBytes {
+			continue
+		}
+		switch field.number {
+		// DescriptorProto.field
+		case 2:
+			messageField, err := decodeProtoField(field.bytes)
+			if err != nil {
+				return err
+			}
+			message.fields[messageField.number] = messageField
+		// DescriptorProto.nested_type
+		case 3:
+			err = registry.addMessage(message.name, field.bytes)
+		// DescriptorProto.enum_type
+		case 4:
+			err = registry.addEnum(message.name, field.bytes)
+		// DescriptorProto.options
+		case 7:
+			options, err := decodeWireFields(field.bytes)
+			if err != nil {
+				return err
+			}
+			for _, option := range options {
+				// MessageOptions.map_entry
+				if option.number == 7 && option.wireType == wireVarint {
+					message.mapEntry = option.value != 0
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	registry.messages[message.name] = message
+	return nil
+}
+
+func (registry *protoRegistry) addEnum(scope string, data []byte) error {
+	fields, err := decodeWireFields(data)
+	if err != nil {
+		return err
+	}
+	name := ""
+	values := make(map[int64]string)
+	for _, field := range fields {
+		if field.wireType != wireBytes {
+			continue
+		}
+		switch field.number {
+		// EnumDescriptorProto.name
+		case 1:
+			name = qualifiedProtoName(scope, string(field.bytes))
+		// EnumDescriptorProto.value
+		case 2:
+			valueFields, err := decodeWireFields(field.bytes)
+			if err != nil {
+				return err
+			}
+			valueName := ""
+			var valueNumber int64
+			for _, valueField := range valueFields {
+				if valueField.number == 1 && valueField.wireType == wireBytes {
+					valueName = string(valueField.bytes)
+				}
+				if valueField.number == 2 && valueField.wireType == wireVarint {
+					valueNumber = int64(int32(valueField.value))
+				}
+			}
+			values[valueNumber] = valueName
+		}
+	}
+	registry.enums[name] = values
+	return nil
+}
+
+func decodeProtoField(data []byte) (*protoField, error) {
+	fields, err := decodeWireFields(data)
+	if err != nil {
+		return nil, err
+	}
+	field := &protoField{}
+	for _, f := range fields {
+		switch f.number {
+		case 1:
+			field.name = string(f.bytes)
+		case 3:
+			field.number = f.value
+		case 4:
+			field.label = f.value
+		case 5:
+			field.typ = f.value
+		case 6:
+			field.typeName = strings.TrimPrefix(string(f.bytes), ".")
+		case 10:
+			field.jsonName = string(f.bytes)
+		}
+	}
+	if field.jsonName == "" {
+		field.jsonName = field.name
+	}
+	return field, nil
+}
+
+func qualifiedProtoName(scope string, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+// Decodes the protobuf-encoded message 'data' of type 'messageType' into its JSON form
+func (registry *protoRegistry) decode(messageType string, data []byte) (map[string]interface{}, error) {
+	message, ok := registry.messages[strings.TrimPrefix(messageType, ".")]
+	if !ok {
+		return nil, fmt.Errorf("unknown protobuf message type '%s'", messageType)
+	}
+	wireFields, err := decodeWireFields(data)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]interface{})
+	for _, wireField := range wireFields {
+		field, ok := message.fields[wireField.number]
+		if !ok {
+			// Unknown fields are dropped, as in the canonical JSON mapping
+			continue
+		}
+		values, err := registry.decodeFieldValues(field, wireField)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error decoding field '%s' of %s", field.name, message.name))
+		}
+
+		// Map fields are repeated map entry messages
+		if entryType, isMap := registry.messages[field.typeName]; isMap && entryType.mapEntry {
+			mapVal, _ := res[field.jsonName].(map[string]interface{})
+			if mapVal == nil {
+				mapVal = make(map[string]interface{})
+			}
+			for _, v := range values {
+				entry := v.(map[string]interface{})
+				mapVal[fmt.Sprint(entry["key"])] = entry["value"]
+			}
+			res[field.jsonName] = mapVal
+			continue
+		}
+		if field.label == protoLabelRepeated {
+			existing, _ := res[field.jsonName].([]interface{})
+			res[field.jsonName] = append(existing, values...)
+		} else if len(values) > 0 {
+			res[field.jsonName] = values[len(values)-1]
+		}
+	}
+	return res, nil
+}
+
+// Decodes the value(s) of a single wire field. Packed repeated scalars decode to multiple values.
+func (registry *protoRegistry) decodeFieldValues(field *protoField, wireField protoWireField) ([]interface{}, error) {
+	switch field.typ {
+	case protoTypeString:
+		return []interface{}{string(wireField.bytes)}, nil
+	case protoTypeBytes:
+		return []interface{}{base64.StdEncoding.EncodeToString(wireField.bytes)}, nil
+	case protoTypeMessage:
+		val, err := registry.decode(field.typeName, wireField.bytes)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{val}, nil
+	case protoTypeGroup:
+		return nil, fmt.Errorf("groups are not supported")
+	}
+
+	// Scalars, possibly packed
+	if wireField.wireType == wireBytes {
+		values := make([]interface{}, 0)
+		data := wireField.bytes
+		for len(data) > 0 {
+			var raw uint64
+			switch field.typ {
+			case protoTypeDouble, protoTypeFixed64, protoTypeSfixed64:
+				if len(data) < 8 {
+					return nil, fmt.Errorf("truncated packed field")
+				}
+				raw, data = binary.LittleEndian.Uint64(data), data[8:]
+			case protoTypeFloat, protoTypeFixed32, protoTypeSfixed32:
+				if len(data) < 4 {
+					return nil, fmt.Errorf("truncated packed field")
+				}
+				raw, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+			default:
+				var n int
+				raw, n = binary.Uvarint(data)
+				if n <= 0 {
+					return nil, fmt.Errorf("invalid varint in packed field")
+				}
+				data = data[n:]
+			}
+			values = append(values, registry.scalarValue(field, raw))
+		}
+		return values, nil
+	}
+	return []interface{}{registry.scalarValue(field, wireField.value)}, nil
+}
+
+// Converts a raw varint/fixed value to its JSON form. 64-bit integers are strings, as in the canonical JSON mapping.
+func (registry *protoRegistry) scalarValue(field *protoField, raw uint64) interface{} {
+	switch field.typ {
+	case protoTypeDouble:
+		return math.Float64frombits(raw)
+	case protoTypeFloat:
+		return float64(math.Float32frombits(uint32(raw)))
+	case protoTypeInt64, protoTypeSfixed64:
+		return strconv.FormatInt(int64(raw), 10)
+	case protoTypeUint64, protoTypeFixed64:
+		return strconv.FormatUint(raw, 10)
+	case protoTypeSint64:
+		return strconv.FormatInt(int64(raw>>1)^-int64(raw&1), 10)
+	case protoTypeInt32, protoTypeSfixed32:
+		return float64(int32(raw))
+	case protoTypeUint32, protoTypeFixed32:
+		return float64(uint32(raw))
+	case protoTypeSint32:
+		return float64(int32(uint32(raw>>1) ^ -uint32(raw&1)))
+	case protoTypeBool:
+		return raw != 0
+	case protoTypeEnum:
+		if name, ok := registry.enums[field.typeName][int64(int32(raw))]; ok {
+			return name
+		}
+		return float64(int32(raw))
+	}
+	return float64(raw)
+}
+
+// Splits protobuf-encoded 'data' into its fields
+func decodeWireFields(data []byte) ([]protoWireField, error) {
+	fields := make([]protoWireField, 0)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid field key")
+		}
+		data = data[n:]
+		field := protoWireField{number: key >> 3, wireType: key & 7}
+		switch field.wireType {
+		case wireVarint:
+			field.value, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint for field %d", field.number)
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("truncated fixed64 field %d", field.number)
+			}
+			field.value, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("truncated fixed32 field %d", field.number)
+			}
+			field.value, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return nil, fmt.Errorf("truncated length-delimited field %d", field.number)
+			}
+			field.bytes, data = data[n:n+int(length)], data[n+int(length):]
+		case wireStartGroup, wireEndGroup:
+			return nil, fmt.Errorf("groups are not supported (field %d)", field.number)
+		default:
+			return nil, fmt.Errorf("invalid wire type %d for field %d", field.wireType, field.number)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A known-flaky test whose failures don't fail the run until 'Expires'
+type QuarantineEntry struct {
+	// Test file (relative to the test directory). If empty, the test is quarantined in all test files.
+	File string `json:"file"`
+	Test string `json:"test"`
+	// Date (YYYY-MM-DD, inclusive) or RFC3339 time after which the test's failures count again
+	Expires string `json:"expires"`
+	Reason  string `json:"reason"`
+	expires time.Time
+}
+
+// Loads the quarantine entries in 'quarantineFilename' (in 'fsys', see RunConfig.FS)
+func loadQuarantine(fsys fs.FS, quarantineFilename string) ([]QuarantineEntry, error) {
+	contents, err := readFile(fsys, quarantineFilename)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading quarantine file %s", quarantineFilename))
+	}
+	var entries []QuarantineEntry
+	err = json.Unmarshal(contents, &entries)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error parsing quarantine file %s", quarantineFilename))
+	}
+	for i, entry := range entries {
+		if entry.Test == "" {
+			return nil, fmt.Errorf("quarantine entry %d in %s has no test", i, quarantineFilename)
+		}
+		if date, err := time.Parse(time.DateOnly, entry.Expires); err == nil {
+			entries[i].expires = date.AddDate(0, 0, 1)
+		} else if entries[i].expires, err = time.Parse(time.RFC3339, entry.Expires); err != nil {
+			return nil, fmt.Errorf("invalid expires '%s' of quarantine entry for '%s' in %s, must be a date (YYYY-MM-DD) or RFC3339 time", entry.Expires, entry.Test, quarantineFilename)
+		}
+	}
+	return entries, nil
+}
+
+// Returns the entry quarantining the test 'testName' in 'testFilename' (relative to 'testDir'), if any
+func findQuarantineEntry(entries []QuarantineEntry, testDir string, testFilename string, testName string) (QuarantineEntry, bool) {
+	relFilename, err := filepath.Rel(testDir, testFilename)
+	if err != nil {
+		relFilename = testFilename
+	}
+	for _, entry := range entries {
+		if entry.Test == testName && (entry.File == "" || filepath.Clean(entry.File) == relFilename) {
+			return entry, true
+		}
+	}
+	return QuarantineEntry{}, false
+}
+
+// Moves the failures of tests with an unexpired quarantine entry from each result's Failed to its Quarantined
+// tests, warning about any expired entries on 'out'
+func applyQuarantine(out io.Writer, entries []QuarantineEntry, testDir string, results []TestSuiteResult) {
+	now := time.Now()
+	for i, result := range results {
+		failed := make([]TestResult, 0, len(result.Failed))
+		for _, testResult := range result.Failed {
+			entry, ok := findQuarantineEntry(entries, testDir, result.TestFilename, testResult.Name)
+			switch {
+			case !ok:
+				failed = append(failed, testResult)
+			case now.After(entry.expires):
+				fmt.Fprintf(out, "Quarantine of '%s' in '%s' expired on %s, its failures count again\n", testResult.Name, result.TestFilename, entry.Expires)
+				failed = append(failed, testResult)
+			default:
+				results[i].Quarantined = append(results[i].Quarantined, testResult)
+			}
+		}
+		results[i].Failed = failed
+	}
+}
+
+// Prints the quarantined failures in 'results' to 'out'
+func printQuarantined(out io.Writer, entries []QuarantineEntry, testDir string, results []TestSuiteResult) {
+	for _, result := range results {
+		for _, testResult := range result.Quarantined {
+			entry, _ := findQuarantineEntry(entries, testDir, result.TestFilename, testResult.Name)
+			fmt.Fprintf(out, "'%s' (quarantined until %s: %s):\n%s", result.TestFilename, entry.Expires, entry.Reason, testResult.Result())
+		}
+	}
+}
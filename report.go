@@ -0,0 +1,164 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Statuses of tests in a RunReport
+const (
+	TestStatusPassed      = "passed"
+	TestStatusFailed      = "failed"
+	TestStatusSkipped     = "skipped"
+	TestStatusQuarantined = "quarantined"
+	TestStatusWarning     = "warning"
+)
+
+// Machine-readable results of a run, written via RunOptions.ReportFile or "json" reporters (see RunConfig.Reporters)
+type RunReport struct {
+	Run        RunMetadata   `json:"run"`
+	Summary    RunSummary    `json:"summary"`
+	Suites     []SuiteReport `json:"suites"`
+	DurationMs int64         `json:"durationMs"`
+}
+
+// Number of tests of a run by status
+type RunSummary struct {
+	Total       int `json:"total"`
+	Passed      int `json:"passed"`
+	Failed      int `json:"failed"`
+	Skipped     int `json:"skipped"`
+	Quarantined int `json:"quarantined"`
+	Warnings    int `json:"warnings"`
+}
+
+type SuiteReport struct {
+	// Path of the test file relative to the test directory
+	TestFile   string       `json:"testFile"`
+	DurationMs float64      `json:"durationMs"`
+	TimedOut   bool         `json:"timedOut,omitempty"`
+	Tests      []TestReport `json:"tests"`
+}
+
+type TestReport struct {
+	Name             string                 `json:"name"`
+	Status           string                 `json:"status"`
+	DurationMs       float64                `json:"durationMs"`
+	Method           string                 `json:"method,omitempty"`
+	Url              string                 `json:"url,omitempty"`
+	Slow             bool                   `json:"slow,omitempty"`
+	Errors           []string               `json:"errors,omitempty"`
+	Category         FailureCategory        `json:"category,omitempty"`
+	RequestId        string                 `json:"requestId,omitempty"`
+	TraceId          string                 `json:"traceId,omitempty"`
+	SkipReason       string                 `json:"skipReason,omitempty"`
+	SoftFailures     []string               `json:"softFailures,omitempty"`
+	DeprecatedFields []string               `json:"deprecatedFields,omitempty"`
+	Metadata         map[string]string      `json:"metadata,omitempty"`
+	Response         map[string]interface{} `json:"response,omitempty"`
+}
+
+// Returns the report of the run described by 'run' of the test files in 'testDir' with 'results'
+func newRunReport(run RunMetadata, testDir string, results []TestSuiteResult, duration time.Duration) RunReport {
+	report := RunReport{
+		Run:        run,
+		Suites:     make([]SuiteReport, 0, len(results)),
+		DurationMs: duration.Milliseconds(),
+	}
+	for _, result := range results {
+		testFile, err := filepath.Rel(testDir, result.TestFilename)
+		if err != nil {
+			testFile = result.TestFilename
+		}
+		suite := SuiteReport{
+			TestFile:   filepath.ToSlash(testFile),
+			DurationMs: float64(result.Duration.Microseconds()) / 1000,
+			TimedOut:   result.TimedOut,
+			Tests:      make([]TestReport, 0, result.TotalTests),
+		}
+		report.Summary.Total += result.TotalTests
+		report.Summary.Passed += len(result.Passed)
+		report.Summary.Failed += len(result.Failed)
+		report.Summary.Skipped += len(result.Skipped)
+		report.Summary.Quarantined += len(result.Quarantined)
+		report.Summary.Warnings += len(result.Warnings)
+		for _, tests := range []struct {
+			status  string
+			results []TestResult
+		}{
+			{TestStatusPassed, result.Passed},
+			{TestStatusFailed, result.Failed},
+			{TestStatusSkipped, result.Skipped},
+			{TestStatusQuarantined, result.Quarantined},
+			{TestStatusWarning, result.Warnings},
+		} {
+			for _, testResult := range tests.results {
+				suite.Tests = append(suite.Tests, TestReport{
+					Name:             testResult.Name,
+					Status:           tests.status,
+					DurationMs:       float64(testResult.Duration.Microseconds()) / 1000,
+					Method:           testResult.RequestMethod,
+					Url:              testResult.RequestUrl,
+					Slow:             testResult.Slow,
+					Errors:           testResult.Errors,
+					Category:         testResult.Category,
+					RequestId:        testResult.RequestId,
+					TraceId:          testResult.TraceId,
+					SkipReason:       testResult.SkipReason,
+					SoftFailures:     testResult.SoftFailures,
+					DeprecatedFields: testResult.DeprecatedFields,
+					Metadata:         testResult.Metadata,
+					Response:         testResult.Response,
+				})
+			}
+		}
+		report.Suites = append(report.Suites, suite)
+	}
+	return report
+}
+
+// Writes 'report' to 'reportFilename' as JSON
+func writeRunReport(report RunReport, reportFilename string) error {
+	contents, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "error encoding report")
+	}
+	err = os.WriteFile(reportFilename, contents, 0644)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing report %s", reportFilename))
+	}
+	return nil
+}
+
+// LoadRunReport reads a report written via RunOptions.ReportFile
+func LoadRunReport(reportFilename string) (RunReport, error) {
+	contents, err := os.ReadFile(reportFilename)
+	if err != nil {
+		return RunReport{}, errors.Wrap(err, fmt.Sprintf("error reading report %s", reportFilename))
+	}
+	var report RunReport
+	err = json.Unmarshal(contents, &report)
+	if err != nil {
+		return RunReport{}, errors.Wrap(err, fmt.Sprintf("error parsing report %s", reportFilename))
+	}
+	return report, nil
+}
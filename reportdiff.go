@@ -0,0 +1,137 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Options for comparing two run reports
+type ReportDiffOptions struct {
+	// Minimum ratio of new to old duration for a test to count as significantly slower (default 1.5)
+	SlowerRatio float64
+	// Minimum increase in duration in milliseconds for a test to count as significantly slower (default 100)
+	SlowerMinMs float64
+}
+
+// Differences between two run reports. Tests are identified as '<testFile>: <testName>'.
+type ReportDiff struct {
+	NewlyFailing []string
+	Fixed        []string
+	Added        []string
+	Removed      []string
+	Slower       []SlowerTest
+}
+
+// A test that got significantly slower
+type SlowerTest struct {
+	Test    string
+	OldMs   float64
+	NewMs   float64
+	Percent float64
+}
+
+// Regressed returns true if any test is newly failing
+func (diff ReportDiff) Regressed() bool {
+	return len(diff.NewlyFailing) > 0
+}
+
+// Report returns a printable summary of the differences
+func (diff ReportDiff) Report() string {
+	var report strings.Builder
+	for _, section := range []struct {
+		title string
+		tests []string
+	}{
+		{"Newly failing", diff.NewlyFailing},
+		{"Fixed", diff.Fixed},
+		{"Added", diff.Added},
+		{"Removed", diff.Removed},
+	} {
+		fmt.Fprintf(&report, "%s: %d\n", section.title, len(section.tests))
+		for _, test := range section.tests {
+			fmt.Fprintf(&report, "\t%s\n", test)
+		}
+	}
+	fmt.Fprintf(&report, "Significantly slower: %d\n", len(diff.Slower))
+	for _, slower := range diff.Slower {
+		fmt.Fprintf(&report, "\t%s: %.1fms -> %.1fms (+%.0f%%)\n", slower.Test, slower.OldMs, slower.NewMs, slower.Percent)
+	}
+	return report.String()
+}
+
+// DiffRunReports compares the run report 'newReport' to 'oldReport' (e.g. of a PR branch to main)
+func DiffRunReports(oldReport RunReport, newReport RunReport, options ReportDiffOptions) ReportDiff {
+	if options.SlowerRatio <= 0 {
+		options.SlowerRatio = 1.5
+	}
+	if options.SlowerMinMs <= 0 {
+		options.SlowerMinMs = 100
+	}
+	oldTests := reportTests(oldReport)
+	newTests := reportTests(newReport)
+	diff := ReportDiff{}
+	for _, key := range sortedTestKeys(newTests) {
+		newTest := newTests[key]
+		oldTest, ok := oldTests[key]
+		if !ok {
+			diff.Added = append(diff.Added, key)
+			continue
+		}
+		switch {
+		case newTest.Status == TestStatusFailed && oldTest.Status != TestStatusFailed:
+			diff.NewlyFailing = append(diff.NewlyFailing, key)
+		case oldTest.Status == TestStatusFailed && newTest.Status == TestStatusPassed:
+			diff.Fixed = append(diff.Fixed, key)
+		}
+		if oldTest.Status == TestStatusPassed && newTest.Status == TestStatusPassed &&
+			newTest.DurationMs >= oldTest.DurationMs*options.SlowerRatio && newTest.DurationMs-oldTest.DurationMs >= options.SlowerMinMs {
+			diff.Slower = append(diff.Slower, SlowerTest{
+				Test:    key,
+				OldMs:   oldTest.DurationMs,
+				NewMs:   newTest.DurationMs,
+				Percent: (newTest.DurationMs/oldTest.DurationMs - 1) * 100,
+			})
+		}
+	}
+	for _, key := range sortedTestKeys(oldTests) {
+		if _, ok := newTests[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	return diff
+}
+
+// Returns the tests of 'report' keyed by '<testFile>: <testName>'
+func reportTests(report RunReport) map[string]TestReport {
+	tests := make(map[string]TestReport)
+	for _, suite := range report.Suites {
+		for _, test := range suite.Tests {
+			tests[fmt.Sprintf("%s: %s", suite.TestFile, test.Name)] = test
+		}
+	}
+	return tests
+}
+
+func sortedTestKeys(tests map[string]TestReport) []string {
+	keys := make([]string, 0, len(tests))
+	for key := range tests {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,229 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Types of reporters
+const (
+	ReporterTypeJson    = "json"
+	ReporterTypeJunit   = "junit"
+	ReporterTypeTap     = "tap"
+	ReporterTypeHtml    = "html"
+	ReporterTypeCommand = "command"
+)
+
+var reporterTypes = []string{ReporterTypeJson, ReporterTypeJunit, ReporterTypeTap, ReporterTypeHtml, ReporterTypeCommand}
+
+// Output the results of a run are reported to at its end, in addition to the console. Any number of
+// reporters can be configured, e.g. a JUnit file for CI alongside a JSON report for dashboards.
+type ReporterConfig struct {
+	// One of "json", "junit", "tap", "html" or "command"
+	Type string `json:"type"`
+	// File the report is written to (for all types but "command")
+	File string `json:"file"`
+	// Command (and its args) the run's JSON report (see RunReport) is piped to on stdin, for custom
+	// output without changing apirunner. A non-zero exit status fails the run.
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+func (config ReporterConfig) validate() error {
+	if !slices.Contains(reporterTypes, config.Type) {
+		return fmt.Errorf("invalid reporter type '%s', must be one of %s", config.Type, strings.Join(reporterTypes, ", "))
+	}
+	if config.Type == ReporterTypeCommand {
+		if config.Command == "" {
+			return fmt.Errorf("command reporters require a command")
+		}
+	} else if config.File == "" {
+		return fmt.Errorf("%s reporters require a file", config.Type)
+	}
+	return nil
+}
+
+// Reports 'report' to the reporter's output. Commands print to runConfig.Output and ErrOutput.
+func (config ReporterConfig) write(runConfig RunConfig, report RunReport) error {
+	switch config.Type {
+	case ReporterTypeJson:
+		return writeRunReport(report, config.File)
+	case ReporterTypeJunit:
+		return writeJunitReport(report, config.File)
+	case ReporterTypeTap:
+		tapFile, err := os.Create(config.File)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error writing TAP report %s", config.File))
+		}
+		defer tapFile.Close()
+		err = writeTapReport(report, tapFile)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error writing TAP report %s", config.File))
+		}
+		return nil
+	case ReporterTypeHtml:
+		return writeHtmlReport(report, config.File)
+	default:
+		contents, err := json.Marshal(report)
+		if err != nil {
+			return errors.Wrap(err, "error encoding report")
+		}
+		cmd := exec.Command(config.Command, config.Args...)
+		cmd.Stdin = bytes.NewReader(contents)
+		cmd.Stdout = runConfig.output()
+		cmd.Stderr = runConfig.errOutput()
+		err = cmd.Run()
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error running reporter command %s", config.Command))
+		}
+		return nil
+	}
+}
+
+// Writes 'report' to every one of 'reporters', even if writing to an earlier one fails. Returns the first error.
+func writeReports(config RunConfig, report RunReport, reporters []ReporterConfig) error {
+	var firstErr error
+	for _, reporter := range reporters {
+		err := reporter.write(config, report)
+		if err != nil {
+			fmt.Fprintf(config.errOutput(), "%s\n", fmt.Sprintf(ErrorString, err.Error()))
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if reporter.Type != ReporterTypeCommand {
+			fmt.Fprintf(config.output(), "Wrote %s report '%s'\n", reporter.Type, reporter.File)
+		}
+	}
+	return firstErr
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name       string          `xml:"name,attr"`
+	ClassName  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitMessage   `xml:"failure"`
+	Skipped    *junitMessage   `xml:"skipped"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// Writes 'report' to 'junitFilename' in JUnit XML format, one testsuite per test file. Quarantined
+// failures and warnings (see RunOptions.FailOnSeverity) pass, with their errors as the test's output.
+// The run's metadata are properties of each testsuite and each test's metadata properties of its testcase.
+func writeJunitReport(report RunReport, junitFilename string) error {
+	junit := junitTestSuites{Time: junitSeconds(float64(report.DurationMs))}
+	runProperties := junitRunProperties(report.Run)
+	for _, suite := range report.Suites {
+		junitSuite := junitTestSuite{Name: suite.TestFile, Time: junitSeconds(suite.DurationMs), Properties: runProperties}
+		for _, test := range suite.Tests {
+			testCase := junitTestCase{Name: test.Name, ClassName: suite.TestFile, Time: junitSeconds(test.DurationMs)}
+			for _, key := range slices.Sorted(maps.Keys(test.Metadata)) {
+				testCase.Properties = append(testCase.Properties, junitProperty{Name: key, Value: test.Metadata[key]})
+			}
+			switch test.Status {
+			case TestStatusFailed:
+				junitSuite.Failures++
+				testCase.Failure = &junitMessage{Type: string(test.Category), Text: strings.Join(test.Errors, "\n")}
+				if len(test.Errors) > 0 {
+					testCase.Failure.Message = test.Errors[0]
+				}
+			case TestStatusSkipped:
+				junitSuite.Skipped++
+				testCase.Skipped = &junitMessage{Message: test.SkipReason}
+			case TestStatusQuarantined, TestStatusWarning:
+				testCase.SystemOut = fmt.Sprintf("%s:\n%s", test.Status, strings.Join(test.Errors, "\n"))
+			}
+			junitSuite.Cases = append(junitSuite.Cases, testCase)
+		}
+		junitSuite.Tests = len(junitSuite.Cases)
+		junit.Tests += junitSuite.Tests
+		junit.Failures += junitSuite.Failures
+		junit.Skipped += junitSuite.Skipped
+		junit.Suites = append(junit.Suites, junitSuite)
+	}
+	contents, err := xml.MarshalIndent(junit, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "error encoding JUnit report")
+	}
+	err = os.WriteFile(junitFilename, append([]byte(xml.Header), contents...), 0644)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing JUnit report %s", junitFilename))
+	}
+	return nil
+}
+
+// Returns the JUnit properties of the run metadata 'run', omitting unknown ones
+func junitRunProperties(run RunMetadata) []junitProperty {
+	var startTime string
+	if !run.StartTime.IsZero() {
+		startTime = run.StartTime.Format(time.RFC3339)
+	}
+	var properties []junitProperty
+	for _, property := range []junitProperty{{"run.id", run.Id}, {"run.startTime", startTime}, {"run.gitSha", run.GitSha}, {"run.hostname", run.Hostname}, {"run.profile", run.Profile}} {
+		if property.Value != "" {
+			properties = append(properties, property)
+		}
+	}
+	return properties
+}
+
+// Formats a duration of 'ms' milliseconds as JUnit's seconds
+func junitSeconds(ms float64) string {
+	return fmt.Sprintf("%.3f", ms/1000)
+}
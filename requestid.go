@@ -0,0 +1,54 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"net/http"
+)
+
+const defaultRequestIdHeader = "X-Request-Id"
+
+// Correlation of tests with server-side logs. The request id in Header (default X-Request-Id) of each
+// test's response is recorded with its result. If Generate is set, a fresh id is sent with every request
+// that doesn't set Header itself, and recorded if the server doesn't echo back an id of its own.
+type RequestIdConfig struct {
+	Header   string `json:"header"`
+	Generate bool   `json:"generate"`
+}
+
+func (config RequestIdConfig) headerName() string {
+	if config.Header == "" {
+		return defaultRequestIdHeader
+	}
+	return config.Header
+}
+
+// Returns the configured request id settings (the defaults if not configured)
+func (suite TestSuite) requestIdConfig() RequestIdConfig {
+	if suite.config.RequestId == nil {
+		return RequestIdConfig{}
+	}
+	return *suite.config.RequestId
+}
+
+// Returns the id correlating 'req' (and 'resp', if one was received) with server-side logs
+func (config RequestIdConfig) requestId(req *http.Request, resp *http.Response) string {
+	if resp != nil {
+		if requestId := resp.Header.Get(config.headerName()); requestId != "" {
+			return requestId
+		}
+	}
+	return req.Header.Get(config.headerName())
+}
@@ -15,13 +15,16 @@
 package apirunner
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -29,83 +32,659 @@ import (
 )
 
 type RunConfig struct {
-	BaseUrl       string            `json:"baseUrl"`
-	CustomHeaders map[string]string `json:"headers"`
-	HttpClient    HttpClient
+	BaseUrl            string                     `json:"baseUrl"`
+	CustomHeaders      map[string]string          `json:"headers"`
+	Auth               *TokenAuthConfig           `json:"auth"`
+	Signing            *SigningConfig             `json:"signing"`
+	IdempotencyKey     *IdempotencyKeyConfig      `json:"idempotencyKey"`
+	RequestId          *RequestIdConfig           `json:"requestId"`
+	Transport          *TransportConfig           `json:"transport"`
+	ClientProfiles     map[string]TransportConfig `json:"clientProfiles"`
+	DefaultStatusCode  int                        `json:"defaultStatusCode"`
+	ProtoDescriptorSet string                     `json:"protoDescriptorSet"`
+	DryRun             bool                       `json:"dryRun"`
+	Stubs              *StubsConfig               `json:"stubs"`
+	TestTime           *TestTimeConfig            `json:"testTime"`
+	PortForward        *PortForwardConfig         `json:"portForward"`
+	Pact               *PactConfig                `json:"pact"`
+	OpenApiSpec        string                     `json:"openApiSpec"`
+	Timeouts           *TimeoutConfig             `json:"timeouts"`
+	SuiteOrder         string                     `json:"suiteOrder"`
+	Quarantine         string                     `json:"quarantine"`
+	Tracing            *TracingConfig             `json:"tracing"`
+	Plugins            []PluginConfig             `json:"plugins"`
+	AllowTemplateExec  bool                       `json:"allowTemplateExec"`
+	Factories          map[string]Factory         `json:"factories"`
+	Deprecations       *DeprecationConfig         `json:"deprecations"`
+	Budget             *BudgetConfig              `json:"budget"`
+	ProtectedUrls      []string                   `json:"protectedUrls"`
+	Flags              *FlagsConfig               `json:"flags"`
+	Doctor             *DoctorConfig              `json:"doctor"`
+	Reporters          []ReporterConfig           `json:"reporters"`
+	// Directory to write the artifacts of each failed test to (see writeFailureArtifacts), under a
+	// directory per run that's zipped at the end of the run for CI to upload
+	ArtifactsDir string `json:"artifactsDir"`
+	// Number of response body differences tolerated as soft failures per test (see TestSpec.AllowedDiffCount)
+	MaxDiffs int `json:"maxDiffs"`
+	// File to write template vars to at the end of the run, for later steps of a pipeline
+	VariableExport *VariableExportConfig `json:"variableExport"`
+	// Environment variables set for the process during the run (restored afterwards), e.g. for plugins,
+	// exec steps or a custom HttpClient reading their config from the environment
+	Env map[string]string `json:"env"`
+	// File caching the results of passing tests, to skip tests whose inputs are unchanged in later runs
+	CacheFile string `json:"cacheFile"`
+	// Name of the config profile selecting the tests' expected response overrides (see TestSpec.ExpectedResponseOverrides)
+	Profile    string `json:"profile"`
+	HttpClient HttpClient
+	// Writers results and progress (stdout if nil) and errors (stderr if nil) are printed to, e.g. to
+	// capture or silence the output of an embedded run
+	Output    io.Writer `json:"-"`
+	ErrOutput io.Writer `json:"-"`
+	// Lifecycle hooks, e.g. for custom metrics or notifications
+	Hooks *Hooks `json:"-"`
+	// Filesystem test files (and the suiteOrder index, body files, files read by the "file" template
+	// function and the quarantine, openApiSpec, protoDescriptorSet and transport certificate files) are
+	// loaded from instead of the OS filesystem, e.g. an embed.FS, with slash-separated paths relative to its root
+	FS               fs.FS `json:"-"`
+	tokenCache       *tokenCache
+	profileClients   map[string]HttpClient
+	protoRegistry    *protoRegistry
+	stubServer       *stubServer
+	chaosProxy       *chaosProxy
+	portForward      *portForward
+	pactRecorder     *pactRecorder
+	tracer           *tracer
+	plugins          []*plugin
+	deprecatedFields []ignoredField
+	budget           *requestBudget
+	protectedUrls    []*regexp.Regexp
+	activeFlags      map[string]bool
+	resultCache      *resultCache
+	noCache          bool
+	factories        map[string]Factory
+	runId            string
+	openApiExamples  map[string]openApiExample
+	runDeadline      deadline
+	// Canceled to abort the run, see RunWithContext
+	ctx            context.Context
+	metadataFilter map[string]string
+	approve        bool
+}
+
+// Options for a run that override the RunConfig loaded from the config file
+type RunOptions struct {
+	// Don't send any requests, only assert on the requests that would be sent (see TestSpec.ExpectedRequest)
+	DryRun bool
+	// Only execute tests with all of these metadata key/value pairs (others are skipped)
+	Metadata map[string]string
+	// Minimum severity (see ParseFailOn) of failures that fail the run. Failures of tests with a lower
+	// "severity" metadata are reported as warnings instead.
+	FailOnSeverity string
+	// Approval mode: record the response body of each passing test without an expected body
+	// (or with "__record__" as its body) as its expected body in its test file
+	Approve bool
+	// File to write a machine-readable (JSON) report of the run's results to
+	ReportFile string
+	// Writer to write the run's results to in Test Anything Protocol format, in addition to the console output
+	Tap io.Writer
+	// File to write a self-contained HTML page of the run's results to, e.g. for sharing nightly results
+	HtmlReportFile string
+	// Run tests even if they passed with the same inputs before (see RunConfig.CacheFile), still recording them
+	NoCache bool
+	// Name of the config profile, selecting the tests' expected response overrides and recorded in reports
+	// (overrides RunConfig.Profile, which defaults to the config file's name without extension)
+	Profile string
+	// Skip tests with "destructive": "true" metadata and refuse to send methods other than GET, HEAD and
+	// OPTIONS (see BudgetConfig.ReadOnly)
+	ReadOnly bool
+	// Override RunConfig.Output and RunConfig.ErrOutput if set
+	Output    io.Writer
+	ErrOutput io.Writer
+	// Overrides RunConfig.Hooks if set
+	Hooks *Hooks
+	// Overrides RunConfig.ArtifactsDir if set
+	ArtifactsDir string
+	// Overrides RunConfig.MaxDiffs if set
+	MaxDiffs int
+	// Overrides RunConfig.VariableExport if set
+	VariableExport *VariableExportConfig
+	// Filesystem the config file is loaded from, overriding RunConfig.FS if set
+	FS fs.FS
 }
 
 // Run executes all test files in 'testDir'. Returns true if all tests pass, false otherwise (including on err)
 func Run(runConfigFilename string, testDir string, testFilenameMatchRegex *regexp.Regexp) (bool, error) {
+	return RunWithOptions(runConfigFilename, testDir, testFilenameMatchRegex, RunOptions{})
+}
+
+// RunWithOptions executes all test files in 'testDir' like Run, applying 'options' on top of the loaded RunConfig
+func RunWithOptions(runConfigFilename string, testDir string, testFilenameMatchRegex *regexp.Regexp, options RunOptions) (bool, error) {
+	return RunWithContext(context.Background(), runConfigFilename, testDir, testFilenameMatchRegex, options)
+}
+
+// RunWithContext executes all test files in 'testDir' like RunWithOptions until 'ctx' is done (e.g. on Ctrl-C).
+// Requests in flight are then aborted, the remaining tests are skipped and the results so far are summarized
+// and reported as a failed run.
+func RunWithContext(ctx context.Context, runConfigFilename string, testDir string, testFilenameMatchRegex *regexp.Regexp, options RunOptions) (bool, error) {
 	// Load and validate RunConfig
-	configFile, err := os.Open(runConfigFilename)
+	config, err := loadRunConfig(options.FS, runConfigFilename)
 	if err != nil {
-		return false, errors.Wrap(err, fmt.Sprintf("invalid config file: %s", runConfigFilename))
+		return false, err
 	}
-	defer configFile.Close()
-	configBytes, err := io.ReadAll(configFile)
-	if err != nil {
-		return false, errors.Wrap(err, fmt.Sprintf("error reading %s", runConfigFilename))
+	if options.FS != nil {
+		config.FS = options.FS
 	}
-	var config RunConfig
-	err = json.Unmarshal(configBytes, &config)
+	if options.Approve && config.FS != nil {
+		return false, fmt.Errorf("approval mode requires test files on the OS filesystem to write to")
+	}
+	if options.DryRun {
+		config.DryRun = true
+	}
+	if options.FailOnSeverity != "" && severityRank(options.FailOnSeverity) < 0 {
+		return false, fmt.Errorf("invalid severity '%s', must be one of %s", options.FailOnSeverity, strings.Join(severities, ", "))
+	}
+	config.noCache = options.NoCache
+	config.ctx = ctx
+	if options.Output != nil {
+		config.Output = options.Output
+	}
+	if options.ErrOutput != nil {
+		config.ErrOutput = options.ErrOutput
+	}
+	if options.Hooks != nil {
+		config.Hooks = options.Hooks
+	}
+	if options.ArtifactsDir != "" {
+		config.ArtifactsDir = options.ArtifactsDir
+	}
+	if options.MaxDiffs != 0 {
+		config.MaxDiffs = options.MaxDiffs
+	}
+	if options.VariableExport != nil {
+		config.VariableExport = options.VariableExport
+	}
+	if options.ReadOnly {
+		if config.Budget == nil {
+			config.Budget = &BudgetConfig{}
+		}
+		config.Budget.ReadOnly = true
+	}
+	if options.Profile != "" {
+		config.Profile = options.Profile
+	}
+	config.Profile = profileName(config.Profile, runConfigFilename)
+	config.metadataFilter = options.Metadata
+	config.approve = options.Approve
+	config, closeRun, err := prepareRun(config)
 	if err != nil {
-		return false, errors.Wrap(err, "invalid run config")
+		return false, err
 	}
-	// Use default httpclient to make requests
-	config.HttpClient = http.DefaultClient
+	defer closeRun()
 
 	// Find test files
-	testFiles := make([]string, 0)
-	err = filepath.Walk(testDir, func(path string, info os.FileInfo, err error) error {
+	testFiles, err := findOrderedTestFiles(config, testDir, testFilenameMatchRegex)
+	if err != nil {
+		return false, err
+	}
+	for _, testFile := range testFiles {
+		fmt.Fprintf(config.output(), "Found '%s'\n", testFile)
+	}
+	// Parse and validate all test files before making any requests
+	suites, err := compileTestFiles(config, testFiles)
+	if err != nil {
+		return false, err
+	}
+	var quarantine []QuarantineEntry
+	if config.Quarantine != "" {
+		quarantine, err = loadQuarantine(config.FS, config.Quarantine)
 		if err != nil {
-			return err
+			return false, err
 		}
-
-		if !info.IsDir() && strings.HasSuffix(info.Name(), ".json") && testFilenameMatchRegex.MatchString(info.Name()) {
-			fmt.Printf("Found '%s'\n", path)
-			testFiles = append(testFiles, path)
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return false, errors.Wrap(err, fmt.Sprintf("Error reading dir: %s", testDir))
 	}
 
 	// Execute tests
 	results := make([]TestSuiteResult, 0)
 	start := time.Now()
-	for _, testFile := range testFiles {
-		suiteResult, err := ExecuteSuite(config, testFile, false)
+	// Run metadata is only looked up (which may run git) if hooks or reports need it
+	var run *RunMetadata
+	runMetadata := func() RunMetadata {
+		if run == nil {
+			metadata := newRunMetadata(config, runConfigFilename, testDir, config.Profile, start)
+			run = &metadata
+		}
+		return *run
+	}
+	if config.Hooks != nil {
+		config.Hooks.runStart(RunStartEvent{Run: runMetadata(), TestFiles: testFiles})
+	}
+	config.runDeadline = runDeadline(config, start)
+	timedOut := false
+	canceled := false
+	// Error for the first request refused by the run's budget, which aborts the run
+	var budgetErr error
+	prevDir := ""
+	for i, suite := range suites {
+		// Print a header whenever execution moves on to a different directory
+		if dir := filepath.Dir(suite.fileName); dir != prevDir {
+			fmt.Fprintf(config.output(), "\n== %s/ ==\n", dir)
+			prevDir = dir
+		}
+		suiteResult, err := executeCompiledSuite(config, suite, false)
 		if err != nil {
-			fmt.Printf("Error running tests for '%s': %v\n", testFile, err)
+			fmt.Fprintf(config.errOutput(), "Error running tests for '%s': %v\n", suite.fileName, err)
 			continue
 		}
 		results = append(results, suiteResult)
+		if budgetErr = config.budget.err(); budgetErr != nil {
+			if i < len(suites)-1 {
+				fmt.Fprintf(config.output(), "\nNot running the remaining %d test file(s): %v\n", len(suites)-1-i, budgetErr)
+			}
+			break
+		}
+		if ctx.Err() != nil {
+			canceled = true
+			if i < len(suites)-1 {
+				fmt.Fprintf(config.output(), "\nNot running the remaining %d test file(s): run canceled\n", len(suites)-1-i)
+			}
+			break
+		}
+		if suiteResult.TimedOut {
+			timedOut = true
+			if stopsAfterTimeout(config) && i < len(suites)-1 {
+				fmt.Fprintf(config.output(), "\nNot running the remaining %d test file(s) after '%s' timed out\n", len(suites)-1-i, suite.fileName)
+				break
+			}
+		}
 	}
 	execDuration := time.Since(start)
+	if config.pactRecorder != nil {
+		pactPath, err := config.pactRecorder.write()
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(config.output(), "Wrote pact file '%s'\n", pactPath)
+	}
 
+	applyQuarantine(config.output(), quarantine, testDir, results)
+	applySeverityGate(options.FailOnSeverity, results)
 	total := 0
 	numPassed := 0
 	numFailed := 0
 	numSkipped := 0
+	numQuarantined := 0
+	numWarnings := 0
 	for _, result := range results {
 		total += result.TotalTests
 		numFailed += len(result.Failed)
 		numPassed += len(result.Passed)
 		numSkipped += len(result.Skipped)
-		if len(result.Failed) == 0 {
+		numQuarantined += len(result.Quarantined)
+		numWarnings += len(result.Warnings)
+	}
+	fmt.Fprintf(config.output(), "\n* Results by directory:\n")
+	groupResults(testDir, results).print(config.output(), 0)
+	if numQuarantined > 0 {
+		fmt.Fprintf(config.output(), "\n* Quarantined failures (not failing the run):\n")
+		printQuarantined(config.output(), quarantine, testDir, results)
+	}
+	if numWarnings > 0 {
+		fmt.Fprintf(config.output(), "\n* Warnings (failures below severity %s, not failing the run):\n", options.FailOnSeverity)
+		for _, result := range results {
+			for _, warning := range result.Warnings {
+				fmt.Fprintf(config.output(), "'%s':\n%s", result.TestFilename, warning.Result())
+			}
+		}
+	}
+	printDeprecatedFields(config.output(), results)
+	printSlowRequests(config.output(), results)
+	softFailures := 0
+	for _, result := range results {
+		for _, tests := range [][]TestResult{result.Passed, result.Failed, result.Quarantined, result.Warnings} {
+			for _, test := range tests {
+				if len(test.SoftFailures) == 0 {
+					continue
+				}
+				if softFailures == 0 {
+					fmt.Fprintf(config.output(), "\n* Soft failures (not failing tests):\n")
+				}
+				softFailures += len(test.SoftFailures)
+				fmt.Fprintf(config.output(), "'%s' %s:\n", result.TestFilename, test.Name)
+				for _, softFailure := range test.SoftFailures {
+					fmt.Fprintf(config.output(), "\t%s\n", fmt.Sprintf(WarningString, softFailure))
+				}
+			}
+		}
+	}
+	fmt.Fprintf(config.output(), "\nRun: %s\nTotal: %d\nPassed: %d\nFailed: %d\nSkipped: %d\nQuarantined: %d\nWarnings: %d\nDuration: %s\n", config.runId, total, numPassed, numFailed, numSkipped, numQuarantined, numWarnings, execDuration)
+	// Report to the configured reporters and those requested by options alike
+	reporters := append([]ReporterConfig{}, config.Reporters...)
+	if options.ReportFile != "" {
+		reporters = append(reporters, ReporterConfig{Type: ReporterTypeJson, File: options.ReportFile})
+	}
+	if options.HtmlReportFile != "" {
+		reporters = append(reporters, ReporterConfig{Type: ReporterTypeHtml, File: options.HtmlReportFile})
+	}
+	var report RunReport
+	if len(reporters) > 0 || options.Tap != nil || config.Hooks != nil {
+		report = newRunReport(runMetadata(), testDir, results, execDuration)
+		if options.Tap != nil {
+			err = writeTapReport(report, options.Tap)
+			if err != nil {
+				return false, errors.Wrap(err, "error writing TAP output")
+			}
+		}
+		err = writeReports(config, report, reporters)
+		if err != nil {
+			return false, err
+		}
+	}
+	if config.VariableExport != nil {
+		numVars, err := config.VariableExport.write(results)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(config.output(), "Wrote %d variable(s) to '%s'\n", numVars, config.VariableExport.File)
+	}
+	if config.ArtifactsDir != "" {
+		zipPath, err := zipFailureArtifacts(config)
+		if err != nil {
+			return false, err
+		}
+		if zipPath != "" {
+			fmt.Fprintf(config.output(), "Wrote failure artifacts '%s'\n", zipPath)
+		}
+	}
+	if budgetErr != nil {
+		fmt.Fprintf(config.output(), "%s\n", fmt.Sprintf(ErrorString, fmt.Sprintf("Run aborted, %v", budgetErr)))
+	}
+	if canceled {
+		fmt.Fprintf(config.output(), "%s\n", fmt.Sprintf(ErrorString, fmt.Sprintf("Run canceled, %v", context.Cause(ctx))))
+	}
+	passed := numFailed == 0 && !timedOut && budgetErr == nil && !canceled
+	config.Hooks.runEnd(RunEndEvent{Report: report, Passed: passed})
+	return passed, nil
+}
+
+// Prepares the resources shared by all suites in a run (stubs, port-forward, auth tokens, descriptors etc.)
+// on top of 'config'. The returned func releases them.
+func prepareRun(config RunConfig) (_ RunConfig, closeRun func(), err error) {
+	if config.Timeouts != nil {
+		err = config.Timeouts.validate()
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+	}
+	if config.Tracing != nil {
+		err = config.Tracing.validate()
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+	}
+	if config.Deprecations != nil {
+		config.deprecatedFields, err = parseIgnoredFields(config.Deprecations.Fields)
+		if err != nil {
+			return RunConfig{}, nil, errors.Wrap(err, "invalid deprecations.fields")
+		}
+	}
+	if len(config.ProtectedUrls) > 0 {
+		config.protectedUrls, err = compileProtectedUrls(config.ProtectedUrls)
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+	}
+	if config.MaxDiffs < 0 {
+		return RunConfig{}, nil, fmt.Errorf("invalid maxDiffs %d, must be positive (or 0 for none)", config.MaxDiffs)
+	}
+	for _, reporter := range config.Reporters {
+		err = reporter.validate()
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+	}
+	if config.VariableExport != nil {
+		err = config.VariableExport.validate()
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+	}
+	// Count requests from all suites against a single budget
+	if config.Budget != nil {
+		err = config.Budget.validate()
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+		config.budget = newRequestBudget(*config.Budget)
+	}
+	config.runId = newUUID()
+	cleanups := make([]func(), 0)
+	release := func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+
+	// Set environment variables before anything reads them, e.g. proxy settings read by the first request
+	if len(config.Env) > 0 {
+		restoreEnv, err := setProcessEnv(config.Env)
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+		cleanups = append(cleanups, restoreEnv)
+	}
+	// Skip tests that passed with the same inputs in earlier runs (nothing is recorded in dry-run mode)
+	if config.CacheFile != "" && !config.DryRun {
+		config.resultCache, err = loadResultCache(config.CacheFile, config.noCache)
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+		cleanups = append(cleanups, func() {
+			err := config.resultCache.save()
+			if err != nil {
+				fmt.Fprintf(config.errOutput(), "%v\n", err)
+			}
+		})
+	}
+	// Serve stub endpoints for the whole run
+	if config.Stubs != nil {
+		config.stubServer, err = startStubServer(*config.Stubs)
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+		cleanups = append(cleanups, func() { config.stubServer.close() })
+	}
+	// Point baseUrl at a kubectl port-forward held open for the whole run
+	if config.PortForward != nil {
+		config.portForward, err = startPortForward(*config.PortForward)
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+		cleanups = append(cleanups, func() { config.portForward.close() })
+		config.BaseUrl = config.portForward.baseUrl(*config.PortForward)
+	}
+	// Leave HttpClient unset so each suite gets its own isolated session client
+	config.HttpClient = nil
+	// Share auth tokens across all suites in the run
+	if config.Auth != nil {
+		config.tokenCache = newTokenCache(*config.Auth)
+	}
+	// Load protobuf descriptors once for all suites
+	if config.ProtoDescriptorSet != "" {
+		config.protoRegistry, err = loadProtoRegistry(config.FS, config.ProtoDescriptorSet)
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+	}
+	// Record interactions from all suites into a single pact file
+	if config.Pact != nil {
+		config.pactRecorder, err = newPactRecorder(*config.Pact)
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+	}
+	// Load OpenAPI examples once for all suites
+	if config.OpenApiSpec != "" {
+		config.openApiExamples, err = loadOpenApiExamples(config.FS, config.OpenApiSpec)
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+	}
+	if len(config.Factories) > 0 {
+		config.factories, err = resolveFactories(config)
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+	}
+	// Start plugins once for all suites
+	if len(config.Plugins) > 0 {
+		var closePlugins func()
+		config.plugins, closePlugins, err = startPlugins(config.Plugins, config.errOutput())
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+		cleanups = append(cleanups, closePlugins)
+	}
+	// Collect spans from all suites and export them at the end of the run (nothing is sent in dry-run mode)
+	if config.Tracing != nil && !config.DryRun {
+		config.tracer = newTracer(*config.Tracing)
+		cleanups = append(cleanups, func() { config.tracer.flush(config.output(), config.errOutput()) })
+	}
+	// Resolve flags once for all suites, after everything their endpoint's request needs is ready
+	if config.Flags != nil {
+		config.activeFlags, err = resolveFlags(config)
+		if err != nil {
+			return RunConfig{}, nil, err
+		}
+	}
+	return config, release, nil
+}
+
+// Compiles all test files, printing every invalid file before returning an error if there are any
+func compileTestFiles(config RunConfig, testFiles []string) ([]compiledSuite, error) {
+	suites, errs := compileSuites(config.FS, testFiles)
+	if len(errs) == 0 {
+		return suites, nil
+	}
+	for _, err := range errs {
+		fmt.Fprintf(config.errOutput(), "Invalid test file: %v\n", err)
+	}
+	return nil, fmt.Errorf("%d invalid test file(s)", len(errs))
+}
+
+// Loads the RunConfig in 'runConfigFilename' (in 'fsys', or the OS filesystem if nil)
+func loadRunConfig(fsys fs.FS, runConfigFilename string) (RunConfig, error) {
+	configBytes, err := readFile(fsys, runConfigFilename)
+	if err != nil {
+		return RunConfig{}, errors.Wrap(err, fmt.Sprintf("invalid config file: %s", runConfigFilename))
+	}
+	var config RunConfig
+	err = json.Unmarshal(configBytes, &config)
+	if err != nil {
+		return RunConfig{}, errors.Wrap(err, "invalid run config")
+	}
+	return config, nil
+}
+
+// Returns the paths of all test files in 'testDir' whose names match 'testFilenameMatchRegex' in the order
+// they should be executed: the order of the index file configured via suiteOrder (if any), followed by
+// any test files it doesn't list in alphabetical order
+func findOrderedTestFiles(config RunConfig, testDir string, testFilenameMatchRegex *regexp.Regexp) ([]string, error) {
+	testFiles, err := findTestFiles(config.FS, testDir, testFilenameMatchRegex)
+	if err != nil {
+		return nil, err
+	}
+	if config.SuiteOrder == "" {
+		return testFiles, nil
+	}
+	contents, err := readFile(config.FS, config.SuiteOrder)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading suiteOrder %s", config.SuiteOrder))
+	}
+	found := make(map[string]bool, len(testFiles))
+	for _, testFile := range testFiles {
+		found[testFile] = true
+	}
+	ordered := make([]string, 0, len(testFiles))
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		fmt.Printf("* Failures for '%s':\n", result.TestFilename)
-		for _, failed := range result.Failed {
-			fmt.Print(failed.Result())
+		// Entries are relative to testDir
+		testFile := filepath.Join(testDir, line)
+		if config.FS != nil {
+			testFile = path.Join(testDir, line)
+		}
+		if err := statFile(config.FS, testFile); err != nil {
+			return nil, fmt.Errorf("test file '%s' listed in suiteOrder %s not found", line, config.SuiteOrder)
+		}
+		// Skip files filtered out by testFilenameMatchRegex or listed twice
+		if found[testFile] {
+			ordered = append(ordered, testFile)
+			delete(found, testFile)
 		}
 	}
-	fmt.Printf("\nTotal: %d\nPassed: %d\nFailed: %d\nSkipped: %d\nDuration: %s\n", total, numPassed, numFailed, numSkipped, execDuration)
-	if numFailed > 0 {
-		return false, nil
+	for _, testFile := range testFiles {
+		if found[testFile] {
+			ordered = append(ordered, testFile)
+		}
+	}
+	return ordered, nil
+}
+
+// Returns the paths of all test files in 'testDir' (in 'fsys', or the OS filesystem if nil) whose names
+// match 'testFilenameMatchRegex', sorted alphabetically
+func findTestFiles(fsys fs.FS, testDir string, testFilenameMatchRegex *regexp.Regexp) ([]string, error) {
+	testFiles := make([]string, 0)
+	walk := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".json") && testFilenameMatchRegex.MatchString(d.Name()) {
+			testFiles = append(testFiles, path)
+		}
+
+		return nil
+	}
+	var err error
+	if fsys == nil {
+		err = filepath.WalkDir(testDir, walk)
+	} else {
+		err = fs.WalkDir(fsys, testDir, walk)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Error reading dir: %s", testDir))
+	}
+	sort.Strings(testFiles)
+	return testFiles, nil
+}
+
+// Returns the writer results and progress are printed to (see RunConfig.Output)
+func (config RunConfig) output() io.Writer {
+	if config.Output == nil {
+		return os.Stdout
+	}
+	return config.Output
+}
+
+// Returns the context that cancels the run (see RunWithContext)
+func (config RunConfig) runContext() context.Context {
+	if config.ctx == nil {
+		return context.Background()
+	}
+	return config.ctx
+}
+
+// Returns the writer errors are printed to (see RunConfig.ErrOutput)
+func (config RunConfig) errOutput() io.Writer {
+	if config.ErrOutput == nil {
+		return os.Stderr
 	}
-	return true, nil
+	return config.ErrOutput
 }
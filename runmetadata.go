@@ -0,0 +1,67 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Metadata identifying a run, included in reports to join results with deploy events
+type RunMetadata struct {
+	Id        string    `json:"id"`
+	StartTime time.Time `json:"startTime"`
+	// Commit of the tests being run (empty if unknown)
+	GitSha   string `json:"gitSha,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	// Name of the config profile (see RunOptions.Profile)
+	Profile string `json:"profile"`
+}
+
+// Environment variables CI providers set to the commit being built, checked before asking git
+var gitShaEnvVars = []string{"GIT_COMMIT", "GITHUB_SHA", "CI_COMMIT_SHA", "CIRCLE_SHA1", "BUILDKITE_COMMIT"}
+
+// Returns the metadata of a run of the tests in 'testDir' with the config in 'runConfigFilename'
+func newRunMetadata(config RunConfig, runConfigFilename string, testDir string, profile string, start time.Time) RunMetadata {
+	hostname, _ := os.Hostname()
+	return RunMetadata{
+		Id:        config.runId,
+		StartTime: start.UTC(),
+		GitSha:    gitSha(testDir),
+		Hostname:  hostname,
+		Profile:   profileName(profile, runConfigFilename),
+	}
+}
+
+// Returns the commit checked out in 'dir', or "" if it's unknown (e.g. git isn't installed)
+func gitSha(dir string) string {
+	for _, envVar := range gitShaEnvVars {
+		if sha := os.Getenv(envVar); sha != "" {
+			return sha
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
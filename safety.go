@@ -0,0 +1,66 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Methods that are sent to protected urls (and in read-only runs) without a test's AllowUnsafeMethods
+var safeMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+
+// Compiles the regexes of RunConfig.ProtectedUrls
+func compileProtectedUrls(patterns []string) ([]*regexp.Regexp, error) {
+	regexes := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid protectedUrls pattern '%s'", pattern))
+		}
+		regexes = append(regexes, regex)
+	}
+	return regexes, nil
+}
+
+// Returns an error if 'req' must not be sent because it has an unsafe method and either the run is
+// read-only or its url matches one of the protected urls, unless unsafe methods are allowed
+func (suite TestSuite) checkSafeRequest(req *http.Request) error {
+	if suite.allowUnsafeMethods || isSafeMethod(req.Method) {
+		return nil
+	}
+	if suite.config.Budget != nil && suite.config.Budget.ReadOnly {
+		return fmt.Errorf("refusing to send %s %s in a read-only run (set allowUnsafeMethods on the test to allow it)", req.Method, req.URL)
+	}
+	for _, regex := range suite.config.protectedUrls {
+		if regex.MatchString(req.URL.String()) {
+			return fmt.Errorf("refusing to send %s to protected url %s (set allowUnsafeMethods on the test to allow it)", req.Method, req.URL)
+		}
+	}
+	return nil
+}
+
+func isSafeMethod(method string) bool {
+	for _, safe := range safeMethods {
+		if strings.EqualFold(method, safe) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// Maximum number of tests of a suite executed at once by default (see TestSuiteSpec.MaxParallel)
+const defaultMaxParallel = 8
+
+// Matches the names of tests referenced in a template expression, e.g. "createUser" in "{{ createUser.id }}"
+var templateReferenceRegex = regexp.MustCompile(`([a-zA-Z0-9]+)\.`)
+
+// Returns which of the 'earlier' tests of its suite 'test' depends on: those it declares in DependsOn,
+// references in its templates or is conditional on, in that order
+func testDependencies(test TestSpec, earlier []string) ([]string, error) {
+	spec, err := json.Marshal(test)
+	if err != nil {
+		return nil, err
+	}
+	dependsOn := make([]string, 0)
+	add := func(name string) {
+		if slices.Contains(earlier, name) && !slices.Contains(dependsOn, name) {
+			dependsOn = append(dependsOn, name)
+		}
+	}
+	for _, dependency := range test.DependsOn {
+		add(dependency)
+	}
+	add(test.Request.ConditionalOn)
+	for _, template := range templateExpressionRegex.FindAllStringSubmatch(string(spec), -1) {
+		for _, reference := range templateReferenceRegex.FindAllStringSubmatch(template[1], -1) {
+			add(reference[1])
+		}
+	}
+	return dependsOn, nil
+}
+
+// Returns whether the suite's tests run concurrently, i.e. whether any of them declare DependsOn
+func (suite TestSuite) schedulesConcurrently() bool {
+	return slices.ContainsFunc(suite.spec.Tests, func(test TestSpec) bool {
+		return len(test.DependsOn) > 0
+	})
+}
+
+// Returns the maximum number of the suite's tests executed at once if they run concurrently
+func (spec TestSuiteSpec) maxParallel() int {
+	if spec.MaxParallel == 0 {
+		return defaultMaxParallel
+	}
+	return spec.MaxParallel
+}
+
+// Returns the indexes of the earlier tests each of the suite's tests depends on (see testDependencies)
+func (suite TestSuite) dependencyGraph() [][]int {
+	graph := make([][]int, len(suite.spec.Tests))
+	earlier := make([]string, 0, len(suite.spec.Tests))
+	// Index of the latest test of each name, which references to the name are to
+	latest := make(map[string]int, len(suite.spec.Tests))
+	for i, test := range suite.spec.Tests {
+		dependsOn, err := testDependencies(test, earlier)
+		if err != nil {
+			// Conservatively wait for all earlier tests
+			dependsOn = earlier
+		}
+		for _, name := range dependsOn {
+			graph[i] = append(graph[i], latest[name])
+		}
+		earlier = append(earlier, test.Name)
+		latest[test.Name] = i
+	}
+	return graph
+}
+
+// Executes the suite's tests concurrently, at most MaxParallel at once, each as soon as the tests it
+// depends on (see dependencyGraph) completed. The cases of a test are executed in order, by calling
+// 'execute' with a copy of 'extractedFields' and the case's vars. The template vars a test memoizes are
+// merged into 'extractedFields' once it completes, before its dependents start.
+func (suite TestSuite) runConcurrently(extractedFields map[string]interface{}, execute func(test TestSpec, testCase testCase, fields map[string]interface{})) {
+	graph := suite.dependencyGraph()
+	slots := make(chan struct{}, suite.spec.maxParallel())
+	done := make([]chan struct{}, len(suite.spec.Tests))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i, test := range suite.spec.Tests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[i])
+			for _, dependency := range graph[i] {
+				<-done[dependency]
+			}
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			mutex.Lock()
+			before := maps.Clone(extractedFields)
+			mutex.Unlock()
+			fields := maps.Clone(before)
+			for _, testCase := range testCases(test) {
+				for k, v := range testCase.vars {
+					fields[k] = v
+				}
+				execute(test, testCase, fields)
+				for k := range testCase.vars {
+					delete(fields, k)
+				}
+			}
+
+			// Merge only what the test changed, as tests running alongside may have memoized other vars
+			mutex.Lock()
+			defer mutex.Unlock()
+			for k, v := range fields {
+				if previous, ok := before[k]; !ok || !reflect.DeepEqual(previous, v) {
+					extractedFields[k] = v
+				}
+			}
+			for k := range before {
+				if _, ok := fields[k]; !ok {
+					delete(extractedFields, k)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
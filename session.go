@@ -0,0 +1,158 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Transport-level tuning shared by the http clients of all suites in a run. CaFile adds CA
+// certificates (PEM) to trust, CertFile and KeyFile are a client certificate for mTLS and
+// Proxy is the url of a proxy to send requests through (instead of the environment's).
+type TransportConfig struct {
+	InsecureSkipVerify  bool   `json:"insecureSkipVerify"`
+	MaxIdleConnsPerHost int    `json:"maxIdleConnsPerHost"`
+	DisableKeepAlives   bool   `json:"disableKeepAlives"`
+	CaFile              string `json:"caFile"`
+	CertFile            string `json:"certFile"`
+	KeyFile             string `json:"keyFile"`
+	Proxy               string `json:"proxy"`
+}
+
+// Returns a new transport with its own connection pool, configured by 'config' (if not nil). Certificate
+// files are read from 'fsys' (see RunConfig.FS).
+func newTransport(fsys fs.FS, config *TransportConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config == nil {
+		return transport, nil
+	}
+	if config.InsecureSkipVerify || config.CaFile != "" || config.CertFile != "" {
+		tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+		if config.CaFile != "" {
+			caCerts, err := readFile(fsys, config.CaFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to read caFile")
+			}
+			tlsConfig.RootCAs, err = x509.SystemCertPool()
+			if err != nil {
+				tlsConfig.RootCAs = x509.NewCertPool()
+			}
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(caCerts) {
+				return nil, fmt.Errorf("no certificates found in caFile '%s'", config.CaFile)
+			}
+		}
+		if config.CertFile != "" {
+			certPem, err := readFile(fsys, config.CertFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to read certFile")
+			}
+			keyPem, err := readFile(fsys, config.KeyFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to read keyFile")
+			}
+			cert, err := tls.X509KeyPair(certPem, keyPem)
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to load client certificate")
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	if config.Proxy != "" {
+		proxyUrl, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid proxy url")
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+	if config.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	transport.DisableKeepAlives = config.DisableKeepAlives
+	return transport, nil
+}
+
+// Returns a new http client with its own cookie jar and connection pool so that
+// session state can't bleed between suites. Auth tokens are cached per run, not per session.
+func newSessionClient(fsys fs.FS, config *TransportConfig) (*http.Client, error) {
+	transport, err := newTransport(fsys, config)
+	if err != nil {
+		return nil, err
+	}
+	// cookiejar.New only errors on invalid options
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{
+		Transport: transport,
+		Jar:       jar,
+	}, nil
+}
+
+// Returns a client per profile in 'profiles' for a suite's session, sharing the cookie jar of the
+// suite's default client 'client' (if it has one) so that tests can switch profiles within a session
+func newProfileClients(fsys fs.FS, profiles map[string]TransportConfig, client HttpClient) (map[string]HttpClient, error) {
+	var jar http.CookieJar
+	if sessionClient, ok := client.(*http.Client); ok {
+		jar = sessionClient.Jar
+	}
+	clients := make(map[string]HttpClient, len(profiles))
+	for name, profile := range profiles {
+		transport, err := newTransport(fsys, &profile)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid client profile '%s'", name))
+		}
+		clients[name] = &http.Client{
+			Transport: transport,
+			Jar:       jar,
+		}
+	}
+	return clients, nil
+}
+
+// Releases idle connections held by a session client created by newSessionClient
+func closeSessionClient(client HttpClient) {
+	if sessionClient, ok := client.(*http.Client); ok {
+		sessionClient.CloseIdleConnections()
+	}
+}
+
+// Returns the client profile of 'test', which overrides its suite's, or "" for the default client
+func (suite TestSuite) clientProfile(test TestSpec) string {
+	if test.ClientProfile != "" {
+		return test.ClientProfile
+	}
+	return suite.spec.ClientProfile
+}
+
+// Returns the names of the client profiles used by a suite and its tests
+func suiteProfiles(spec TestSuiteSpec) []string {
+	profiles := make([]string, 0)
+	if spec.ClientProfile != "" {
+		profiles = append(profiles, spec.ClientProfile)
+	}
+	for _, test := range spec.Tests {
+		if test.ClientProfile != "" {
+			profiles = append(profiles, test.ClientProfile)
+		}
+	}
+	return profiles
+}
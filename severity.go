@@ -0,0 +1,71 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severities that can be set via a test's (or suite's) "severity" metadata, in ascending order
+var severities = []string{"low", "medium", "high", "critical"}
+
+// Returns the rank of 'severity' in severities, or -1 if it isn't one
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ParseFailOn parses a failure gate of the form "severity>=<severity>", e.g. "severity>=high",
+// returning the minimum severity of failures that fail a run
+func ParseFailOn(s string) (string, error) {
+	severity, found := strings.CutPrefix(strings.ReplaceAll(s, " ", ""), "severity>=")
+	if !found {
+		return "", fmt.Errorf("invalid fail-on '%s', expected severity>=<%s>", s, strings.Join(severities, "|"))
+	}
+	if severityRank(severity) < 0 {
+		return "", fmt.Errorf("invalid severity '%s', must be one of %s", severity, strings.Join(severities, ", "))
+	}
+	return strings.ToLower(severity), nil
+}
+
+// Returns true if a failure of a test with 'metadata' fails the run given the minimum severity 'failOn'.
+// Failures of tests without a (known) severity always fail the run.
+func failsRun(metadata map[string]string, failOn string) bool {
+	if failOn == "" {
+		return true
+	}
+	rank := severityRank(metadata["severity"])
+	return rank < 0 || rank >= severityRank(failOn)
+}
+
+// Moves the failures of tests below the minimum severity 'failOn' from each result's Failed to its Warnings
+func applySeverityGate(failOn string, results []TestSuiteResult) {
+	for i, result := range results {
+		failed := make([]TestResult, 0, len(result.Failed))
+		for _, testResult := range result.Failed {
+			if failsRun(testResult.Metadata, failOn) {
+				failed = append(failed, testResult)
+			} else {
+				results[i].Warnings = append(results[i].Warnings, testResult)
+			}
+		}
+		results[i].Failed = failed
+	}
+}
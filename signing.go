@@ -0,0 +1,134 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Request signing scheme applied to every request. StringToSign is a template rendered with
+// the following vars: request.method, request.url, request.path, request.query, request.host,
+// request.body, request.timestamp and request.header.<Canonical-Header-Name>.
+type SigningConfig struct {
+	Header          string `json:"header"`
+	Key             string `json:"key"`
+	Algorithm       string `json:"algorithm"`
+	Encoding        string `json:"encoding"`
+	Prefix          string `json:"prefix"`
+	StringToSign    string `json:"stringToSign"`
+	TimestampHeader string `json:"timestampHeader"`
+}
+
+// HttpClient that signs each request before passing it on to the wrapped client
+type signingHttpClient struct {
+	client HttpClient
+	config SigningConfig
+}
+
+func (c signingHttpClient) Do(req *http.Request) (*http.Response, error) {
+	err := c.config.sign(req)
+	if err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+func (config SigningConfig) hashFunc() (func() hash.Hash, error) {
+	switch config.Algorithm {
+	case "", "sha256":
+		return sha256.New, nil
+	case "sha1":
+		return sha1.New, nil
+	case "sha512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm '%s'", config.Algorithm)
+	}
+}
+
+// Computes the signature for 'req' and sets it in the configured header
+func (config SigningConfig) sign(req *http.Request) error {
+	hashFunc, err := config.hashFunc()
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	if req.GetBody != nil {
+		bodyReader, err := req.GetBody()
+		if err != nil {
+			return errors.Wrap(err, "error reading request body for signing")
+		}
+		body, err = io.ReadAll(bodyReader)
+		if err != nil {
+			return errors.Wrap(err, "error reading request body for signing")
+		}
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	if config.TimestampHeader != "" {
+		req.Header.Set(config.TimestampHeader, timestamp)
+	}
+	vars := map[string]interface{}{
+		"request.method":    req.Method,
+		"request.url":       req.URL.String(),
+		"request.path":      req.URL.EscapedPath(),
+		"request.query":     req.URL.RawQuery,
+		"request.host":      req.URL.Host,
+		"request.body":      string(body),
+		"request.timestamp": timestamp,
+	}
+	for headerName, headerValues := range req.Header {
+		if len(headerValues) > 0 {
+			vars["request.header."+headerName] = headerValues[0]
+		}
+	}
+	stringToSign, err := templateReplace(config.StringToSign, vars)
+	if err != nil {
+		return errors.Wrap(err, "invalid signing stringToSign template")
+	}
+
+	mac := hmac.New(hashFunc, []byte(config.Key))
+	mac.Write([]byte(stringToSign))
+	var signature string
+	switch config.Encoding {
+	case "", "hex":
+		signature = hex.EncodeToString(mac.Sum(nil))
+	case "base64":
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	default:
+		return fmt.Errorf("unsupported signature encoding '%s'", config.Encoding)
+	}
+
+	header := config.Header
+	if header == "" {
+		header = "X-Signature"
+	}
+	req.Header.Set(header, config.Prefix+signature)
+	return nil
+}
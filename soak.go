@@ -0,0 +1,142 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Options for soaking test suites
+type SoakOptions struct {
+	// Wall-clock duration to keep looping the suites for. The iteration in progress when it elapses is completed.
+	Duration time.Duration
+	// Maximum fraction of failed tests (e.g. 0.01 for 1%) before the soak is considered failed
+	FailureBudget float64
+	// Pause between iterations
+	Interval time.Duration
+}
+
+// Cumulative results of a soak
+type SoakResult struct {
+	Iterations int
+	Total      int
+	Failed     int
+	Elapsed    time.Duration
+	// Number of failures per test, keyed by '<testFilename>: <testName>'
+	FailuresByTest map[string]int
+	FailureBudget  float64
+}
+
+// ErrorRate returns the fraction of executed tests that failed
+func (result SoakResult) ErrorRate() float64 {
+	if result.Total == 0 {
+		return 0
+	}
+	return float64(result.Failed) / float64(result.Total)
+}
+
+// WithinBudget returns true if the error rate didn't exceed the failure budget
+func (result SoakResult) WithinBudget() bool {
+	return result.ErrorRate() <= result.FailureBudget
+}
+
+// Report returns a printable summary of the soak
+func (result SoakResult) Report() string {
+	var report strings.Builder
+	fmt.Fprintf(&report, "\nIterations: %d\nTotal: %d\nFailed: %d\nError rate: %.2f%% (budget %.2f%%)\nDuration: %s\n",
+		result.Iterations, result.Total, result.Failed, result.ErrorRate()*100, result.FailureBudget*100, result.Elapsed)
+	tests := make([]string, 0, len(result.FailuresByTest))
+	for test := range result.FailuresByTest {
+		tests = append(tests, test)
+	}
+	sort.Slice(tests, func(i, j int) bool { return result.FailuresByTest[tests[i]] > result.FailuresByTest[tests[j]] })
+	for _, test := range tests {
+		fmt.Fprintf(&report, "\t%dx %s\n", result.FailuresByTest[test], test)
+	}
+	return report.String()
+}
+
+// Soak executes the test files in 'testDir' whose names match 'testFilenameMatchRegex' over and over for
+// the configured duration, printing a line per iteration with the cumulative error rate. Only the first
+// failure of each test is printed in full. Pact generation is disabled while soaking.
+func Soak(runConfigFilename string, testDir string, testFilenameMatchRegex *regexp.Regexp, options SoakOptions) (SoakResult, error) {
+	config, err := loadRunConfig(nil, runConfigFilename)
+	if err != nil {
+		return SoakResult{}, err
+	}
+	config.Pact = nil
+	config, closeRun, err := prepareRun(config)
+	if err != nil {
+		return SoakResult{}, err
+	}
+	defer closeRun()
+	testFiles, err := findOrderedTestFiles(config, testDir, testFilenameMatchRegex)
+	if err != nil {
+		return SoakResult{}, err
+	}
+	// Report invalid test files up front rather than on every iteration
+	suites, err := compileTestFiles(config, testFiles)
+	if err != nil {
+		return SoakResult{}, err
+	}
+
+	result := SoakResult{
+		FailuresByTest: make(map[string]int),
+		FailureBudget:  options.FailureBudget,
+	}
+	start := time.Now()
+	for result.Iterations == 0 || time.Since(start) < options.Duration {
+		if result.Iterations > 0 && options.Interval > 0 {
+			time.Sleep(options.Interval)
+		}
+		result.Iterations++
+		iterationFailed := 0
+		for _, suite := range suites {
+			suiteResult, err := soakSuite(config, suite)
+			if err != nil {
+				return result, err
+			}
+			result.Total += suiteResult.TotalTests
+			result.Failed += len(suiteResult.Failed)
+			iterationFailed += len(suiteResult.Failed)
+			for _, failed := range suiteResult.Failed {
+				key := fmt.Sprintf("%s: %s", suite.fileName, failed.Name)
+				if result.FailuresByTest[key] == 0 {
+					fmt.Fprintf(config.output(), "* First failure of '%s':\n%s", key, failed.Result())
+				}
+				result.FailuresByTest[key]++
+			}
+		}
+		fmt.Fprintf(config.output(), "Iteration %d: %d failed, cumulative error rate %.2f%% (%d/%d), elapsed %s\n",
+			result.Iterations, iterationFailed, result.ErrorRate()*100, result.Failed, result.Total, time.Since(start).Round(time.Second))
+	}
+	result.Elapsed = time.Since(start)
+	return result, nil
+}
+
+// Executes a suite without printing its results
+func soakSuite(config RunConfig, suite compiledSuite) (TestSuiteResult, error) {
+	testSuite, closeSuite, err := prepareTestSuite(config, suite)
+	if err != nil {
+		return TestSuiteResult{}, err
+	}
+	defer closeSuite()
+	return testSuite.run(io.Discard, false), nil
+}
@@ -0,0 +1,280 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Lightweight stub endpoints served on a local port for the duration of a run, e.g. to receive
+// webhooks from the API under test. The port and url are available as {{ stubs.port }} and {{ stubs.url }}.
+type StubsConfig struct {
+	Host      string         `json:"host"`
+	Port      int            `json:"port"`
+	Endpoints []StubEndpoint `json:"endpoints"`
+}
+
+// A stub endpoint and its canned response. An empty Method matches any method and a Path ending in '*' matches by prefix.
+type StubEndpoint struct {
+	Method   string       `json:"method"`
+	Path     string       `json:"path"`
+	Response StubResponse `json:"response"`
+}
+
+type StubResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers"`
+	Body       interface{}       `json:"body"`
+}
+
+// A request received by the stub server
+type receivedRequest struct {
+	Method     string
+	Path       string
+	Query      string
+	Headers    http.Header
+	Body       []byte
+	ReceivedAt time.Time
+}
+
+type stubServer struct {
+	config   StubsConfig
+	server   *http.Server
+	listener net.Listener
+	mutex    sync.Mutex
+	received []receivedRequest
+	// Signalled (closed and replaced) whenever a request is received
+	notify chan struct{}
+}
+
+// Starts serving the configured stub endpoints
+func startStubServer(config StubsConfig) (*stubServer, error) {
+	host := config.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(config.Port)))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to start stub server")
+	}
+	stubs := &stubServer{
+		config:   config,
+		listener: listener,
+		notify:   make(chan struct{}),
+	}
+	stubs.server = &http.Server{
+		Handler:           stubs,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go stubs.server.Serve(listener)
+	return stubs, nil
+}
+
+func (stubs *stubServer) close() {
+	stubs.server.Close()
+}
+
+func (stubs *stubServer) port() int {
+	return stubs.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (stubs *stubServer) url() string {
+	return "http://" + stubs.listener.Addr().String()
+}
+
+// Template vars exposing the stub server's address
+func (stubs *stubServer) vars() map[string]interface{} {
+	return map[string]interface{}{
+		"stubs.port": stubs.port(),
+		"stubs.url":  stubs.url(),
+	}
+}
+
+func (stubs *stubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	stubs.mutex.Lock()
+	stubs.received = append(stubs.received, receivedRequest{
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.RawQuery,
+		Headers:    r.Header.Clone(),
+		Body:       body,
+		ReceivedAt: time.Now(),
+	})
+	close(stubs.notify)
+	stubs.notify = make(chan struct{})
+	stubs.mutex.Unlock()
+
+	for _, endpoint := range stubs.config.Endpoints {
+		if endpoint.matches(r.Method, r.URL.Path) {
+			endpoint.Response.write(w)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// Waits up to 'timeout' for a request received after 'since' for which 'match' returns true
+func (stubs *stubServer) waitFor(since time.Time, timeout time.Duration, match func(receivedRequest) bool) (receivedRequest, bool) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	checked := 0
+	for {
+		stubs.mutex.Lock()
+		received := stubs.received
+		notify := stubs.notify
+		stubs.mutex.Unlock()
+		for ; checked < len(received); checked++ {
+			if !received[checked].ReceivedAt.Before(since) && match(received[checked]) {
+				return received[checked], true
+			}
+		}
+		select {
+		case <-notify:
+		case <-deadline.C:
+			return receivedRequest{}, false
+		}
+	}
+}
+
+func (endpoint StubEndpoint) matches(method string, path string) bool {
+	if endpoint.Method != "" && !strings.EqualFold(endpoint.Method, method) {
+		return false
+	}
+	if prefix, isPrefix := strings.CutSuffix(endpoint.Path, "*"); isPrefix {
+		return strings.HasPrefix(path, prefix)
+	}
+	return endpoint.Path == path
+}
+
+func (response StubResponse) write(w http.ResponseWriter) {
+	var body []byte
+	switch b := response.Body.(type) {
+	case nil:
+	case string:
+		body = []byte(b)
+	default:
+		var err error
+		body, err = json.Marshal(b)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid stub response body: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+	}
+	for k, v := range response.Headers {
+		w.Header().Set(k, v)
+	}
+	statusCode := response.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// Callback the API under test is expected to deliver to the stub server while a test runs
+type ExpectedCallback struct {
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Headers   map[string]string `json:"headers"`
+	Body      interface{}       `json:"body"`
+	TimeoutMs int               `json:"timeoutMs"`
+}
+
+const defaultCallbackTimeout = 5 * time.Second
+
+// Waits for a callback matching 'expected' received since 'since'. Returns the callback or a list of errors.
+// The body of the expected callback only needs to be a subset of the received body.
+func (stubs *stubServer) awaitCallback(expected ExpectedCallback, since time.Time, extractedFields map[string]interface{}) (receivedRequest, []string) {
+	timeout := defaultCallbackTimeout
+	if expected.TimeoutMs > 0 {
+		timeout = time.Duration(expected.TimeoutMs) * time.Millisecond
+	}
+	endpoint := StubEndpoint{Method: expected.Method, Path: expected.Path}
+	var lastMismatch []string
+	callback, found := stubs.waitFor(since, timeout, func(received receivedRequest) bool {
+		if !endpoint.matches(received.Method, received.Path) {
+			return false
+		}
+		lastMismatch = expected.compare(received, extractedFields)
+		return len(lastMismatch) == 0
+	})
+	if found {
+		return callback, nil
+	}
+	callbackErrors := []string{fmt.Sprintf("Expected callback %s %s not received within %s", expected.Method, expected.Path, timeout)}
+	for _, mismatch := range lastMismatch {
+		callbackErrors = append(callbackErrors, "Last callback received: "+mismatch)
+	}
+	return receivedRequest{}, callbackErrors
+}
+
+// Returns a description of each way 'received' doesn't match the expected callback
+func (expected ExpectedCallback) compare(received receivedRequest, extractedFields map[string]interface{}) []string {
+	mismatches := make([]string, 0)
+	for headerName, expectedValTemplate := range expected.Headers {
+		expectedVal, err := templateReplace(expectedValTemplate, extractedFields)
+		if err != nil {
+			mismatches = append(mismatches, err.Error())
+			continue
+		}
+		if actualVal := strings.Join(received.Headers.Values(headerName), ","); actualVal != expectedVal {
+			mismatches = append(mismatches, fmt.Sprintf("expected header '%s: %s' but got '%s: %s'", headerName, expectedVal, headerName, actualVal))
+		}
+	}
+	if expected.Body == nil {
+		return mismatches
+	}
+	expectedBytes, err := json.Marshal(expected.Body)
+	if err != nil {
+		return append(mismatches, fmt.Sprintf("invalid expected callback body: %v", err))
+	}
+	var actualBody interface{}
+	err = json.Unmarshal(received.Body, &actualBody)
+	if err != nil {
+		return append(mismatches, fmt.Sprintf("expected JSON body but got %s", string(received.Body)))
+	}
+	var expectedBody interface{}
+	err = json.Unmarshal(expectedBytes, &expectedBody)
+	if err != nil {
+		return append(mismatches, fmt.Sprintf("invalid expected callback body: %v", err))
+	}
+	matchedActual, matchedExpected, diffs := applyMatchers(actualBody, expectedBody, "", compareItems(extractedFields, nil, StringComparison{}))
+	mismatches = append(mismatches, differenceStrings(diffs)...)
+	matchedExpectedBytes, err := json.Marshal(matchedExpected)
+	if err != nil {
+		return append(mismatches, fmt.Sprintf("invalid expected callback body: %v", err))
+	}
+	processedExpected, err := templateReplace(string(matchedExpectedBytes), extractedFields)
+	if err != nil {
+		return append(mismatches, err.Error())
+	}
+	err = json.Unmarshal([]byte(processedExpected), &expectedBody)
+	if err != nil {
+		return append(mismatches, fmt.Sprintf("invalid expected callback body: %v", err))
+	}
+	return append(mismatches, differenceStrings(diffValues(subset(matchedActual, expectedBody), expectedBody, ""))...)
+}
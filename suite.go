@@ -16,17 +16,26 @@ package apirunner
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
+	"maps"
+	"mime"
 	"net/http"
-	"os"
+	"net/url"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-test/deep"
 	"github.com/pkg/errors"
 )
 
@@ -35,6 +44,16 @@ const (
 	PassedString  = "\033[1;32mPASSED (%s)\033[0m"
 	FailedString  = "\033[1;31mFAILED (%s)\033[0m"
 	ErrorString   = "\033[1;31m%s\033[0m"
+	WarningString = "\033[1;33m%s\033[0m"
+)
+
+var (
+	// Test names must be alphanumeric without spaces
+	testNameRegex = regexp.MustCompile(`^[a-zA-Z0-9]*$`)
+	// Matches template vars, e.g. "{{ value }}" or "{{ value | lower }}"
+	templateVariableRegex = regexp.MustCompile(`{{\s*[^\s|{}]+(\s*\|\s*[a-zA-Z0-9]+)*\s*}}`)
+	// Matches candidate template expressions, e.g. "{{ createOrder.body.total * 100 }}"
+	templateExpressionRegex = regexp.MustCompile(`{{([^{}]*)}}`)
 )
 
 // Mock-able HttpClient interface
@@ -47,22 +66,110 @@ type TestSuite struct {
 	spec     TestSuiteSpec
 	config   RunConfig
 	fileName string
+	// Fields excluded from response body comparisons
+	ignoredFields []ignoredField
+	// Compiled extraction regexes keyed by their source
+	extractionRegexes map[string]*regexp.Regexp
+	// Deadline for the suite's tests (zero if unlimited)
+	deadline deadline
+	// Span of the suite, or of the test being executed (nil unless tracing is configured)
+	span *span
+	// Whether the test being executed may send unsafe methods to protected urls (see checkSafeRequest)
+	allowUnsafeMethods bool
+	// Fixtures instantiated by the request being built, which are created once it's sent (nil to create them immediately)
+	pendingFixtures *pendingFixtures
 }
 
 // Spec defining the tests in a suite
 type TestSuiteSpec struct {
-	Skip          bool       `json:"skip"`
-	IgnoredFields []string   `json:"ignoredFields"`
-	BaseUrl       string     `json:"baseUrl"`
-	Tests         []TestSpec `json:"tests"`
+	// Version of the test file format (see currentSuiteVersion), 1 if not specified
+	Version int `json:"version"`
+	// Don't reject fields that aren't part of the test file format
+	AllowUnknownFields bool             `json:"allowUnknownFields"`
+	Skip               bool             `json:"skip"`
+	IgnoredFields      []string         `json:"ignoredFields"`
+	BaseUrl            string           `json:"baseUrl"`
+	StrictBody         *bool            `json:"strictBody"`
+	StringComparison   StringComparison `json:"stringComparison"`
+	Setup              []ExecStep       `json:"setup"`
+	Teardown           []ExecStep       `json:"teardown"`
+	Tests              []TestSpec       `json:"tests"`
+	// Arbitrary annotations (e.g. owner, jira, severity) inherited by all tests
+	Metadata map[string]string `json:"metadata"`
+	// Name of the client profile (see RunConfig.ClientProfiles) to send the suite's requests with
+	ClientProfile string `json:"clientProfile"`
+	// Maximum duration of the suite's setup steps, tests and teardown steps in milliseconds, failing the
+	// suite if exceeded (0 for no limit). Unlike TimeoutConfig.SuiteMs, tests aren't interrupted.
+	MaxTotalDurationMs int `json:"maxTotalDurationMs"`
+	// Maximum number of tests executed at once if any of the suite's tests declare DependsOn (see
+	// runConcurrently), defaultMaxParallel if 0
+	MaxParallel int `json:"maxParallel"`
+	// Environment variables set for the process while the suite runs (see RunConfig.Env), overriding
+	// those of the run
+	Env map[string]string `json:"env"`
+}
+
+// Options for comparing string values in response bodies
+type StringComparison struct {
+	IgnoreCase bool `json:"ignoreCase"`
+	TrimSpace  bool `json:"trimSpace"`
 }
 
 // Spec defining a single test case
 type TestSpec struct {
-	Name             string           `json:"name"`
-	Skip             bool             `json:"skip"`
-	Request          Request          `json:"request"`
-	ExpectedResponse ExpectedResponse `json:"expectedResponse"`
+	Name             string                   `json:"name"`
+	Skip             bool                     `json:"skip"`
+	StrictBody       *bool                    `json:"strictBody"`
+	Request          Request                  `json:"request"`
+	ExpectedResponse ExpectedResponse         `json:"expectedResponse"`
+	ExpectedRequest  *ExpectedRequest         `json:"expectedRequest"`
+	ExpectedCallback *ExpectedCallback        `json:"expectedCallback"`
+	Fault            *Fault                   `json:"fault"`
+	Exec             *ExecStep                `json:"exec"`
+	TestTime         string                   `json:"testTime"`
+	Assert           []string                 `json:"assert"`
+	SoftAssert       []string                 `json:"softAssert"`
+	Extract          map[string]Extraction    `json:"extract"`
+	Metadata         map[string]string        `json:"metadata"`
+	Repeat           int                      `json:"repeat"`
+	Matrix           map[string][]interface{} `json:"matrix"`
+	Critical         bool                     `json:"critical"`
+	Preflight        *Preflight               `json:"preflight"`
+	// Headers from config (custom headers and the auth token header) not to send, e.g. to test anonymous access
+	OmitDefaultHeaders []string `json:"omitDefaultHeaders"`
+	ClientProfile      string   `json:"clientProfile"`
+	// Overrides TimeoutConfig.SlowMs for the test's request
+	SlowMs int `json:"slowMs"`
+	// Allow sending methods other than GET, HEAD and OPTIONS to protected urls and in read-only runs
+	AllowUnsafeMethods bool `json:"allowUnsafeMethods"`
+	// Overrides of fields of ExpectedResponse keyed by config profile (see RunConfig.Profile), e.g. for
+	// feature flags that differ between staging and prod
+	ExpectedResponseOverrides map[string]ExpectedResponse `json:"expectedResponseOverrides"`
+	// Feature flags (see RunConfig.Flags) that must be on for the test to run, or off if prefixed with "!"
+	RequiresFlags []string `json:"requiresFlags"`
+	// Number of response body differences tolerated as soft failures (overrides RunConfig.MaxDiffs)
+	AllowedDiffCount *int `json:"allowedDiffCount"`
+	// Earlier tests that must complete before the test starts. If any test of a suite declares
+	// dependencies, the suite's tests run concurrently, each once its dependencies completed.
+	DependsOn []string `json:"dependsOn"`
+}
+
+// Returns whether the default header 'name' from config is omitted from the test's request
+func (test TestSpec) omitsDefaultHeader(name string) bool {
+	for _, omitted := range test.OmitDefaultHeaders {
+		if strings.EqualFold(omitted, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Extracts a value from a (typically non-JSON) response body into the template var 'testName.varName'
+// using either the first capture group (or Group) of Regex, or XPath (for XML or HTML responses)
+type Extraction struct {
+	Regex string `json:"regex"`
+	Group *int   `json:"group"`
+	XPath string `json:"xpath"`
 }
 
 // Request information for a single test case
@@ -72,13 +179,32 @@ type Request struct {
 	Url     string            `json:"url"`
 	Body    interface{}       `json:"body"`
 	Headers map[string]string `json:"headers"`
+	// Name of an earlier test whose response to revalidate, by sending its ETag and Last-Modified
+	// as If-None-Match and If-Modified-Since (the expected status code then defaults to 304)
+	ConditionalOn string `json:"conditionalOn"`
+}
+
+// Expected request for a single test case, asserted on in dry-run mode instead of sending the request
+type ExpectedRequest struct {
+	Method  string            `json:"method"`
+	Url     string            `json:"url"`
+	Body    interface{}       `json:"body"`
+	Headers map[string]string `json:"headers"`
 }
 
 // Expected test case response
 type ExpectedResponse struct {
-	StatusCode int               `json:"statusCode"`
-	Body       interface{}       `json:"body"`
-	Headers    map[string]string `json:"headers"`
+	StatusCode      int               `json:"statusCode"`
+	Body            interface{}       `json:"body"`
+	Headers         map[string]string `json:"headers"`
+	Redirects       *int              `json:"redirects"`
+	FinalUrl        string            `json:"finalUrl"`
+	BodyEmpty       bool              `json:"bodyEmpty"`
+	ContentType     string            `json:"contentType"`
+	BodyFile        string            `json:"bodyFile"`
+	ProtoMessage    string            `json:"protoMessage"`
+	FromSpecExample string            `json:"fromSpecExample"`
+	SoftFields      []string          `json:"softFields"`
 }
 
 // Results for an executed TestSuite
@@ -88,6 +214,16 @@ type TestSuiteResult struct {
 	Skipped      []TestResult
 	TestFilename string
 	TotalTests   int
+	// Failures of quarantined tests, which don't fail the run
+	Quarantined []TestResult
+	// Failures of tests below the run's minimum severity, which don't fail the run
+	Warnings []TestResult
+	// True if a test hung past the suite's deadline, in which case the remaining tests and teardown were skipped
+	TimedOut bool
+	// Duration of the suite's setup steps, tests and teardown steps
+	Duration time.Duration
+	// Template vars memoized by the end of the suite (see RunConfig.VariableExport)
+	Variables map[string]interface{}
 }
 
 // Result for an executed test case
@@ -97,6 +233,33 @@ type TestResult struct {
 	Name     string
 	Errors   []string
 	Duration time.Duration
+	// Category of the first failure (empty unless the test failed)
+	Category FailureCategory
+	// Id correlating the test's request with server-side logs (see RequestIdConfig)
+	RequestId string
+	// Id of the trace the test's span belongs to (empty unless tracing is configured)
+	TraceId string
+	// Why the test was skipped, if not because it's marked as skipped (e.g. a critical test failed)
+	SkipReason string
+	// Failed soft expectations (see TestSpec.SoftAssert and ExpectedResponse.SoftFields), which don't fail the test
+	SoftFailures []string
+	// Paths of deprecated fields (see DeprecationConfig) in the test's response
+	DeprecatedFields []string
+	// Status, parsed body and headers of the test's response (nil if none was received)
+	Response map[string]interface{}
+	// Metadata of the test, including that inherited from its suite
+	Metadata map[string]string
+	// Method, url, headers and body of the test's request (empty if it wasn't built)
+	RequestMethod  string
+	RequestUrl     string
+	RequestHeaders http.Header
+	RequestBody    string
+	// Time until the test's response was received (0 if no request was sent)
+	RequestDuration time.Duration
+	// Whether the request took longer than the test's slow threshold (see TimeoutConfig.SlowMs)
+	Slow bool
+	// Whether the test's request or exec step failed because its context ended (see executeSpecWithDeadline)
+	aborted bool
 }
 
 func Failed(name string, errors []string, duration time.Duration) TestResult {
@@ -134,6 +297,9 @@ func (result TestResult) ResultNoDetail() string {
 		return fmt.Sprintf("\t%s %s\n", result.Name, fmt.Sprintf(PassedString, result.Duration))
 	}
 
+	if result.Skipped && result.SkipReason != "" {
+		return fmt.Sprintf("\t%s %s (%s)\n", result.Name, fmt.Sprintf(SkippedString, result.Duration), result.SkipReason)
+	}
 	if result.Skipped {
 		return fmt.Sprintf("\t%s %s\n", result.Name, fmt.Sprintf(SkippedString, result.Duration))
 	}
@@ -144,7 +310,22 @@ func (result TestResult) ResultNoDetail() string {
 
 func (result TestResult) Result() string {
 	resultString := result.ResultNoDetail()
+	for _, softFailure := range result.SoftFailures {
+		resultString = resultString + fmt.Sprintf("\t\t%s\n", fmt.Sprintf(WarningString, "Soft failure: "+softFailure))
+	}
 	if !result.Passed && !result.Skipped {
+		if result.Category != "" {
+			resultString = resultString + fmt.Sprintf("\t\t[%s]\n", result.Category)
+		}
+		if result.RequestId != "" {
+			resultString = resultString + fmt.Sprintf("\t\tRequest id: %s\n", result.RequestId)
+		}
+		if result.TraceId != "" {
+			resultString = resultString + fmt.Sprintf("\t\tTrace id: %s\n", result.TraceId)
+		}
+		if len(result.Metadata) > 0 {
+			resultString = resultString + fmt.Sprintf("\t\t(%s)\n", formatMetadata(result.Metadata))
+		}
 		for _, err := range result.Errors {
 			resultString = resultString + fmt.Sprintf("\t\t%s\n", fmt.Sprintf(ErrorString, err))
 		}
@@ -154,58 +335,139 @@ func (result TestResult) Result() string {
 
 // ExecuteSuite executes a test suite and prints + returns the results
 func ExecuteSuite(runConfig RunConfig, testFilename string, logFailureDetails bool) (TestSuiteResult, error) {
-	// Read test suite spec
-	jsonFile, err := os.Open(testFilename)
-	if err != nil {
-		return TestSuiteResult{}, errors.Wrap(err, fmt.Sprintf("error opening test file %s", testFilename))
-	}
-	defer jsonFile.Close()
-	byteValue, err := io.ReadAll(jsonFile)
+	return ExecuteSuiteWithContext(context.Background(), runConfig, testFilename, logFailureDetails)
+}
+
+// ExecuteSuiteWithContext executes a test suite like ExecuteSuite until 'ctx' is done, after which requests
+// in flight are aborted and the remaining tests are skipped
+func ExecuteSuiteWithContext(ctx context.Context, runConfig RunConfig, testFilename string, logFailureDetails bool) (TestSuiteResult, error) {
+	runConfig.ctx = ctx
+	compiled, err := compileSuite(runConfig.FS, testFilename)
 	if err != nil {
-		return TestSuiteResult{}, errors.Wrap(err, fmt.Sprintf("error reading test file %s", testFilename))
+		return TestSuiteResult{}, err
 	}
-	var suiteSpec TestSuiteSpec
-	err = json.Unmarshal(byteValue, &suiteSpec)
+	return executeCompiledSuite(runConfig, compiled, logFailureDetails)
+}
+
+// Executes an already compiled suite and prints + returns the results
+func executeCompiledSuite(runConfig RunConfig, compiled compiledSuite, logFailureDetails bool) (TestSuiteResult, error) {
+	testSuite, closeSuite, err := prepareTestSuite(runConfig, compiled)
 	if err != nil {
-		return TestSuiteResult{}, errors.Wrap(err, fmt.Sprintf("error parsing test data in %s", testFilename))
+		return TestSuiteResult{}, err
 	}
+	defer closeSuite()
 
-	// Validate test suite spec (no duplicate tests, names must be alphanumeric without spaces)
-	nameRegex := regexp.MustCompile(`^[a-zA-Z0-9]*$`)
-	testNames := make(map[string]bool)
-	for _, testSpec := range suiteSpec.Tests {
-		if !nameRegex.MatchString(testSpec.Name) {
-			return TestSuiteResult{}, fmt.Errorf("invalid test case name: '%s', must be alphanumeric without spaces", testSpec.Name)
-		}
-		if _, ok := testNames[testSpec.Name]; ok {
-			return TestSuiteResult{}, fmt.Errorf("test case '%s' defined twice", testSpec.Name)
-		}
-		testNames[testSpec.Name] = true
-	}
+	fmt.Fprintf(runConfig.output(), "\n* '%s':\n", testSuite.fileName)
+	return testSuite.run(runConfig.output(), logFailureDetails), nil
+}
 
-	// Execute test suite
-	testSuite := TestSuite{
-		suiteSpec,
-		runConfig,
-		testFilename,
+// Executes the suite's setup steps, tests and teardown steps, printing results to 'out'
+func (suite TestSuite) run(out io.Writer, logFailureDetails bool) (result TestSuiteResult) {
+	if suite.config.tracer != nil {
+		suite.span = suite.config.tracer.start(suite.fileName, spanKindInternal, nil)
+		defer func() {
+			suite.span.end(map[string]interface{}{
+				"apirunner.suite.file":   suite.fileName,
+				"apirunner.suite.passed": len(result.Passed),
+				"apirunner.suite.failed": len(result.Failed),
+			}, len(result.Failed) > 0, fmt.Sprintf("%d test(s) failed", len(result.Failed)))
+		}()
 	}
+	suite.config.Hooks.suiteStart(SuiteStartEvent{TestFile: suite.fileName})
+	defer func() {
+		suite.config.Hooks.suiteEnd(SuiteEndEvent{TestFile: suite.fileName, Result: result})
+	}()
+	start := time.Now()
 	passed := make([]TestResult, 0)
 	failed := make([]TestResult, 0)
 	skipped := make([]TestResult, 0)
 	totalTests := 0
-	fmt.Printf("\n* '%s':\n", testSuite.fileName)
 	// Memoized attrs map
-	extractedFields := make(map[string]interface{})
-	for _, test := range testSuite.spec.Tests {
-		totalTests++
+	extractedFields := suite.initialFields()
+	// Run setup steps (not in dry-run mode). If any fail, the suite's tests are skipped.
+	runSteps := !suite.spec.Skip && !suite.config.DryRun
+	setupFailed := false
+	// Durations of the setup and teardown steps as a whole, to report if the suite exceeds its maxTotalDurationMs
+	steps := make([]TestResult, 0, 2)
+	if runSteps {
+		setupStart := time.Now()
+		setupFailures := suite.executeSteps(suite.spec.Setup, "setup", extractedFields, true)
+		if len(suite.spec.Setup) > 0 {
+			steps = append(steps, Passed("setup", time.Since(setupStart)))
+		}
+		setupFailed = len(setupFailures) > 0
+		failed = append(failed, setupFailures...)
+		for _, result := range setupFailures {
+			fmt.Fprint(out, result.Result())
+		}
+	}
+	// Guards the state below, which tests update as they complete if they run concurrently
+	var mutex sync.Mutex
+	timedOut := false
+	// Name of the critical test whose failure skips the rest of the suite, if any
+	failedCritical := ""
+	// Expected bodies recorded in approval mode, keyed by test name
+	approved := make(map[string]interface{})
+	// Executes 'testCase' of 'test' with the template vars 'fields' (unless an earlier result skips it),
+	// then records and prints its result
+	executeCase := func(test TestSpec, testCase testCase, fields map[string]interface{}) {
+		suite.config.Hooks.testStart(TestStartEvent{TestFile: suite.fileName, Name: testCase.name, Spec: test})
+		mutex.Lock()
+		skipReason := ""
+		if setupFailed {
+			skipReason = "setup failed"
+		} else if timedOut {
+			skipReason = "suite timed out"
+		} else if suite.config.runContext().Err() != nil {
+			skipReason = "run canceled"
+		} else if failedCritical != "" {
+			skipReason = fmt.Sprintf("critical test '%s' failed", failedCritical)
+		} else if suite.config.budget.err() != nil {
+			skipReason = "request budget exceeded"
+		}
+		mutex.Unlock()
 
 		var result TestResult
-		if testSuite.spec.Skip || test.Skip {
+		caseTimedOut := false
+		if skipReason != "" {
 			result = Skipped(test.Name)
+			result.SkipReason = skipReason
+		} else if suite.config.approve && needsApproval(test) {
+			// Record the body of the response if the test passes without one
+			fieldsBefore := make(map[string]interface{}, len(fields))
+			for k, v := range fields {
+				fieldsBefore[k] = v
+			}
+			test.ExpectedResponse.Body = nil
+			result, caseTimedOut = suite.executeSpecWithDeadline(test, fields)
+			if result.Passed && result.Response != nil && result.Response["body"] != nil {
+				mutex.Lock()
+				approved[test.Name] = approvedBody(result.Response["body"], fieldsBefore)
+				mutex.Unlock()
+				// Memoize the response's fields as if it had been compared to the recorded body
+				for k, v := range flatten(result.Response["body"], test.Name, 0) {
+					fields[k] = v
+				}
+			}
 		} else {
-			result = testSuite.executeTest(test, extractedFields)
+			result, caseTimedOut = suite.executeSpecWithDeadline(test, fields)
+		}
+		result.Name = testCase.name
+		suite.config.Hooks.testEnd(TestEndEvent{TestFile: suite.fileName, Result: result})
+		if suite.config.ArtifactsDir != "" && !result.Passed && !result.Skipped {
+			err := suite.writeFailureArtifacts(result, fields)
+			if err != nil {
+				fmt.Fprintf(suite.config.errOutput(), "%v\n", err)
+			}
 		}
 
+		mutex.Lock()
+		defer mutex.Unlock()
+		totalTests++
+		timedOut = timedOut || caseTimedOut
+		if test.Critical && failedCritical == "" && !result.Passed && !result.Skipped {
+			failedCritical = result.Name
+		}
 		if result.Passed {
 			passed = append(passed, result)
 		} else if result.Skipped {
@@ -214,203 +476,1315 @@ func ExecuteSuite(runConfig RunConfig, testFilename string, logFailureDetails bo
 			failed = append(failed, result)
 		}
 		if logFailureDetails {
-			fmt.Print(result.Result())
+			fmt.Fprint(out, result.Result())
+		} else {
+			fmt.Fprint(out, result.ResultNoDetail())
+		}
+		if result.Slow {
+			fmt.Fprintf(out, "\t\t%s\n", fmt.Sprintf(WarningString, fmt.Sprintf("Slow request: %s %s took %s", result.RequestMethod, result.RequestUrl, result.RequestDuration.Round(time.Millisecond))))
+		}
+	}
+	if suite.schedulesConcurrently() {
+		suite.runConcurrently(extractedFields, executeCase)
+	} else {
+		for _, test := range suite.spec.Tests {
+			for _, testCase := range testCases(test) {
+				for k, v := range testCase.vars {
+					extractedFields[k] = v
+				}
+				executeCase(test, testCase, extractedFields)
+				for k := range testCase.vars {
+					delete(extractedFields, k)
+				}
+			}
+		}
+	}
+	if len(approved) > 0 {
+		err := writeApprovedBodies(suite.fileName, approved)
+		if err != nil {
+			fmt.Fprintf(out, "Error writing approved bodies to '%s': %v\n", suite.fileName, err)
 		} else {
-			fmt.Print(result.ResultNoDetail())
+			fmt.Fprintf(out, "Approved expected bodies of %d test(s) in '%s'\n", len(approved), suite.fileName)
+		}
+	}
+	// Teardown steps always run, even if tests failed, unless a test hung (the API is likely unresponsive)
+	// or the run was canceled
+	if runSteps && timedOut && len(suite.spec.Teardown) > 0 {
+		fmt.Fprintf(out, "Skipping teardown of '%s' after timeout\n", suite.fileName)
+	} else if runSteps && suite.config.runContext().Err() != nil && len(suite.spec.Teardown) > 0 {
+		fmt.Fprintf(out, "Skipping teardown of '%s' after the run was canceled\n", suite.fileName)
+	} else if runSteps {
+		teardownStart := time.Now()
+		teardownFailures := suite.executeSteps(suite.spec.Teardown, "teardown", extractedFields, false)
+		if len(suite.spec.Teardown) > 0 {
+			steps = append(steps, Passed("teardown", time.Since(teardownStart)))
 		}
+		failed = append(failed, teardownFailures...)
+		for _, result := range teardownFailures {
+			fmt.Fprint(out, result.Result())
+		}
+	}
+	if maxMs := suite.spec.MaxTotalDurationMs; maxMs > 0 && time.Since(start) > time.Duration(maxMs)*time.Millisecond {
+		durationFailure := suiteDurationFailure(maxMs, time.Since(start), append(append(steps, passed...), failed...))
+		failed = append(failed, durationFailure)
+		fmt.Fprint(out, durationFailure.Result())
 	}
 	return TestSuiteResult{
 		TotalTests:   totalTests,
 		Passed:       passed,
 		Failed:       failed,
 		Skipped:      skipped,
-		TestFilename: testSuite.fileName,
-	}, nil
+		TestFilename: suite.fileName,
+		TimedOut:     timedOut,
+		Duration:     time.Since(start),
+		Variables:    extractedFields,
+	}
 }
 
-func (suite TestSuite) executeTest(test TestSpec, extractedFields map[string]interface{}) TestResult {
-	start := time.Now()
-	testErrors := make([]string, 0)
+// Loads the suite in 'testFilename' and prepares the resources its tests need that aren't already set
+// in 'runConfig' (session client, auth, stubs, proxies). The returned func releases them.
+func newTestSuite(runConfig RunConfig, testFilename string) (TestSuite, func(), error) {
+	compiled, err := compileSuite(runConfig.FS, testFilename)
+	if err != nil {
+		return TestSuite{}, nil, err
+	}
+	return prepareTestSuite(runConfig, compiled)
+}
 
-	// Prep & make request
-	var requestBody io.Reader
-	if test.Request.Body == nil {
-		requestBody = bytes.NewBuffer([]byte("{}"))
-	} else {
-		var stringBody string
-		// Marshalling a string directly will escape the string, so we need to handle it separately
-		if str, ok := test.Request.Body.(string); ok {
-			stringBody = str
-		} else {
-			reqBodyBytes, err := json.Marshal(test.Request.Body)
+// Prepares the resources needed by the tests of an already compiled suite, like newTestSuite
+func prepareTestSuite(runConfig RunConfig, compiled compiledSuite) (testSuite TestSuite, closeSuite func(), err error) {
+	cleanups := make([]func(), 0)
+	release := func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
 
+	suiteSpec := compiled.spec
+
+	if len(suiteSpec.Env) > 0 {
+		restoreEnv, err := setProcessEnv(suiteSpec.Env)
+		if err != nil {
+			return TestSuite{}, nil, err
+		}
+		cleanups = append(cleanups, restoreEnv)
+	}
+	// Use an isolated session client (cookies, connection pool) for this suite unless one was provided
+	if runConfig.HttpClient == nil {
+		runConfig.HttpClient, err = newSessionClient(runConfig.FS, runConfig.Transport)
+		if err != nil {
+			return TestSuite{}, nil, err
+		}
+		cleanups = append(cleanups, func() { closeSessionClient(runConfig.HttpClient) })
+	}
+	if len(runConfig.ClientProfiles) > 0 {
+		runConfig.profileClients, err = newProfileClients(runConfig.FS, runConfig.ClientProfiles, runConfig.HttpClient)
+		if err != nil {
+			return TestSuite{}, nil, err
+		}
+		cleanups = append(cleanups, func() {
+			for _, client := range runConfig.profileClients {
+				closeSessionClient(client)
+			}
+		})
+	}
+	for _, profile := range suiteProfiles(suiteSpec) {
+		if _, ok := runConfig.ClientProfiles[profile]; !ok {
+			return TestSuite{}, nil, fmt.Errorf("unknown client profile '%s'", profile)
+		}
+	}
+	if runConfig.Auth != nil && runConfig.tokenCache == nil {
+		runConfig.tokenCache = newTokenCache(*runConfig.Auth)
+	}
+	if runConfig.Stubs != nil && runConfig.stubServer == nil {
+		runConfig.stubServer, err = startStubServer(*runConfig.Stubs)
+		if err != nil {
+			return TestSuite{}, nil, err
+		}
+		cleanups = append(cleanups, func() { runConfig.stubServer.close() })
+	}
+	if runConfig.PortForward != nil && runConfig.portForward == nil {
+		runConfig.portForward, err = startPortForward(*runConfig.PortForward)
+		if err != nil {
+			return TestSuite{}, nil, err
+		}
+		cleanups = append(cleanups, func() { runConfig.portForward.close() })
+		runConfig.BaseUrl = runConfig.portForward.baseUrl(*runConfig.PortForward)
+	}
+	if runConfig.Pact != nil && runConfig.pactRecorder == nil {
+		runConfig.pactRecorder, err = newPactRecorder(*runConfig.Pact)
+		if err != nil {
+			return TestSuite{}, nil, err
+		}
+		cleanups = append(cleanups, func() {
+			if _, err := runConfig.pactRecorder.write(); err != nil {
+				fmt.Fprintf(runConfig.errOutput(), "Error writing pact file: %v\n", err)
+			}
+		})
+	}
+	// Route tests with faults through a local chaos proxy
+	for _, testSpec := range suiteSpec.Tests {
+		if testSpec.Fault != nil && runConfig.chaosProxy == nil {
+			runConfig.chaosProxy, err = startChaosProxy(runConfig.FS, runConfig.Transport)
 			if err != nil {
-				testErrors = append(testErrors, fmt.Sprintf("Invalid request body: %v", err))
-				return Failed(test.Name, testErrors, time.Since(start))
+				return TestSuite{}, nil, err
 			}
-			stringBody = string(reqBodyBytes)
+			cleanups = append(cleanups, func() { runConfig.chaosProxy.close() })
 		}
-
-		// Replace any template variables in test's request body with the appropriate value
-		processedRequestBody, err := templateReplace(stringBody, extractedFields)
+	}
+	if runConfig.ProtoDescriptorSet != "" && runConfig.protoRegistry == nil {
+		runConfig.protoRegistry, err = loadProtoRegistry(runConfig.FS, runConfig.ProtoDescriptorSet)
 		if err != nil {
-			testErrors = append(testErrors, err.Error())
-			return Failed(test.Name, testErrors, time.Since(start))
+			return TestSuite{}, nil, err
+		}
+	}
+	if runConfig.OpenApiSpec != "" && runConfig.openApiExamples == nil {
+		runConfig.openApiExamples, err = loadOpenApiExamples(runConfig.FS, runConfig.OpenApiSpec)
+		if err != nil {
+			return TestSuite{}, nil, err
+		}
+	}
+	if len(runConfig.Plugins) > 0 && runConfig.plugins == nil {
+		var closePlugins func()
+		runConfig.plugins, closePlugins, err = startPlugins(runConfig.Plugins, runConfig.errOutput())
+		if err != nil {
+			return TestSuite{}, nil, err
+		}
+		cleanups = append(cleanups, closePlugins)
+	}
+	if len(runConfig.Factories) > 0 && runConfig.factories == nil {
+		runConfig.factories, err = resolveFactories(runConfig)
+		if err != nil {
+			return TestSuite{}, nil, err
+		}
+	}
+	if runConfig.Deprecations != nil && runConfig.deprecatedFields == nil {
+		runConfig.deprecatedFields, err = parseIgnoredFields(runConfig.Deprecations.Fields)
+		if err != nil {
+			return TestSuite{}, nil, errors.Wrap(err, "invalid deprecations.fields")
+		}
+	}
+	if len(runConfig.ProtectedUrls) > 0 && runConfig.protectedUrls == nil {
+		runConfig.protectedUrls, err = compileProtectedUrls(runConfig.ProtectedUrls)
+		if err != nil {
+			return TestSuite{}, nil, err
+		}
+	}
+	if runConfig.CacheFile != "" && runConfig.resultCache == nil && !runConfig.DryRun {
+		runConfig.resultCache, err = loadResultCache(runConfig.CacheFile, runConfig.noCache)
+		if err != nil {
+			return TestSuite{}, nil, err
+		}
+		cleanups = append(cleanups, func() {
+			err := runConfig.resultCache.save()
+			if err != nil {
+				fmt.Fprintf(runConfig.errOutput(), "%v\n", err)
+			}
+		})
+	}
+	if runConfig.Budget != nil && runConfig.budget == nil {
+		err = runConfig.Budget.validate()
+		if err != nil {
+			return TestSuite{}, nil, err
+		}
+		runConfig.budget = newRequestBudget(*runConfig.Budget)
+	}
+	if runConfig.runId == "" {
+		runConfig.runId = newUUID()
+	}
+	if runConfig.Tracing != nil && runConfig.tracer == nil && !runConfig.DryRun {
+		runConfig.tracer = newTracer(*runConfig.Tracing)
+		cleanups = append(cleanups, func() { runConfig.tracer.flush(runConfig.output(), runConfig.errOutput()) })
+	}
+	if runConfig.Flags != nil && runConfig.activeFlags == nil {
+		runConfig.activeFlags, err = resolveFlags(runConfig)
+		if err != nil {
+			return TestSuite{}, nil, err
 		}
+	}
 
-		requestBody = bytes.NewBuffer([]byte(processedRequestBody))
+	return TestSuite{
+		spec:              suiteSpec,
+		config:            runConfig,
+		fileName:          compiled.fileName,
+		ignoredFields:     compiled.ignoredFields,
+		extractionRegexes: compiled.extractionRegexes,
+		deadline:          suiteDeadline(runConfig),
+	}, release, nil
+}
 
-		// Memoize request body
-		for k, v := range flatten(test.Request.Body, "", 0) {
-			extractedFields[test.Name+".request.body."+k] = v
+// Returns the template vars available to all tests before any have run
+func (suite TestSuite) initialFields() map[string]interface{} {
+	extractedFields := make(map[string]interface{})
+	if suite.config.stubServer != nil {
+		for k, v := range suite.config.stubServer.vars() {
+			extractedFields[k] = v
 		}
 	}
+	return extractedFields
+}
 
-	baseUrl := suite.config.BaseUrl
-	if suite.spec.BaseUrl != "" {
-		baseUrl = suite.spec.BaseUrl
+// Executes 'test' (its request or exec step) unless it's skipped
+func (suite TestSuite) executeSpec(test TestSpec, extractedFields map[string]interface{}) (result TestResult) {
+	metadata := suite.testMetadata(test)
+	defer func() {
+		result.Metadata = metadata
+	}()
+	if suite.spec.Skip || test.Skip || !matchesMetadata(metadata, suite.config.metadataFilter) {
+		return Skipped(test.Name)
 	}
-	if test.Request.BaseUrl != "" {
-		baseUrl = test.Request.BaseUrl
+	if reason := suite.flagSkipReason(test); reason != "" {
+		result = Skipped(test.Name)
+		result.SkipReason = reason
+		return result
+	}
+	if skippedByReadOnly(suite.config.Budget, metadata) {
+		result = Skipped(test.Name)
+		result.SkipReason = "destructive test in read-only run"
+		return result
+	}
+	if suite.config.tracer != nil {
+		suite.span = suite.config.tracer.start(test.Name, spanKindClient, suite.span)
+		defer func() {
+			result.TraceId = suite.span.traceIdString()
+			suite.span.endTest(suite.fileName, result)
+		}()
+	}
+	if test.Exec != nil {
+		if suite.config.DryRun {
+			return Skipped(test.Name)
+		}
+		step := *test.Exec
+		step.Name = test.Name
+		result = suite.executeExec(step, extractedFields)
+		if !result.Passed {
+			result.Category = FailureExecError
+		}
+		return result
 	}
+	delete(extractedFields, test.Name+".response")
+	result = suite.executeTest(test.withProfileOverrides(suite.config.Profile), extractedFields)
+	result.Response, _ = extractedFields[test.Name+".response"].(map[string]interface{})
+	return result
+}
 
-	// Replace any template variables in test's request url with the appropriate value
-	requestUrl, err := templateReplace(test.Request.Url, extractedFields)
+// Reads and validates the test suite spec in 'testFilename'
+func loadSuiteSpec(fsys fs.FS, testFilename string) (TestSuiteSpec, error) {
+	byteValue, err := readFile(fsys, testFilename)
 	if err != nil {
-		testErrors = append(testErrors, err.Error())
-		return Failed(test.Name, testErrors, time.Since(start))
+		return TestSuiteSpec{}, errors.Wrap(err, fmt.Sprintf("error reading test file %s", testFilename))
+	}
+	var suiteSpec TestSuiteSpec
+	err = json.Unmarshal(byteValue, &suiteSpec)
+	if err != nil {
+		return TestSuiteSpec{}, errors.Wrap(err, fmt.Sprintf("error parsing test data in %s", testFilename))
+	}
+	err = findDuplicateKey(byteValue)
+	if err != nil {
+		return TestSuiteSpec{}, errors.Wrap(err, fmt.Sprintf("error parsing test data in %s", testFilename))
+	}
+	// Fields that aren't part of the test file format are likely typos of ones that are
+	if !suiteSpec.AllowUnknownFields {
+		err = findUnknownField(byteValue)
+		if err != nil {
+			return TestSuiteSpec{}, errors.Wrap(err, fmt.Sprintf("error parsing test data in %s (set allowUnknownFields to allow it)", testFilename))
+		}
+	}
+	if suiteSpec.MaxTotalDurationMs < 0 {
+		return TestSuiteSpec{}, fmt.Errorf("invalid maxTotalDurationMs %d in %s, must be positive (or 0 for no limit)", suiteSpec.MaxTotalDurationMs, testFilename)
+	}
+	if suiteSpec.MaxParallel < 0 {
+		return TestSuiteSpec{}, fmt.Errorf("invalid maxParallel %d in %s, must be positive (or 0 for the default)", suiteSpec.MaxParallel, testFilename)
+	}
+	if suiteSpec.Version < 0 || suiteSpec.Version > currentSuiteVersion {
+		return TestSuiteSpec{}, fmt.Errorf("test file %s has unsupported version %d, the latest supported version is %d", testFilename, suiteSpec.Version, currentSuiteVersion)
+	}
+
+	// Validate test suite spec (no duplicate tests, names must be alphanumeric without spaces)
+	testNames := make(map[string]bool)
+	for _, testSpec := range suiteSpec.Tests {
+		if !testNameRegex.MatchString(testSpec.Name) {
+			return TestSuiteSpec{}, fmt.Errorf("invalid test case name: '%s', must be alphanumeric without spaces", testSpec.Name)
+		}
+		if _, ok := testNames[testSpec.Name]; ok {
+			return TestSuiteSpec{}, fmt.Errorf("test case '%s' defined twice", testSpec.Name)
+		}
+		if testSpec.Repeat < 0 {
+			return TestSuiteSpec{}, fmt.Errorf("test case '%s' has a negative repeat", testSpec.Name)
+		}
+		for param, values := range testSpec.Matrix {
+			if len(values) == 0 {
+				return TestSuiteSpec{}, fmt.Errorf("matrix parameter '%s' of test case '%s' has no values", param, testSpec.Name)
+			}
+		}
+		if testSpec.Preflight != nil && (testSpec.Preflight.Origin == "" || testSpec.Preflight.Method == "") {
+			return TestSuiteSpec{}, fmt.Errorf("preflight of test case '%s' must have an origin and method", testSpec.Name)
+		}
+		if conditionalOn := testSpec.Request.ConditionalOn; conditionalOn != "" && !testNames[conditionalOn] {
+			return TestSuiteSpec{}, fmt.Errorf("test case '%s' is conditional on '%s', which isn't an earlier test case", testSpec.Name, conditionalOn)
+		}
+		if testSpec.AllowedDiffCount != nil && *testSpec.AllowedDiffCount < 0 {
+			return TestSuiteSpec{}, fmt.Errorf("test case '%s' has a negative allowedDiffCount", testSpec.Name)
+		}
+		for _, dependency := range testSpec.DependsOn {
+			if !testNames[dependency] {
+				return TestSuiteSpec{}, fmt.Errorf("test case '%s' depends on '%s', which isn't an earlier test case", testSpec.Name, dependency)
+			}
+		}
+		testNames[testSpec.Name] = true
+	}
+	return suiteSpec, nil
+}
+
+func (suite TestSuite) executeTest(test TestSpec, extractedFields map[string]interface{}) (result TestResult) {
+	start := time.Now()
+	testErrors := make([]string, 0)
+	// The category of the first failure is reported as the test's category
+	var category FailureCategory
+	fail := func(c FailureCategory, errs ...string) {
+		if category == "" && len(errs) > 0 {
+			category = c
+		}
+		testErrors = append(testErrors, errs...)
 	}
+	softFailures := make([]string, 0)
+	var deprecatedFields []string
+	defer func() {
+		result.DeprecatedFields = deprecatedFields
+		if !result.Passed && !result.Skipped {
+			result.Category = category
+		}
+		if len(softFailures) > 0 {
+			result.SoftFailures = softFailures
+		}
+	}()
 
-	req, err := http.NewRequest(test.Request.Method, baseUrl+requestUrl, requestBody)
+	// Prep & make request ('suite' is a copy)
+	suite.allowUnsafeMethods = test.AllowUnsafeMethods
+	// Fixtures the request instantiates are only created once it's about to be sent
+	pending := &pendingFixtures{}
+	suite.pendingFixtures = pending
+	req, err := suite.buildRequest(test, extractedFields)
+	suite.pendingFixtures = nil
 	if err != nil {
-		testErrors = append(testErrors, fmt.Sprintf("Unable to create request: %v", err))
+		fail(FailureTemplateError, err.Error())
 		return Failed(test.Name, testErrors, time.Since(start))
 	}
-	for k, v := range suite.config.CustomHeaders {
-		req.Header.Add(k, v)
+	defer func() {
+		result.RequestMethod = req.Method
+		result.RequestUrl = req.URL.String()
+		result.RequestHeaders = req.Header.Clone()
+		body, _ := readRequestBody(req)
+		result.RequestBody = string(body)
+	}()
+	// Send the test's request without an auth token if its header is omitted ('suite' is a copy)
+	if suite.config.tokenCache != nil && test.omitsDefaultHeader(suite.config.tokenCache.headerName()) {
+		suite.config.tokenCache = nil
 	}
-	for k, v := range test.Request.Headers {
-		headerVal, err := templateReplace(v, extractedFields)
+	// In dry-run mode, only assert on the request that would be sent, headers added when sending included
+	if suite.config.DryRun {
+		category = FailureRequestDiff
+		_, err = suite.decoratedDo(dryRunHttpClient{}, req)
 		if err != nil {
-			testErrors = append(testErrors, err.Error())
+			fail(FailureTemplateError, fmt.Sprintf("Error preparing request: %v", err))
 			return Failed(test.Name, testErrors, time.Since(start))
 		}
-		req.Header.Add(k, headerVal)
+		return suite.previewRequest(test, req, extractedFields, start)
+	}
+	// Skip the test if it passed with the same inputs before, restoring what it memoized then. Tests
+	// depending on something other than their response (faults, callbacks) always run.
+	if suite.config.resultCache != nil && test.Fault == nil && test.ExpectedCallback == nil {
+		key, err := suite.resultCacheKey(test, req)
+		if err != nil {
+			fail(FailureTemplateError, fmt.Sprintf("Error hashing request: %v", err))
+			return Failed(test.Name, testErrors, time.Since(start))
+		}
+		if entry, ok := suite.config.resultCache.lookup(key); ok {
+			for k, v := range entry.Fields {
+				extractedFields[k] = v
+			}
+			result = Skipped(test.Name)
+			result.SkipReason = "unchanged since it last passed"
+			return result
+		}
+		defer func() {
+			if result.Passed {
+				suite.config.resultCache.record(key, resultCacheEntry{
+					Test:   fmt.Sprintf("%s %s", suite.fileName, test.Name),
+					Fields: testFields(test.Name, extractedFields),
+				})
+			}
+		}()
+	}
+	// Create the request's fixtures, unless the request itself would be refused
+	if len(pending.fixtures) > 0 && suite.checkSafeRequest(req) == nil {
+		req, err = suite.createPendingFixtures(req, pending, extractedFields)
+		if err != nil {
+			fail(FailureTemplateError, err.Error())
+			return Failed(test.Name, testErrors, time.Since(start))
+		}
+	}
+	if test.Fault != nil {
+		var release func()
+		req, release = suite.config.chaosProxy.route(req, *test.Fault)
+		defer release()
+	}
+	// Connect the backend's trace of the request to the test's span
+	if suite.span != nil {
+		req.Header.Set("traceparent", suite.span.traceparent())
+	}
+	if profile := suite.clientProfile(test); profile != "" {
+		suite.config.HttpClient = suite.config.profileClients[profile]
 	}
-	resp, err := suite.config.HttpClient.Do(req)
+	requestStart := time.Now()
+	resp, err := suite.doRequest(req)
+	requestDuration := time.Since(requestStart)
+	requestId := suite.requestIdConfig().requestId(req, resp)
+	defer func() {
+		result.RequestDuration = requestDuration
+		if threshold := suite.slowThreshold(test); threshold > 0 && requestDuration > threshold {
+			result.Slow = true
+		}
+		result.RequestId = requestId
+	}()
 	if err != nil {
-		testErrors = append(testErrors, fmt.Sprintf("Error making request: %v", err))
-		return Failed(test.Name, testErrors, time.Since(start))
+		fail(transportFailureCategory(err), fmt.Sprintf("Error making request: %v", err))
+		result = Failed(test.Name, testErrors, time.Since(start))
+		result.aborted = isContextError(err)
+		return result
+	}
+
+	// Memoize injected idempotency key
+	if suite.config.IdempotencyKey != nil {
+		if idempotencyKey := req.Header.Get(suite.config.IdempotencyKey.headerName()); idempotencyKey != "" {
+			extractedFields[test.Name+".request.idempotencyKey"] = idempotencyKey
+		}
+	}
+
+	// Compare redirect chain
+	redirects, finalUrl := redirectChain(req, resp)
+	extractedFields[test.Name+".response.redirects"] = redirects
+	extractedFields[test.Name+".response.finalUrl"] = finalUrl
+	if test.ExpectedResponse.Redirects != nil && redirects != *test.ExpectedResponse.Redirects {
+		fail(FailureStatusMismatch, fmt.Sprintf("Expected %d redirects but got %d", *test.ExpectedResponse.Redirects, redirects))
+	}
+	if test.ExpectedResponse.FinalUrl != "" {
+		expectedFinalUrl, err := templateReplace(test.ExpectedResponse.FinalUrl, extractedFields)
+		if err != nil {
+			fail(FailureTemplateError, err.Error())
+		} else if finalUrl != expectedFinalUrl {
+			fail(FailureStatusMismatch, fmt.Sprintf("Expected final url %s but got %s", expectedFinalUrl, finalUrl))
+		}
 	}
 
-	// Compare response statusCode
+	// Compare response statusCode (defaults to 200 or the configured default if not specified, any 2xx for preflights)
 	statusCode := resp.StatusCode
-	if statusCode != test.ExpectedResponse.StatusCode {
-		testErrors = append(testErrors, fmt.Sprintf("Expected http %d but got http %d", test.ExpectedResponse.StatusCode, statusCode))
+	if test.Preflight != nil && test.ExpectedResponse.StatusCode == 0 {
+		if statusCode < 200 || statusCode > 299 {
+			fail(FailureStatusMismatch, fmt.Sprintf("Expected http 2xx for preflight but got http %d", statusCode))
+		}
+	} else if expectedStatusCode := expectedStatusCode(suite.config, test); statusCode != expectedStatusCode {
+		fail(FailureStatusMismatch, fmt.Sprintf("Expected http %d but got http %d", expectedStatusCode, statusCode))
 	}
 
 	// Memoize response headers
-	for headerName, headerValues := range resp.Header {
-		headerValConcat := strings.Join(headerValues, ",")
-		extractedFields[test.Name+".header."+headerName] = strings.TrimSpace(headerValConcat)
+	for name, value := range headerFields(resp.Header) {
+		extractedFields[test.Name+".header."+name] = value
 	}
 	// Compare all expected response headers
-	for expHeaderName, expHeaderValTemplate := range test.ExpectedResponse.Headers {
+	for _, expHeaderName := range slices.Sorted(maps.Keys(test.ExpectedResponse.Headers)) {
+		expHeaderValTemplate := test.ExpectedResponse.Headers[expHeaderName]
 		if actualVals, ok := resp.Header[http.CanonicalHeaderKey(expHeaderName)]; ok {
 			expHeaderVal, err := templateReplace(expHeaderValTemplate, extractedFields)
 			if err != nil {
-				testErrors = append(testErrors, fmt.Sprintf("Invalid expected response header template %s", expHeaderValTemplate))
+				fail(FailureTemplateError, fmt.Sprintf("Invalid expected response header template %s", expHeaderValTemplate))
 				continue
 			}
 			actualVal := strings.Join(actualVals, ",")
 			if actualVal != expHeaderVal {
-				testErrors = append(testErrors, fmt.Sprintf("Expected response header '%s: %s' but got '%s: %s'", expHeaderName, expHeaderVal, expHeaderName, actualVal))
+				fail(FailureHeaderDiff, fmt.Sprintf("Expected response header '%s: %s' but got '%s: %s'", expHeaderName, expHeaderVal, expHeaderName, actualVal))
 			}
 		} else {
-			testErrors = append(testErrors, fmt.Sprintf("Expected response header '%s: %s' not present", expHeaderName, expHeaderValTemplate))
+			fail(FailureHeaderDiff, fmt.Sprintf("Expected response header '%s: %s' not present", expHeaderName, expHeaderValTemplate))
+		}
+	}
+
+	// Compare CORS headers of preflight responses
+	if test.Preflight != nil {
+		fail(FailureHeaderDiff, preflightMismatches(*test.Preflight, req, resp)...)
+	}
+
+	// Compare response content type
+	if test.ExpectedResponse.ContentType != "" {
+		if mismatch := matchContentType(resp.Header.Get("Content-Type"), test.ExpectedResponse.ContentType); mismatch != "" {
+			fail(FailureHeaderDiff, mismatch)
 		}
 	}
 
 	// Read response payload
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		testErrors = append(testErrors, fmt.Sprintf("Error reading response from server: %v", err))
-		return Failed(test.Name, testErrors, time.Since(start))
+		fail(transportFailureCategory(err), fmt.Sprintf("Error reading response from server: %v", err))
+		result = Failed(test.Name, testErrors, time.Since(start))
+		result.aborted = isContextError(err)
+		return result
+	}
+	body = normalizeCharset(resp.Header.Get("Content-Type"), body)
+	// Memoize the full response for later tests, e.g. {{ testName.response.body.items.0.id }}
+	response := responseObject(resp, body)
+	extractedFields[test.Name+".response"] = response
+	for name, value := range cookieFields(response["cookies"].(map[string]interface{})) {
+		extractedFields[test.Name+".cookie."+name] = value
 	}
 
-	// Compare response payload
-	expectedResponse := test.ExpectedResponse.Body
-	// Confirm there is no response payload if that's what is expected
-	if expectedResponse == nil {
-		if len(body) != 0 {
-			testErrors = append(testErrors, fmt.Sprintf("Expected response payload %s but got empty response", expectedResponse))
+	// Check for deprecated fields
+	if len(suite.config.deprecatedFields) > 0 {
+		deprecatedFields = findFields(response["body"], suite.config.deprecatedFields, nil)
+		for _, field := range deprecatedFields {
+			if suite.config.Deprecations.Fail {
+				fail(FailureBodyDiff, fmt.Sprintf("Response contains deprecated field '%s'", field))
+			} else {
+				softFailures = append(softFailures, fmt.Sprintf("Response contains deprecated field '%s'", field))
+			}
 		}
-		// No need to check anything else since no response was expected
-		if len(testErrors) > 0 {
-			return Failed(test.Name, testErrors, time.Since(start))
-		} else {
-			return Passed(test.Name, time.Since(start))
+	}
+
+	// Extract values from response payload
+	for _, varName := range slices.Sorted(maps.Keys(test.Extract)) {
+		extraction := test.Extract[varName]
+		val, err := extraction.extract(body, suite.extractionRegexes[extraction.Regex])
+		if err != nil {
+			fail(FailureParseError, fmt.Sprintf("Error extracting '%s': %v", varName, err))
+			continue
 		}
+		extractedFields[test.Name+"."+varName] = val
 	}
 
-	// Otherwise, deep compare response payload to expected response payload
-	var r interface{}
-	err = json.Unmarshal(body, &r)
-	switch {
-	case err != nil:
-		// If JSON unmarshalling fails, compare the response as a plain text string
+	// Evaluate assert expressions
+	if len(test.Assert) > 0 {
+		fail(FailureBodyDiff, evalAssertions(test.Assert, resp, body, extractedFields)...)
+	}
+	if len(test.SoftAssert) > 0 {
+		softFailures = append(softFailures, evalAssertions(test.SoftAssert, resp, body, extractedFields)...)
+	}
+
+	// Wait for the expected callback to the stub server
+	if test.ExpectedCallback != nil {
+		if suite.config.stubServer == nil {
+			fail(FailureBodyDiff, "Expected callback but no stubs are configured")
+		} else {
+			callback, callbackErrors := suite.config.stubServer.awaitCallback(*test.ExpectedCallback, start, extractedFields)
+			fail(FailureBodyDiff, callbackErrors...)
+			if len(callbackErrors) == 0 {
+				var callbackBody interface{}
+				if json.Unmarshal(callback.Body, &callbackBody) == nil {
+					for k, v := range flatten(callbackBody, "", 0) {
+						extractedFields[test.Name+".callback.body."+k] = v
+					}
+				}
+			}
+		}
+	}
+
+	// Compare response payload
+	expectedResponse := test.ExpectedResponse.Body
+	if test.ExpectedResponse.BodyFile != "" {
+		expectedResponse, err = suite.loadBodyFile(test.ExpectedResponse.BodyFile)
+		if err != nil {
+			fail(FailureParseError, err.Error())
+			return Failed(test.Name, testErrors, time.Since(start))
+		}
+	}
+	if test.ExpectedResponse.FromSpecExample != "" {
+		example, err := suite.specExample(test.ExpectedResponse.FromSpecExample)
+		if err != nil {
+			fail(FailureParseError, err.Error())
+			return Failed(test.Name, testErrors, time.Since(start))
+		}
+		expectedResponse = example.Body
+	}
+	// Confirm there is no response payload if that's what is expected
+	if test.ExpectedResponse.BodyEmpty {
+		if len(body) != 0 {
+			fail(FailureBodyDiff, fmt.Sprintf("Expected empty response payload but got %s", string(body)))
+		}
+	}
+	// Responses to HEAD requests and 1xx, 204 and 304 responses have no payload, so there's nothing to parse or compare
+	if !hasPayload(req.Method, statusCode) {
+		if len(body) != 0 {
+			fail(FailureBodyDiff, fmt.Sprintf("Expected no response payload for %s but got %s", noPayloadDescription(req.Method, statusCode), string(body)))
+		}
+		if expectedResponse != nil {
+			expectedBytes, _ := json.Marshal(expectedResponse)
+			fail(FailureBodyDiff, fmt.Sprintf("Expected response payload %s but %s has none", string(expectedBytes), noPayloadDescription(req.Method, statusCode)))
+		}
+		expectedResponse = nil
+	}
+	// No need to check anything else if no response payload was specified
+	if expectedResponse == nil {
+		if len(testErrors) > 0 {
+			return Failed(test.Name, testErrors, time.Since(start))
+		}
+		suite.recordPact(test, req, resp, body)
+		return Passed(test.Name, time.Since(start))
+	}
+
+	// Otherwise, deep compare response payload to expected response payload
+	var r interface{}
+	if contentType := resp.Header.Get("Content-Type"); isProtobufContentType(contentType) {
+		// Compare protobuf responses in their JSON form
+		r, err = suite.decodeProtobuf(test.ExpectedResponse.ProtoMessage, contentType, body)
+		if err != nil {
+			fail(FailureParseError, fmt.Sprintf("Error decoding protobuf response: %v", err))
+			return Failed(test.Name, testErrors, time.Since(start))
+		}
+	} else if isXmlContentType(contentType) {
+		// Compare XML responses in their JSON-ified form
+		r, err = xmlToJson(body)
+		if expectedXml, ok := expectedResponse.(string); ok && err == nil && strings.HasPrefix(strings.TrimSpace(expectedXml), "<") {
+			expectedResponse, err = xmlToJson([]byte(expectedXml))
+			if err != nil {
+				fail(FailureParseError, fmt.Sprintf("Invalid expected XML response payload: %v", err))
+				return Failed(test.Name, testErrors, time.Since(start))
+			}
+		}
+	} else {
+		err = json.Unmarshal(body, &r)
+	}
+	// Spec examples can have any shape, so make sure the example is comparable to the response before comparing them
+	if test.ExpectedResponse.FromSpecExample != "" && err == nil && jsonTypeName(r) != jsonTypeName(expectedResponse) {
+		fail(FailureBodyDiff, fmt.Sprintf("Spec example '%s' is %s but the response payload is %s", test.ExpectedResponse.FromSpecExample, withArticle(jsonTypeName(expectedResponse)), withArticle(jsonTypeName(r))))
+		return Failed(test.Name, testErrors, time.Since(start))
+	}
+	// Differences between the response's and expected fields (see allowedDiffCount)
+	var bodyDifferences []string
+	switch {
+	case err != nil:
+		// If JSON unmarshalling fails, compare the response as a plain text string
 		expectedString, ok := expectedResponse.(string)
 		if !ok {
-			testErrors = append(testErrors, fmt.Sprintf("Expected a JSON object, but got a non-JSON response: %s", string(body)))
+			fail(FailureTransportError, nonJsonResponseDescription(resp.Header.Get("Content-Type"), body))
 		} else {
 			processedExpectedBody, err := templateReplace(expectedString, extractedFields)
 			if err != nil {
-				testErrors = append(testErrors, fmt.Sprintf("Error comparing actual and expected responses: %v", err))
-			} else if string(body) != processedExpectedBody {
-				testErrors = append(testErrors, fmt.Sprintf("Expected response payload %s but got %s", expectedString, string(body)))
+				fail(FailureTemplateError, fmt.Sprintf("Error comparing actual and expected responses: %v", err))
+			} else if suite.spec.StringComparison.normalize(string(body)) != suite.spec.StringComparison.normalize(processedExpectedBody) {
+				fail(FailureBodyDiff, fmt.Sprintf("Expected response payload %s but got %s", expectedString, string(body)))
 			}
 		}
 	case isMap(r):
-		differences, err := suite.compareObjects(r.(map[string]interface{}), expectedResponse.(map[string]interface{}), extractedFields, test.Name)
+		expected, ok := expectedResponse.(map[string]interface{})
+		if !ok {
+			fail(FailureBodyDiff, payloadTypeMismatch(expectedResponse, r))
+			break
+		}
+		differences, softDifferences, err := suite.compareObjectsWithSoftFields(r.(map[string]interface{}), expected, extractedFields, test.Name, test)
 		if err != nil {
-			testErrors = append(testErrors, fmt.Sprintf("Error comparing actual and expected responses: %v", err))
+			fail(FailureTemplateError, fmt.Sprintf("Error comparing actual and expected responses: %v", err))
 		}
-
-		if len(differences) > 0 {
-			testErrors = append(testErrors, differences...)
+		softFailures = append(softFailures, differenceStrings(softDifferences)...)
+		bodyDifferences = append(bodyDifferences, differenceStrings(differences)...)
+	case isSlice(r) && isArrayMatcher(expectedResponse):
+		// Memoize response elements
+		for k, v := range flatten(r, test.Name, 0) {
+			extractedFields[k] = v
 		}
+		bodyDifferences = append(bodyDifferences, differenceStrings(matchArray(r, expectedResponse.(map[string]interface{}), "", compareItems(extractedFields, suite.ignoredFields, suite.spec.StringComparison)))...)
 	case isSlice(r):
 		response := r.([]interface{})
-		expected := expectedResponse.([]interface{})
-		if len(response) != len(expected) {
-			testErrors = append(testErrors, "The number of array elements in response and expectedResponse don't match")
+		expected, ok := expectedResponse.([]interface{})
+		if !ok {
+			fail(FailureBodyDiff, payloadTypeMismatch(expectedResponse, r))
+		} else if len(response) != len(expected) {
+			fail(FailureBodyDiff, "The number of array elements in response and expectedResponse don't match")
 		} else {
 			for i := range response {
-				differences, err := suite.compareObjects(response[i].(map[string]interface{}), expected[i].(map[string]interface{}), extractedFields, fmt.Sprintf("%s[%d]", test.Name, i))
-				if err != nil {
-					testErrors = append(testErrors, fmt.Sprintf("Error comparing actual and expected responses: %v", err))
+				responseElem, responseIsMap := response[i].(map[string]interface{})
+				expectedElem, expectedIsMap := expected[i].(map[string]interface{})
+				if !responseIsMap || !expectedIsMap {
+					// Elements other than objects are compared as values
+					differences := diffValues(suite.spec.StringComparison.normalizeAll(response[i]), suite.spec.StringComparison.normalizeAll(expected[i]), fmt.Sprintf("slice[%d]", i))
+					bodyDifferences = append(bodyDifferences, differenceStrings(differences)...)
+					continue
 				}
-
-				if len(differences) > 0 {
-					testErrors = append(testErrors, differences...)
+				differences, softDifferences, err := suite.compareObjectsWithSoftFields(responseElem, expectedElem, extractedFields, fmt.Sprintf("%s[%d]", test.Name, i), test)
+				if err != nil {
+					fail(FailureTemplateError, fmt.Sprintf("Error comparing actual and expected responses: %v", err))
 				}
-
+				softFailures = append(softFailures, differenceStrings(softDifferences)...)
+				bodyDifferences = append(bodyDifferences, differenceStrings(differences)...)
 			}
 		}
 	default:
-		differences := deep.Equal(r, expectedResponse)
-		if len(differences) > 0 {
-			testErrors = append(testErrors, differences...)
+		differences := diffValues(suite.spec.StringComparison.normalizeAll(r), suite.spec.StringComparison.normalizeAll(expectedResponse), "")
+		bodyDifferences = append(bodyDifferences, differenceStrings(differences)...)
+	}
+	// Tolerate a bounded number of differences as soft failures, e.g. during staged contract migrations
+	if allowed := suite.allowedDiffCount(test); len(bodyDifferences) > 0 && len(bodyDifferences) <= allowed {
+		for _, difference := range bodyDifferences {
+			softFailures = append(softFailures, fmt.Sprintf("%s (tolerated, %d of %d allowed differences)", difference, len(bodyDifferences), allowed))
 		}
+	} else {
+		fail(FailureBodyDiff, bodyDifferences...)
 	}
 	if len(testErrors) > 0 {
 		// Append raw server response payload to errors for easier debugging
 		testErrors = append(testErrors, fmt.Sprintf("Full response payload from server: %s", string(body)))
 		return Failed(test.Name, testErrors, time.Since(start))
 	}
+	suite.recordPact(test, req, resp, body)
 	return Passed(test.Name, time.Since(start))
 }
 
+// Returns the failure of a response payload 'actual' whose JSON type differs from the expected payload's
+func payloadTypeMismatch(expected interface{}, actual interface{}) string {
+	return fmt.Sprintf("Expected response payload to be %s but got %s", withArticle(jsonTypeName(expected)), withArticle(jsonTypeName(actual)))
+}
+
+// Returns the JSON type name 'typeName' with its indefinite article, e.g. "an object"
+func withArticle(typeName string) string {
+	switch typeName {
+	case "null":
+		return typeName
+	case "object", "array":
+		return "an " + typeName
+	default:
+		return "a " + typeName
+	}
+}
+
+// Returns the number of response body differences tolerated for 'test' (see TestSpec.AllowedDiffCount)
+func (suite TestSuite) allowedDiffCount(test TestSpec) int {
+	if test.AllowedDiffCount != nil {
+		return *test.AllowedDiffCount
+	}
+	return suite.config.MaxDiffs
+}
+
+// Records the interaction of a passing test if pact generation is enabled
+func (suite TestSuite) recordPact(test TestSpec, req *http.Request, resp *http.Response, body []byte) {
+	if suite.config.pactRecorder != nil {
+		suite.config.pactRecorder.record(strings.TrimSuffix(filepath.Base(suite.fileName), ".json"), test, req, resp, body)
+	}
+}
+
+// Builds the request for 'test', replacing any template variables with values from 'extractedFields'
+func (suite TestSuite) buildRequest(test TestSpec, extractedFields map[string]interface{}) (*http.Request, error) {
+	var requestBody io.Reader
+	method := test.Request.Method
+	if test.Preflight != nil {
+		method = http.MethodOptions
+		requestBody = http.NoBody
+	} else if test.Request.Body == nil {
+		// Version 1 test files send an empty JSON object if a request has no body
+		if suite.spec.version() < 2 {
+			requestBody = bytes.NewBuffer([]byte("{}"))
+		} else {
+			requestBody = http.NoBody
+		}
+	} else {
+		stringBody, err := requestBodyTemplate(test)
+		if err != nil {
+			return nil, err
+		}
+
+		// Replace any template variables in test's request body with the appropriate value
+		processedRequestBody, err := suite.templateRequestValue(stringBody, extractedFields)
+		if err != nil {
+			return nil, err
+		}
+
+		requestBody = bytes.NewBuffer([]byte(processedRequestBody))
+
+		// Memoize request body
+		for k, v := range flatten(test.Request.Body, "", 0) {
+			extractedFields[test.Name+".request.body."+k] = v
+		}
+	}
+
+	baseUrl := suite.config.BaseUrl
+	if suite.spec.BaseUrl != "" {
+		baseUrl = suite.spec.BaseUrl
+	}
+	if test.Request.BaseUrl != "" {
+		baseUrl = test.Request.BaseUrl
+	}
+
+	// Replace any template variables in test's request url with the appropriate value
+	requestUrl, err := suite.templateRequestValue(test.Request.Url, extractedFields)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(suite.config.runContext(), method, baseUrl+requestUrl, requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create request: %v", err)
+	}
+	// Custom headers from config can be computed from the test and run (e.g. {{ test.name }}, {{ run.id }})
+	computedFields := map[string]interface{}{
+		"test.name": test.Name,
+		"test.file": filepath.Base(suite.fileName),
+		"run.id":    suite.config.runId,
+	}
+	for k, v := range suite.config.CustomHeaders {
+		if test.omitsDefaultHeader(k) {
+			continue
+		}
+		headerVal, err := templateReplace(v, computedFields)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid config header '%s'", k))
+		}
+		req.Header.Add(k, headerVal)
+	}
+	for k, v := range test.Request.Headers {
+		headerVal, err := suite.templateRequestValue(v, extractedFields)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add(k, headerVal)
+	}
+	if test.Preflight != nil {
+		err = suite.setPreflightHeaders(req, *test.Preflight, extractedFields)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Revalidate an earlier test's response, unless the test sets the conditional headers itself
+	if test.Request.ConditionalOn != "" {
+		headers, err := conditionalHeaders(test.Request.ConditionalOn, extractedFields)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range headers {
+			if req.Header.Get(k) == "" {
+				req.Header.Set(k, v)
+			}
+		}
+	}
+	// Inject the time the server should treat as "now"
+	if suite.config.TestTime != nil || test.TestTime != "" {
+		testTimeConfig := TestTimeConfig{}
+		if suite.config.TestTime != nil {
+			testTimeConfig = *suite.config.TestTime
+		}
+		testTime, err := testTimeConfig.headerValue(test.TestTime, extractedFields)
+		if err != nil {
+			return nil, err
+		}
+		if testTime != "" {
+			req.Header.Set(testTimeConfig.headerName(), testTime)
+			extractedFields[test.Name+".request.testTime"] = testTime
+		}
+	}
+	return req, nil
+}
+
+// Returns the (untemplated) request body of 'test' as a string
+func requestBodyTemplate(test TestSpec) (string, error) {
+	if test.Request.Body == nil {
+		return "{}", nil
+	}
+	// Marshalling a string directly will escape the string, so we need to handle it separately
+	if str, ok := test.Request.Body.(string); ok {
+		return str, nil
+	}
+	reqBodyBytes, err := json.Marshal(test.Request.Body)
+	if err != nil {
+		return "", fmt.Errorf("Invalid request body: %v", err)
+	}
+	return string(reqBodyBytes), nil
+}
+
+// Returns the expected response status code of 'test', defaulting to the status of its spec example, or 200 or the configured default if not specified
+func expectedStatusCode(config RunConfig, test TestSpec) int {
+	if test.ExpectedResponse.StatusCode != 0 {
+		return test.ExpectedResponse.StatusCode
+	}
+	if example, ok := config.openApiExamples[test.ExpectedResponse.FromSpecExample]; ok {
+		return example.StatusCode
+	}
+	if test.Request.ConditionalOn != "" {
+		return http.StatusNotModified
+	}
+	if config.DefaultStatusCode != 0 {
+		return config.DefaultStatusCode
+	}
+	return http.StatusOK
+}
+
+// Compares the request that would be sent for 'test' to its expectedRequest
+func (suite TestSuite) previewRequest(test TestSpec, req *http.Request, extractedFields map[string]interface{}, start time.Time) TestResult {
+	if test.ExpectedRequest == nil {
+		return Skipped(test.Name)
+	}
+	testErrors := make([]string, 0)
+	expected := test.ExpectedRequest
+	if expected.Method != "" && !strings.EqualFold(expected.Method, req.Method) {
+		testErrors = append(testErrors, fmt.Sprintf("Expected request method %s but got %s", expected.Method, req.Method))
+	}
+	if expected.Url != "" {
+		expectedUrl, err := templateReplace(expected.Url, extractedFields)
+		if err != nil {
+			testErrors = append(testErrors, err.Error())
+		} else {
+			// Relative expected urls are compared to the request's path and query only
+			actualUrl := req.URL.String()
+			if strings.HasPrefix(expectedUrl, "/") {
+				actualUrl = req.URL.RequestURI()
+			}
+			if actualUrl != expectedUrl {
+				testErrors = append(testErrors, fmt.Sprintf("Expected request url %s but got %s", expectedUrl, actualUrl))
+			}
+		}
+	}
+	for _, headerName := range slices.Sorted(maps.Keys(expected.Headers)) {
+		expectedValTemplate := expected.Headers[headerName]
+		expectedVal, err := templateReplace(expectedValTemplate, extractedFields)
+		if err != nil {
+			testErrors = append(testErrors, err.Error())
+			continue
+		}
+		actualVals, ok := req.Header[http.CanonicalHeaderKey(headerName)]
+		if !ok {
+			testErrors = append(testErrors, fmt.Sprintf("Expected request header '%s: %s' not present", headerName, expectedVal))
+		} else if actualVal := strings.Join(actualVals, ","); actualVal != expectedVal {
+			testErrors = append(testErrors, fmt.Sprintf("Expected request header '%s: %s' but got '%s: %s'", headerName, expectedVal, headerName, actualVal))
+		}
+	}
+	if expected.Body != nil {
+		bodyBytes, err := io.ReadAll(req.Body)
+		if err != nil {
+			testErrors = append(testErrors, fmt.Sprintf("Error reading request body: %v", err))
+		} else {
+			testErrors = append(testErrors, compareRequestBody(bodyBytes, expected.Body, extractedFields)...)
+		}
+	}
+	if len(testErrors) > 0 {
+		return Failed(test.Name, testErrors, time.Since(start))
+	}
+	return Passed(test.Name, time.Since(start))
+}
+
+// Compares a request body to the expected request body (a string or JSON value, possibly containing template variables)
+func compareRequestBody(body []byte, expectedBody interface{}, extractedFields map[string]interface{}) []string {
+	if expectedString, ok := expectedBody.(string); ok {
+		processedExpectedBody, err := templateReplace(expectedString, extractedFields)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		if string(body) != processedExpectedBody {
+			return []string{fmt.Sprintf("Expected request body %s but got %s", processedExpectedBody, string(body))}
+		}
+		return nil
+	}
+	expectedBytes, err := json.Marshal(expectedBody)
+	if err != nil {
+		return []string{fmt.Sprintf("Invalid expected request body: %v", err)}
+	}
+	processedExpectedBody, err := templateReplace(string(expectedBytes), extractedFields)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var expected interface{}
+	err = json.Unmarshal([]byte(processedExpectedBody), &expected)
+	if err != nil {
+		return []string{fmt.Sprintf("Invalid expected request body: %v", err)}
+	}
+	var actual interface{}
+	err = json.Unmarshal(body, &actual)
+	if err != nil {
+		return []string{fmt.Sprintf("Expected JSON request body %s but got %s", processedExpectedBody, string(body))}
+	}
+	diffs := make([]string, 0)
+	for _, diff := range diffValues(actual, expected, "") {
+		diffs = append(diffs, "Request body "+diff.String())
+	}
+	return diffs
+}
+
+// Makes 'req' using the suite's HttpClient, attaching an auth token if token auth is configured
+// and signing the request if a signing scheme is configured. If idempotency keys or generated request
+// ids are configured, the key last sent (and the request id) are left in req's headers.
+func (suite TestSuite) doRequest(req *http.Request) (*http.Response, error) {
+	err := suite.checkSafeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if suite.config.budget != nil {
+		err = suite.config.budget.spend(req.Method)
+		if err != nil {
+			return nil, err
+		}
+	}
+	client := suite.config.HttpClient
+	if suite.config.chaosProxy != nil {
+		client = suite.config.chaosProxy.client(client)
+	}
+	return suite.decoratedDo(client, req)
+}
+
+// Placeholder auth token set on requests in dry-run mode, where no token is fetched
+const dryRunAuthToken = "dry-run-token"
+
+// Makes 'req' using 'client' after adding the headers configured for the suite's requests: a generated
+// request id, an idempotency key, auth plugin headers, the auth token and the signature. In dry-run mode
+// the token isn't fetched and dryRunAuthToken is set instead.
+func (suite TestSuite) decoratedDo(client HttpClient, req *http.Request) (*http.Response, error) {
+	if requestIdConfig := suite.requestIdConfig(); requestIdConfig.Generate && req.Header.Get(requestIdConfig.headerName()) == "" {
+		req.Header.Set(requestIdConfig.headerName(), newUUID())
+	}
+	if suite.config.Signing != nil {
+		client = signingHttpClient{client, *suite.config.Signing}
+	}
+	// Auth plugins set their headers before the request is signed
+	if len(suite.config.plugins) > 0 {
+		client = pluginAuthHttpClient{client, suite.config.plugins}
+	}
+	if idempotencyConfig := suite.config.IdempotencyKey; idempotencyConfig != nil && idempotencyConfig.appliesTo(req.Method) {
+		if idempotencyConfig.PerRetry {
+			var lastKey string
+			client = idempotencyHttpClient{client, *idempotencyConfig, &lastKey}
+			defer func() {
+				req.Header.Set(idempotencyConfig.headerName(), lastKey)
+			}()
+		} else {
+			req.Header.Set(idempotencyConfig.headerName(), newUUID())
+		}
+	}
+	if suite.config.tokenCache != nil {
+		if suite.config.DryRun {
+			suite.config.tokenCache.apply(req, dryRunAuthToken)
+			return client.Do(req)
+		}
+		return suite.config.tokenCache.do(client, suite.config.HttpClient, suite.config.budget, req)
+	}
+	return client.Do(req)
+}
+
+// HttpClient that doesn't send requests, used to add the headers a request would be sent with in dry-run mode
+type dryRunHttpClient struct{}
+
+func (dryRunHttpClient) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+}
+
+// Extracts a value from 'body'. 'regex' is the compiled extraction.Regex, if already compiled.
+func (extraction Extraction) extract(body []byte, regex *regexp.Regexp) (string, error) {
+	switch {
+	case extraction.Regex != "":
+		if regex == nil {
+			var err error
+			regex, err = regexp.Compile(extraction.Regex)
+			if err != nil {
+				return "", errors.Wrap(err, "invalid regex")
+			}
+		}
+		match := regex.FindSubmatch(body)
+		if match == nil {
+			return "", fmt.Errorf("regex '%s' did not match response", extraction.Regex)
+		}
+		group := 0
+		if len(match) > 1 {
+			group = 1
+		}
+		if extraction.Group != nil {
+			group = *extraction.Group
+		}
+		if group < 0 || group >= len(match) {
+			return "", fmt.Errorf("regex '%s' has no group %d", extraction.Regex, group)
+		}
+		return string(match[group]), nil
+	case extraction.XPath != "":
+		doc, err := parseXml(body, true)
+		if err != nil {
+			return "", errors.Wrap(err, "unable to parse response as XML/HTML")
+		}
+		val, found, err := xpathString(doc, extraction.XPath)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return "", fmt.Errorf("xpath '%s' did not match response", extraction.XPath)
+		}
+		return strings.TrimSpace(val), nil
+	default:
+		return "", fmt.Errorf("either regex or xpath must be specified")
+	}
+}
+
+// Decodes a protobuf response of type 'messageType' (or the type named in its content type) into its JSON form
+func (suite TestSuite) decodeProtobuf(messageType string, contentType string, body []byte) (interface{}, error) {
+	if suite.config.protoRegistry == nil {
+		return nil, fmt.Errorf("no protoDescriptorSet configured")
+	}
+	if messageType == "" {
+		messageType = protobufMessageType(contentType)
+	}
+	if messageType == "" {
+		return nil, fmt.Errorf("unknown message type, specify expectedResponse.protoMessage")
+	}
+	return suite.config.protoRegistry.decode(messageType, body)
+}
+
+// Returns the OpenAPI response example named 'name'
+func (suite TestSuite) specExample(name string) (openApiExample, error) {
+	if suite.config.openApiExamples == nil {
+		return openApiExample{}, fmt.Errorf("no openApiSpec configured")
+	}
+	example, ok := suite.config.openApiExamples[name]
+	if !ok {
+		return openApiExample{}, fmt.Errorf("no example '%s' in openApiSpec", name)
+	}
+	return example, nil
+}
+
+// Loads an expected response payload from 'bodyFile' (relative to the suite file). XML files are returned as a string, all other files are parsed as JSON.
+func (suite TestSuite) loadBodyFile(bodyFile string) (interface{}, error) {
+	path := relativeToTestFile(suite.config.FS, suite.fileName, bodyFile)
+	contents, err := readFile(suite.config.FS, path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading body file %s", bodyFile))
+	}
+	if strings.EqualFold(filepath.Ext(path), ".xml") {
+		return string(contents), nil
+	}
+	var body interface{}
+	err = json.Unmarshal(contents, &body)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error parsing body file %s", bodyFile))
+	}
+	return body, nil
+}
+
+// Evaluates each assert expression against the response, returning an error for each one that fails
+func evalAssertions(assertions []string, resp *http.Response, body []byte, extractedFields map[string]interface{}) []string {
+	env := exprEnv{
+		roots: map[string]interface{}{
+			"response": responseObject(resp, body),
+		},
+		vars: extractedFields,
+	}
+
+	assertErrors := make([]string, 0)
+	for _, assertion := range assertions {
+		result, err := evalExpr(assertion, env)
+		if err != nil {
+			assertErrors = append(assertErrors, fmt.Sprintf("Invalid assertion '%s': %v", assertion, err))
+		} else if !truthy(result) {
+			assertErrors = append(assertErrors, fmt.Sprintf("Assertion failed: %s", assertion))
+		}
+	}
+	return assertErrors
+}
+
+// Describes a response that isn't JSON though a JSON payload is expected, e.g. "Expected JSON but got
+// text/html (first 200 chars: <html><head>...)", with whitespace collapsed to keep HTML pages readable
+func nonJsonResponseDescription(contentType string, body []byte) string {
+	mediaType := "a non-JSON response"
+	if contentType != "" {
+		mediaType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	}
+	if len(body) == 0 {
+		return fmt.Sprintf("Expected JSON but got %s with an empty body", mediaType)
+	}
+	return fmt.Sprintf("Expected JSON but got %s (first 200 chars: %s)", mediaType, truncate(strings.Join(strings.Fields(string(body)), " "), 200))
+}
+
+// Returns the status, parsed body (or the raw body if it isn't JSON) and headers of a response
+func responseObject(resp *http.Response, body []byte) map[string]interface{} {
+	method := ""
+	if resp.Request != nil {
+		method = resp.Request.Method
+	}
+	var parsedBody interface{}
+	err := json.Unmarshal(body, &parsedBody)
+	if err != nil && (len(body) != 0 || hasPayload(method, resp.StatusCode)) {
+		parsedBody = string(body)
+	}
+	headers := make(map[string]interface{})
+	for headerName, headerValues := range resp.Header {
+		headers[headerName] = strings.Join(headerValues, ",")
+	}
+	return map[string]interface{}{
+		"status":  float64(resp.StatusCode),
+		"body":    parsedBody,
+		"headers": headers,
+		"cookies": responseCookies(resp),
+	}
+}
+
+// Returns the template vars for response headers, keyed by the header name as received, canonicalized
+// (e.g. 'X-Request-Id') and lowercased, so that templates resolve regardless of casing. The value of each
+// var is the header's values joined by ','. Individual values are keyed by their index, e.g. 'Set-Cookie.1'.
+func headerFields(header http.Header) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for headerName, headerValues := range header {
+		for _, key := range []string{headerName, http.CanonicalHeaderKey(headerName), strings.ToLower(headerName)} {
+			fields[key] = strings.TrimSpace(strings.Join(headerValues, ","))
+			for i, headerValue := range headerValues {
+				fields[fmt.Sprintf("%s.%d", key, i)] = strings.TrimSpace(headerValue)
+			}
+		}
+	}
+	return fields
+}
+
+// Returns whether a response to a 'method' request with 'statusCode' can have a payload. Responses to HEAD
+// requests and 1xx (informational), 204 (No Content) and 304 (Not Modified) responses can't.
+func hasPayload(method string, statusCode int) bool {
+	return method != http.MethodHead && statusCode >= 200 && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
+
+func noPayloadDescription(method string, statusCode int) string {
+	if method == http.MethodHead {
+		return "HEAD request"
+	}
+	return fmt.Sprintf("http %d response", statusCode)
+}
+
+// Compares a Content-Type header value to the expected content type. Media types are compared case-insensitively
+// and only the parameters present in 'expected' (e.g. charset) must match. Returns a description of the mismatch, "" otherwise.
+func matchContentType(actual string, expected string) string {
+	if actual == "" {
+		return fmt.Sprintf("Expected content type '%s' but response has no Content-Type header", expected)
+	}
+	expectedMediaType, expectedParams, err := mime.ParseMediaType(expected)
+	if err != nil {
+		return fmt.Sprintf("Invalid expected content type '%s': %v", expected, err)
+	}
+	actualMediaType, actualParams, err := mime.ParseMediaType(actual)
+	if err != nil {
+		return fmt.Sprintf("Expected content type '%s' but got invalid content type '%s'", expected, actual)
+	}
+	if actualMediaType != expectedMediaType {
+		return fmt.Sprintf("Expected content type '%s' but got '%s'", expected, actual)
+	}
+	for param, expectedVal := range expectedParams {
+		if actualVal, ok := actualParams[param]; !ok || !strings.EqualFold(actualVal, expectedVal) {
+			return fmt.Sprintf("Expected content type '%s' but got '%s'", expected, actual)
+		}
+	}
+	return ""
+}
+
+// Returns the number of redirects followed to get 'resp' for 'req' and the final url requested
+func redirectChain(req *http.Request, resp *http.Response) (int, string) {
+	finalReq := resp.Request
+	if finalReq == nil {
+		return 0, req.URL.String()
+	}
+	redirects := 0
+	for r := finalReq; r.Response != nil && r.Response.Request != nil; r = r.Response.Request {
+		redirects++
+	}
+	return redirects, finalReq.URL.String()
+}
+
 func isMap(v interface{}) bool {
 	_, ok := v.(map[string]interface{})
 	return ok
@@ -421,16 +1795,30 @@ func isSlice(v interface{}) bool {
 	return ok
 }
 
-func (suite TestSuite) compareObjects(obj map[string]interface{}, expectedObj map[string]interface{}, extractedFields map[string]interface{}, objPrefix string) ([]string, error) {
+// Returns true if the response body of 'test' must exactly match the expected body, false if the expected body only needs to be a subset of it
+func (suite TestSuite) strictBody(test TestSpec) bool {
+	if test.StrictBody != nil {
+		return *test.StrictBody
+	}
+	if suite.spec.StrictBody != nil {
+		return *suite.spec.StrictBody
+	}
+	return true
+}
+
+// Compares 'obj' to 'expectedObj'. If 'strict' is false, fields in 'obj' that aren't in 'expectedObj' are ignored.
+func (suite TestSuite) compareObjects(obj map[string]interface{}, expectedObj map[string]interface{}, extractedFields map[string]interface{}, objPrefix string, strict bool) ([]Difference, error) {
 	// Track all new field values from response obj
 	flattenedObj := flatten(obj, objPrefix, 0)
 	for k, v := range flattenedObj {
 		extractedFields[k] = v
 	}
 
-	diffs := make([]string, 0)
+	// Evaluate matchers in expectedObj, excluding matched fields from the deep comparison below
+	matchedObj, matchedExpectedObj, diffs := applyMatchers(obj, expectedObj, "", compareItems(extractedFields, suite.ignoredFields, suite.spec.StringComparison))
+
 	// Replace any template strings in expectedObj with values from extracted fields
-	expectedObjBytes, err := json.Marshal(expectedObj)
+	expectedObjBytes, err := json.Marshal(matchedExpectedObj)
 	if err != nil {
 		return diffs, errors.Wrap(err, "error marshaling expectedObj")
 	}
@@ -445,36 +1833,89 @@ func (suite TestSuite) compareObjects(obj map[string]interface{}, expectedObj ma
 		return diffs, errors.Wrap(err, "error unmarshaling expectedObj")
 	}
 
-	// Deep compare the objects and return any errors,
-	// ignoring any errors that match an ignored field.
-	//
-	// NOTE: This approach is brittle as it assumes the
-	// github.com/go-test/deep package's Equal method
-	// continues to return errors in the expected format.
-	deepLibDiffs := deep.Equal(obj, processedExpectedObj)
-	ignoredFieldsMatchRegExp, err := regexp.Compile(fmt.Sprintf(`\[%s\]$`, strings.Join(suite.spec.IgnoredFields, `\]$|\[`)))
-	if err != nil {
-		return diffs, errors.Wrap(err, "invalid ignored fields regexp")
+	// Deep compare the objects (without their ignored fields) and return any errors
+	actualObj := matchedObj
+	if !strict {
+		actualObj = subset(matchedObj, processedExpectedObj)
 	}
+	actualObj = removeIgnoredFields(actualObj, suite.ignoredFields, nil)
+	expected := removeIgnoredFields(processedExpectedObj, suite.ignoredFields, nil)
+	diffs = append(diffs, diffValues(suite.spec.StringComparison.normalizeAll(actualObj), suite.spec.StringComparison.normalizeAll(expected), "")...)
+	// Interleave matcher differences (found in map order) with the others
+	sortDifferences(diffs)
+	return diffs, nil
+}
 
-	for _, diff := range deepLibDiffs {
-		field, _, found := strings.Cut(diff, ": ")
-		if !found {
-			return diffs, fmt.Errorf("unexpectedly formatted diff %s returned by deep.Equal", diff)
+// Compares 'obj' to 'expectedObj' like compareObjects, separating the differences in the soft fields of
+// 'test' (returned second) from the others
+func (suite TestSuite) compareObjectsWithSoftFields(obj map[string]interface{}, expectedObj map[string]interface{}, extractedFields map[string]interface{}, objPrefix string, test TestSpec) ([]Difference, []Difference, error) {
+	differences, err := suite.compareObjects(obj, expectedObj, extractedFields, objPrefix, suite.strictBody(test))
+	if err != nil || len(test.ExpectedResponse.SoftFields) == 0 || len(differences) == 0 {
+		return differences, nil, err
+	}
+	softFields, err := parseIgnoredFields(test.ExpectedResponse.SoftFields)
+	if err != nil {
+		return differences, nil, errors.Wrap(err, "invalid softFields")
+	}
+	// Differences that remain with the soft fields ignored are hard differences, the rest are soft
+	hardSuite := suite
+	hardSuite.ignoredFields = append(append([]ignoredField{}, suite.ignoredFields...), softFields...)
+	hardDifferences, err := hardSuite.compareObjects(obj, expectedObj, extractedFields, objPrefix, suite.strictBody(test))
+	if err != nil {
+		return differences, nil, err
+	}
+	hard := make(map[string]int)
+	for _, diff := range hardDifferences {
+		hard[diff.String()]++
+	}
+	softDifferences := make([]Difference, 0)
+	for _, diff := range differences {
+		if hard[diff.String()] > 0 {
+			hard[diff.String()]--
+		} else {
+			softDifferences = append(softDifferences, diff)
 		}
+	}
+	return hardDifferences, softDifferences, nil
+}
 
-		// Only register errors that don't match an ignored field
-		if !ignoredFieldsMatchRegExp.Match([]byte(field)) {
-			diffs = append(diffs, diff)
+// Returns a copy of 'actual' with all object fields that aren't present in 'expected' removed
+func subset(actual interface{}, expected interface{}) interface{} {
+	switch expectedVal := expected.(type) {
+	case map[string]interface{}:
+		actualMap, ok := actual.(map[string]interface{})
+		if !ok {
+			return actual
+		}
+		res := make(map[string]interface{})
+		for k, v := range actualMap {
+			if expectedChild, ok := expectedVal[k]; ok {
+				res[k] = subset(v, expectedChild)
+			}
+		}
+		return res
+	case []interface{}:
+		actualSlice, ok := actual.([]interface{})
+		if !ok || len(actualSlice) != len(expectedVal) {
+			return actual
+		}
+		res := make([]interface{}, len(actualSlice))
+		for i := range actualSlice {
+			res[i] = subset(actualSlice[i], expectedVal[i])
 		}
+		return res
+	default:
+		return actual
 	}
-
-	return diffs, nil
 }
 
 // Replaces all instances of the template format "{{ value }}" in 's' with values from 'extractedFields'. Returns err if a value is not found in extractedFields.
+// Values can be piped through transforms, e.g. "{{ value | lower }}".
 func templateReplace(s string, extractedFields map[string]interface{}) (string, error) {
-	templateVariableRegex := regexp.MustCompile(`{{\s*[^\s]+\s*}}`)
+	s, err := replaceTemplateExpressions(s, extractedFields)
+	if err != nil {
+		return s, err
+	}
 	matches := templateVariableRegex.FindAll([]byte(s), -1)
 
 	// No template matches, return original string
@@ -484,17 +1925,133 @@ func templateReplace(s string, extractedFields map[string]interface{}) (string,
 
 	// Replace each match with extracted value. Err if no value found for any match.
 	for _, varMatch := range matches {
-		// Remove '{{ }}' to get varName
-		varName := strings.Trim(string(varMatch), "{ }")
-		varValue, ok := extractedFields[varName]
+		// Remove '{{ }}' to get varName and any transforms
+		varExpr := strings.Split(strings.Trim(string(varMatch), "{ }"), "|")
+		varName := strings.TrimSpace(varExpr[0])
+		varValue, ok := lookupField(extractedFields, varName)
 		if !ok {
 			return s, fmt.Errorf("missing template value for var: '%s'", varName)
 		}
+		for _, transformName := range varExpr[1:] {
+			transformName = strings.TrimSpace(transformName)
+			if transform, ok := templateTransforms[transformName]; ok {
+				varValue = transform(fmt.Sprint(varValue))
+			} else if p, ok := pluginTransform(transformName); ok {
+				transformed, err := p.transform(transformName, fmt.Sprint(varValue))
+				if err != nil {
+					return s, errors.Wrap(err, fmt.Sprintf("error applying template transform '%s' to var: '%s'", transformName, varName))
+				}
+				varValue = transformed
+			} else {
+				return s, fmt.Errorf("unknown template transform '%s' for var: '%s'", transformName, varName)
+			}
+		}
 		s = strings.Replace(s, string(varMatch), fmt.Sprint(varValue), 1)
 	}
 	return s, nil
 }
 
+var jsonHTMLUnescaper = strings.NewReplacer(`\u003c`, "<", `\u003e`, ">", `\u0026`, "&")
+
+// Replaces all template expressions (e.g. "{{ listUsers.body.count + 1 }}") in 's' with their values. Templates
+// that are plain vars (replaced by templateReplace) or don't parse as expressions (e.g. matchers) are left as is.
+// A quoted expression evaluating to a number or boolean (e.g. a JSON value "{{ a * 100 }}") replaces its quotes too.
+func replaceTemplateExpressions(s string, extractedFields map[string]interface{}) (string, error) {
+	var sb strings.Builder
+	prevEnd := 0
+	for _, match := range templateExpressionRegex.FindAllStringSubmatchIndex(s, -1) {
+		start, end := match[0], match[1]
+		// Operators in templates of marshaled JSON bodies are HTML-escaped
+		source := jsonHTMLUnescaper.Replace(strings.TrimSpace(s[match[2]:match[3]]))
+		// Template vars that look like expressions, e.g. 'test1.header.x-request-id'
+		if _, ok := lookupField(extractedFields, source); ok {
+			continue
+		}
+		node, err := parseExpr(source)
+		if err != nil {
+			continue
+		}
+		switch node.(type) {
+		case pathNode, indexNode:
+			continue
+		}
+		value, err := node.eval(exprEnv{vars: extractedFields})
+		if err != nil {
+			return s, errors.Wrap(err, fmt.Sprintf("error evaluating template expression '%s'", source))
+		}
+		var formatted string
+		switch v := value.(type) {
+		case float64:
+			formatted = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			formatted = fmt.Sprint(v)
+		}
+		switch value.(type) {
+		case float64, bool:
+			if start > 0 && end < len(s) && s[start-1] == '"' && s[end] == '"' && start-1 >= prevEnd {
+				start--
+				end++
+			}
+		}
+		sb.WriteString(s[prevEnd:start])
+		sb.WriteString(formatted)
+		prevEnd = end
+	}
+	if prevEnd == 0 {
+		return s, nil
+	}
+	sb.WriteString(s[prevEnd:])
+	return sb.String(), nil
+}
+
+// Returns the value of the template var 'name'. Vars holding objects or arrays (e.g. 'testName.response')
+// can be indexed into with a dotted path, e.g. 'testName.response.body.items.0.id'.
+func lookupField(extractedFields map[string]interface{}, name string) (interface{}, bool) {
+	if val, ok := extractedFields[name]; ok {
+		return val, true
+	}
+	for i := strings.LastIndex(name, "."); i > 0; i = strings.LastIndex(name[:i], ".") {
+		val, ok := extractedFields[name[:i]]
+		if !ok {
+			continue
+		}
+		for _, segment := range strings.Split(name[i+1:], ".") {
+			switch v := val.(type) {
+			case map[string]interface{}:
+				val, ok = v[segment]
+			case []interface{}:
+				index, err := strconv.Atoi(segment)
+				ok = err == nil && index >= 0 && index < len(v)
+				if ok {
+					val = v[index]
+				}
+			default:
+				ok = false
+			}
+			if !ok {
+				return nil, false
+			}
+		}
+		return val, true
+	}
+	return nil, false
+}
+
+// Transforms that can be applied to template values, e.g. "{{ createUser.email | lower }}"
+var templateTransforms = map[string]func(string) string{
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"trim":      strings.TrimSpace,
+	"urlencode": url.QueryEscape,
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"sha256": func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	},
+}
+
 func flatten(m interface{}, prefix string, level int) map[string]interface{} {
 	res := make(map[string]interface{})
 	switch obj := m.(type) {
@@ -560,3 +2117,12 @@ func flatten(m interface{}, prefix string, level int) map[string]interface{} {
 
 	return res
 }
+
+// Returns the first 'n' characters of 's', followed by "..." if it's longer
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
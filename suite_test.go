@@ -15,10 +15,32 @@
 package apirunner
 
 import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
+	"unicode/utf16"
+
+	"github.com/warrant-dev/apirunner/apirunnertest"
 )
 
 type MockHttpClient struct {
@@ -99,6 +121,29 @@ func TestIgnoredFields(t *testing.T) {
 	}
 }
 
+func TestIgnoredFieldPaths(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "{\"id\": \"order_1\", \"user\": {\"id\": \"user_1\", \"name\": \"name1\"}, \"items\": [{\"id\": \"item_1\", \"updatedAt\": \"2023-04-05T12:38:54.038Z\"}, {\"id\": \"item_2\", \"updatedAt\": \"2023-04-05T12:38:54.036Z\"}]}"
+	results, _ := ExecuteSuite(RunConfig{
+		HttpClient: &mockClient,
+	}, "ignoredfieldpaths.json", true)
+
+	if len(results.Passed) != 1 {
+		t.Errorf("Expected ignored field paths to be excluded from comparison: %v\n", results.Failed)
+	}
+
+	// Paths only match from the root, so the top-level id is still compared
+	mockClient.Body = "{\"id\": \"order_2\", \"user\": {\"name\": \"name1\"}, \"items\": [{\"id\": \"item_1\"}, {\"id\": \"item_2\"}]}"
+	results, _ = ExecuteSuite(RunConfig{
+		HttpClient: &mockClient,
+	}, "ignoredfieldpaths.json", true)
+
+	if len(results.Failed) != 1 || !strings.Contains(results.Failed[0].Result(), "map[id]") {
+		t.Errorf("Expected top-level id to be compared")
+	}
+}
+
 func TestTemplateVars(t *testing.T) {
 	mockClient := MockHttpClient{}
 	mockClient.StatusCode = 200
@@ -148,3 +193,4340 @@ func TestParsesBodyCorrectly(t *testing.T) {
 		}
 	}
 }
+
+type TokenAuthHttpClient struct {
+	TokenRequests int
+}
+
+func (c *TokenAuthHttpClient) Do(req *http.Request) (*http.Response, error) {
+	if req.URL.Path == "/token" {
+		c.TokenRequests++
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(strings.NewReader(fmt.Sprintf("{\"access_token\": \"token%d\"}", c.TokenRequests))),
+		}, nil
+	}
+	// Reject the first token issued
+	if req.Header.Get("Authorization") != "Bearer token2" {
+		return &http.Response{
+			StatusCode: 401,
+			Body:       io.NopCloser(strings.NewReader("")),
+		}, nil
+	}
+	return &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader("{ \"id\": 1, \"name\": \"name\" }")),
+	}, nil
+}
+
+func TestTokenAuthRefresh(t *testing.T) {
+	mockClient := TokenAuthHttpClient{}
+	config := RunConfig{
+		BaseUrl:    "",
+		Auth:       &TokenAuthConfig{TokenUrl: "/token"},
+		HttpClient: &mockClient,
+	}
+	config.tokenCache = newTokenCache(*config.Auth)
+	for i := 0; i < 2; i++ {
+		results, _ := ExecuteSuite(config, "basicresponse.json", true)
+		if len(results.Failed) > 0 {
+			for _, test := range results.Failed {
+				t.Errorf("Failed test result: [%s]\n", test.Result())
+			}
+		}
+	}
+	if mockClient.TokenRequests != 2 {
+		t.Errorf("Expected 2 token requests across suites but got %d", mockClient.TokenRequests)
+	}
+}
+
+func TestOmitDefaultHeaders(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "omitheaders.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}},
+		{"name": "getUserAnonymously", "request": {"method": "GET", "url": "/users/1"}, "omitDefaultHeaders": ["authorization", "X-Api-Key"]}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = `{"access_token": "token"}`
+	config := RunConfig{
+		BaseUrl:       "",
+		CustomHeaders: map[string]string{"X-Api-Key": "key", "X-Tenant": "acme"},
+		Auth:          &TokenAuthConfig{TokenUrl: "/token"},
+		HttpClient:    &mockClient,
+	}
+	config.tokenCache = newTokenCache(*config.Auth)
+	results, err := ExecuteSuite(config, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Passed) != 2 || len(mockClient.Requests) != 3 {
+		t.Fatalf("Expected a token request and 2 requests to pass but got %d passed, %d failed", len(results.Passed), len(results.Failed))
+	}
+
+	authenticated, anonymous := mockClient.Requests[1], mockClient.Requests[2]
+	if authenticated.Header.Get("Authorization") != "Bearer token" || authenticated.Header.Get("X-Api-Key") != "key" {
+		t.Errorf("Expected default headers to be sent but got %v", authenticated.Header)
+	}
+	if anonymous.Header.Get("Authorization") != "" || anonymous.Header.Get("X-Api-Key") != "" {
+		t.Errorf("Expected omitted default headers not to be sent but got %v", anonymous.Header)
+	}
+	if anonymous.Header.Get("X-Tenant") != "acme" {
+		t.Errorf("Expected other default headers to be sent but got %v", anonymous.Header)
+	}
+}
+
+func TestClientProfiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"via": "direct"}`))
+	}))
+	defer server.Close()
+	// Plain http proxy standing in for a differently configured route to the same server
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != strings.TrimPrefix(server.URL, "http://") {
+			t.Errorf("Expected proxied request to %s but got %s", server.URL, r.URL)
+		}
+		w.Write([]byte(`{"via": "proxy"}`))
+	}))
+	defer proxy.Close()
+
+	testFile := filepath.Join(t.TempDir(), "profiles.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "direct", "request": {"method": "GET", "url": "/users"}, "expectedResponse": {"body": {"via": "direct"}}},
+		{"name": "proxied", "request": {"method": "GET", "url": "/users"}, "clientProfile": "internal", "expectedResponse": {"body": {"via": "proxy"}}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := RunConfig{
+		BaseUrl:        server.URL,
+		ClientProfiles: map[string]TransportConfig{"internal": {Proxy: proxy.URL}},
+	}
+	results, err := ExecuteSuite(config, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Failed) > 0 {
+		for _, test := range results.Failed {
+			t.Errorf("Failed test result: [%s]\n", test.Result())
+		}
+	}
+
+	config.ClientProfiles = nil
+	_, err = ExecuteSuite(config, testFile, true)
+	if err == nil || !strings.Contains(err.Error(), "unknown client profile 'internal'") {
+		t.Errorf("Expected unknown client profile error but got %v", err)
+	}
+}
+
+func TestFactories(t *testing.T) {
+	created := make([]map[string]interface{}, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Write([]byte(fmt.Sprintf(`{"path": "%s"}`, r.URL.Path)))
+			return
+		}
+		var fixture map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&fixture)
+		created = append(created, fixture)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"id": fmt.Sprintf("%s_%d", strings.TrimPrefix(r.URL.Path, "/"), len(created))}})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	specFile := filepath.Join(dir, "openapi.json")
+	err := os.WriteFile(specFile, []byte(`{"paths": {"/orgs": {"post": {"operationId": "createOrg",
+		"requestBody": {"content": {"application/json": {"example": {"name": "Acme", "plan": "free"}}}}}}}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testFile := filepath.Join(dir, "factories.json")
+	err = os.WriteFile(testFile, []byte(`{
+		"setup": [{"name": "org", "factory": "org", "overrides": {"plan": "pro"}}],
+		"tests": [
+			{"name": "getUser", "request": {"method": "GET", "url": "/users/{{ factory.user({\"orgId\": \"{{ org.id }}\", \"profile\": {\"role\": \"admin\"}}) }}"}, "expectedResponse": {"body": {"path": "/users/users_2"}}},
+			{"name": "createUserWithOwner", "request": {"method": "POST", "url": "/users", "body": {"owner": "{{ factory.user() }}"}}, "expectedResponse": {"statusCode": 201, "body": {"data": {"id": "users_4"}}}}
+		]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:     server.URL,
+		OpenApiSpec: specFile,
+		Factories: map[string]Factory{
+			"org":  {OperationId: "createOrg", IdField: "data.id"},
+			"user": {Request: Request{Method: "POST", Url: "/users", Body: map[string]interface{}{"email": "jane@example.com", "profile": map[string]interface{}{"role": "member", "title": "CTO"}}}, IdField: "data.id"},
+		},
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Failed) > 0 {
+		for _, test := range results.Failed {
+			t.Errorf("Failed test result: [%s]\n", test.Result())
+		}
+	}
+
+	if len(created) != 4 {
+		t.Fatalf("Expected 4 created fixtures but got %v", created)
+	}
+	if created[0]["name"] != "Acme" || created[0]["plan"] != "pro" {
+		t.Errorf("Expected org from the OpenAPI example with overrides but got %v", created[0])
+	}
+	if created[1]["orgId"] != "orgs_1" || created[1]["email"] != "jane@example.com" || !reflect.DeepEqual(created[1]["profile"], map[string]interface{}{"role": "admin", "title": "CTO"}) {
+		t.Errorf("Expected user with merged overrides but got %v", created[1])
+	}
+	if created[3]["owner"] != "users_3" {
+		t.Errorf("Expected fixture id in request body but got %v", created[3])
+	}
+}
+
+func TestFactoriesCreatedOnSend(t *testing.T) {
+	var mutex sync.Mutex
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requested = append(requested, r.Method+" "+r.URL.Path)
+		mutex.Unlock()
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"id": "org_1"}`)
+		}
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"factories.json": `{"tests": [
+			{"name": "getOrg", "request": {"method": "GET", "url": "/orgs/{{ factory.org() }}", "headers": {"X-Org": "{{ factory.org() }}"}}},
+			{"name": "deleteOrg", "request": {"method": "DELETE", "url": "/protected/orgs/{{ factory.org() }}"}}
+		]}`,
+	})
+	config := RunConfig{
+		BaseUrl:       server.URL,
+		HttpClient:    http.DefaultClient,
+		Factories:     map[string]Factory{"org": {Request: Request{Method: "POST", Url: "/orgs"}}},
+		ProtectedUrls: []string{"/protected/"},
+		CacheFile:     filepath.Join(dir, "cache.json"),
+	}
+	requestsOf := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		res := requested
+		requested = make([]string, 0)
+		return res
+	}
+
+	// Only the fixtures of requests that are sent are created
+	results, err := ExecuteSuite(config, filepath.Join(dir, "factories.json"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Passed) != 1 || len(results.Failed) != 1 || !strings.Contains(results.Failed[0].Result(), "refusing to send DELETE") {
+		t.Errorf("Expected getOrg to pass and deleteOrg to be refused: %v", results)
+	}
+	if requests := requestsOf(); !slices.Equal(requests, []string{"POST /orgs", "POST /orgs", "GET /orgs/org_1"}) {
+		t.Errorf("Expected fixtures to be created only for the request that's sent, got requests %v", requests)
+	}
+
+	// No fixtures are created for tests skipped because they passed before
+	results, err = ExecuteSuite(config, filepath.Join(dir, "factories.json"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Skipped) != 1 || results.Skipped[0].Name != "getOrg" {
+		t.Errorf("Expected getOrg to be skipped as unchanged: %v", results)
+	}
+	if requests := requestsOf(); len(requests) != 0 {
+		t.Errorf("Expected no requests for skipped and refused tests, got %v", requests)
+	}
+
+	// Or in dry-run mode, where the request has placeholders for the fixtures' ids
+	config.DryRun = true
+	results, err = ExecuteSuite(config, filepath.Join(dir, "factories.json"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Skipped) != 2 || results.Skipped[0].RequestUrl != server.URL+"/orgs/factory-org-0" || results.Skipped[0].RequestHeaders.Get("X-Org") != "factory-org-1" {
+		t.Errorf("Expected dry run to preview requests with placeholders for the fixtures: %v", results)
+	}
+	if requests := requestsOf(); len(requests) != 0 {
+		t.Errorf("Expected no requests in dry-run mode, got %v", requests)
+	}
+}
+
+func TestGC(t *testing.T) {
+	users := map[string]string{"1": "apirunner-jane", "2": "john", "3": "apirunner-joe"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/users":
+			data := make([]map[string]string, 0)
+			for id, name := range users {
+				data = append(data, map[string]string{"id": id, "name": name})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		case r.Method == http.MethodDelete && r.URL.Path == "/users/3":
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.Method == http.MethodDelete:
+			delete(users, strings.TrimPrefix(r.URL.Path, "/users/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "apirunner.conf")
+	err := os.WriteFile(configFile, []byte(fmt.Sprintf(`{"baseUrl": "%s", "headers": {"Authorization": "Bearer token"}}`, server.URL)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcFile := filepath.Join(dir, "gc.json")
+	err = os.WriteFile(gcFile, []byte(`{"prefix": "apirunner-", "resources": [
+		{"name": "users", "list": {"url": "/users"}, "items": "data", "delete": {"url": "/users/{{ item.id }}"}},
+		{"name": "orgs", "list": {"url": "/orgs"}, "delete": {"url": "/orgs/{{ item.id }}"}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := GC(configFile, gcFile, GCOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(result.Deleted)
+	if !reflect.DeepEqual(result.Deleted, []string{"users apirunner-jane", "users apirunner-joe"}) || len(users) != 3 {
+		t.Errorf("Expected dry run to list prefixed resources without deleting them but got %v", result.Deleted)
+	}
+
+	result, err = GC(configFile, gcFile, GCOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Deleted, []string{"users apirunner-jane"}) || !reflect.DeepEqual(users, map[string]string{"2": "john", "3": "apirunner-joe"}) {
+		t.Errorf("Expected only prefixed resources to be deleted but got %v", result.Deleted)
+	}
+	if len(result.Errors) != 2 || !strings.Contains(strings.Join(result.Errors, "\n"), "users apirunner-joe: error deleting resource, got http 500") || !strings.Contains(strings.Join(result.Errors, "\n"), "orgs: error listing resources, got http 404") {
+		t.Errorf("Expected errors for failed deletes and lists but got %v", result.Errors)
+	}
+
+	err = os.WriteFile(gcFile, []byte(`{"resources": [{"name": "users", "list": {"url": "/users"}, "delete": {"url": "/users/{{ item.id }}"}}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = GC(configFile, gcFile, GCOptions{})
+	if err == nil || !strings.Contains(err.Error(), "has no prefix") {
+		t.Errorf("Expected resources without a prefix to be invalid but got %v", err)
+	}
+}
+
+func TestScriptedMockClient(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "scripted.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "timeout", "request": {"method": "GET", "url": "/users/1"}},
+		{"name": "unavailable", "request": {"method": "GET", "url": "/users/1"}},
+		{"name": "getUser", "request": {"method": "POST", "url": "/users", "body": {"name": "Jane"}}, "expectedResponse": {"statusCode": 201, "body": {"id": 1}}},
+		{"name": "exhausted", "request": {"method": "GET", "url": "/users/1"}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := apirunnertest.NewClient(
+		apirunnertest.Error(apirunnertest.ErrTimeout),
+		apirunnertest.Response{StatusCode: 503, Delay: 10 * time.Millisecond},
+	).Then(apirunnertest.JSON(201, `{"id": 1}`))
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: mockClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 1 || results.Passed[0].Name != "getUser" {
+		t.Errorf("Expected only the test with a scripted success to pass")
+	}
+	categories := make(map[string]FailureCategory)
+	for _, test := range results.Failed {
+		categories[test.Name] = test.Category
+	}
+	expected := map[string]FailureCategory{"timeout": FailureTimeout, "unavailable": FailureStatusMismatch, "exhausted": FailureTransportError}
+	if !reflect.DeepEqual(categories, expected) {
+		t.Errorf("Expected failure categories %v but got %v", expected, categories)
+	}
+	if results.Failed[1].Duration < 10*time.Millisecond {
+		t.Errorf("Expected scripted delay but request took %s", results.Failed[1].Duration)
+	}
+	if bodies := mockClient.RequestBodies(); len(bodies) != 4 || bodies[2] != `{"name":"Jane"}` || mockClient.Remaining() != 0 {
+		t.Errorf("Expected all requests to be recorded but got %v", bodies)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "users.json")
+	err := os.WriteFile(testFile, []byte(`{
+		"tests": [
+			{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}},
+			{"name": "deleteUser", "request": {"method": "DELETE", "url": "/users/1"}, "expectedResponse": {"statusCode": 204}},
+			{"name": "createUser", "request": {"method": "POST", "url": "/users", "body": {"name": "Jane"}}}
+		]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upToDateFile := filepath.Join(dir, "orgs.json")
+	err = os.WriteFile(upToDateFile, []byte(`{"version": 2, "tests": [{"name": "getOrg", "request": {"method": "GET", "url": "/orgs/1"}}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Version 1 files send an empty JSON object for requests without a body, later versions don't
+	mockClient := apirunnertest.Static(apirunnertest.Response{StatusCode: 200})
+	ExecuteSuite(RunConfig{BaseUrl: "", HttpClient: mockClient}, testFile, true)
+	ExecuteSuite(RunConfig{BaseUrl: "", HttpClient: mockClient}, upToDateFile, true)
+	if bodies := mockClient.RequestBodies(); !reflect.DeepEqual(bodies, []string{"{}", "{}", `{"name":"Jane"}`, ""}) {
+		t.Errorf("Expected request bodies to depend on the file version but got %q", bodies)
+	}
+
+	results, err := Migrate(dir, regexp.MustCompile(".*"), MigrateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].TestFilename != testFile || results[0].FromVersion != 1 || results[0].ToVersion != 2 {
+		t.Fatalf("Expected only the version 1 file to be migrated but got %v", results)
+	}
+	if len(results[0].Notes) != 1 || !strings.Contains(results[0].Notes[0], "GET request of test 'getUser'") {
+		t.Errorf("Expected a note on the changed GET request but got %v", results[0].Notes)
+	}
+	contents, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(contents), "{\n    \"version\": 2,\n    \"tests\"") {
+		t.Errorf("Expected the version to be added first but got %s", contents)
+	}
+
+	mockClient = apirunnertest.Static(apirunnertest.Response{StatusCode: 200})
+	ExecuteSuite(RunConfig{BaseUrl: "", HttpClient: mockClient}, testFile, true)
+	if bodies := mockClient.RequestBodies(); !reflect.DeepEqual(bodies, []string{"", "{}", `{"name":"Jane"}`}) {
+		t.Errorf("Expected migrated file to preserve non-GET request bodies but got %q", bodies)
+	}
+
+	err = os.WriteFile(upToDateFile, []byte(`{"version": 3, "tests": []}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ExecuteSuite(RunConfig{BaseUrl: ""}, upToDateFile, true)
+	if err == nil || !strings.Contains(err.Error(), "unsupported version 3") {
+		t.Errorf("Expected newer versions to be unsupported but got %v", err)
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "unknown.json")
+	cases := map[string]string{
+		`{"tests": [{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}}, {"name": "listUsers", "request": {"method": "GET", "url": "/users"}, "expectedReponse": {"statusCode": 200}}]}`: `unknown field "expectedReponse" in test 'listUsers' (tests[1])`,
+		`{"tests": [{"name": "getUser", "request": {"method": "GET", "url": "/users/1", "header": {"X-Tenant": "acme"}}}]}`:                                                                               `unknown field "header" in test 'getUser' (tests[0])`,
+		`{"setup": [{"name": "seed", "command": "true", "arg": ["--all"]}], "tests": []}`:                                                                                                                 `unknown field "arg" in setup step 1`,
+		`{"test": [{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}}]}`:                                                                                                                `unknown field "test" at the top level`,
+	}
+	for contents, expected := range cases {
+		err := os.WriteFile(testFile, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = loadSuiteSpec(nil, testFile)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error '%s' but got %v", expected, err)
+		}
+	}
+
+	err := os.WriteFile(testFile, []byte(`{"allowUnknownFields": true, "tests": [{"name": "getUser", "description": "Gets a user", "request": {"method": "GET", "url": "/users/1"}}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadSuiteSpec(nil, testFile)
+	if err != nil {
+		t.Errorf("Expected unknown fields to be allowed but got %v", err)
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "duplicates.json")
+	cases := map[string]string{
+		`{"tests": [{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": {"id": "1", "name": "Ann", "id": "2"}}}]}`:  `duplicate key 'tests[0].expectedResponse.body.id'`,
+		`{"tests": [{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": [{"id": "1"}, {"a": {"b": 1, "b": 2}}]}}]}`: `duplicate key 'tests[0].expectedResponse.body[1].a.b'`,
+		`{"tests": [{"name": "getUser", "name": "listUsers", "request": {"method": "GET", "url": "/users"}}]}`:                                                                       `duplicate key 'tests[0].name'`,
+	}
+	for contents, expected := range cases {
+		err := os.WriteFile(testFile, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = loadSuiteSpec(nil, testFile)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error '%s' but got %v", expected, err)
+		}
+	}
+
+	err := os.WriteFile(testFile, []byte(`{"tests": [{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": [{"id": "1"}, {"id": "2"}]}}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadSuiteSpec(nil, testFile)
+	if err != nil {
+		t.Errorf("Expected repeated keys in separate objects to be allowed but got %v", err)
+	}
+}
+
+func TestRequestSigning(t *testing.T) {
+	mockClient := EchoRequestHttpClient{}
+	mockClient.StatusCode = 200
+	config := RunConfig{
+		BaseUrl: "",
+		Signing: &SigningConfig{
+			Header:       "X-Signature",
+			Key:          "secret",
+			StringToSign: "{{ request.method }}\n{{ request.path }}\n{{ request.body }}",
+		},
+		HttpClient: &mockClient,
+	}
+	req, _ := http.NewRequest("POST", "/json", strings.NewReader("{\"hello\":\"world\"}"))
+	resp, err := TestSuite{config: config}.doRequest(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// printf 'POST\n/json\n{"hello":"world"}' | openssl dgst -sha256 -hmac secret
+	expectedSignature := "6fc8d56f3fe6a7493dd04ef4cb9312a0062f2f9bc2697e0fa6c2bd92e83f502b"
+	if resp.Header.Get("X-Signature") != expectedSignature {
+		t.Errorf("Expected signature %s but got %s", expectedSignature, resp.Header.Get("X-Signature"))
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	mockClient := EchoRequestHttpClient{}
+	mockClient.StatusCode = 200
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:        "",
+		IdempotencyKey: &IdempotencyKeyConfig{},
+		HttpClient:     &mockClient,
+	}, "idempotencykey.json", true)
+
+	if len(results.Passed) == 0 {
+		t.Errorf("All tests should have passed.\n")
+	}
+	if len(results.Failed) > 0 {
+		for _, test := range results.Failed {
+			t.Errorf("Failed test result: [%s]\n", test.Result())
+		}
+	}
+}
+
+func TestRequestId(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/server" {
+			w.Header().Set("X-Request-Id", "server-id")
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	testFile := filepath.Join(t.TempDir(), "requestid.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "generated", "request": {"method": "GET", "url": "/generated"}, "expectedResponse": {"statusCode": 200}},
+		{"name": "server", "request": {"method": "GET", "url": "/server"}, "expectedResponse": {"statusCode": 200}},
+		{"name": "explicit", "request": {"method": "GET", "url": "/explicit", "headers": {"X-Request-Id": "test-id"}}, "expectedResponse": {"statusCode": 200}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    server.URL,
+		RequestId:  &RequestIdConfig{Generate: true},
+		HttpClient: http.DefaultClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requestIds := make(map[string]string)
+	for _, result := range results.Failed {
+		requestIds[result.Name] = result.RequestId
+		if !strings.Contains(result.Result(), "Request id: "+result.RequestId) {
+			t.Errorf("Expected request id to be printed with failure of '%s'", result.Name)
+		}
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(requestIds["generated"]) {
+		t.Errorf("Expected a generated request id but got '%s'", requestIds["generated"])
+	}
+	if requestIds["server"] != "server-id" {
+		t.Errorf("Expected request id returned by server but got '%s'", requestIds["server"])
+	}
+	if requestIds["explicit"] != "test-id" {
+		t.Errorf("Expected request id set by test but got '%s'", requestIds["explicit"])
+	}
+}
+
+type RedirectHttpClient struct {
+	Redirects int
+}
+
+func (c *RedirectHttpClient) Do(req *http.Request) (*http.Response, error) {
+	finalReq := req
+	for i := 0; i < c.Redirects; i++ {
+		nextReq, _ := http.NewRequest(req.Method, fmt.Sprintf("/redirect%d", i+1), nil)
+		nextReq.Response = &http.Response{StatusCode: 302, Request: finalReq}
+		finalReq = nextReq
+	}
+	return &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    finalReq,
+	}, nil
+}
+
+func TestRedirects(t *testing.T) {
+	mockClient := RedirectHttpClient{Redirects: 2}
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "redirects.json", true)
+
+	if len(results.Passed) != 1 || len(results.Failed) != 1 {
+		t.Errorf("Expected 1 Passed, 1 Failed.")
+	}
+	if len(results.Failed) > 0 && !strings.Contains(results.Failed[0].Result(), "Expected 1 redirects but got 2") {
+		t.Errorf("Expected failure result to contain string: 'Expected 1 redirects but got 2'")
+	}
+}
+
+func TestSessionIsolation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "user1"})
+			return
+		}
+		cookie, err := r.Cookie("session")
+		if err != nil {
+			w.Write([]byte("anonymous"))
+			return
+		}
+		w.Write([]byte(cookie.Value))
+	}))
+	defer server.Close()
+
+	// Each suite execution gets a fresh session, so the login cookie from the first must not be sent by the second
+	for i := 0; i < 2; i++ {
+		results, _ := ExecuteSuite(RunConfig{
+			BaseUrl: server.URL,
+		}, "session.json", true)
+		if len(results.Failed) > 0 {
+			for _, test := range results.Failed {
+				t.Errorf("Failed test result: [%s]\n", test.Result())
+			}
+		}
+	}
+}
+
+func TestExpectationDefaults(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "{ \"id\": 1, \"name\": \"name\" }"
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "defaults.json", true)
+
+	if len(results.Passed) != 2 {
+		t.Errorf("Expected tests 'implicitStatusNoBody' and 'nullBodyNotChecked' to pass.")
+	}
+	if len(results.Failed) != 1 || results.Failed[0].Name != "bodyEmpty" {
+		t.Errorf("Expected test 'bodyEmpty' to fail.")
+	}
+
+	mockClient.StatusCode = 204
+	mockClient.Body = ""
+	results, _ = ExecuteSuite(RunConfig{
+		BaseUrl:           "",
+		DefaultStatusCode: 204,
+		HttpClient:        &mockClient,
+	}, "defaults.json", true)
+	if len(results.Failed) > 0 {
+		for _, test := range results.Failed {
+			t.Errorf("Failed test result: [%s]\n", test.Result())
+		}
+	}
+}
+
+func TestStrictBody(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "{ \"id\": 1, \"name\": \"name\", \"nested\": { \"hello\": \"world\", \"extra\": true } }"
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "strictbody.json", true)
+
+	if len(results.Passed) != 1 || results.Passed[0].Name != "subsetMatch" {
+		t.Errorf("Expected test 'subsetMatch' to pass.")
+	}
+	if len(results.Failed) != 1 || results.Failed[0].Name != "strictMatch" {
+		t.Errorf("Expected test 'strictMatch' to fail.")
+	}
+}
+
+func TestNullMatchers(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "{ \"id\": 1, \"name\": \"name\", \"deletedAt\": null }"
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "nullmatchers.json", true)
+
+	if len(results.Passed) != 1 || results.Passed[0].Name != "nullAndAbsentMatchers" {
+		t.Errorf("Expected test 'nullAndAbsentMatchers' to pass.")
+	}
+	if len(results.Failed) != 1 {
+		t.Fatalf("Expected test 'nullVsMissing' to fail.")
+	}
+	result := results.Failed[0].Result()
+	if !strings.Contains(result, "map[deletedAt]: expected field to be absent but it is null") {
+		t.Errorf("Expected failure result to contain string: 'map[deletedAt]: expected field to be absent but it is null'")
+	}
+	if !strings.Contains(result, "map[description]: field is missing but expected null") {
+		t.Errorf("Expected failure result to contain string: 'map[description]: field is missing but expected null'")
+	}
+}
+
+func TestStringComparison(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "{ \"status\": \"ACTIVE\", \"role\": \" Admin \" }"
+	for _, testFile := range []string{"stringcomparison.json", "stringmatchers.json"} {
+		results, _ := ExecuteSuite(RunConfig{
+			BaseUrl:    "",
+			HttpClient: &mockClient,
+		}, testFile, true)
+
+		if len(results.Passed) == 0 {
+			t.Errorf("All tests should have passed.\n")
+		}
+		if len(results.Failed) > 0 {
+			for _, test := range results.Failed {
+				t.Errorf("Failed test result: [%s]\n", test.Result())
+			}
+		}
+	}
+}
+
+func TestDateMatchers(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = fmt.Sprintf("{ \"createdAt\": \"2023-04-05T12:38:54.038Z\", \"birthday\": \"1990-01-31\", \"updatedAt\": \"%s\" }", time.Now().UTC().Format(time.RFC3339))
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "datematchers.json", true)
+
+	if len(results.Passed) != 1 || results.Passed[0].Name != "dateMatchers" {
+		t.Errorf("Expected test 'dateMatchers' to pass.")
+	}
+	if len(results.Failed) != 1 || !strings.Contains(results.Failed[0].Result(), "map[birthday]: expected date formatted as RFC3339 but got 1990-01-31") {
+		t.Errorf("Expected test 'invalidDateFormat' to fail on field 'birthday'.")
+	}
+}
+
+func TestTemplateTransforms(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "{ \"email\": \"someone@example.com\" }"
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "templatetransforms.json", true)
+
+	if len(results.Passed) != 2 {
+		t.Errorf("All tests should have passed.\n")
+	}
+	if len(results.Failed) > 0 {
+		for _, test := range results.Failed {
+			t.Errorf("Failed test result: [%s]\n", test.Result())
+		}
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "{\"count\": 2, \"users\": [{\"id\": 1, \"name\": \"name1\"},{\"id\": 2,\"name\": \"name2\"}]}"
+	mockClient.Header = map[string][]string{"Warrant-Token": {"asdf"}}
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "assertions.json", true)
+
+	if len(results.Passed) != 1 || results.Passed[0].Name != "listUsers" {
+		t.Errorf("Expected test 'listUsers' to pass.")
+	}
+	if len(results.Failed) != 1 {
+		t.Fatalf("Expected test 'failingAssertion' to fail.")
+	}
+	if len(results.Failed[0].Errors) != 1 || results.Failed[0].Errors[0] != "Assertion failed: response.body.count > 2" {
+		t.Errorf("Expected only assertion 'response.body.count > 2' to fail but got %v", results.Failed[0].Errors)
+	}
+}
+
+func TestItemsMatcher(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "[{\"id\": \"1\", \"type\": \"user\", \"createdAt\": \"2023-04-05T12:38:54.038Z\", \"roles\": [{\"name\": \"admin\"}]},{\"id\": \"2\", \"type\": \"user\", \"createdAt\": \"2023-04-05T12:38:54.036Z\", \"roles\": [{\"name\": \"\"}]}]"
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "itemsmatcher.json", true)
+
+	if len(results.Passed) != 1 || results.Passed[0].Name != "listUsers" {
+		t.Errorf("Expected test 'listUsers' to pass.")
+	}
+	if len(results.Failed) != 1 || !strings.Contains(results.Failed[0].Result(), "map[roles].slice[0].map[name]: expected non-empty value but got ") {
+		t.Errorf("Expected test 'nestedItems' to fail on the second element's role name.")
+	}
+}
+
+func TestItemsMatcherTemplates(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "{\"id\": \"org_1\", \"users\": [{\"orgId\": \"org_1\", \"name\": \"a\", \"updatedAt\": \"2024-01-01\"}, {\"orgId\": \"org_1\", \"name\": \"b\", \"updatedAt\": \"2024-01-02\"}]}"
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "itemstemplates.json", true)
+
+	if len(results.Passed) != 2 || results.Passed[1].Name != "listOrgUsers" {
+		t.Errorf("Expected test 'listOrgUsers' to pass with template vars and ignored fields in its $items shape.")
+	}
+	if len(results.Failed) != 1 || !strings.Contains(results.Failed[0].Result(), "map[users].slice[0].map[orgId]: org_1 != org_1_other") {
+		t.Errorf("Expected test 'otherOrgUsers' to fail on the first element's orgId.")
+	}
+}
+
+func TestSortedMatcher(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "[{\"id\": 1, \"name\": \"a\"},{\"id\": 2, \"name\": \"b\"},{\"id\": 3, \"name\": \"c\"}]"
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "sortedmatcher.json", true)
+
+	if len(results.Passed) != 1 || results.Passed[0].Name != "sortedByName" {
+		t.Errorf("Expected test 'sortedByName' to pass.")
+	}
+	if len(results.Failed) != 1 || !strings.Contains(results.Failed[0].Result(), "slice[1]: expected array sorted by 'id' desc but 2 comes after 1") {
+		t.Errorf("Expected test 'sortedByIdDesc' to fail.")
+	}
+}
+
+func TestContentType(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "{ \"id\": 1, \"name\": \"name\" }"
+	mockClient.Header = map[string][]string{"Content-Type": {"application/json; charset=utf-8"}}
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "contenttype.json", true)
+
+	if len(results.Passed) != 2 {
+		t.Errorf("Expected tests 'mediaTypeOnly' and 'withCharset' to pass.")
+	}
+	if len(results.Failed) != 1 || results.Failed[0].Name != "wrongCharset" {
+		t.Errorf("Expected test 'wrongCharset' to fail.")
+	}
+}
+
+type RequestRecordingHttpClient struct {
+	MockHttpClient
+	Requests []*http.Request
+}
+
+func (c *RequestRecordingHttpClient) Do(req *http.Request) (*http.Response, error) {
+	c.Requests = append(c.Requests, req)
+	return c.MockHttpClient.Do(req)
+}
+
+func TestResponseHeaderVars(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "headers.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "login", "request": {"method": "POST", "url": "/login"}},
+		{"name": "getUser", "request": {"method": "GET", "url": "/users?req={{ login.header.x-request-id }}&canonical={{ login.header.X-Request-Id }}&cookie={{ login.header.set-cookie.1 }}&cookies={{ login.header.Set-Cookie | urlencode }}"}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Header = map[string][]string{
+		"x-request-id": {"req_1"},
+		"Set-Cookie":   {"session=abc", "theme=dark"},
+	}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Failed) > 0 {
+		for _, test := range results.Failed {
+			t.Errorf("Failed test result: [%s]\n", test.Result())
+		}
+	}
+	if len(mockClient.Requests) != 2 {
+		t.Fatalf("Expected 2 requests but got %d", len(mockClient.Requests))
+	}
+	expectedUrl := "/users?req=req_1&canonical=req_1&cookie=theme=dark&cookies=session%3Dabc%2Ctheme%3Ddark"
+	if url := mockClient.Requests[1].URL.String(); url != expectedUrl {
+		t.Errorf("Expected url %s but got %s", expectedUrl, url)
+	}
+}
+
+func TestCookieVars(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "cookies.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "login", "request": {"method": "POST", "url": "/login"}, "assert": [
+			"response.cookies.session.secure && response.cookies.session.httpOnly",
+			"response.cookies.session.sameSite == 'Strict'",
+			"response.cookies.theme.sameSite == 'Strict'"
+		]},
+		{"name": "getUser", "request": {"method": "GET", "url": "/users?session={{ login.cookie.session }}&expires={{ login.cookie.session.expires | urlencode }}&maxAge={{ login.cookie.theme.maxAge }}"}, "assert": [
+			"login.cookie.session.path == '/' && !login.cookie.theme.secure"
+		]}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Header = map[string][]string{
+		"Set-Cookie": {
+			"session=abc; Path=/; Expires=Wed, 21 Oct 2026 07:28:00 GMT; Secure; HttpOnly; SameSite=Strict",
+			"theme=dark; Max-Age=3600; SameSite=Lax",
+		},
+	}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 1 || len(results.Failed) != 1 || results.Failed[0].Name != "login" {
+		t.Fatalf("Expected only the theme cookie's SameSite assertion to fail")
+	}
+	if errs := strings.Join(results.Failed[0].Errors, "\n"); !strings.Contains(errs, "response.cookies.theme.sameSite") || strings.Contains(errs, "response.cookies.session") {
+		t.Errorf("Expected only the theme cookie's SameSite assertion to fail but got %s", errs)
+	}
+	expectedUrl := "/users?session=abc&expires=2026-10-21T07%3A28%3A00Z&maxAge=3600"
+	if url := mockClient.Requests[1].URL.String(); url != expectedUrl {
+		t.Errorf("Expected url %s but got %s", expectedUrl, url)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	lastModified := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/1" {
+			w.Header().Set("ETag", `"v1"`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeContent(w, r, "", lastModified, strings.NewReader(`{"id": 1}`))
+	}))
+	defer server.Close()
+
+	testFile := filepath.Join(t.TempDir(), "conditional.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": {"id": 1}}},
+		{"name": "getUserNotModified", "request": {"method": "GET", "url": "/users/1", "conditionalOn": "getUser"}},
+		{"name": "getOtherUser", "request": {"method": "GET", "url": "/users/2"}, "expectedResponse": {"statusCode": 200}},
+		{"name": "getOtherUserNotModified", "request": {"method": "GET", "url": "/users/2", "conditionalOn": "getOtherUser"}},
+		{"name": "getUserModified", "request": {"method": "GET", "url": "/users/1", "conditionalOn": "getUser", "headers": {"If-None-Match": "\"v0\""}}, "expectedResponse": {"statusCode": 200}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    server.URL,
+		HttpClient: http.DefaultClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Failed) > 0 {
+		for _, test := range results.Failed {
+			t.Errorf("Failed test result: [%s]\n", test.Result())
+		}
+	}
+	if len(results.Passed) != 5 {
+		t.Errorf("Expected all tests to pass")
+	}
+
+	err = os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "getUserNotModified", "request": {"method": "GET", "url": "/users/1", "conditionalOn": "getUser"}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ExecuteSuite(RunConfig{BaseUrl: server.URL}, testFile, true)
+	if err == nil || !strings.Contains(err.Error(), "isn't an earlier test case") {
+		t.Errorf("Expected conditional test on an unknown test to be invalid but got %v", err)
+	}
+}
+
+func TestCorsPreflight(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions {
+			t.Errorf("Expected preflight to be an OPTIONS request but got %s", r.Method)
+		}
+		if r.Header.Get("Origin") == "https://app.example.com" {
+			w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			w.Header().Set("Access-Control-Allow-Headers", "content-type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	testFile := filepath.Join(t.TempDir(), "cors.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "allowed", "request": {"url": "/users"}, "preflight": {"origin": "https://app.example.com", "method": "POST", "headers": ["Content-Type"]}, "expectedResponse": {"headers": {"Access-Control-Max-Age": "600"}}},
+		{"name": "disallowedMethod", "request": {"url": "/users"}, "preflight": {"origin": "https://app.example.com", "method": "DELETE", "headers": ["Authorization"]}},
+		{"name": "disallowedOrigin", "request": {"url": "/users"}, "preflight": {"origin": "https://evil.example.com", "method": "GET"}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    server.URL,
+		HttpClient: http.DefaultClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 1 || results.Passed[0].Name != "allowed" {
+		t.Errorf("Expected allowed preflight to pass")
+	}
+	if len(results.Failed) != 2 {
+		t.Fatalf("Expected disallowed preflights to fail")
+	}
+	if errs := strings.Join(results.Failed[0].Errors, "\n"); !strings.Contains(errs, "allow method DELETE") || !strings.Contains(errs, "allow header Authorization") || strings.Contains(errs, "origin") {
+		t.Errorf("Expected disallowed method and header but got %s", errs)
+	}
+	if errs := strings.Join(results.Failed[1].Errors, "\n"); !strings.Contains(errs, "allow origin https://evil.example.com") {
+		t.Errorf("Expected disallowed origin but got %s", errs)
+	}
+}
+
+func TestNoPayloadResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	defer server.Close()
+
+	testFile := filepath.Join(t.TempDir(), "nopayload.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "headUser", "request": {"method": "HEAD", "url": "/users/1"}, "assert": ["response.body == null"]},
+		{"name": "deleteUser", "request": {"method": "DELETE", "url": "/users/1"}, "expectedResponse": {"statusCode": 204}},
+		{"name": "headUserWithBody", "request": {"method": "HEAD", "url": "/users/1"}, "expectedResponse": {"body": {"id": 1}}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    server.URL,
+		HttpClient: http.DefaultClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 2 {
+		for _, test := range results.Failed {
+			t.Errorf("Failed test result: [%s]\n", test.Result())
+		}
+	}
+	if len(results.Failed) != 1 {
+		t.Fatalf("Expected the test expecting a payload for a HEAD request to fail")
+	}
+	if errs := strings.Join(results.Failed[0].Errors, "\n"); !strings.Contains(errs, "HEAD request has none") || strings.Contains(errs, "non-JSON") {
+		t.Errorf("Expected a missing payload failure but got %s", errs)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "<html><head><title>Sign in</title></head><body><form><input type=\"hidden\" name=\"code\" value=\"abc123\"><input type=\"hidden\" name=\"state\" value=\"xyz\"><br></form></body></html>"
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "extract.json", true)
+
+	if len(results.Failed) > 0 {
+		for _, test := range results.Failed {
+			t.Errorf("Failed test result: [%s]\n", test.Result())
+		}
+	}
+	if len(mockClient.Requests) != 2 || mockClient.Requests[1].URL.String() != "/token?code=abc123&state=xyz" {
+		t.Errorf("Expected extracted values to be used in the second request")
+	}
+}
+
+func TestXmlResponse(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "<?xml version=\"1.0\"?><users count=\"2\" requestId=\"123\"><user id=\"1\"><name>name1</name></user><user id=\"2\"><name>name2</name></user></users>"
+	mockClient.Header = map[string][]string{"Content-Type": {"application/xml; charset=utf-8"}}
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "xmlresponse.json", true)
+
+	if len(results.Passed) != 3 {
+		t.Errorf("All tests should have passed.\n")
+	}
+	if len(results.Failed) > 0 {
+		for _, test := range results.Failed {
+			t.Errorf("Failed test result: [%s]\n", test.Result())
+		}
+	}
+}
+
+func TestPayloadTypeMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/object":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": "u1"}`))
+		case "/array":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"id": "u1"}]`))
+		case "/strings":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`["a", "b"]`))
+		case "/xml":
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(`<?xml version="1.0"?><user id="u1"></user>`))
+		}
+	}))
+	defer server.Close()
+
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    server.URL,
+		HttpClient: http.DefaultClient,
+	}, "payloadtypes.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedErrors := map[string]string{
+		"objectExpectedArray":        "Expected response payload to be an array but got an object",
+		"objectExpectedString":       "Expected response payload to be a string but got an object",
+		"arrayExpectedObject":        "Expected response payload to be an object but got an array",
+		"arrayExpectedString":        "Expected response payload to be a string but got an array",
+		"arrayElementExpectedString": "slice[0]",
+		"xmlExpectedArray":           "Expected response payload to be an array but got an object",
+	}
+	if len(results.Passed) != 1 || results.Passed[0].Name != "stringElements" {
+		t.Errorf("Expected only stringElements to pass, got %v", results.Passed)
+	}
+	if len(results.Failed) != len(expectedErrors) {
+		t.Errorf("Expected %d failed tests, got %d", len(expectedErrors), len(results.Failed))
+	}
+	for _, result := range results.Failed {
+		expected, ok := expectedErrors[result.Name]
+		if !ok {
+			t.Errorf("Unexpected failed test %s", result.Name)
+			continue
+		}
+		if result.Category != FailureBodyDiff {
+			t.Errorf("Expected %s to fail with %s, got %s", result.Name, FailureBodyDiff, result.Category)
+		}
+		if !strings.Contains(strings.Join(result.Errors, "\n"), expected) {
+			t.Errorf("Expected %s to fail with '%s', got %v", result.Name, expected, result.Errors)
+		}
+	}
+}
+
+// Minimal protobuf encoding helpers for building descriptor sets and messages in tests
+func protoTag(number uint64, wireType uint64) []byte {
+	return binary.AppendUvarint(nil, number<<3|wireType)
+}
+
+func protoVarint(number uint64, value uint64) []byte {
+	return binary.AppendUvarint(protoTag(number, 0), value)
+}
+
+func protoBytes(number uint64, value []byte) []byte {
+	return append(binary.AppendUvarint(protoTag(number, 2), uint64(len(value))), value...)
+}
+
+func protoFieldDescriptor(name string, number uint64, label uint64, typ uint64, typeName string) []byte {
+	field := protoBytes(1, []byte(name))
+	field = append(field, protoVarint(3, number)...)
+	field = append(field, protoVarint(4, label)...)
+	field = append(field, protoVarint(5, typ)...)
+	if typeName != "" {
+		field = append(field, protoBytes(6, []byte(typeName))...)
+	}
+	return protoBytes(2, field)
+}
+
+func TestProtobufResponse(t *testing.T) {
+	// package acme.v1
+	// enum Status { UNKNOWN = 0; ACTIVE = 1; }
+	// message Address { string city = 1; }
+	// message User { int64 id = 1; string name = 2; repeated int32 scores = 3; Status status = 4; Address address = 5; }
+	status := protoBytes(1, []byte("Status"))
+	status = append(status, protoBytes(2, append(protoBytes(1, []byte("UNKNOWN")), protoVarint(2, 0)...))...)
+	status = append(status, protoBytes(2, append(protoBytes(1, []byte("ACTIVE")), protoVarint(2, 1)...))...)
+	address := append(protoBytes(1, []byte("Address")), protoFieldDescriptor("city", 1, 1, 9, "")...)
+	user := protoBytes(1, []byte("User"))
+	user = append(user, protoFieldDescriptor("id", 1, 1, 3, "")...)
+	user = append(user, protoFieldDescriptor("name", 2, 1, 9, "")...)
+	user = append(user, protoFieldDescriptor("scores", 3, 3, 5, "")...)
+	user = append(user, protoFieldDescriptor("status", 4, 1, 14, ".acme.v1.Status")...)
+	user = append(user, protoFieldDescriptor("address", 5, 1, 11, ".acme.v1.Address")...)
+	file := protoBytes(1, []byte("user.proto"))
+	file = append(file, protoBytes(2, []byte("acme.v1"))...)
+	file = append(file, protoBytes(4, user)...)
+	file = append(file, protoBytes(4, address)...)
+	file = append(file, protoBytes(5, status)...)
+	descriptorSetFile := filepath.Join(t.TempDir(), "descriptors.pb")
+	err := os.WriteFile(descriptorSetFile, protoBytes(1, file), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := protoVarint(1, 42)
+	body = append(body, protoBytes(2, []byte("name"))...)
+	body = append(body, protoBytes(3, []byte{1, 2, 3})...)
+	body = append(body, protoVarint(4, 1)...)
+	body = append(body, protoBytes(5, protoBytes(1, []byte("Paris")))...)
+
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = string(body)
+	mockClient.Header = map[string][]string{"Content-Type": {"application/x-protobuf; messageType=acme.v1.User"}}
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:            "",
+		ProtoDescriptorSet: descriptorSetFile,
+		HttpClient:         &mockClient,
+	}, "protobufresponse.json", true)
+
+	if len(results.Passed) != 1 {
+		t.Errorf("All tests should have passed.\n")
+	}
+	if len(results.Failed) > 0 {
+		for _, test := range results.Failed {
+			t.Errorf("Failed test result: [%s]\n", test.Result())
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	mockClient := RequestRecordingHttpClient{}
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:       "",
+		CustomHeaders: map[string]string{"Authorization": "Bearer token"},
+		DryRun:        true,
+		HttpClient:    &mockClient,
+	}, "dryrun.json", true)
+
+	if len(mockClient.Requests) != 0 {
+		t.Errorf("Expected no requests to be sent in dry-run mode")
+	}
+	if len(results.Passed) != 2 || len(results.Skipped) != 1 || len(results.Failed) != 1 {
+		t.Errorf("Expected 2 Passed, 1 Failed, 1 Skipped.")
+	}
+	if len(results.Failed) > 0 && !strings.Contains(results.Failed[0].Result(), "Expected request url /users but got /user") {
+		t.Errorf("Expected failure result to contain string: 'Expected request url /users but got /user'")
+	}
+}
+
+func TestDryRunHeaders(t *testing.T) {
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:        "",
+		DryRun:         true,
+		HttpClient:     &mockClient,
+		Auth:           &TokenAuthConfig{TokenUrl: "/token", TokenField: "token"},
+		Signing:        &SigningConfig{Header: "X-Signature", Key: "secret", StringToSign: "{{ request.method }} {{ request.path }}"},
+		IdempotencyKey: &IdempotencyKeyConfig{},
+		RequestId:      &RequestIdConfig{Generate: true},
+	}, "dryrunheaders.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mockClient.Requests) != 0 {
+		t.Errorf("Expected no requests (including token requests) to be sent in dry-run mode")
+	}
+	if len(results.Passed) != 1 || len(results.Failed) != 1 {
+		t.Fatalf("Expected 1 Passed, 1 Failed. Got %d Passed, %d Failed", len(results.Passed), len(results.Failed))
+	}
+	headers := results.Passed[0].RequestHeaders
+	for _, header := range []string{"X-Signature", "Idempotency-Key", defaultRequestIdHeader} {
+		if headers.Get(header) == "" {
+			t.Errorf("Expected dry-run request to include header %s", header)
+		}
+	}
+	if !strings.Contains(results.Failed[0].Result(), "Expected request header 'Authorization: Bearer dry-run-token' not present") {
+		t.Errorf("Expected omitted auth header to be absent from dry-run request. Got %s", results.Failed[0].Result())
+	}
+}
+
+func TestComputedHeaders(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "computedheaders.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "listUsers", "request": {"method": "GET", "url": "/users"}},
+		{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl: "",
+		CustomHeaders: map[string]string{
+			"Authorization": "Bearer token",
+			"X-Test-Name":   "{{ test.file }}/{{ test.name }}",
+			"X-Run-Id":      "{{ run.id }}",
+		},
+		HttpClient: &mockClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Passed) != 2 || len(mockClient.Requests) != 2 {
+		t.Fatalf("Expected 2 requests to pass but got %d passed, %d failed", len(results.Passed), len(results.Failed))
+	}
+
+	runId := mockClient.Requests[0].Header.Get("X-Run-Id")
+	for i, testName := range []string{"listUsers", "getUser"} {
+		req := mockClient.Requests[i]
+		if req.Header.Get("X-Test-Name") != "computedheaders.json/"+testName {
+			t.Errorf("Expected X-Test-Name 'computedheaders.json/%s' but got '%s'", testName, req.Header.Get("X-Test-Name"))
+		}
+		if runId == "" || req.Header.Get("X-Run-Id") != runId {
+			t.Errorf("Expected the same X-Run-Id for all requests but got '%s'", req.Header.Get("X-Run-Id"))
+		}
+		if req.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Expected static headers to be sent unchanged but got '%s'", req.Header.Get("Authorization"))
+		}
+	}
+
+	results, _ = ExecuteSuite(RunConfig{
+		BaseUrl:       "",
+		CustomHeaders: map[string]string{"X-Tenant": "{{ tenant.id }}"},
+		HttpClient:    &mockClient,
+	}, testFile, true)
+	if len(results.Failed) != 2 || !strings.Contains(results.Failed[0].Result(), "invalid config header 'X-Tenant'") {
+		t.Errorf("Expected unknown variables in config headers to fail tests")
+	}
+}
+
+// Not a real test: runs as the plugin process started by TestPlugins
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Getenv("APIRUNNER_TEST_PLUGIN") != "1" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var request struct {
+			Id     int                    `json:"id"`
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+		}
+		json.Unmarshal(scanner.Bytes(), &request)
+		var result interface{}
+		switch request.Method {
+		case "initialize":
+			result = map[string]interface{}{"matchers": []string{"sku"}, "transforms": []string{"reverse"}, "auth": true}
+		case "match":
+			mismatch := ""
+			if s, ok := request.Params["actual"].(string); !ok || !strings.HasPrefix(s, "SKU-") {
+				mismatch = fmt.Sprintf("expected SKU but got %v", request.Params["actual"])
+			}
+			result = map[string]interface{}{"mismatch": mismatch}
+		case "transform":
+			value := []rune(request.Params["value"].(string))
+			slices.Reverse(value)
+			result = map[string]interface{}{"value": string(value)}
+		case "authenticate":
+			result = map[string]interface{}{"headers": map[string]string{"Authorization": "Plugin " + request.Params["method"].(string)}}
+		}
+		response, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": request.Id, "result": result})
+		fmt.Println(string(response))
+	}
+	os.Exit(0)
+}
+
+func TestPlugins(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "plugins.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "getProduct", "request": {"method": "GET", "url": "/products/1"}, "expectedResponse": {"statusCode": 200, "body": {"sku": "{{ sku }}", "name": "abc"}}},
+		{"name": "searchProducts", "request": {"method": "GET", "url": "/products?q={{ getProduct.name | reverse }}"}, "expectedResponse": {"statusCode": 200}},
+		{"name": "invalidSku", "request": {"method": "GET", "url": "/products/1"}, "expectedResponse": {"statusCode": 200, "body": {"name": "{{ sku }}"}}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = `{"sku": "SKU-1", "name": "abc"}`
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl: "",
+		Plugins: []PluginConfig{{
+			Name:    "products",
+			Command: os.Args[0],
+			Args:    []string{"-test.run=^TestPluginHelperProcess$"},
+			Env:     map[string]string{"APIRUNNER_TEST_PLUGIN": "1"},
+		}},
+		HttpClient: &mockClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 2 || len(results.Failed) != 1 {
+		t.Fatalf("Expected 2 passed and 1 failed test but got %d passed, %d failed", len(results.Passed), len(results.Failed))
+	}
+	if !strings.Contains(results.Failed[0].Result(), "expected SKU but got abc") {
+		t.Errorf("Expected plugin matcher mismatch but got %s", results.Failed[0].Result())
+	}
+	if query := mockClient.Requests[1].URL.Query().Get("q"); query != "cba" {
+		t.Errorf("Expected plugin transform to reverse 'abc' but got '%s'", query)
+	}
+	for _, req := range mockClient.Requests {
+		if req.Header.Get("Authorization") != "Plugin GET" {
+			t.Errorf("Expected auth plugin header but got '%s'", req.Header.Get("Authorization"))
+		}
+	}
+	if _, ok := pluginMatcher("sku"); ok {
+		t.Errorf("Expected plugin matchers to be unregistered after the suite")
+	}
+
+	_, err = ExecuteSuite(RunConfig{
+		BaseUrl: "",
+		Plugins: []PluginConfig{{
+			Name:    "dates",
+			Command: os.Args[0],
+			Args:    []string{"-test.run=^TestPluginHelperProcess$"},
+			Env:     map[string]string{"APIRUNNER_TEST_PLUGIN": "1"},
+		}, {
+			Name:    "dates",
+			Command: os.Args[0],
+		}},
+		HttpClient: &mockClient,
+	}, testFile, true)
+	if err == nil || !strings.Contains(err.Error(), "duplicate plugin 'dates'") {
+		t.Errorf("Expected duplicate plugins to be rejected but got %v", err)
+	}
+}
+
+func TestWasmPlugins(t *testing.T) {
+	command, args, err := PluginConfig{Name: "catalog", Wasm: "catalog.wasm", Args: []string{"-v"}, Env: map[string]string{"B": "2", "A": "1"}}.command()
+	if err != nil || command != "wasmtime" || !reflect.DeepEqual(args, []string{"run", "--env", "A=1", "--env", "B=2", "catalog.wasm", "-v"}) {
+		t.Errorf("Unexpected wasm plugin command: %s %v (%v)", command, args, err)
+	}
+	_, _, err = PluginConfig{Name: "catalog", Command: "catalog", Wasm: "catalog.wasm"}.command()
+	if err == nil {
+		t.Errorf("Expected plugins with both a command and a wasm module to be rejected")
+	}
+	_, err = startPlugin(PluginConfig{Name: "catalog", Wasm: "catalog.wasm", WasmRuntimeCommand: []string{"apirunner-missing-wasm-runtime"}}, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "requires the WASI runtime 'apirunner-missing-wasm-runtime' to be installed") {
+		t.Errorf("Expected wasm plugin without its runtime installed to be rejected but got %v", err)
+	}
+
+	// Run the helper process as the wasm runtime
+	t.Setenv("APIRUNNER_TEST_PLUGIN", "1")
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = `{"sku": "SKU-1", "name": "abc"}`
+	testFile := filepath.Join(t.TempDir(), "wasmplugins.json")
+	err = os.WriteFile(testFile, []byte(`{"tests": [{"name": "getProduct", "request": {"method": "GET", "url": "/products/1"}, "expectedResponse": {"statusCode": 200, "body": {"sku": "{{ sku }}", "name": "abc"}}}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl: "",
+		Plugins: []PluginConfig{{
+			Name:               "catalog",
+			Wasm:               "catalog.wasm",
+			WasmRuntimeCommand: []string{os.Args[0], "-test.run=^TestPluginHelperProcess$", "--"},
+		}},
+		HttpClient: &mockClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Passed) != 1 {
+		t.Errorf("Expected wasm plugin matcher to pass")
+	}
+}
+
+func TestTemplateFileFunction(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"payloads/user.json": "{\"name\": \"{{ getUser.name }}\", \"roles\": [\"admin\"]}\n",
+		"payloads/token.txt": "secret-token\n",
+		"files.json": `{"tests": [
+			{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": {"name": "Jane"}}},
+			{"name": "updateUser", "request": {"method": "PUT", "url": "/users/1", "headers": {"Authorization": "Bearer {{ file('payloads/token.txt') }}"}, "body": "{{ file('payloads/user.json') }}"}, "expectedResponse": {"statusCode": 200}},
+			{"name": "missingFile", "request": {"method": "PUT", "url": "/users/1", "body": "{{ file('payloads/missing.json') }}"}, "expectedResponse": {"statusCode": 200}}
+		]}`,
+	})
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = `{"name": "Jane"}`
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, filepath.Join(dir, "files.json"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 2 || len(results.Failed) != 1 || !strings.Contains(results.Failed[0].Result(), "error reading template file payloads/missing.json") {
+		t.Fatalf("Expected 2 passed tests and a failure reading the missing file")
+	}
+	req := mockClient.Requests[1]
+	if req.Header.Get("Authorization") != "Bearer secret-token" {
+		t.Errorf("Expected header from file but got '%s'", req.Header.Get("Authorization"))
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"name": "Jane", "roles": ["admin"]}` {
+		t.Errorf("Expected templated body from file but got %s", string(body))
+	}
+}
+
+func TestTemplateExecFunction(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "scripts"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "scripts", "get_token.sh"), []byte("#!/bin/sh\necho \"token-$1\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "exec.json"), []byte(`{"tests": [
+		{"name": "getUser", "request": {"method": "GET", "url": "/users/1", "headers": {"Authorization": "Bearer {{ exec('scripts/get_token.sh staging') }}"}}, "expectedResponse": {"statusCode": 200}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	results, _ := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, filepath.Join(dir, "exec.json"), true)
+	if len(results.Failed) != 1 || !strings.Contains(results.Failed[0].Result(), "template function 'exec' is disabled") {
+		t.Errorf("Expected exec template function to be disabled by default")
+	}
+
+	results, _ = ExecuteSuite(RunConfig{
+		BaseUrl:           "",
+		AllowTemplateExec: true,
+		HttpClient:        &mockClient,
+	}, filepath.Join(dir, "exec.json"), true)
+	if len(results.Passed) != 1 {
+		t.Fatalf("Expected test using exec template function to pass")
+	}
+	if auth := mockClient.Requests[0].Header.Get("Authorization"); auth != "Bearer token-staging" {
+		t.Errorf("Expected header from command output but got '%s'", auth)
+	}
+
+	// A command still running at the suite's deadline is killed
+	err = os.WriteFile(filepath.Join(dir, "scripts", "hang.sh"), []byte("#!/bin/sh\necho $$ > \"$1\"\nexec sleep 10\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pidFile := filepath.Join(dir, "hang.pid")
+	err = os.WriteFile(filepath.Join(dir, "hang.json"), []byte(fmt.Sprintf(`{"tests": [
+		{"name": "getUser", "request": {"method": "GET", "url": "/users/1", "headers": {"Authorization": "Bearer {{ exec('scripts/hang.sh %s') }}"}}}
+	]}`, pidFile)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := compileSuite(nil, filepath.Join(dir, "hang.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _ := executeCompiledSuite(RunConfig{
+		BaseUrl:           "",
+		AllowTemplateExec: true,
+		HttpClient:        &mockClient,
+		Timeouts:          &TimeoutConfig{SuiteMs: 200},
+	}, compiled, true)
+	if !result.TimedOut {
+		t.Fatalf("Expected test running a hanging command to time out")
+	}
+	pid, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	killed := false
+	for i := 0; i < 20 && !killed; i++ {
+		time.Sleep(50 * time.Millisecond)
+		_, err := os.Stat(filepath.Join("/proc", strings.TrimSpace(string(pid))))
+		killed = os.IsNotExist(err)
+	}
+	if !killed {
+		t.Errorf("Expected command run by the exec template function to be killed at the suite's deadline")
+	}
+
+	fsys := fstest.MapFS{
+		"exec.json":            {Data: []byte(`{"tests": [{"name": "getUser", "request": {"method": "GET", "url": "/users/1", "headers": {"Authorization": "Bearer {{ exec('scripts/get_token.sh staging') }}"}}}]}`)},
+		"scripts/get_token.sh": {Data: []byte("#!/bin/sh\necho \"token-$1\"\n"), Mode: 0755},
+	}
+	results, _ = ExecuteSuite(RunConfig{
+		BaseUrl:           "",
+		AllowTemplateExec: true,
+		HttpClient:        &mockClient,
+		FS:                fsys,
+	}, "exec.json", true)
+	if len(results.Failed) != 1 || !strings.Contains(results.Failed[0].Result(), "template function 'exec' isn't supported") {
+		t.Errorf("Expected exec template function to be rejected for test files read from an fs.FS")
+	}
+}
+
+func TestTemplateExpressions(t *testing.T) {
+	fields := map[string]interface{}{
+		"createOrder.total": float64(15.5),
+		"createOrder.count": float64(2),
+		"createOrder.id":    "ord_1",
+	}
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{"amount": "{{ createOrder.total * 100 }}"}`, `{"amount": 1550}`},
+		{`{"valid": "{{ createOrder.total \u003e 10 \u0026\u0026 createOrder.count == 2 }}"}`, `{"valid": true}`},
+		{`/orders?page={{ createOrder.count + 1 }}`, `/orders?page=3`},
+		{`{"label": "order {{ createOrder.id }} #{{ createOrder.count+1 }}"}`, `{"label": "order ord_1 #3"}`},
+		{`{"id": "{{ createOrder.id | upper }}", "note": "{{ not an expression }}"}`, `{"id": "ORD_1", "note": "{{ not an expression }}"}`},
+	}
+	for _, test := range tests {
+		actual, err := templateReplace(test.template, fields)
+		if err != nil {
+			t.Errorf("Error replacing %s: %v", test.template, err)
+		} else if actual != test.expected {
+			t.Errorf("Expected %s to be replaced with %s but got %s", test.template, test.expected, actual)
+		}
+	}
+	_, err := templateReplace(`{{ createOrder.missing * 2 }}`, fields)
+	if err == nil || !strings.Contains(err.Error(), "unknown variable 'createOrder.missing'") {
+		t.Errorf("Expected error for unknown variable in expression but got %v", err)
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "repeat.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "listItems", "repeat": 3, "request": {"method": "GET", "url": "/items?page={{ iteration }}"}, "expectedResponse": {"statusCode": 200}},
+		{"name": "afterRepeat", "request": {"method": "GET", "url": "/items?page={{ iteration }}"}, "expectedResponse": {"statusCode": 200}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0)
+	for _, result := range results.Passed {
+		names = append(names, result.Name)
+	}
+	if results.TotalTests != 4 || !reflect.DeepEqual(names, []string{"listItems#1", "listItems#2", "listItems#3"}) {
+		t.Errorf("Expected each iteration to be reported separately but got %v", names)
+	}
+	for i, req := range mockClient.Requests {
+		if req.URL.String() != fmt.Sprintf("/items?page=%d", i+1) {
+			t.Errorf("Expected request for page %d but got %s", i+1, req.URL)
+		}
+	}
+	if len(results.Failed) != 1 || !strings.Contains(results.Failed[0].Result(), "missing template value for var: 'iteration'") {
+		t.Errorf("Expected iteration to only be defined for repeated tests")
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "matrix.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "export", "matrix": {"role": ["admin", "member"], "format": ["json", "csv"]}, "request": {"method": "GET", "url": "/export?role={{ role }}&format={{ format }}"}, "expectedResponse": {"statusCode": 200}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0)
+	for _, result := range results.Passed {
+		names = append(names, result.Name)
+	}
+	expectedNames := []string{"export[format=json,role=admin]", "export[format=json,role=member]", "export[format=csv,role=admin]", "export[format=csv,role=member]"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Expected test to be expanded into %v but got %v", expectedNames, names)
+	}
+	urls := make([]string, 0)
+	for _, req := range mockClient.Requests {
+		urls = append(urls, req.URL.String())
+	}
+	expectedUrls := []string{"/export?role=admin&format=json", "/export?role=member&format=json", "/export?role=admin&format=csv", "/export?role=member&format=csv"}
+	if !reflect.DeepEqual(urls, expectedUrls) {
+		t.Errorf("Expected requests %v but got %v", expectedUrls, urls)
+	}
+
+	cases := testCases(TestSpec{Name: "list", Repeat: 2, Matrix: map[string][]interface{}{"page": {float64(1), float64(2)}}})
+	names = make([]string, 0)
+	for _, c := range cases {
+		names = append(names, c.name)
+	}
+	if !reflect.DeepEqual(names, []string{"list[page=1]#1", "list[page=1]#2", "list[page=2]#1", "list[page=2]#2"}) || cases[3].vars["iteration"] != 2 || cases[3].vars["page"] != float64(2) {
+		t.Errorf("Unexpected cases of repeated matrix test: %v", cases)
+	}
+}
+
+func TestCriticalTest(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "critical.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "health", "request": {"method": "GET", "url": "/health"}, "expectedResponse": {"statusCode": 200}},
+		{"name": "login", "critical": true, "request": {"method": "POST", "url": "/login"}, "expectedResponse": {"statusCode": 201}},
+		{"name": "listUsers", "request": {"method": "GET", "url": "/users"}, "expectedResponse": {"statusCode": 200}},
+		{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 1 || len(results.Failed) != 1 || len(results.Skipped) != 2 || len(mockClient.Requests) != 2 {
+		t.Fatalf("Expected the tests after the failed critical test to be skipped")
+	}
+	for _, result := range results.Skipped {
+		if result.SkipReason != "critical test 'login' failed" || !strings.Contains(result.Result(), "critical test 'login' failed") {
+			t.Errorf("Expected '%s' to be skipped due to the failed critical test but got '%s'", result.Name, result.SkipReason)
+		}
+	}
+}
+
+func TestDependsOn(t *testing.T) {
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	paths := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		paths = append(paths, r.URL.Path)
+		mutex.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+		switch r.URL.Path {
+		case "/users":
+			w.Write([]byte(`{"id": "u1"}`))
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	testFile := filepath.Join(t.TempDir(), "dependsOn.json")
+	writeSuite := func(maxParallel int) {
+		err := os.WriteFile(testFile, []byte(fmt.Sprintf(`{"maxParallel": %d, "tests": [
+			{"name": "createUser", "request": {"method": "POST", "url": "/users"}, "expectedResponse": {"statusCode": 200, "body": {"id": "{{ nonEmpty }}"}}},
+			{"name": "getUser", "request": {"method": "GET", "url": "/users/{{ createUser.id }}"}},
+			{"name": "getOrders", "dependsOn": ["createUser"], "request": {"method": "GET", "url": "/orders"}},
+			{"name": "getSettings", "dependsOn": ["createUser"], "matrix": {"format": ["csv", "json"]}, "request": {"method": "GET", "url": "/settings.{{ format }}"}},
+			{"name": "getHealth", "request": {"method": "GET", "url": "/health"}},
+			{"name": "broken", "critical": true, "dependsOn": ["getUser"], "request": {"method": "GET", "url": "/broken"}},
+			{"name": "afterBroken", "dependsOn": ["broken"], "request": {"method": "GET", "url": "/after"}}
+		]}`, maxParallel)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeSuite(0)
+	results, err := ExecuteSuite(RunConfig{BaseUrl: server.URL}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 6 || len(results.Failed) != 1 || len(results.Skipped) != 1 || results.Skipped[0].SkipReason != "critical test 'broken' failed" {
+		t.Errorf("Expected tests to pass except for the critical test and its dependent but got %v", results)
+	}
+	if !slices.Contains(paths, "/users/u1") {
+		t.Errorf("Expected extracted fields to flow to dependent tests but got requests %v", paths)
+	}
+	if paths[0] != "/users" && paths[0] != "/health" {
+		t.Errorf("Expected tests to start once their dependencies completed but got requests %v", paths)
+	}
+	if maxInFlight < 3 {
+		t.Errorf("Expected independent tests to run concurrently but at most %d ran at once", maxInFlight)
+	}
+
+	writeSuite(1)
+	maxInFlight = 0
+	_, err = ExecuteSuite(RunConfig{BaseUrl: server.URL}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxInFlight != 1 {
+		t.Errorf("Expected maxParallel to limit concurrent tests but %d ran at once", maxInFlight)
+	}
+
+	err = os.WriteFile(testFile, []byte(`{"tests": [{"name": "getUser", "dependsOn": ["createUser"], "request": {"method": "GET", "url": "/users/1"}}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ExecuteSuite(RunConfig{BaseUrl: server.URL}, testFile, true)
+	if err == nil || !strings.Contains(err.Error(), "depends on 'createUser', which isn't an earlier test case") {
+		t.Errorf("Expected unknown dependency to be rejected but got %v", err)
+	}
+}
+
+func TestSoftExpectations(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "soft.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}, "softAssert": ["response.body.id == 2"], "expectedResponse": {"statusCode": 200, "softFields": ["legacyId"], "body": {"id": 1, "legacyId": "usr_1", "name": "Jane"}}},
+		{"name": "getOtherUser", "request": {"method": "GET", "url": "/users/2"}, "expectedResponse": {"statusCode": 200, "softFields": ["legacyId"], "body": {"id": 2, "legacyId": "usr_1", "name": "Jane"}}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := MockHttpClient{
+		StatusCode: 200,
+		Body:       `{"id": 1, "legacyId": null, "name": "Jane"}`,
+	}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 1 || len(results.Failed) != 1 {
+		t.Fatalf("Expected only hard expectations to fail tests")
+	}
+	passed := results.Passed[0]
+	if len(passed.SoftFailures) != 2 || !strings.Contains(passed.SoftFailures[0], "Assertion failed: response.body.id == 2") || !strings.Contains(passed.SoftFailures[1], "map[legacyId]") {
+		t.Errorf("Expected soft assertion and soft field failures but got %v", passed.SoftFailures)
+	}
+	failed := results.Failed[0]
+	if len(failed.SoftFailures) != 1 || !strings.Contains(failed.SoftFailures[0], "map[legacyId]") {
+		t.Errorf("Expected soft field failure but got %v", failed.SoftFailures)
+	}
+	for _, err := range failed.Errors {
+		if strings.Contains(err, "map[legacyId]") {
+			t.Errorf("Expected soft field difference not to fail the test: %s", err)
+		}
+	}
+	if !strings.Contains(strings.Join(failed.Errors, "\n"), "map[id]") {
+		t.Errorf("Expected hard difference to fail the test but got %v", failed.Errors)
+	}
+}
+
+func TestAllowedDiffCount(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "diffs.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "tolerated", "allowedDiffCount": 2, "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": {"id": 1, "name": "John", "email": "john@example.com"}}},
+		{"name": "tooMany", "allowedDiffCount": 1, "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": {"id": 1, "name": "John", "email": "john@example.com"}}},
+		{"name": "runDefault", "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": {"id": 1, "name": "John", "email": "john@example.com"}}},
+		{"name": "strict", "allowedDiffCount": 0, "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": {"id": 1, "name": "John", "email": "jane@example.com"}}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := MockHttpClient{
+		StatusCode: 200,
+		Body:       `{"id": 1, "name": "Jane", "email": "jane@example.com"}`,
+	}
+	results, err := ExecuteSuite(RunConfig{BaseUrl: "", HttpClient: &mockClient, MaxDiffs: 2}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passed := make([]string, 0)
+	for _, result := range results.Passed {
+		passed = append(passed, result.Name)
+	}
+	if !reflect.DeepEqual(passed, []string{"tolerated", "runDefault"}) || len(results.Failed) != 2 {
+		t.Fatalf("Expected only tests within their allowed diff count to pass but got %v", passed)
+	}
+	tolerated := results.Passed[0]
+	if len(tolerated.SoftFailures) != 2 || !strings.Contains(tolerated.SoftFailures[0], "(tolerated, 2 of 2 allowed differences)") {
+		t.Errorf("Expected tolerated differences as soft failures but got %v", tolerated.SoftFailures)
+	}
+	if results.Failed[1].Name != "strict" || results.Failed[1].Category != FailureBodyDiff {
+		t.Errorf("Expected allowedDiffCount 0 to override the run's maxDiffs but got %v", results.Failed[1])
+	}
+
+	err = os.WriteFile(testFile, []byte(`{"tests": [{"name": "negative", "allowedDiffCount": -1, "request": {"method": "GET", "url": "/"}}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ExecuteSuite(RunConfig{BaseUrl: "", HttpClient: &mockClient}, testFile, true)
+	if err == nil || !strings.Contains(err.Error(), "negative allowedDiffCount") {
+		t.Errorf("Expected negative allowedDiffCount to be rejected but got %v", err)
+	}
+}
+
+func TestDeprecations(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "deprecations.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "listUsers", "request": {"method": "GET", "url": "/users"}, "expectedResponse": {"statusCode": 200}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := MockHttpClient{
+		StatusCode: 200,
+		Body:       `{"items": [{"id": 1, "legacyId": "usr_1", "status": "active"}, {"id": 2, "oldStatus": "active"}]}`,
+	}
+	config := RunConfig{
+		BaseUrl:      "",
+		HttpClient:   &mockClient,
+		Deprecations: &DeprecationConfig{Fields: []string{"items.*.legacyId", "items.*.oldStatus"}},
+	}
+	results, err := ExecuteSuite(config, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 1 {
+		t.Fatalf("Expected deprecated fields not to fail the test")
+	}
+	passed := results.Passed[0]
+	if strings.Join(passed.DeprecatedFields, ",") != "items.0.legacyId,items.1.oldStatus" {
+		t.Errorf("Expected deprecated fields to be tracked but got %v", passed.DeprecatedFields)
+	}
+	if len(passed.SoftFailures) != 2 || !strings.Contains(passed.SoftFailures[0], "deprecated field 'items.0.legacyId'") {
+		t.Errorf("Expected deprecated fields to be soft failures but got %v", passed.SoftFailures)
+	}
+
+	config.Deprecations.Fail = true
+	results, err = ExecuteSuite(config, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Failed) != 1 || !strings.Contains(strings.Join(results.Failed[0].Errors, "\n"), "deprecated field 'items.1.oldStatus'") {
+		t.Errorf("Expected deprecated fields to fail the test")
+	}
+}
+
+func TestStubServer(t *testing.T) {
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl: "",
+		Stubs: &StubsConfig{
+			Endpoints: []StubEndpoint{
+				{
+					Method:   "GET",
+					Path:     "/ping",
+					Response: StubResponse{Body: map[string]interface{}{"pong": true}},
+				},
+			},
+		},
+	}, "stubs.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 2 {
+		t.Errorf("All tests should have passed.\n")
+	}
+	if len(results.Failed) > 0 {
+		for _, test := range results.Failed {
+			t.Errorf("Failed test result: [%s]\n", test.Result())
+		}
+	}
+}
+
+func TestExpectedCallback(t *testing.T) {
+	// Accepts subscriptions and asynchronously delivers a webhook for the subscribed event to the callback url
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var subscription struct {
+			Event       string `json:"event"`
+			CallbackUrl string `json:"callbackUrl"`
+		}
+		json.NewDecoder(r.Body).Decode(&subscription)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			req, _ := http.NewRequest("POST", subscription.CallbackUrl, strings.NewReader(fmt.Sprintf(`{"event":"%s","id":"evt_1"}`, subscription.Event)))
+			req.Header.Set("X-Event", subscription.Event)
+			resp, err := http.DefaultClient.Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl: server.URL,
+		Stubs:   &StubsConfig{},
+	}, "callbacks.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 1 || len(results.Failed) != 1 {
+		t.Errorf("Expected 1 Passed, 1 Failed.")
+	}
+	if len(results.Failed) > 0 && !strings.Contains(results.Failed[0].Result(), "Expected callback POST /webhooks/users not received within 200ms") {
+		t.Errorf("Expected failure result to contain string: 'Expected callback POST /webhooks/users not received within 200ms'")
+	}
+}
+
+// HttpClient that retries requests failing with an error or a 5xx status code
+type RetryingHttpClient struct {
+	Client   HttpClient
+	Attempts int
+}
+
+func (c *RetryingHttpClient) Do(req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	for i := 0; i < 3; i++ {
+		c.Attempts++
+		if i > 0 && req.GetBody != nil {
+			req.Body, _ = req.GetBody()
+		}
+		resp, err = c.Client.Do(req)
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+	}
+	return resp, err
+}
+
+func TestFaultInjection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(faultIdHeader) != "" {
+			t.Errorf("Expected fault id header not to be forwarded")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"path":"%s","query":"%s"}`, r.URL.Path, r.URL.RawQuery)
+	}))
+	defer server.Close()
+
+	retryingClient := RetryingHttpClient{Client: &http.Client{}}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    server.URL,
+		HttpClient: &retryingClient,
+	}, "chaos.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 3 || len(results.Failed) != 1 {
+		t.Errorf("Expected 3 Passed, 1 Failed.")
+	}
+	for _, test := range results.Passed {
+		if test.Name == "slowResponse" && test.Duration < 50*time.Millisecond {
+			t.Errorf("Expected injected latency of 50ms but test took %s", test.Duration)
+		}
+	}
+	if len(results.Failed) > 0 && !strings.Contains(results.Failed[0].Result(), "Error making request") {
+		t.Errorf("Expected failure result to contain string: 'Error making request'")
+	}
+	// 3 for serviceUnavailable, 3 for recoversAfterRetries and 3 for connectionReset
+	if retryingClient.Attempts != 10 {
+		t.Errorf("Expected 10 attempts but got %d", retryingClient.Attempts)
+	}
+}
+
+func TestFaultInjectionTarget(t *testing.T) {
+	signing := SigningConfig{Header: "X-Signature", Key: "secret", StringToSign: "{{ request.url }}"}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+		} else if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "s1" {
+			t.Errorf("Expected session cookie set by the target to be sent to it through the proxy")
+		}
+		targetReq, _ := http.NewRequest(r.Method, server.URL+r.URL.RequestURI(), nil)
+		if err := signing.sign(targetReq); err != nil || r.Header.Get("X-Signature") != targetReq.Header.Get("X-Signature") {
+			t.Errorf("Expected the target url to be signed")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	testFile := filepath.Join(t.TempDir(), "chaostarget.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "login", "fault": {"latencyMs": 1}, "request": {"method": "POST", "url": "/login"}, "expectedResponse": {"statusCode": 204}},
+		{"name": "getUser", "fault": {"latencyMs": 1}, "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 204}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl: server.URL,
+		Signing: &signing,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 2 {
+		t.Errorf("All tests should have passed.")
+	}
+	for _, result := range results.Passed {
+		if !strings.HasPrefix(result.RequestUrl, server.URL) {
+			t.Errorf("Expected request url of %s to be the target's but got %s", result.Name, result.RequestUrl)
+		}
+	}
+}
+
+func TestExecSteps(t *testing.T) {
+	mockClient := EchoRequestHttpClient{}
+	mockClient.StatusCode = 200
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		HttpClient: &mockClient,
+	}, "exec.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 3 || len(results.Failed) != 1 {
+		t.Errorf("Expected 3 Passed, 1 Failed.")
+	}
+	if len(results.Failed) > 0 && !strings.Contains(results.Failed[0].Result(), "Expected exit code 0 but got 3") {
+		t.Errorf("Expected failure result to contain string: 'Expected exit code 0 but got 3'")
+	}
+	if len(results.Failed) > 0 && !strings.Contains(results.Failed[0].Result(), "stderr: oops") {
+		t.Errorf("Expected failure result to contain string: 'stderr: oops'")
+	}
+}
+
+func TestTestTime(t *testing.T) {
+	mockClient := EchoRequestHttpClient{}
+	mockClient.StatusCode = 200
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:    "",
+		TestTime:   &TestTimeConfig{Value: "2030-01-01T00:00:00Z"},
+		HttpClient: &mockClient,
+	}, "testtime.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 3 || len(results.Failed) != 1 {
+		t.Errorf("Expected 3 Passed, 1 Failed.")
+	}
+	if len(results.Failed) > 0 && !strings.Contains(results.Failed[0].Result(), "invalid testTime 'tomorrow'") {
+		t.Errorf("Expected failure result to contain string: 'invalid testTime 'tomorrow''")
+	}
+}
+
+func TestPortForward(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"path":"%s"}`, r.URL.Path)
+	}))
+	defer server.Close()
+
+	// Fake kubectl that "forwards" to the test server
+	kubectl := filepath.Join(t.TempDir(), "kubectl")
+	script := fmt.Sprintf(`#!/bin/sh
+[ "$*" = "port-forward --namespace api svc/users :8080" ] || { echo "unexpected args: $*" >&2; exit 1; }
+echo "Forwarding from 127.0.0.1:%d -> 8080"
+exec sleep 60
+`, server.Listener.Addr().(*net.TCPAddr).Port)
+	err := os.WriteFile(kubectl, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := ExecuteSuite(RunConfig{
+		PortForward: &PortForwardConfig{
+			Kubectl:   kubectl,
+			Namespace: "api",
+			Service:   "users",
+			Port:      8080,
+			BasePath:  "/v1",
+		},
+	}, "portforward.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Passed) != 1 {
+		t.Errorf("All tests should have passed.\n")
+	}
+	for _, test := range results.Failed {
+		t.Errorf("Failed test result: [%s]\n", test.Result())
+	}
+
+	_, err = ExecuteSuite(RunConfig{
+		PortForward: &PortForwardConfig{
+			Kubectl:   kubectl,
+			Namespace: "other",
+			Service:   "users",
+			Port:      8080,
+		},
+	}, "portforward.json", true)
+	if err == nil || !strings.Contains(err.Error(), "unexpected args") {
+		t.Errorf("Expected port-forward error containing 'unexpected args' but got %v", err)
+	}
+}
+
+func TestPactGeneration(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "{ \"id\": 1, \"name\": \"name\" }"
+	mockClient.Header = map[string][]string{"Content-Type": {"application/json"}}
+	pactDir := t.TempDir()
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:       "",
+		CustomHeaders: map[string]string{"Authorization": "Bearer secret"},
+		Pact:          &PactConfig{Consumer: "web", Provider: "users api", Dir: pactDir},
+		HttpClient:    &mockClient,
+	}, "basicresponse.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Passed) != 2 {
+		t.Errorf("All tests should have passed.\n")
+	}
+
+	contents, err := os.ReadFile(filepath.Join(pactDir, "web-users_api.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pact pactFile
+	err = json.Unmarshal(contents, &pact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pact.Consumer.Name != "web" || pact.Provider.Name != "users api" {
+		t.Errorf("Expected consumer 'web' and provider 'users api' but got '%s' and '%s'", pact.Consumer.Name, pact.Provider.Name)
+	}
+	if len(pact.Interactions) != 2 {
+		t.Fatalf("Expected 2 interactions but got %d", len(pact.Interactions))
+	}
+	interaction := pact.Interactions[1]
+	if interaction.Description != "basicresponse basicStringBody" || interaction.Request.Method != "POST" || interaction.Request.Path != "/user" || interaction.Request.Body != "name" {
+		t.Errorf("Unexpected request in interaction: %+v", interaction)
+	}
+	if _, ok := interaction.Request.Headers["Authorization"]; ok {
+		t.Errorf("Expected custom headers not to be recorded")
+	}
+	if interaction.Response.Status != 200 || interaction.Response.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Unexpected response in interaction: %+v", interaction.Response)
+	}
+}
+
+func TestExportK6(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "apirunner.conf")
+	err := os.WriteFile(configFile, []byte(`{"baseUrl": "http://localhost:8000", "headers": {"Authorization": "Bearer token"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var script strings.Builder
+	err = ExportK6(configFile, ".", regexp.MustCompile(`^(templatevars|exec)\.json$`), &script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLines := []string{
+		`const BASE_URL = __ENV.BASE_URL || "http://localhost:8000";`,
+		`const HEADERS = {"Authorization":"Bearer token"};`,
+		`group("templatevars.json", function () {`,
+		`res = http.request("GET", BASE_URL + tpl("/users/{{ test1.userIdWrongVar }}", vars), tpl("{}", vars), { headers: HEADERS });`,
+		`res = http.request("POST", BASE_URL + tpl("/users", vars), tpl("{\"userId\":\"{{ seed.userId }}\"}", vars), { headers: HEADERS });`,
+		`check(res, { "test2 status is 200": (r) => r.status === 200 });`,
+		`memoize(vars, "test1", res);`,
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(script.String(), line) {
+			t.Errorf("Expected k6 script to contain '%s'", line)
+		}
+	}
+	if strings.Contains(script.String(), "flushCache") {
+		t.Errorf("Expected exec steps to be left out of k6 script")
+	}
+}
+
+func TestExportPostman(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "apirunner.conf")
+	err := os.WriteFile(configFile, []byte(`{"baseUrl": "http://localhost:8000", "headers": {"Authorization": "Bearer token"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	err = ExportPostman(configFile, ".", regexp.MustCompile(`^(templatetransforms|contenttype)\.json$`), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var collection postmanCollection
+	err = json.Unmarshal([]byte(out.String()), &collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collection.Info.Schema != postmanSchema || len(collection.Event) != 1 {
+		t.Errorf("Expected a v2.1 collection with a collection-level test script")
+	}
+	if len(collection.Variable) != 2 || collection.Variable[0] != (postmanKeyValue{"baseUrl", "http://localhost:8000"}) || collection.Variable[1] != (postmanKeyValue{"header.Authorization", "Bearer token"}) {
+		t.Errorf("Unexpected collection variables: %+v", collection.Variable)
+	}
+	if len(collection.Item) != 2 || collection.Item[1].Name != "templatetransforms.json" {
+		t.Fatalf("Expected a folder per suite")
+	}
+	getUser := collection.Item[1].Item[1]
+	if getUser.Name != "getUser" || getUser.Request.Url.Raw != "{{baseUrl}}/users?email={{createUser.email}}" {
+		t.Errorf("Unexpected request: %+v", getUser.Request)
+	}
+	if len(getUser.Request.Header) == 0 || getUser.Request.Header[0] != (postmanKeyValue{"Authorization", "{{header.Authorization}}"}) {
+		t.Errorf("Expected custom headers to reference collection variables")
+	}
+	if len(getUser.Event) != 1 || !strings.Contains(getUser.Event[0].Script.Exec[0], "pm.response.to.have.status(200)") {
+		t.Errorf("Expected status check in test script")
+	}
+}
+
+func TestInsomniaRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "apirunner.conf")
+	err := os.WriteFile(configFile, []byte(`{"baseUrl": "http://localhost:8000", "headers": {"Authorization": "Bearer token"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportFile, err := os.Create(filepath.Join(dir, "insomnia.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ExportInsomnia(configFile, ".", regexp.MustCompile(`^templatetransforms\.json$`), exportFile)
+	exportFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := ImportInsomnia(exportFile.Name(), filepath.Join(dir, "tests"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || filepath.Base(written[0]) != "templatetransforms.json" {
+		t.Fatalf("Expected templatetransforms.json to be written but got %v", written)
+	}
+	original, _ := loadSuiteSpec(nil, "templatetransforms.json")
+	imported, err := loadSuiteSpec(nil, written[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Tests) != len(original.Tests) {
+		t.Fatalf("Expected %d tests but got %d", len(original.Tests), len(imported.Tests))
+	}
+	for i, test := range imported.Tests {
+		if test.Name != original.Tests[i].Name || test.Request.Method != original.Tests[i].Request.Method || test.Request.Url != original.Tests[i].Request.Url {
+			t.Errorf("Expected request %+v but got %+v", original.Tests[i].Request, test.Request)
+		}
+		if _, ok := test.Request.Headers["Authorization"]; ok {
+			t.Errorf("Expected custom headers not to be imported")
+		}
+	}
+
+	// Existing files are never overwritten
+	_, err = ImportInsomnia(exportFile.Name(), filepath.Join(dir, "tests"))
+	if err == nil {
+		t.Errorf("Expected error importing over existing test file")
+	}
+}
+
+func TestImportInsomnia(t *testing.T) {
+	dir := t.TempDir()
+	exportFile := filepath.Join(dir, "insomnia.json")
+	err := os.WriteFile(exportFile, []byte(`{
+		"_type": "export",
+		"__export_format": 4,
+		"resources": [
+			{"_id": "wrk_1", "_type": "workspace", "name": "My Workspace"},
+			{"_id": "req_1", "_type": "request", "parentId": "wrk_1", "name": "Create user", "method": "post", "url": "https://api.example.com/users?source={{ _.source }}",
+				"body": {"mimeType": "application/json", "text": "{\"email\": \"{{ _.email }}\"}"},
+				"headers": [{"name": "X-Tenant", "value": "acme"}, {"name": "X-Debug", "value": "1", "disabled": true}]},
+			{"_id": "req_2", "_type": "request", "parentId": "wrk_1", "name": "Create user", "method": "GET", "url": "{{_.base_url}}/health"}
+		]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := ImportInsomnia(exportFile, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || filepath.Base(written[0]) != "my_workspace.json" {
+		t.Fatalf("Expected my_workspace.json to be written but got %v", written)
+	}
+	suiteSpec, err := loadSuiteSpec(nil, written[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	createUser := suiteSpec.Tests[0].Request
+	if suiteSpec.Tests[0].Name != "createUser" || createUser.Method != "POST" || createUser.BaseUrl != "https://api.example.com" || createUser.Url != "/users?source={{ source }}" {
+		t.Errorf("Unexpected request: %+v", createUser)
+	}
+	if body, ok := createUser.Body.(map[string]interface{}); !ok || body["email"] != "{{ email }}" {
+		t.Errorf("Expected JSON body with templated email but got %v", createUser.Body)
+	}
+	if len(createUser.Headers) != 1 || createUser.Headers["X-Tenant"] != "acme" {
+		t.Errorf("Expected only enabled headers but got %v", createUser.Headers)
+	}
+	if suiteSpec.Tests[1].Name != "createUser2" || suiteSpec.Tests[1].Request.Url != "/health" || suiteSpec.Tests[1].Request.BaseUrl != "" {
+		t.Errorf("Unexpected request: %+v", suiteSpec.Tests[1])
+	}
+}
+
+func TestOpenApiExamples(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "{\"id\": \"user_1\", \"name\": \"Alice\"}"
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:     "",
+		OpenApiSpec: "openapispec.json",
+		HttpClient:  &mockClient,
+	}, "openapiexamples.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 2 || len(results.Failed) != 3 {
+		t.Fatalf("Expected 2 Passed, 3 Failed.")
+	}
+	if !strings.Contains(results.Failed[0].Result(), "Expected http 404 but got http 200") {
+		t.Errorf("Expected failure result to contain string: 'Expected http 404 but got http 200'")
+	}
+	if !strings.Contains(results.Failed[1].Result(), "Spec example 'listUsers_200' is an array but the response payload is an object") || results.Failed[1].Category != FailureBodyDiff {
+		t.Errorf("Expected failure result to contain string: 'Spec example 'listUsers_200' is an array but the response payload is an object'")
+	}
+	if !strings.Contains(results.Failed[2].Result(), "no example 'getUser_500' in openApiSpec") {
+		t.Errorf("Expected failure result to contain string: 'no example 'getUser_500' in openApiSpec'")
+	}
+}
+
+func TestBench(t *testing.T) {
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.Method+" "+r.URL.Path]++
+		n := requests[r.Method+" "+r.URL.Path]
+		mutex.Unlock()
+		if r.Method == "GET" && n%4 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprint(w, `{"id": "user_1"}`)
+	}))
+	defer server.Close()
+	configFile := filepath.Join(t.TempDir(), "apirunner.conf")
+	err := os.WriteFile(configFile, []byte(fmt.Sprintf(`{"baseUrl": "%s"}`, server.URL)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Bench(configFile, ".", regexp.MustCompile(`^bench\.json$`), BenchOptions{Test: "GetUser", Requests: 20, Concurrency: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests["POST /users"] != 1 || requests["GET /users/user_1"] != 20 {
+		t.Errorf("Expected 1 POST and 20 GET requests but got %v", requests)
+	}
+	if result.Test != "getUser" || result.Requests != 20 || len(result.Durations) != 20 {
+		t.Errorf("Expected 20 executions of getUser")
+	}
+	if result.Failed != 5 || result.ErrorRate() != 0.25 || result.Errors["Expected http 200 but got http 500"] != 5 {
+		t.Errorf("Expected 5 failures but got %d: %v", result.Failed, result.Errors)
+	}
+	if result.Percentile(50) > result.Percentile(99) || result.Percentile(100) != result.Durations[19] {
+		t.Errorf("Expected percentiles to be ordered")
+	}
+
+	_, err = Bench(configFile, ".", regexp.MustCompile(`^bench\.json$`), BenchOptions{Test: "deleteUser"})
+	if err == nil || !strings.Contains(err.Error(), "no test named 'deleteUser' found") {
+		t.Errorf("Expected error for unknown test but got %v", err)
+	}
+}
+
+func TestSoak(t *testing.T) {
+	var mutex sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests++
+		n := requests
+		mutex.Unlock()
+		if n%5 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprint(w, `{"id": "user_1"}`)
+	}))
+	defer server.Close()
+	configFile := filepath.Join(t.TempDir(), "apirunner.conf")
+	err := os.WriteFile(configFile, []byte(fmt.Sprintf(`{"baseUrl": "%s"}`, server.URL)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Soak(configFile, ".", regexp.MustCompile(`^bench\.json$`), SoakOptions{
+		Duration:      50 * time.Millisecond,
+		FailureBudget: 0.1,
+		Interval:      5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Iterations < 2 || result.Total != 2*result.Iterations || result.Total != requests {
+		t.Errorf("Expected both tests to run every iteration but got %d tests in %d iterations", result.Total, result.Iterations)
+	}
+	if result.Failed != requests/5 || result.WithinBudget() {
+		t.Errorf("Expected 1 in 5 tests to fail, exceeding the 10%% budget, but got %d/%d", result.Failed, result.Total)
+	}
+	if result.FailuresByTest["bench.json: createUser"]+result.FailuresByTest["bench.json: getUser"] != result.Failed {
+		t.Errorf("Expected failures to be tracked per test")
+	}
+}
+
+// Writes 'files' (contents by slash-separated path) to a new temporary directory and returns it
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filename, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestInvalidTestFilesReportedUpFront(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf":      fmt.Sprintf(`{"baseUrl": "%s"}`, server.URL),
+		"1valid.json":         `{"tests": [{"name": "getUsers", "request": {"method": "GET", "url": "/users"}}]}`,
+		"2invalidjson.json":   `{"tests": [`,
+		"3invalidignore.json": `{"ignoredFields": ["user..id"], "tests": []}`,
+		"4invalidassert.json": `{"tests": [{"name": "getUsers", "request": {"method": "GET", "url": "/users"}, "assert": ["response.status =="]}]}`,
+	})
+
+	passed, err := Run(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(".*"))
+	if passed || err == nil || err.Error() != "3 invalid test file(s)" {
+		t.Errorf("Expected error for 3 invalid test files but got %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("Expected no requests to be made but got %d", requests)
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	var actual, expected interface{}
+	json.Unmarshal([]byte(`{"id": "user_1", "age": "30", "roles": [{"name": "admin"}], "extra": null}`), &actual)
+	json.Unmarshal([]byte(`{"id": "user_2", "age": 30, "roles": [{"name": "admin"}, {"name": "member"}], "email": "a@b.c"}`), &expected)
+
+	diffs := diffValues(actual, expected, "")
+	expectedDiffs := []Difference{
+		{Path: "map[age]", Kind: DifferenceType, Expected: float64(30), Actual: "30"},
+		{Path: "map[email]", Kind: DifferenceMissing, Expected: "a@b.c"},
+		{Path: "map[extra]", Kind: DifferenceUnexpected},
+		{Path: "map[id]", Kind: DifferenceChanged, Expected: "user_2", Actual: "user_1"},
+		{Path: "map[roles].slice[1]", Kind: DifferenceMissing, Expected: map[string]interface{}{"name": "member"}},
+	}
+	if !reflect.DeepEqual(diffs, expectedDiffs) {
+		t.Errorf("Expected diffs %v but got %v", expectedDiffs, diffs)
+	}
+	if diffs[0].String() != "map[age]: expected number 30 but got string 30" {
+		t.Errorf("Unexpected description of type difference: %s", diffs[0])
+	}
+	if diffs[2].String() != "map[extra]: field is null but expected it to be absent" {
+		t.Errorf("Unexpected description of unexpected null field: %s", diffs[2])
+	}
+}
+
+func TestDeterministicDifferences(t *testing.T) {
+	diffs := []Difference{
+		{Path: "map[items].slice[10].map[id]"},
+		{Path: "map[name]"},
+		{Path: "map[items].slice[2]"},
+		{Path: "map[items].slice[2].map[id]"},
+		{Path: "map[email]"},
+	}
+	sortDifferences(diffs)
+	paths := make([]string, 0)
+	for _, diff := range diffs {
+		paths = append(paths, diff.Path)
+	}
+	expectedPaths := []string{"map[email]", "map[items].slice[2]", "map[items].slice[2].map[id]", "map[items].slice[10].map[id]", "map[name]"}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("Expected diffs ordered by path %v but got %v", expectedPaths, paths)
+	}
+
+	testFile := filepath.Join(t.TempDir(), "differences.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": {"a": "{{ nonEmpty }}", "b": 2, "c": "{{ nonEmpty }}", "d": 4, "e": "{{ nonEmpty }}", "f": 6}}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockClient := MockHttpClient{
+		StatusCode: 200,
+		Body:       `{"a": "", "b": 0, "c": "", "d": 0, "e": "", "f": 0}`,
+	}
+	var firstErrors []string
+	for i := 0; i < 20; i++ {
+		results, err := ExecuteSuite(RunConfig{BaseUrl: "", HttpClient: &mockClient}, testFile, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results.Failed) != 1 || len(results.Failed[0].Errors) < 6 {
+			t.Fatalf("Expected a failure for each of the 6 fields but got %v", results.Failed)
+		}
+		errs := results.Failed[0].Errors
+		if firstErrors == nil {
+			firstErrors = errs
+			for j, field := range []string{"a", "b", "c", "d", "e", "f"} {
+				if !strings.Contains(errs[j], "map["+field+"]") {
+					t.Fatalf("Expected matcher and value differences ordered by field but got %v", errs)
+				}
+			}
+		} else if !reflect.DeepEqual(errs, firstErrors) {
+			t.Fatalf("Expected the same failure messages on every run but got %v and %v", firstErrors, errs)
+		}
+	}
+}
+
+func TestSuiteTimeout(t *testing.T) {
+	release := make(chan struct{})
+	aborted := make(chan struct{}, 3)
+	var mutex sync.Mutex
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requested = append(requested, r.URL.Path)
+		mutex.Unlock()
+		if r.URL.Path == "/hang" {
+			// Reading the body lets the server notice the client closing the connection
+			io.ReadAll(r.Body)
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				aborted <- struct{}{}
+			}
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	dir := writeTestFiles(t, map[string]string{
+		"1hang.json": `{"tests": [{"name": "hang", "request": {"method": "GET", "url": "/hang"}}, {"name": "afterHang", "request": {"method": "GET", "url": "/after"}}]}`,
+		"2next.json": `{"tests": [{"name": "next", "request": {"method": "GET", "url": "/next"}}]}`,
+	})
+
+	compiled, err := compileSuite(nil, filepath.Join(dir, "1hang.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _ := executeCompiledSuite(RunConfig{BaseUrl: server.URL, Timeouts: &TimeoutConfig{SuiteMs: 200}}, compiled, true)
+	if !result.TimedOut || len(result.Failed) != 1 || len(result.Skipped) != 1 || !strings.Contains(result.Failed[0].Result(), "on hang (suite timeout of 200ms exceeded)") {
+		t.Errorf("Expected hung test to fail and the remaining test to be skipped: %v", result)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the hung test's request to be aborted at the suite's deadline")
+	}
+
+	for _, onTimeout := range []string{"continue", "exit"} {
+		config := fmt.Sprintf(`{"baseUrl": "%s", "timeouts": {"suiteMs": 200, "onTimeout": "%s"}}`, server.URL, onTimeout)
+		err := os.WriteFile(filepath.Join(dir, "apirunner.conf"), []byte(config), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mutex.Lock()
+		requested = make([]string, 0)
+		mutex.Unlock()
+		passed, err := Run(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`^\d.*`))
+		if passed || err != nil {
+			t.Errorf("Expected run to fail after timeout but got %v, %v", passed, err)
+		}
+		mutex.Lock()
+		nextRequested := slices.Contains(requested, "/next")
+		mutex.Unlock()
+		if nextRequested != (onTimeout == "continue") {
+			t.Errorf("Expected next suite to be run only if onTimeout is 'continue' (%s), requested %v", onTimeout, requested)
+		}
+	}
+}
+
+func TestSuiteTimeoutDiscardsDeletedVars(t *testing.T) {
+	var mutex sync.Mutex
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requested = append(requested, r.URL.Path)
+		mutex.Unlock()
+		if r.URL.Path == "/flaky/2" {
+			// Fail the second request without a response
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"vars.json": `{"tests": [{"name": "flaky", "repeat": 2, "request": {"method": "GET", "url": "/flaky/{{ iteration }}"}}, {"name": "check", "request": {"method": "GET", "url": "/check/{{ flaky.response.status }}"}}]}`,
+	})
+
+	compiled, err := compileSuite(nil, filepath.Join(dir, "vars.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _ := executeCompiledSuite(RunConfig{BaseUrl: server.URL, Timeouts: &TimeoutConfig{SuiteMs: 5000}}, compiled, true)
+	if result.TimedOut || len(result.Passed) != 1 || len(result.Failed) != 2 {
+		t.Errorf("Expected only the first iteration of flaky to pass: %v", result)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if slices.Contains(requested, "/check/200") {
+		t.Errorf("Expected the response of the failed iteration of flaky to be discarded, requested %v", requested)
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requested = append(requested, r.URL.Path)
+		mutex.Unlock()
+		if r.URL.Path == "/hang" {
+			// Cancel the run while the request is in flight, which should abort it
+			cancel()
+			<-release
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s"}`, server.URL),
+		"1hang.json":     `{"tests": [{"name": "hang", "request": {"method": "GET", "url": "/hang"}}, {"name": "afterHang", "request": {"method": "GET", "url": "/after"}}]}`,
+		"2next.json":     `{"tests": [{"name": "next", "request": {"method": "GET", "url": "/next"}}]}`,
+	})
+
+	var output strings.Builder
+	passed, err := RunWithContext(ctx, filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{Output: &output})
+	if passed || err != nil {
+		t.Errorf("Expected canceled run to fail but got %v, %v", passed, err)
+	}
+	mutex.Lock()
+	if !slices.Equal(requested, []string{"/hang"}) {
+		t.Errorf("Expected no requests after the run was canceled but got %v", requested)
+	}
+	mutex.Unlock()
+	for _, expected := range []string{"Error making request", "Not running the remaining 1 test file(s): run canceled", "Failed: 1\nSkipped: 1", "Run canceled, context canceled"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected output to contain '%s' but got %s", expected, output.String())
+		}
+	}
+
+	result, err := ExecuteSuiteWithContext(ctx, RunConfig{BaseUrl: server.URL, Output: io.Discard}, filepath.Join(dir, "2next.json"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0].SkipReason != "run canceled" {
+		t.Errorf("Expected tests of an already canceled run to be skipped but got %v", result)
+	}
+}
+
+func TestRequestBudget(t *testing.T) {
+	var mutex sync.Mutex
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requested = append(requested, r.Method+" "+r.URL.Path)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"1users.json":  `{"tests": [{"name": "deleteUser1", "request": {"method": "DELETE", "url": "/users/1"}}, {"name": "deleteUser2", "request": {"method": "DELETE", "url": "/users/2"}}, {"name": "getUser", "request": {"method": "GET", "url": "/users/3"}}]}`,
+		"2orders.json": `{"tests": [{"name": "getOrder", "request": {"method": "GET", "url": "/orders/1"}}, {"name": "purgeOrders", "metadata": {"destructive": "true"}, "request": {"method": "POST", "url": "/orders/purge"}}]}`,
+	})
+	run := func(budget string, options RunOptions) ([]string, bool) {
+		config := fmt.Sprintf(`{"baseUrl": "%s", "budget": %s}`, server.URL, budget)
+		err := os.WriteFile(filepath.Join(dir, "apirunner.conf"), []byte(config), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mutex.Lock()
+		requested = make([]string, 0)
+		mutex.Unlock()
+		passed, err := RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`^\d.*`), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		return requested, passed
+	}
+
+	// The second DELETE exceeds the budget, aborting the run
+	requests, passed := run(`{"maxDestructiveRequests": 1}`, RunOptions{})
+	if passed || !slices.Equal(requests, []string{"DELETE /users/1"}) {
+		t.Errorf("Expected run to be aborted after the first destructive request but got %v, %v", passed, requests)
+	}
+	requests, passed = run(`{"maxRequests": 4}`, RunOptions{})
+	if passed || len(requests) != 4 {
+		t.Errorf("Expected run to be aborted after 4 requests but got %v, %v", passed, requests)
+	}
+	requests, passed = run(`{"maxRequests": 5, "destructiveMethods": ["POST"], "maxDestructiveRequests": 1}`, RunOptions{})
+	if !passed || len(requests) != 5 {
+		t.Errorf("Expected run within budget to pass but got %v, %v", passed, requests)
+	}
+	// Untagged DELETEs are refused (failing their tests) rather than skipped
+	requests, passed = run(`{}`, RunOptions{ReadOnly: true})
+	if passed || !slices.Equal(requests, []string{"GET /users/3", "GET /orders/1"}) {
+		t.Errorf("Expected destructive test to be skipped in read-only run but got %v, %v", passed, requests)
+	}
+
+	compiled, err := compileSuite(nil, filepath.Join(dir, "1users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _ := executeCompiledSuite(RunConfig{BaseUrl: server.URL, Budget: &BudgetConfig{MaxRequests: 1}}, compiled, true)
+	if len(result.Failed) != 1 || !strings.Contains(result.Failed[0].Result(), "request budget exceeded: run is limited to 1 request(s)") || len(result.Skipped) != 1 || result.Skipped[0].SkipReason != "request budget exceeded" {
+		t.Errorf("Expected test over budget to fail and the remaining test to be skipped: %v", result)
+	}
+}
+
+func TestRequestBudgetTokenRefresh(t *testing.T) {
+	var mutex sync.Mutex
+	requested := make([]string, 0)
+	tokens := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requested = append(requested, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/token" {
+			tokens++
+			fmt.Fprintf(w, `{"access_token": "token%d"}`, tokens)
+			return
+		}
+		// The first token is rejected
+		if r.Header.Get("Authorization") != "Bearer token2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"users.json": `{"tests": [{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}}]}`,
+	})
+	run := func(maxRequests int) ([]string, TestSuiteResult) {
+		mutex.Lock()
+		requested = make([]string, 0)
+		tokens = 0
+		mutex.Unlock()
+		results, err := ExecuteSuite(RunConfig{
+			BaseUrl:    server.URL,
+			HttpClient: http.DefaultClient,
+			Auth:       &TokenAuthConfig{TokenUrl: server.URL + "/token"},
+			Budget:     &BudgetConfig{MaxRequests: maxRequests},
+		}, filepath.Join(dir, "users.json"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		return requested, results
+	}
+
+	// The token requests and the retry with the refreshed token count against the budget
+	requests, results := run(4)
+	if len(results.Passed) != 1 || !slices.Equal(requests, []string{"POST /token", "GET /users/1", "POST /token", "GET /users/1"}) {
+		t.Errorf("Expected test to pass after refreshing its token within budget but got %v, %v", requests, results)
+	}
+	requests, results = run(3)
+	if len(results.Failed) != 1 || !strings.Contains(results.Failed[0].Result(), "request budget exceeded: run is limited to 3 request(s)") || len(requests) != 3 {
+		t.Errorf("Expected the retry with the refreshed token to exceed the budget but got %v, %v", requests, results)
+	}
+	requests, results = run(2)
+	if len(results.Failed) != 1 || !strings.Contains(results.Failed[0].Result(), "request budget exceeded: run is limited to 2 request(s)") || len(requests) != 2 {
+		t.Errorf("Expected the token refresh to exceed the budget but got %v, %v", requests, results)
+	}
+}
+
+func TestProtectedUrls(t *testing.T) {
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	testFile := filepath.Join(t.TempDir(), "protected.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}},
+		{"name": "createUser", "request": {"method": "post", "url": "/users", "body": {"name": "smoke"}}},
+		{"name": "createSession", "allowUnsafeMethods": true, "request": {"method": "POST", "url": "/sessions"}},
+		{"name": "createStagingUser", "request": {"method": "POST", "baseUrl": "https://staging.example.com", "url": "/users"}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl:       "https://api.example.com",
+		ProtectedUrls: []string{`^https://api\.example\.com/`},
+		HttpClient:    &mockClient,
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Failed) != 1 || results.Failed[0].Name != "createUser" || !strings.Contains(results.Failed[0].Result(), "refusing to send post to protected url https://api.example.com/users") {
+		t.Errorf("Expected only the unsafe request to the protected url to be refused but got %v", results.Failed)
+	}
+	requested := make([]string, 0)
+	for _, req := range mockClient.Requests {
+		requested = append(requested, req.URL.String())
+	}
+	if !slices.Equal(requested, []string{"https://api.example.com/users/1", "https://api.example.com/sessions", "https://staging.example.com/users"}) {
+		t.Errorf("Expected refused request not to be sent but got %v", requested)
+	}
+
+	_, err = ExecuteSuite(RunConfig{ProtectedUrls: []string{`(`}, HttpClient: &mockClient}, testFile, true)
+	if err == nil || !strings.Contains(err.Error(), "invalid protectedUrls pattern '('") {
+		t.Errorf("Expected invalid pattern to be rejected but got %v", err)
+	}
+}
+
+func TestExpectedResponseOverrides(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = `{"id": "1", "betaEnabled": false}`
+	mockClient.Header = map[string][]string{"X-Region": {"us-east-1"}, "X-Flags": {"stable"}}
+	testFile := filepath.Join(t.TempDir(), "overrides.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [{
+		"name": "getFlags",
+		"request": {"method": "GET", "url": "/flags"},
+		"expectedResponse": {"statusCode": 200, "headers": {"X-Region": "us-east-1", "X-Flags": "beta"}, "body": {"id": "1", "betaEnabled": true}},
+		"expectedResponseOverrides": {"prod": {"headers": {"X-Flags": "stable"}, "body": {"id": "1", "betaEnabled": false}}}
+	}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for profile, shouldPass := range map[string]bool{"prod": true, "staging": false, "": false} {
+		results, err := ExecuteSuite(RunConfig{Profile: profile, HttpClient: &mockClient}, testFile, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(results.Passed) == 1) != shouldPass {
+			t.Errorf("Expected test to pass only with the prod overrides (profile '%s') but got %v", profile, results.Failed)
+		}
+	}
+
+	merged := ExpectedResponse{StatusCode: 200, BodyFile: "user.json", Headers: map[string]string{"A": "1"}}.merge(ExpectedResponse{Body: map[string]interface{}{}, Headers: map[string]string{"B": "2"}})
+	if merged.StatusCode != 200 || merged.BodyFile != "" || merged.Body == nil || len(merged.Headers) != 2 {
+		t.Errorf("Expected body to be replaced and headers merged but got %v", merged)
+	}
+}
+
+func TestRequiresFlags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/flags" {
+			w.Write([]byte(`{"data": {"new-billing": true, "new-ui": false}}`))
+		}
+	}))
+	defer server.Close()
+	testFile := filepath.Join(t.TempDir(), "flags.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "newBilling", "requiresFlags": ["new-billing", "beta"], "request": {"method": "GET", "url": "/billing"}},
+		{"name": "oldBilling", "requiresFlags": ["!new-billing"], "request": {"method": "GET", "url": "/billing/legacy"}},
+		{"name": "newUi", "requiresFlags": ["new-ui"], "request": {"method": "GET", "url": "/ui"}},
+		{"name": "oldUi", "requiresFlags": ["!new-ui"], "request": {"method": "GET", "url": "/ui/legacy"}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ExecuteSuite(RunConfig{
+		BaseUrl: server.URL,
+		Flags:   &FlagsConfig{Enabled: []string{"beta"}, Endpoint: &Request{Url: "/flags"}, Path: "data"},
+	}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results.Passed) != 2 || results.Passed[0].Name != "newBilling" || results.Passed[1].Name != "oldUi" {
+		t.Errorf("Expected tests whose flags are on to run but got %v", results.Passed)
+	}
+	if len(results.Skipped) != 2 || results.Skipped[0].SkipReason != "flag 'new-billing' is on" || results.Skipped[1].SkipReason != "flag 'new-ui' is off" {
+		t.Errorf("Expected tests whose flags are off to be skipped but got %v", results.Skipped)
+	}
+
+	_, err = ExecuteSuite(RunConfig{BaseUrl: server.URL, Flags: &FlagsConfig{Endpoint: &Request{Url: "/missing"}}}, testFile, true)
+	if err == nil || !strings.Contains(err.Error(), "error fetching flags") {
+		t.Errorf("Expected error fetching flags but got %v", err)
+	}
+}
+
+func TestExecutionPlan(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s", "flags": {"enabled": ["beta"]}}`, server.URL),
+		"a.json": `{"setup": [{"command": "true"}], "tests": [
+			{"name": "createUser", "critical": true, "request": {"method": "POST", "url": "/users"}},
+			{"name": "getUser", "matrix": {"format": ["csv", "json"]}, "request": {"method": "GET", "url": "/users/{{ createUser.id }}?format={{ format }}"}},
+			{"name": "getUserNotModified", "request": {"method": "GET", "url": "/users/1", "conditionalOn": "getUser"}},
+			{"name": "deleteUser", "metadata": {"destructive": "true"}, "request": {"method": "DELETE", "url": "/users/{{ createUser.id }}"}},
+			{"name": "newUi", "requiresFlags": ["new-ui"], "request": {"method": "GET", "url": "/ui"}}
+		]}`,
+		"b.json": `{"skip": true, "tests": [{"name": "skipped", "request": {"method": "GET", "url": "/"}}]}`,
+	})
+	plan, err := Plan(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 0 {
+		t.Errorf("Expected no requests but got %d", requests)
+	}
+	if len(plan.Suites) != 2 || !strings.HasSuffix(plan.Suites[0].FileName, "a.json") || !plan.Suites[1].Skipped {
+		t.Fatalf("Expected both test files in order but got %v", plan.Suites)
+	}
+	if !reflect.DeepEqual(plan.Suites[0].Setup, []string{"setup1"}) {
+		t.Errorf("Expected setup step but got %v", plan.Suites[0].Setup)
+	}
+	tests := make([]string, 0)
+	for _, test := range plan.Suites[0].Tests {
+		tests = append(tests, fmt.Sprintf("%s %v %s", test.Name, test.DependsOn, test.SkipReason))
+	}
+	expected := []string{
+		"createUser [] ",
+		"getUser[format=csv] [createUser] ",
+		"getUser[format=json] [createUser] ",
+		"getUserNotModified [getUser] ",
+		"deleteUser [createUser] destructive test in read-only run",
+		"newUi [] flag 'new-ui' is off",
+	}
+	if !reflect.DeepEqual(tests, expected) {
+		t.Errorf("Expected tests %v but got %v", expected, tests)
+	}
+	report := plan.Report()
+	for _, s := range []string{"1. " + plan.Suites[0].FileName, "createUser: POST /users (critical)", "(depends on getUser)", "2. " + plan.Suites[1].FileName + " (skipped)", "2 test file(s), 4 test(s) to run, 3 skipped"} {
+		if !strings.Contains(report, s) {
+			t.Errorf("Expected plan report to contain '%s' but got:\n%s", s, report)
+		}
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/me" && r.Header.Get("Authorization") != "Bearer valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+	configFile := filepath.Join(t.TempDir(), "apirunner.conf")
+	statuses := func(config string) (DoctorResult, string) {
+		err := os.WriteFile(configFile, []byte(config), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := Doctor(configFile)
+		res := make([]string, 0)
+		for _, check := range result.Checks {
+			res = append(res, fmt.Sprintf("%s=%s", check.Name, check.Status))
+		}
+		return result, strings.Join(res, " ")
+	}
+
+	cases := map[string]string{
+		fmt.Sprintf(`{"baseUrl": "%s", "headers": {"Authorization": "Bearer valid"}, "doctor": {"probe": {"url": "/me"}}}`, server.URL):   "config=ok dns=ok tls=skipped http=ok auth=ok clock=ok",
+		fmt.Sprintf(`{"baseUrl": "%s", "headers": {"Authorization": "Bearer expired"}, "doctor": {"probe": {"url": "/me"}}}`, server.URL): "config=ok dns=ok tls=skipped http=ok auth=failed clock=ok",
+		fmt.Sprintf(`{"baseUrl": "%s", "header": {"Authorization": "Bearer valid"}}`, server.URL):                                         "config=warning dns=ok tls=skipped http=ok auth=skipped clock=ok",
+		fmt.Sprintf(`{"baseUrl": "%s"}`, tlsServer.URL):                                                                                   "config=ok dns=ok tls=failed http=skipped auth=skipped clock=skipped",
+		fmt.Sprintf(`{"baseUrl": "%s", "transport": {"insecureSkipVerify": true}}`, tlsServer.URL):                                        "config=ok dns=ok tls=ok http=ok auth=skipped clock=ok",
+		`{"baseUrl": "/relative"}`: "config=failed dns=skipped tls=skipped http=skipped auth=skipped clock=skipped",
+	}
+	for config, expected := range cases {
+		result, actual := statuses(config)
+		if actual != expected {
+			t.Errorf("Expected checks %s for config %s but got %s:\n%s", expected, config, actual, result.Report())
+		}
+		if result.Passed() != !strings.Contains(expected, "failed") {
+			t.Errorf("Expected doctor to fail only if a check failed for config %s", config)
+		}
+	}
+}
+
+func TestNonJsonResponses(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "<html>\n  <head><title>502 Bad Gateway</title></head>\n  <body>" + strings.Repeat("x", 300) + "</body>\n</html>"
+	mockClient.Header = map[string][]string{"Content-Type": {"text/html; charset=utf-8"}}
+	testFile := filepath.Join(t.TempDir(), "html.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": {"id": "1"}}}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := ExecuteSuite(RunConfig{HttpClient: &mockClient}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Expected JSON but got text/html (first 200 chars: <html> <head><title>502 Bad Gateway</title></head> <body>xxx"
+	if len(results.Failed) != 1 || results.Failed[0].Category != FailureTransportError || !strings.HasPrefix(results.Failed[0].Errors[0], expected) || !strings.HasSuffix(results.Failed[0].Errors[0], "xxx...)") {
+		t.Errorf("Expected a truncated description of the HTML response but got %v", results.Failed)
+	}
+
+	mockClient.Body = ""
+	mockClient.Header = nil
+	results, _ = ExecuteSuite(RunConfig{HttpClient: &mockClient}, testFile, true)
+	if len(results.Failed) != 1 || results.Failed[0].Errors[0] != "Expected JSON but got a non-JSON response with an empty body" {
+		t.Errorf("Expected empty response to be described but got %v", results.Failed)
+	}
+}
+
+func TestCharsetNormalization(t *testing.T) {
+	utf16Le := func(s string) []byte {
+		res := make([]byte, 0)
+		for _, unit := range utf16.Encode([]rune(s)) {
+			res = append(res, byte(unit), byte(unit>>8))
+		}
+		return res
+	}
+	utf16Be := func(s string) []byte {
+		res := make([]byte, 0)
+		for _, unit := range utf16.Encode([]rune(s)) {
+			res = append(res, byte(unit>>8), byte(unit))
+		}
+		return res
+	}
+	body := `{"name": "Zoë 😀"}`
+	cases := map[string]struct {
+		contentType string
+		body        []byte
+	}{
+		"utf-8 bom":           {"application/json", append([]byte{0xEF, 0xBB, 0xBF}, body...)},
+		"utf-16le bom":        {"application/json", append([]byte{0xFF, 0xFE}, utf16Le(body)...)},
+		"utf-16be bom":        {"application/json", append([]byte{0xFE, 0xFF}, utf16Be(body)...)},
+		"utf-16le charset":    {"application/json; charset=UTF-16LE", utf16Le(body)},
+		"utf-16 charset":      {"application/json; charset=utf-16", utf16Be(body)},
+		"utf-16le sniffed":    {"application/json", utf16Le(body)},
+		"utf-16be sniffed":    {"", utf16Be(body)},
+		"utf-8 without a bom": {"application/json; charset=utf-8", []byte(body)},
+	}
+	for name, c := range cases {
+		if normalized := string(normalizeCharset(c.contentType, c.body)); normalized != body {
+			t.Errorf("Expected %s body to be normalized to %s but got %q", name, body, normalized)
+		}
+	}
+	protobuf := []byte{0x0A, 0x00, 0x12}
+	if !slices.Equal(normalizeCharset("application/x-protobuf", protobuf), protobuf) {
+		t.Errorf("Expected binary body to be unchanged")
+	}
+
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = "\uFEFF" + body
+	testFile := filepath.Join(t.TempDir(), "bom.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": {"name": "Zoë 😀"}}}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _ := ExecuteSuite(RunConfig{HttpClient: &mockClient}, testFile, true)
+	if len(results.Passed) != 1 {
+		t.Errorf("Expected BOM-prefixed response to be parsed but got %v", results.Failed)
+	}
+}
+
+func TestSlowRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	testFile := filepath.Join(t.TempDir(), "slow.json")
+	err := os.WriteFile(testFile, []byte(`{"tests": [
+		{"name": "fast", "request": {"method": "GET", "url": "/fast"}},
+		{"name": "slow", "request": {"method": "GET", "url": "/slow"}},
+		{"name": "slowAllowed", "slowMs": 5000, "request": {"method": "GET", "url": "/slow"}}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suite, closeSuite, err := newTestSuite(RunConfig{BaseUrl: server.URL, Timeouts: &TimeoutConfig{SlowMs: 50}}, testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSuite()
+	var out strings.Builder
+	result := suite.run(&out, true)
+
+	if len(result.Passed) != 3 {
+		t.Fatalf("Expected slow requests not to fail their tests but got %v", result.Failed)
+	}
+	for _, test := range result.Passed {
+		if test.Slow != (test.Name == "slow") || test.RequestDuration <= 0 {
+			t.Errorf("Expected only the request of 'slow' to be slow but got %v (%s) for '%s'", test.Slow, test.RequestDuration, test.Name)
+		}
+	}
+	if !strings.Contains(out.String(), "Slow request: GET "+server.URL+"/slow took") {
+		t.Errorf("Expected slow request to be logged but got:\n%s", out.String())
+	}
+	out.Reset()
+	printSlowRequests(&out, []TestSuiteResult{result})
+	if !strings.Contains(out.String(), "* Slow requests:") || !strings.Contains(out.String(), "slow (GET "+server.URL+"/slow)") || strings.Contains(out.String(), "slowAllowed") {
+		t.Errorf("Expected summary of slow requests but got:\n%s", out.String())
+	}
+}
+
+func TestMaxTotalDuration(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(60 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	testFile := filepath.Join(t.TempDir(), "budget.json")
+	write := func(maxTotalDurationMs int) {
+		err := os.WriteFile(testFile, []byte(fmt.Sprintf(`{"maxTotalDurationMs": %d, "setup": [{"command": "true"}], "tests": [
+			{"name": "fast", "request": {"method": "GET", "url": "/fast"}},
+			{"name": "slow", "request": {"method": "GET", "url": "/slow"}}
+		]}`, maxTotalDurationMs)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(30)
+	results, err := ExecuteSuite(RunConfig{BaseUrl: server.URL}, testFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Passed) != 2 || len(results.Failed) != 1 || results.Failed[0].Name != "maxTotalDuration" || results.Failed[0].Category != FailureTimeout {
+		t.Fatalf("Expected the suite to fail after exceeding its duration budget but got %v", results.Failed)
+	}
+	if errs := results.Failed[0].Errors; !strings.Contains(errs[0], "exceeding its maxTotalDurationMs of 30") || !strings.HasPrefix(errs[1], "Biggest contributors: slow (") || !strings.Contains(errs[1], "setup (") {
+		t.Errorf("Expected the biggest contributors to be reported but got %v", errs)
+	}
+
+	write(5000)
+	results, _ = ExecuteSuite(RunConfig{BaseUrl: server.URL}, testFile, true)
+	if len(results.Failed) != 0 {
+		t.Errorf("Expected suite within its duration budget to pass but got %v", results.Failed)
+	}
+	write(-1)
+	_, err = ExecuteSuite(RunConfig{BaseUrl: server.URL}, testFile, true)
+	if err == nil || !strings.Contains(err.Error(), "invalid maxTotalDurationMs -1") {
+		t.Errorf("Expected negative maxTotalDurationMs to be rejected but got %v", err)
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	var mutex sync.Mutex
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requested = append(requested, r.URL.Path)
+		mutex.Unlock()
+		w.Write([]byte(`{"id": "user_1"}`))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "cached.json")
+	write := func(name string) {
+		err := os.WriteFile(testFile, []byte(fmt.Sprintf(`{"tests": [
+			{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}, "expectedResponse": {"statusCode": 200, "body": {"id": "user_1"}}},
+			{"name": "getOrders", "request": {"method": "GET", "url": "/users/{{ getUser.id }}/%s"}}
+		]}`, name)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(noCache bool) ([]string, TestSuiteResult) {
+		mutex.Lock()
+		requested = make([]string, 0)
+		mutex.Unlock()
+		results, err := ExecuteSuite(RunConfig{BaseUrl: server.URL, CacheFile: filepath.Join(dir, "cache.json"), noCache: noCache}, testFile, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		return requested, results
+	}
+
+	write("orders")
+	requests, results := run(false)
+	if len(requests) != 2 || len(results.Passed) != 2 {
+		t.Fatalf("Expected first run to send all requests but got %v, %v", requests, results.Failed)
+	}
+	requests, results = run(false)
+	if len(requests) != 0 || len(results.Skipped) != 2 || results.Skipped[1].SkipReason != "unchanged since it last passed" {
+		t.Errorf("Expected unchanged tests to be skipped but got %v, %v", requests, results)
+	}
+	// getOrders still resolves {{ getUser.id }} from the cached fields of getUser
+	write("invoices")
+	requests, _ = run(false)
+	if !slices.Equal(requests, []string{"/users/user_1/invoices"}) {
+		t.Errorf("Expected only the changed test to run but got %v", requests)
+	}
+	requests, _ = run(true)
+	if len(requests) != 2 {
+		t.Errorf("Expected all tests to run without the cache but got %v", requests)
+	}
+}
+
+func TestResponseMemory(t *testing.T) {
+	mockClient := RequestRecordingHttpClient{}
+	mockClient.StatusCode = 200
+	mockClient.Body = `{"users": [{"id": "user_1", "roles": []}, {"id": "user_2", "roles": ["admin"]}]}`
+	mockClient.Header = map[string][]string{"X-Total": {"2"}}
+	results, _ := ExecuteSuite(RunConfig{
+		HttpClient: &mockClient,
+	}, "responsememory.json", true)
+
+	if len(results.Passed) != 2 {
+		t.Errorf("Expected later tests to reference the full response of earlier tests: %v", results.Failed)
+	}
+	if len(mockClient.Requests) != 2 || mockClient.Requests[1].URL.String() != "/users/user_1" {
+		t.Errorf("Expected request url to be templated from the first response")
+	}
+	if results.Passed[0].Response["status"] != float64(200) || results.Passed[0].Response["body"] == nil {
+		t.Errorf("Expected test result to include the response but got %v", results.Passed[0].Response)
+	}
+}
+
+func TestSuiteOrder(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.json", "a.json", "c.json", "sub/d.json"} {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(dir, name), []byte(`{"tests": []}`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testFiles, err := findOrderedTestFiles(RunConfig{}, dir, regexp.MustCompile(".*"))
+	expected := []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json"), filepath.Join(dir, "c.json"), filepath.Join(dir, "sub/d.json")}
+	if err != nil || !reflect.DeepEqual(testFiles, expected) {
+		t.Errorf("Expected test files in alphabetical order %v but got %v (%v)", expected, testFiles, err)
+	}
+
+	orderFile := filepath.Join(dir, "order.txt")
+	err = os.WriteFile(orderFile, []byte("# Setup first\nsub/d.json\nc.json\n\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testFiles, err = findOrderedTestFiles(RunConfig{SuiteOrder: orderFile}, dir, regexp.MustCompile("^[a-c].*"))
+	expected = []string{filepath.Join(dir, "c.json"), filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")}
+	if err != nil || !reflect.DeepEqual(testFiles, expected) {
+		t.Errorf("Expected test files in index file order %v but got %v (%v)", expected, testFiles, err)
+	}
+
+	err = os.WriteFile(orderFile, []byte("missing.json\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = findOrderedTestFiles(RunConfig{SuiteOrder: orderFile}, dir, regexp.MustCompile(".*"))
+	if err == nil || !strings.Contains(err.Error(), "'missing.json'") {
+		t.Errorf("Expected error for missing test file listed in suiteOrder but got %v", err)
+	}
+}
+
+func TestGroupResults(t *testing.T) {
+	passed := Passed("ok", 0)
+	failed := Failed("broken", []string{"Expected http 200 but got http 500"}, 0)
+	results := []TestSuiteResult{
+		{TestFilename: filepath.Join("tests", "root.json"), Passed: []TestResult{passed}},
+		{TestFilename: filepath.Join("tests", "users", "get.json"), Passed: []TestResult{passed, passed}, Failed: []TestResult{failed}},
+		{TestFilename: filepath.Join("tests", "users", "admin", "list.json"), Skipped: []TestResult{Skipped("skipped")}},
+		{TestFilename: filepath.Join("tests", "orders", "create.json"), Passed: []TestResult{passed}},
+	}
+
+	var out strings.Builder
+	groupResults("tests", results).print(&out, 0)
+	expected := "tests/ (passed: 4, failed: 1, skipped: 1)\n" +
+		"  root.json (passed: 1, failed: 0, skipped: 0)\n" +
+		"  users/ (passed: 2, failed: 1, skipped: 1)\n" +
+		"    get.json (passed: 2, failed: 1, skipped: 0)\n" +
+		failed.Result() +
+		"    users/admin/ (passed: 0, failed: 0, skipped: 1)\n" +
+		"      list.json (passed: 0, failed: 0, skipped: 1)\n" +
+		"  orders/ (passed: 1, failed: 0, skipped: 0)\n" +
+		"    create.json (passed: 1, failed: 0, skipped: 0)\n"
+	if out.String() != expected {
+		t.Errorf("Expected grouped results:\n%s\nbut got:\n%s", expected, out.String())
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/flaky" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	quarantineFile := filepath.Join(t.TempDir(), "quarantine.txt")
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s", "quarantine": "%s"}`, server.URL, quarantineFile),
+		"flaky.json":     `{"tests": [{"name": "flaky", "request": {"method": "GET", "url": "/flaky"}}, {"name": "stable", "request": {"method": "GET", "url": "/stable"}}]}`,
+	})
+
+	for expires, expectedPassed := range map[string]bool{
+		time.Now().Format(time.DateOnly):                     true,
+		time.Now().AddDate(0, 0, -1).Format(time.DateOnly):   false,
+		time.Now().Add(-time.Minute).Format(time.RFC3339):    false,
+		time.Now().Add(time.Hour).UTC().Format(time.RFC3339): true,
+	} {
+		quarantine := fmt.Sprintf(`[{"file": "flaky.json", "test": "flaky", "expires": "%s", "reason": "intermittent 500s"}]`, expires)
+		err := os.WriteFile(quarantineFile, []byte(quarantine), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		passed, err := Run(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`))
+		if err != nil || passed != expectedPassed {
+			t.Errorf("Expected run with quarantine expiring %s to pass: %v, but got %v (%v)", expires, expectedPassed, passed, err)
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	mockClient := MockHttpClient{}
+	mockClient.StatusCode = 500
+	results, _ := ExecuteSuite(RunConfig{
+		HttpClient: &mockClient,
+	}, "metadata.json", true)
+
+	if len(results.Failed) != 2 {
+		t.Fatalf("Expected 2 failed tests but got %d", len(results.Failed))
+	}
+	expected := map[string]string{"owner": "identity", "severity": "high", "jira": "ID-123"}
+	if !reflect.DeepEqual(results.Failed[0].Metadata, expected) {
+		t.Errorf("Expected test metadata to be merged with suite metadata %v but got %v", expected, results.Failed[0].Metadata)
+	}
+	if !strings.Contains(results.Failed[0].Result(), "(jira: ID-123, owner: identity, severity: high)") {
+		t.Errorf("Expected failure result to contain the test's metadata")
+	}
+	if results.Failed[1].Metadata["severity"] != "low" {
+		t.Errorf("Expected test metadata to override suite metadata")
+	}
+
+	filter, err := ParseMetadataFilter("owner=identity, severity=low")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _ = ExecuteSuite(RunConfig{
+		HttpClient:     &mockClient,
+		metadataFilter: filter,
+	}, "metadata.json", true)
+	if len(results.Skipped) != 1 || results.Skipped[0].Name != "getUser" || len(results.Failed) != 1 {
+		t.Errorf("Expected tests not matching the metadata filter to be skipped")
+	}
+	if _, err := ParseMetadataFilter("owner"); err == nil {
+		t.Errorf("Expected error for invalid metadata filter")
+	}
+}
+
+func TestFailOnSeverity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "apirunner.conf"), []byte(fmt.Sprintf(`{"baseUrl": "%s"}`, server.URL)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for severity, expectedPassed := range map[string]bool{"low": true, "medium": true, "high": false, "": false} {
+		suite := fmt.Sprintf(`{"metadata": {"severity": "low"}, "tests": [{"name": "getUser", "request": {"method": "GET", "url": "/users/1"}, "metadata": {"severity": "%s"}}]}`, severity)
+		err = os.WriteFile(filepath.Join(dir, "users.json"), []byte(suite), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		passed, err := RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{FailOnSeverity: "high"})
+		if err != nil || passed != expectedPassed {
+			t.Errorf("Expected run with a failing test of severity '%s' to pass: %v, but got %v (%v)", severity, expectedPassed, passed, err)
+		}
+	}
+
+	if _, err := ParseFailOn("severity >= high"); err != nil {
+		t.Errorf("Expected valid fail-on but got %v", err)
+	}
+	if _, err := ParseFailOn("severity>=urgent"); err == nil {
+		t.Errorf("Expected error for unknown severity")
+	}
+}
+
+func TestApprove(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			fmt.Fprintf(w, `{"id": "user_123456", "name": "<Alice>", "createdAt": "%s"}`, time.Now().UTC().Format(time.RFC3339))
+		} else {
+			fmt.Fprintf(w, `{"id": "user_123456", "requestId": "%s", "roles": ["admin"], "score": 1.5}`, newUUID())
+		}
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s"}`, server.URL),
+		"users.json":     `{"tests": [{"name": "createUser", "request": {"method": "POST", "url": "/users"}}, {"name": "getUser", "request": {"method": "GET", "url": "/users/{{ createUser.id }}"}, "expectedResponse": {"statusCode": 200, "body": "__record__"}}]}`,
+	})
+
+	passed, err := RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{Approve: true})
+	if !passed || err != nil {
+		t.Fatalf("Expected approval run to pass but got %v, %v", passed, err)
+	}
+	contents, err := os.ReadFile(filepath.Join(dir, "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`"name": "createUser",
+            "request": {`,
+		`"createdAt": "{{ date:RFC3339 }}"`,
+		`"name": "<Alice>"`,
+		`"id": "{{ createUser.id }}"`,
+		`"requestId": "{{ nonEmpty }}"`,
+		`"score": 1.5`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Expected approved test file to contain %s but got:\n%s", expected, string(contents))
+		}
+	}
+
+	passed, err = Run(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`))
+	if !passed || err != nil {
+		t.Errorf("Expected run against approved bodies to pass but got %v, %v", passed, err)
+	}
+}
+
+func TestRunReport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf":   fmt.Sprintf(`{"baseUrl": "%s"}`, server.URL),
+		"users/users.json": `{"metadata": {"owner": "identity"}, "tests": [{"name": "ok", "request": {"method": "GET", "url": "/ok"}}, {"name": "broken", "request": {"method": "GET", "url": "/broken"}}, {"name": "skipped", "skip": true, "request": {"method": "GET", "url": "/"}}]}`,
+	})
+	t.Setenv("GIT_COMMIT", "abc123")
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	start := time.Now()
+	var tap strings.Builder
+	htmlFile := filepath.Join(t.TempDir(), "report.html")
+	_, err := RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{ReportFile: reportFile, Tap: &tap, HtmlReportFile: htmlFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	html, err := os.ReadFile(htmlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"<h2>users/users.json", "Failed: 1", `<details open><summary>broken</summary><div class="muted">status-mismatch</div><div class="muted">owner: identity</div><pre>Expected http 200 but got http 500</pre>`, "<code>GET " + server.URL + "/ok</code>"} {
+		if !strings.Contains(string(html), expected) {
+			t.Errorf("Expected HTML report to contain %s but got:\n%s", expected, string(html))
+		}
+	}
+	if !strings.HasPrefix(tap.String(), "TAP version 13\n1..3\nok 1 - users/users.json: ok\n") {
+		t.Errorf("Expected TAP output of the run but got:\n%s", tap.String())
+	}
+
+	report, err := LoadRunReport(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Run.Id == "" || report.Run.StartTime.Before(start.Truncate(time.Second)) || report.Run.GitSha != "abc123" || report.Run.Profile != "apirunner" {
+		t.Errorf("Unexpected run metadata in report: %+v", report.Run)
+	}
+	if len(report.Suites) != 1 || report.Suites[0].TestFile != "users/users.json" || len(report.Suites[0].Tests) != 3 {
+		t.Fatalf("Expected report of 3 tests in users/users.json but got %v", report)
+	}
+	statuses := make(map[string]string)
+	urls := make(map[string]string)
+	for _, test := range report.Suites[0].Tests {
+		statuses[test.Name] = test.Status
+		urls[test.Name] = strings.TrimSpace(test.Method + " " + test.Url)
+		if test.Metadata["owner"] != "identity" {
+			t.Errorf("Expected report to include metadata of test '%s'", test.Name)
+		}
+	}
+	if !reflect.DeepEqual(statuses, map[string]string{"ok": TestStatusPassed, "broken": TestStatusFailed, "skipped": TestStatusSkipped}) {
+		t.Errorf("Unexpected test statuses in report: %v", statuses)
+	}
+	if !reflect.DeepEqual(urls, map[string]string{"ok": "GET " + server.URL + "/ok", "broken": "GET " + server.URL + "/broken", "skipped": ""}) {
+		t.Errorf("Unexpected request urls in report: %v", urls)
+	}
+	if report.Summary != (RunSummary{Total: 3, Passed: 1, Failed: 1, Skipped: 1}) || report.Suites[0].DurationMs <= 0 {
+		t.Errorf("Unexpected summary or suite duration in report: %+v, %v", report.Summary, report.Suites[0].DurationMs)
+	}
+}
+
+func TestReporters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	outDir := t.TempDir()
+	config := map[string]interface{}{
+		"baseUrl": server.URL,
+		"reporters": []map[string]interface{}{
+			{"type": "junit", "file": filepath.Join(outDir, "junit.xml")},
+			{"type": "tap", "file": filepath.Join(outDir, "results.tap")},
+			{"type": "command", "command": "sh", "args": []string{"-c", "cat > " + filepath.Join(outDir, "piped.json")}},
+		},
+	}
+	contents, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": string(contents),
+		"users.json":     `{"tests": [{"name": "ok", "request": {"method": "GET", "url": "/ok"}}, {"name": "broken", "metadata": {"owner": "team-users", "severity": "high"}, "request": {"method": "GET", "url": "/broken"}}, {"name": "skipped", "skip": true, "request": {"method": "GET", "url": "/"}}]}`,
+	})
+	reportFile := filepath.Join(outDir, "report.json")
+	_, err = RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{ReportFile: reportFile, Profile: "staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	junit, err := os.ReadFile(filepath.Join(outDir, "junit.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`<testsuite name="users.json" tests="3" failures="1" skipped="1"`, `<testcase name="ok" classname="users.json"`, `<failure message="Expected http 200 but got http 500" type="status-mismatch">`, `<skipped></skipped>`,
+		`<property name="run.id" value="`, `<property name="run.profile" value="staging"></property>`,
+		`<testcase name="broken" classname="users.json" time="`, `<property name="owner" value="team-users"></property>`, `<property name="severity" value="high"></property>`} {
+		if !strings.Contains(string(junit), s) {
+			t.Errorf("Expected JUnit report to contain '%s' but got:\n%s", s, junit)
+		}
+	}
+	tap, err := os.ReadFile(filepath.Join(outDir, "results.tap"))
+	if err != nil || !strings.Contains(string(tap), "not ok 2 - users.json: broken") {
+		t.Errorf("Expected TAP report but got %s (%v)", tap, err)
+	}
+	for _, reportFile := range []string{filepath.Join(outDir, "piped.json"), reportFile} {
+		report, err := LoadRunReport(reportFile)
+		if err != nil || report.Summary.Total != 3 || report.Summary.Failed != 1 {
+			t.Errorf("Expected JSON report in '%s' but got %+v (%v)", reportFile, report.Summary, err)
+		}
+	}
+
+	for _, reporters := range []string{`[{"type": "xml", "file": "out.xml"}]`, `[{"type": "junit"}]`, `[{"type": "command"}]`} {
+		err = os.WriteFile(filepath.Join(dir, "apirunner.conf"), []byte(fmt.Sprintf(`{"baseUrl": "%s", "reporters": %s}`, server.URL, reporters)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Run(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`))
+		if err == nil {
+			t.Errorf("Expected invalid reporters %s to be rejected", reporters)
+		}
+	}
+}
+
+func TestOutput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s"}`, server.URL),
+		"users.json":     `{"tests": [{"name": "getUsers", "request": {"method": "GET", "url": "/users"}}]}`,
+	})
+	var out strings.Builder
+	results, err := ExecuteSuite(RunConfig{BaseUrl: server.URL, Output: &out}, filepath.Join(dir, "users.json"), true)
+	if err != nil || len(results.Passed) != 1 {
+		t.Fatalf("Expected test to pass but got %v (%v)", results, err)
+	}
+	if !strings.Contains(out.String(), "users.json':") || !strings.Contains(out.String(), "getUsers") {
+		t.Errorf("Expected suite output to be written to Output but got '%s'", out.String())
+	}
+
+	out.Reset()
+	var errOut strings.Builder
+	passed, err := RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{Output: &out, ErrOutput: &errOut})
+	if err != nil || !passed {
+		t.Fatalf("Expected run to pass but got %v", err)
+	}
+	if !strings.Contains(out.String(), "Found '") || !strings.Contains(out.String(), "Passed: 1") || errOut.Len() > 0 {
+		t.Errorf("Expected run output to be written to Output but got '%s' (errors '%s')", out.String(), errOut.String())
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "invalid.json"), []byte(`{"tests": [{"name": "invalid name"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	_, err = RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{Output: &out, ErrOutput: &errOut})
+	if err == nil || !strings.Contains(errOut.String(), "Invalid test file") || strings.Contains(out.String(), "Invalid test file") {
+		t.Errorf("Expected invalid test file to be reported to ErrOutput but got '%s' (%v)", errOut.String(), err)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s", "headers": {"X-Tenant": "acme"}}`, server.URL),
+		"users.json": `{"tests": [
+			{"name": "createUser", "request": {"method": "POST", "url": "/users", "body": {"name": "Jane"}}},
+			{"name": "skipped", "skip": true, "request": {"method": "GET", "url": "/"}}
+		]}`,
+	})
+	events := make([]string, 0)
+	var createUser TestResult
+	var report RunReport
+	hooks := &Hooks{
+		OnRunStart: func(event RunStartEvent) {
+			events = append(events, fmt.Sprintf("runStart %d", len(event.TestFiles)))
+		},
+		OnSuiteStart: func(event SuiteStartEvent) {
+			events = append(events, "suiteStart "+filepath.Base(event.TestFile))
+		},
+		OnSuiteEnd: func(event SuiteEndEvent) {
+			events = append(events, fmt.Sprintf("suiteEnd %d", event.Result.TotalTests))
+		},
+		OnTestStart: func(event TestStartEvent) {
+			events = append(events, "testStart "+event.Name)
+		},
+		OnTestEnd: func(event TestEndEvent) {
+			events = append(events, "testEnd "+event.Result.Name)
+			if event.Result.Name == "createUser" {
+				createUser = event.Result
+			}
+		},
+		OnRunEnd: func(event RunEndEvent) {
+			events = append(events, fmt.Sprintf("runEnd %t", event.Passed))
+			report = event.Report
+		},
+	}
+	passed, err := RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{Hooks: hooks, Output: io.Discard})
+	if err != nil || !passed {
+		t.Fatalf("Expected run to pass but got %v", err)
+	}
+
+	expected := []string{"runStart 1", "suiteStart users.json", "testStart createUser", "testEnd createUser", "testStart skipped", "testEnd skipped", "suiteEnd 2", "runEnd true"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected hooks %v but got %v", expected, events)
+	}
+	if createUser.RequestMethod != "POST" || createUser.RequestHeaders.Get("X-Tenant") != "acme" || createUser.RequestBody != `{"name":"Jane"}` {
+		t.Errorf("Expected the resolved request but got %s %s %v", createUser.RequestMethod, createUser.RequestBody, createUser.RequestHeaders)
+	}
+	if body, _ := createUser.Response["body"].(map[string]interface{}); body["id"] != float64(1) {
+		t.Errorf("Expected the response but got %v", createUser.Response)
+	}
+	if report.Summary.Total != 2 || report.Run.Id == "" {
+		t.Errorf("Expected the run's report but got %+v", report)
+	}
+}
+
+func TestFailureArtifacts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users" {
+			w.Write([]byte(`{"id": "u1"}`))
+			return
+		}
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": "it's taken"}`))
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s", "headers": {"Authorization": "Bearer secret"}}`, server.URL),
+		"users.json": `{"tests": [
+			{"name": "createUser", "request": {"method": "POST", "url": "/users"}, "expectedResponse": {"statusCode": 200, "body": {"id": "{{ nonEmpty }}"}}},
+			{"name": "renameUser", "request": {"method": "PUT", "url": "/users/{{ createUser.id }}", "body": {"name": "O'Brien"}}}
+		]}`,
+	})
+	artifactsDir := t.TempDir()
+	var out strings.Builder
+	_, err := RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{ArtifactsDir: artifactsDir, Output: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zips, _ := filepath.Glob(filepath.Join(artifactsDir, "*.zip"))
+	if len(zips) != 1 || !strings.Contains(out.String(), "Wrote failure artifacts '"+zips[0]+"'") {
+		t.Fatalf("Expected a zip of the run's failure artifacts but got %v", zips)
+	}
+	archive, err := zip.OpenReader(zips[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	artifacts := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, _ := io.ReadAll(r)
+		r.Close()
+		artifacts[path.Base(f.Name)] = string(contents)
+		if !strings.Contains(f.Name, "users.json/renameUser/") {
+			t.Errorf("Expected only artifacts of the failed test but got '%s'", f.Name)
+		}
+	}
+	expectedCurl := fmt.Sprintf(`curl -X PUT '%s/users/u1' -H 'Authorization: REDACTED' --data-raw '{"name":"O'\''Brien"}'`, server.URL)
+	if !strings.HasPrefix(artifacts["curl.sh"], expectedCurl) {
+		t.Errorf("Expected curl command %s but got %s", expectedCurl, artifacts["curl.sh"])
+	}
+	if !strings.HasPrefix(artifacts["request.http"], "PUT "+server.URL+"/users/u1\n") || strings.Contains(artifacts["request.http"], "secret") {
+		t.Errorf("Expected redacted resolved request but got %s", artifacts["request.http"])
+	}
+	if !strings.Contains(artifacts["response.json"], `"status": 409`) || !strings.Contains(artifacts["response.json"], "it's taken") {
+		t.Errorf("Expected response but got %s", artifacts["response.json"])
+	}
+	if !strings.Contains(artifacts["variables.json"], `"createUser.id": "u1"`) {
+		t.Errorf("Expected template vars but got %s", artifacts["variables.json"])
+	}
+	if !strings.Contains(artifacts["errors.txt"], "Expected http 200 but got http 409") {
+		t.Errorf("Expected errors but got %s", artifacts["errors.txt"])
+	}
+}
+
+func TestVariableExport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "u1", "tags": ["a", "b"], "note": "line1\nline2"}`))
+	}))
+	defer server.Close()
+	exportDir := t.TempDir()
+	exportFile := filepath.Join(exportDir, "vars.json")
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s", "variableExport": {"file": "%s", "pattern": "^createUser\\."}}`, server.URL, exportFile),
+		"users.json": `{"tests": [
+			{"name": "createUser", "request": {"method": "POST", "url": "/users"}, "expectedResponse": {"statusCode": 200, "body": {"id": "{{ nonEmpty }}", "tags": ["a", "b"], "note": "{{ nonEmpty }}"}}},
+			{"name": "getUser", "request": {"method": "GET", "url": "/users/{{ createUser.id }}"}, "expectedResponse": {"statusCode": 200, "body": {"id": "u1", "tags": ["a", "b"], "note": "{{ nonEmpty }}"}}}
+		]}`,
+	})
+	passed, err := RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{Output: io.Discard})
+	if !passed || err != nil {
+		t.Fatalf("Expected run to pass but got %v, %v", passed, err)
+	}
+	contents, err := os.ReadFile(exportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vars map[string]interface{}
+	err = json.Unmarshal(contents, &vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["createUser.id"] != "u1" || vars["getUser.id"] != nil {
+		t.Errorf("Expected only vars matching the pattern to be exported but got %v", vars)
+	}
+
+	envFile := filepath.Join(exportDir, "vars.env")
+	_, err = RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{Output: io.Discard, VariableExport: &VariableExportConfig{File: envFile, Pattern: "^createUser\\.(id|note|response\\.redirects)$"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err = os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "CREATE_USER_ID=u1\nCREATE_USER_NOTE=\"line1\\nline2\"\nCREATE_USER_RESPONSE_REDIRECTS=0\n"
+	if string(contents) != expected {
+		t.Errorf("Expected env file %q but got %q", expected, string(contents))
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "apirunner.conf"), []byte(`{"variableExport": {"file": "vars.yaml", "format": "yaml"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{Output: io.Discard})
+	if err == nil || !strings.Contains(err.Error(), "invalid variableExport format 'yaml'") {
+		t.Errorf("Expected invalid format to be rejected but got %v", err)
+	}
+}
+
+func TestRunWithT(t *testing.T) {
+	var mutex sync.Mutex
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requested = append(requested, r.URL.Path)
+		mutex.Unlock()
+		w.Write([]byte(`{"id": "u1"}`))
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s"}`, server.URL),
+		"users/users.json": `{"tests": [
+			{"name": "createUser", "request": {"method": "POST", "url": "/users"}, "expectedResponse": {"statusCode": 200, "body": {"id": "{{ nonEmpty }}"}}},
+			{"name": "getUser", "request": {"method": "GET", "url": "/users/{{ createUser.id }}"}, "expectedResponse": {"statusCode": 200, "body": {"id": "u1"}}},
+			{"name": "deleteUser", "skip": true, "request": {"method": "DELETE", "url": "/users/{{ createUser.id }}"}}
+		]}`,
+		"orgs.json": `{"tests": [{"name": "listOrgs", "request": {"method": "GET", "url": "/orgs"}}]}`,
+	})
+
+	RunWithT(t, filepath.Join(dir, "apirunner.conf"), dir)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !slices.Equal(requested, []string{"/orgs", "/users", "/users/u1"}) {
+		t.Errorf("Expected the requests of all test files but got %v", requested)
+	}
+}
+
+func TestRunWithTOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "u1"}`))
+	}))
+	defer server.Close()
+	quarantineFile := filepath.Join(t.TempDir(), "quarantine.json")
+	err := os.WriteFile(quarantineFile, []byte(`[{"file": "users.json", "test": "flaky", "expires": "2999-01-01"}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s", "quarantine": "%s"}`, server.URL, quarantineFile),
+		"users.json": `{"tests": [
+			{"name": "getUser", "request": {"method": "GET", "url": "/users/u1"}, "expectedResponse": {"statusCode": 200, "body": {"id": "u1"}}},
+			{"name": "flaky", "request": {"method": "GET", "url": "/users/u1"}, "expectedResponse": {"statusCode": 201}},
+			{"name": "minor", "metadata": {"severity": "low"}, "request": {"method": "GET", "url": "/users/u1"}, "expectedResponse": {"statusCode": 201}}
+		]}`,
+	})
+
+	var mutex sync.Mutex
+	events := make([]string, 0)
+	record := func(event string) {
+		mutex.Lock()
+		events = append(events, event)
+		mutex.Unlock()
+	}
+	var runEnd RunEndEvent
+	hooks := &Hooks{
+		OnRunStart:   func(event RunStartEvent) { record("runStart") },
+		OnSuiteStart: func(event SuiteStartEvent) { record("suiteStart") },
+		OnTestEnd:    func(event TestEndEvent) { record("testEnd " + event.Result.Name) },
+		OnSuiteEnd:   func(event SuiteEndEvent) { record("suiteEnd") },
+		OnRunEnd: func(event RunEndEvent) {
+			record("runEnd")
+			runEnd = event
+		},
+	}
+	t.Run("users", func(t *testing.T) {
+		RunWithTOptions(t, filepath.Join(dir, "apirunner.conf"), dir, RunOptions{Hooks: hooks, FailOnSeverity: "high"})
+	})
+
+	expected := []string{"runStart", "suiteStart", "testEnd getUser", "testEnd flaky", "testEnd minor", "suiteEnd", "runEnd"}
+	if !slices.Equal(events, expected) {
+		t.Errorf("Expected hooks %v to be called but got %v", expected, events)
+	}
+	if !runEnd.Passed || runEnd.Report.Summary != (RunSummary{Total: 3, Passed: 1, Quarantined: 1, Warnings: 1}) {
+		t.Errorf("Expected run with quarantined and low severity failures to pass but got %v, %+v", runEnd.Passed, runEnd.Report.Summary)
+	}
+}
+
+func TestRunWithTTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requested = append(requested, r.URL.Path)
+		mutex.Unlock()
+		if r.URL.Path == "/hang" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	quarantineFile := filepath.Join(t.TempDir(), "quarantine.json")
+	err := os.WriteFile(quarantineFile, []byte(`[{"file": "1hang.json", "test": "hang", "expires": "2999-01-01"}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s", "quarantine": "%s", "timeouts": {"runMs": 200}}`, server.URL, quarantineFile),
+		"1hang.json":     `{"tests": [{"name": "hang", "request": {"method": "GET", "url": "/hang"}}]}`,
+		"2next.json":     `{"tests": [{"name": "next", "request": {"method": "GET", "url": "/next"}}]}`,
+	})
+
+	// The hung test times out at the run's deadline (its quarantined failure skipping its subtest), after which
+	// the remaining test files are skipped
+	start := time.Now()
+	t.Run("run", func(t *testing.T) {
+		RunWithT(t, filepath.Join(dir, "apirunner.conf"), dir)
+	})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the run to time out after 200ms but it took %s", elapsed)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !slices.Equal(requested, []string{"/hang"}) {
+		t.Errorf("Expected no requests after the run timed out but got %v", requested)
+	}
+}
+
+func TestProcessEnv(t *testing.T) {
+	var mutex sync.Mutex
+	regions := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		regions[r.URL.Path] = os.Getenv("APIRUNNER_TEST_REGION")
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s", "env": {"APIRUNNER_TEST_REGION": "us-east-1"}}`, server.URL),
+		"1default.json":  `{"tests": [{"name": "listUsers", "request": {"method": "GET", "url": "/users"}}]}`,
+		"2override.json": `{"env": {"APIRUNNER_TEST_REGION": "eu-west-1"}, "tests": [{"name": "listOrgs", "request": {"method": "GET", "url": "/orgs"}}]}`,
+		"3restored.json": `{"tests": [{"name": "listTeams", "request": {"method": "GET", "url": "/teams"}}]}`,
+	})
+	passed, err := RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{Output: io.Discard})
+	if !passed || err != nil {
+		t.Fatalf("Expected run to pass but got %v, %v", passed, err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := map[string]string{"/users": "us-east-1", "/orgs": "eu-west-1", "/teams": "us-east-1"}
+	if !reflect.DeepEqual(regions, expected) {
+		t.Errorf("Expected environment variables of the run overridden per suite %v but got %v", expected, regions)
+	}
+	if _, ok := os.LookupEnv("APIRUNNER_TEST_REGION"); ok {
+		t.Errorf("Expected environment variables to be unset after the run")
+	}
+}
+
+func TestFS(t *testing.T) {
+	var mutex sync.Mutex
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requested = append(requested, r.URL.Path)
+		mutex.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer server.Close()
+	fsys := fstest.MapFS{
+		"tests/apirunner.conf":   {Data: []byte(fmt.Sprintf(`{"baseUrl": "%s", "suiteOrder": "tests/order.txt", "quarantine": "quarantine.json"}`, server.URL))},
+		"tests/order.txt":        {Data: []byte("users/users.json\n")},
+		"quarantine.json":        {Data: []byte(`[{"file": "orgs.json", "test": "deleteOrg", "expires": "2999-01-01"}]`)},
+		"tests/orgs.json":        {Data: []byte(`{"tests": [{"name": "createOrg", "request": {"method": "POST", "url": "/orgs", "body": {"name": "{{ file('name.txt') }}"}}, "expectedResponse": {"statusCode": 200, "body": {"name": "Acme"}}}, {"name": "deleteOrg", "request": {"method": "DELETE", "url": "/orgs/1"}, "expectedResponse": {"statusCode": 204}}]}`)},
+		"tests/name.txt":         {Data: []byte("Acme\n")},
+		"tests/users/users.json": {Data: []byte(`{"tests": [{"name": "createUser", "request": {"method": "POST", "url": "/users", "body": {"id": "u1"}}, "expectedResponse": {"statusCode": 200, "bodyFile": "../../fixtures/user.json"}}]}`)},
+		"fixtures/user.json":     {Data: []byte(`{"id": "u1"}`)},
+	}
+	passed, err := RunWithOptions("tests/apirunner.conf", "tests", regexp.MustCompile(`\.json$`), RunOptions{FS: fsys, Output: io.Discard})
+	if !passed || err != nil {
+		t.Fatalf("Expected run loaded from the filesystem to pass but got %v, %v", passed, err)
+	}
+	mutex.Lock()
+	if !slices.Equal(requested, []string{"/users", "/orgs", "/orgs/1"}) {
+		t.Errorf("Expected the test files of the filesystem in suiteOrder but got requests %v", requested)
+	}
+	requested = requested[:0]
+	mutex.Unlock()
+
+	t.Run("RunFSWithT", func(t *testing.T) {
+		RunFSWithT(t, fstest.MapFS{
+			"tests/apirunner.conf":   fsys["tests/apirunner.conf"],
+			"tests/order.txt":        fsys["tests/order.txt"],
+			"quarantine.json":        fsys["quarantine.json"],
+			"tests/users/users.json": fsys["tests/users/users.json"],
+			"fixtures/user.json":     fsys["fixtures/user.json"],
+		}, "tests/apirunner.conf", "tests")
+	})
+	mutex.Lock()
+	if !slices.Equal(requested, []string{"/users"}) {
+		t.Errorf("Expected the test files of the filesystem to run under go test but got requests %v", requested)
+	}
+	mutex.Unlock()
+
+	result, err := ExecuteSuite(RunConfig{BaseUrl: server.URL, FS: fsys, Output: io.Discard}, "tests/users/users.json", true)
+	if err != nil || len(result.Passed) != 1 {
+		t.Errorf("Expected suite loaded from the filesystem to pass but got %v, %v", result, err)
+	}
+
+	_, err = RunWithOptions("tests/apirunner.conf", "tests", regexp.MustCompile(`\.json$`), RunOptions{FS: fsys, Approve: true, Output: io.Discard})
+	if err == nil || !strings.Contains(err.Error(), "approval mode requires test files on the OS filesystem") {
+		t.Errorf("Expected approval mode to be rejected for a filesystem but got %v", err)
+	}
+}
+
+func TestTapOutput(t *testing.T) {
+	report := RunReport{
+		Summary: RunSummary{Total: 5, Passed: 1, Failed: 1, Skipped: 1, Quarantined: 1, Warnings: 1},
+		Suites: []SuiteReport{
+			{TestFile: "users/users.json", Tests: []TestReport{
+				{Name: "ok", Status: TestStatusPassed},
+				{Name: "broken", Status: TestStatusFailed, DurationMs: 1.5, Method: "GET", Url: "http://localhost/broken", Category: FailureStatusMismatch, Errors: []string{`Expected http 200 but got http 500: "oops"`}},
+				{Name: "skipped", Status: TestStatusSkipped, SkipReason: "setup failed"},
+			}},
+			{TestFile: "orders#1.json", Tests: []TestReport{
+				{Name: "flaky", Status: TestStatusQuarantined, DurationMs: 2},
+				{Name: "minor", Status: TestStatusWarning, DurationMs: 3},
+			}},
+		},
+	}
+	var out strings.Builder
+	err := writeTapReport(report, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "TAP version 13\n" +
+		"1..5\n" +
+		"ok 1 - users/users.json: ok\n" +
+		"not ok 2 - users/users.json: broken\n" +
+		"  ---\n" +
+		"  category: status-mismatch\n" +
+		"  request: \"GET http://localhost/broken\"\n" +
+		"  durationMs: 1.5\n" +
+		"  errors:\n" +
+		"    - \"Expected http 200 but got http 500: \\\"oops\\\"\"\n" +
+		"  ...\n" +
+		"ok 3 - users/users.json: skipped # SKIP setup failed\n" +
+		"not ok 4 - orders\\#1.json: flaky # TODO quarantined\n" +
+		"  ---\n" +
+		"  durationMs: 2\n" +
+		"  ...\n" +
+		"not ok 5 - orders\\#1.json: minor # TODO below minimum severity\n" +
+		"  ---\n" +
+		"  durationMs: 3\n" +
+		"  ...\n"
+	if out.String() != expected {
+		t.Errorf("Expected TAP output:\n%s\nbut got:\n%s", expected, out.String())
+	}
+}
+
+func TestFailureCategory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s"}`, server.URL),
+		"categories.json": `{"tests": [
+			{"name": "ok", "request": {"method": "GET", "url": "/ok"}, "expectedResponse": {"statusCode": 200, "body": {"id": 1}}},
+			{"name": "status", "request": {"method": "GET", "url": "/broken"}, "expectedResponse": {"statusCode": 200}},
+			{"name": "body", "request": {"method": "GET", "url": "/ok"}, "expectedResponse": {"statusCode": 200, "body": {"id": 2}}},
+			{"name": "header", "request": {"method": "GET", "url": "/ok"}, "expectedResponse": {"statusCode": 200, "headers": {"X-Missing": "value"}}},
+			{"name": "template", "request": {"method": "GET", "url": "/{{ missing.id }}"}, "expectedResponse": {"statusCode": 200}}
+		]}`,
+	})
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	_, err := RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`\.json$`), RunOptions{ReportFile: reportFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := LoadRunReport(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	categories := make(map[string]FailureCategory)
+	for _, test := range report.Suites[0].Tests {
+		categories[test.Name] = test.Category
+	}
+	expected := map[string]FailureCategory{
+		"ok":       "",
+		"status":   FailureStatusMismatch,
+		"body":     FailureBodyDiff,
+		"header":   FailureHeaderDiff,
+		"template": FailureTemplateError,
+	}
+	if !reflect.DeepEqual(categories, expected) {
+		t.Errorf("Expected failure categories %v but got %v", expected, categories)
+	}
+
+	timeoutErr := &url.Error{Op: "Get", URL: "http://localhost", Err: &net.DNSError{IsTimeout: true}}
+	if category := transportFailureCategory(timeoutErr); category != FailureTimeout {
+		t.Errorf("Expected timeout category for %v but got '%s'", timeoutErr, category)
+	}
+	if category := transportFailureCategory(errors.New("connection refused")); category != FailureTransportError {
+		t.Errorf("Expected transport-error category but got '%s'", category)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	var mu sync.Mutex
+	traceparents := make(map[string]string)
+	var exported otlpTraceRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/v1/traces" {
+			err := json.NewDecoder(r.Body).Decode(&exported)
+			if err != nil {
+				t.Errorf("Invalid OTLP request: %v", err)
+			}
+			return
+		}
+		traceparents[r.URL.Path] = r.Header.Get("traceparent")
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	dir := writeTestFiles(t, map[string]string{
+		"apirunner.conf": fmt.Sprintf(`{"baseUrl": "%s", "tracing": {"endpoint": "%s/v1/traces", "serviceName": "users-api-tests"}}`, server.URL, server.URL),
+		"users.json":     `{"tests": [{"name": "ok", "request": {"method": "GET", "url": "/ok"}}, {"name": "broken", "request": {"method": "GET", "url": "/broken"}, "expectedResponse": {"statusCode": 200}}]}`,
+	})
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	_, err := RunWithOptions(filepath.Join(dir, "apirunner.conf"), dir, regexp.MustCompile(`users\.json$`), RunOptions{ReportFile: reportFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(exported.ResourceSpans) != 1 || len(exported.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Expected spans to be exported but got %v", exported)
+	}
+	spans := make(map[string]otlpSpan)
+	for _, span := range exported.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[span.Name] = span
+	}
+	suiteSpan := spans[filepath.Join(dir, "users.json")]
+	if len(spans) != 3 || suiteSpan.ParentSpanId != "" {
+		t.Fatalf("Expected a root span for the suite and a span per test but got %v", spans)
+	}
+	for path, name := range map[string]string{"/ok": "ok", "/broken": "broken"} {
+		span := spans[name]
+		if span.TraceId != suiteSpan.TraceId || span.ParentSpanId != suiteSpan.SpanId {
+			t.Errorf("Expected span of '%s' to be a child of the suite's span but got %v", name, span)
+		}
+		if expected := fmt.Sprintf("00-%s-%s-01", span.TraceId, span.SpanId); traceparents[path] != expected {
+			t.Errorf("Expected traceparent '%s' for '%s' but got '%s'", expected, name, traceparents[path])
+		}
+	}
+	if spans["ok"].Status.Code != spanStatusOk || spans["broken"].Status.Code != spanStatusError || suiteSpan.Status.Code != spanStatusError {
+		t.Errorf("Unexpected span statuses: %v", spans)
+	}
+
+	report, err := LoadRunReport(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range report.Suites[0].Tests {
+		if test.TraceId != suiteSpan.TraceId {
+			t.Errorf("Expected trace id '%s' for '%s' in report but got '%s'", suiteSpan.TraceId, test.Name, test.TraceId)
+		}
+	}
+}
+
+func TestDiffRunReports(t *testing.T) {
+	oldReport := RunReport{Suites: []SuiteReport{{TestFile: "users.json", Tests: []TestReport{
+		{Name: "stable", Status: TestStatusPassed, DurationMs: 100},
+		{Name: "regressed", Status: TestStatusPassed, DurationMs: 10},
+		{Name: "fixed", Status: TestStatusFailed, DurationMs: 10},
+		{Name: "slower", Status: TestStatusPassed, DurationMs: 100},
+		{Name: "removed", Status: TestStatusPassed, DurationMs: 10},
+	}}}}
+	newReport := RunReport{Suites: []SuiteReport{{TestFile: "users.json", Tests: []TestReport{
+		{Name: "stable", Status: TestStatusPassed, DurationMs: 140},
+		{Name: "regressed", Status: TestStatusFailed, DurationMs: 10},
+		{Name: "fixed", Status: TestStatusPassed, DurationMs: 10},
+		{Name: "slower", Status: TestStatusPassed, DurationMs: 250},
+		{Name: "added", Status: TestStatusPassed, DurationMs: 10},
+	}}}}
+
+	diff := DiffRunReports(oldReport, newReport, ReportDiffOptions{})
+	if !reflect.DeepEqual(diff.NewlyFailing, []string{"users.json: regressed"}) || !diff.Regressed() {
+		t.Errorf("Unexpected newly failing tests %v", diff.NewlyFailing)
+	}
+	if !reflect.DeepEqual(diff.Fixed, []string{"users.json: fixed"}) {
+		t.Errorf("Unexpected fixed tests %v", diff.Fixed)
+	}
+	if !reflect.DeepEqual(diff.Added, []string{"users.json: added"}) || !reflect.DeepEqual(diff.Removed, []string{"users.json: removed"}) {
+		t.Errorf("Unexpected added %v or removed %v tests", diff.Added, diff.Removed)
+	}
+	if len(diff.Slower) != 1 || diff.Slower[0].Test != "users.json: slower" || diff.Slower[0].Percent != 150 {
+		t.Errorf("Unexpected slower tests %v", diff.Slower)
+	}
+}
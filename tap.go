@@ -0,0 +1,82 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Writes 'report' to 'out' in Test Anything Protocol (version 13) format, one test point per test. Failures
+// are described in a YAML block. Quarantined failures and warnings (see RunOptions.FailOnSeverity) are
+// TODO test points, which TAP consumers don't count as failures.
+func writeTapReport(report RunReport, out io.Writer) error {
+	var tap strings.Builder
+	fmt.Fprintf(&tap, "TAP version 13\n")
+	fmt.Fprintf(&tap, "1..%d\n", report.Summary.Passed+report.Summary.Failed+report.Summary.Skipped+report.Summary.Quarantined+report.Summary.Warnings)
+	n := 0
+	for _, suite := range report.Suites {
+		for _, test := range suite.Tests {
+			n++
+			description := strings.ReplaceAll(fmt.Sprintf("%s: %s", suite.TestFile, test.Name), "#", "\\#")
+			switch test.Status {
+			case TestStatusPassed:
+				fmt.Fprintf(&tap, "ok %d - %s\n", n, description)
+			case TestStatusSkipped:
+				fmt.Fprintf(&tap, "ok %d - %s # SKIP %s\n", n, description, test.SkipReason)
+			case TestStatusQuarantined:
+				fmt.Fprintf(&tap, "not ok %d - %s # TODO quarantined\n", n, description)
+			case TestStatusWarning:
+				fmt.Fprintf(&tap, "not ok %d - %s # TODO below minimum severity\n", n, description)
+			default:
+				fmt.Fprintf(&tap, "not ok %d - %s\n", n, description)
+			}
+			if test.Status != TestStatusPassed && test.Status != TestStatusSkipped {
+				writeTapDiagnostics(&tap, test)
+			}
+		}
+	}
+	_, err := io.WriteString(out, tap.String())
+	return err
+}
+
+// Writes the YAML diagnostics block of a failed test point
+func writeTapDiagnostics(tap *strings.Builder, test TestReport) {
+	// JSON strings are valid YAML double-quoted scalars
+	quote := func(s string) string {
+		quoted, _ := json.Marshal(s)
+		return string(quoted)
+	}
+	fmt.Fprintf(tap, "  ---\n")
+	if test.Category != "" {
+		fmt.Fprintf(tap, "  category: %s\n", test.Category)
+	}
+	if test.Url != "" {
+		fmt.Fprintf(tap, "  request: %s\n", quote(test.Method+" "+test.Url))
+	}
+	if test.RequestId != "" {
+		fmt.Fprintf(tap, "  requestId: %s\n", quote(test.RequestId))
+	}
+	fmt.Fprintf(tap, "  durationMs: %g\n", test.DurationMs)
+	if len(test.Errors) > 0 {
+		fmt.Fprintf(tap, "  errors:\n")
+		for _, err := range test.Errors {
+			fmt.Fprintf(tap, "    - %s\n", quote(err))
+		}
+	}
+	fmt.Fprintf(tap, "  ...\n")
+}
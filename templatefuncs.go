@@ -0,0 +1,110 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Template functions of the form "{{ name('arg') }}" in requests, evaluated before template vars are
+// replaced (so their values can contain template vars themselves)
+var templateFunctionRegex = regexp.MustCompile(`{{\s*([a-zA-Z]+)\(\s*'([^']*)'\s*\)\s*}}`)
+
+// Template functions keyed by name. Like command substitution, trailing newlines are removed from values.
+var templateFunctions = map[string]func(suite TestSuite, arg string) (string, error){
+	// Contents of a file (relative to the test file)
+	"file": func(suite TestSuite, arg string) (string, error) {
+		contents, err := readFile(suite.config.FS, relativeToTestFile(suite.config.FS, suite.fileName, arg))
+		if err != nil {
+			return "", errors.Wrap(err, fmt.Sprintf("error reading template file %s", arg))
+		}
+		return string(contents), nil
+	},
+	// Stdout of a command (run in the test file's directory), only if allowed by config. Test files read from
+	// a RunConfig.FS have no directory on disk to run commands in, so exec isn't supported for them.
+	"exec": func(suite TestSuite, arg string) (string, error) {
+		if !suite.config.AllowTemplateExec {
+			return "", fmt.Errorf("template function 'exec' is disabled, set allowTemplateExec in config to enable it")
+		}
+		if suite.config.FS != nil {
+			return "", fmt.Errorf("template function 'exec' isn't supported for test files read from a filesystem other than the OS's")
+		}
+		args := strings.Fields(arg)
+		if len(args) == 0 {
+			return "", fmt.Errorf("template function 'exec' requires a command")
+		}
+		// Stop the command if the run is cancelled or the test's deadline passes
+		ctx, cancel := context.WithTimeout(suite.config.runContext(), templateExecTimeout)
+		defer cancel()
+		// Relative command paths are resolved against Dir
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Dir = filepath.Dir(suite.fileName)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", errors.Wrap(err, fmt.Sprintf("error running '%s': %s", arg, strings.TrimSpace(stderr.String())))
+		}
+		return string(out), nil
+	},
+}
+
+// How long commands run by the exec template function may take
+const templateExecTimeout = 30 * time.Second
+
+// Replaces all template functions in 's' with their values
+func (suite TestSuite) replaceTemplateFunctions(s string) (string, error) {
+	var err error
+	replaced := templateFunctionRegex.ReplaceAllStringFunc(s, func(match string) string {
+		if err != nil {
+			return match
+		}
+		groups := templateFunctionRegex.FindStringSubmatch(match)
+		function, ok := templateFunctions[groups[1]]
+		if !ok {
+			err = fmt.Errorf("unknown template function '%s'", groups[1])
+			return match
+		}
+		var value string
+		value, err = function(suite, groups[2])
+		return strings.TrimRight(value, "\r\n")
+	})
+	if err != nil {
+		return s, err
+	}
+	return replaced, nil
+}
+
+// Replaces all template functions, then all factory instantiations and then all template vars in the request value 's'
+func (suite TestSuite) templateRequestValue(s string, extractedFields map[string]interface{}) (string, error) {
+	s, err := suite.replaceTemplateFunctions(s)
+	if err != nil {
+		return s, err
+	}
+	s, err = suite.replaceFactories(s, extractedFields)
+	if err != nil {
+		return s, err
+	}
+	return templateReplace(s, extractedFields)
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A single execution of a test. Tests with a "matrix" are executed once per combination of its
+// parameters' values, and tests with "repeat" once per iteration (of each combination).
+type testCase struct {
+	// Name the case's result is reported under
+	name string
+	// Template vars only defined while the case executes
+	vars map[string]interface{}
+}
+
+// Returns the cases 'test' is executed as, in order
+func testCases(test TestSpec) []testCase {
+	cases := []testCase{{name: test.Name, vars: map[string]interface{}{}}}
+	// Expand the cartesian product of matrix parameters (in alphabetical order), e.g. "test[format=csv,role=admin]"
+	params := make([]string, 0, len(test.Matrix))
+	for param := range test.Matrix {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	for _, param := range params {
+		expanded := make([]testCase, 0, len(cases)*len(test.Matrix[param]))
+		for _, c := range cases {
+			for _, value := range test.Matrix[param] {
+				vars := make(map[string]interface{}, len(c.vars)+1)
+				for k, v := range c.vars {
+					vars[k] = v
+				}
+				vars[param] = value
+				expanded = append(expanded, testCase{vars: vars})
+			}
+		}
+		cases = expanded
+	}
+	if len(params) > 0 {
+		for i := range cases {
+			pairs := make([]string, 0, len(params))
+			for _, param := range params {
+				pairs = append(pairs, fmt.Sprintf("%s=%v", param, cases[i].vars[param]))
+			}
+			cases[i].name = fmt.Sprintf("%s[%s]", test.Name, strings.Join(pairs, ","))
+		}
+	}
+	if test.Repeat == 0 {
+		return cases
+	}
+	repeated := make([]testCase, 0, len(cases)*test.Repeat)
+	for _, c := range cases {
+		for iteration := 1; iteration <= test.Repeat; iteration++ {
+			vars := make(map[string]interface{}, len(c.vars)+1)
+			for k, v := range c.vars {
+				vars[k] = v
+			}
+			vars["iteration"] = iteration
+			repeated = append(repeated, testCase{name: fmt.Sprintf("%s#%d", c.name, iteration), vars: vars})
+		}
+	}
+	return repeated
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config for injecting the time the API under test should treat as "now" into a request header.
+// Value is the default time for all tests (a test's testTime overrides it) and Format is how the
+// time is written to the header: RFC3339 (default), unix, unixMillis, one of the date matcher's
+// named layouts or any Go time layout.
+type TestTimeConfig struct {
+	Header string `json:"header"`
+	Value  string `json:"value"`
+	Format string `json:"format"`
+}
+
+func (config TestTimeConfig) headerName() string {
+	if config.Header == "" {
+		return "X-Test-Time"
+	}
+	return config.Header
+}
+
+func (config TestTimeConfig) format(t time.Time) string {
+	switch config.Format {
+	case "", "RFC3339":
+		return t.UTC().Format(time.RFC3339)
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "unixMillis":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	default:
+		if namedLayout, ok := dateLayouts[config.Format]; ok {
+			return t.UTC().Format(namedLayout)
+		}
+		return t.UTC().Format(config.Format)
+	}
+}
+
+// Returns the header value for a test time 'value' (templated with 'extractedFields'), or "" if no time is set
+func (config TestTimeConfig) headerValue(value string, extractedFields map[string]interface{}) (string, error) {
+	if value == "" {
+		value = config.Value
+	}
+	if value == "" {
+		return "", nil
+	}
+	processedValue, err := templateReplace(value, extractedFields)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid testTime template")
+	}
+	t, err := parseTestTime(processedValue)
+	if err != nil {
+		return "", err
+	}
+	return config.format(t), nil
+}
+
+// Parses a test time of the form "<base>", "<base> + <duration>" or "<base> - <duration>",
+// where base is "now", an RFC3339 timestamp or a unix timestamp in seconds
+func parseTestTime(value string) (time.Time, error) {
+	base, offset := strings.TrimSpace(value), time.Duration(0)
+	for _, sep := range []string{" + ", " - "} {
+		if before, after, found := strings.Cut(base, sep); found {
+			d, err := time.ParseDuration(strings.TrimSpace(after))
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid testTime offset in '%s': %v", value, err)
+			}
+			if sep == " - " {
+				d = -d
+			}
+			base, offset = strings.TrimSpace(before), d
+			break
+		}
+	}
+
+	if base == "now" {
+		return time.Now().Add(offset), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, base); err == nil {
+		return t.Add(offset), nil
+	}
+	if unix, err := strconv.ParseInt(base, 10, 64); err == nil {
+		return time.Unix(unix, 0).Add(offset), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid testTime '%s', expected now, an RFC3339 timestamp or a unix timestamp", value)
+}
@@ -0,0 +1,208 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Wall-clock limits for suites and the whole run
+type TimeoutConfig struct {
+	// Maximum duration of each suite's tests in milliseconds (0 for no limit)
+	SuiteMs int `json:"suiteMs"`
+	// Maximum duration of all suites in milliseconds (0 for no limit)
+	RunMs int `json:"runMs"`
+	// What to do once a suite times out: "continue" with the next suite (default) or "exit" the run
+	OnTimeout string `json:"onTimeout"`
+	// Duration of a request in milliseconds above which it's reported as slow, without failing its test
+	// (0 for no limit, can be overridden per test)
+	SlowMs int `json:"slowMs"`
+}
+
+func (config TimeoutConfig) validate() error {
+	if config.OnTimeout != "" && config.OnTimeout != "continue" && config.OnTimeout != "exit" {
+		return fmt.Errorf("invalid timeouts.onTimeout '%s', must be 'continue' or 'exit'", config.OnTimeout)
+	}
+	if config.SlowMs < 0 {
+		return fmt.Errorf("invalid timeouts.slowMs %d, must be positive (or 0 for no limit)", config.SlowMs)
+	}
+	return nil
+}
+
+// Returns the duration above which the request of 'test' is slow (0 for no limit)
+func (suite TestSuite) slowThreshold(test TestSpec) time.Duration {
+	if test.SlowMs > 0 {
+		return time.Duration(test.SlowMs) * time.Millisecond
+	}
+	if suite.config.Timeouts != nil {
+		return time.Duration(suite.config.Timeouts.SlowMs) * time.Millisecond
+	}
+	return 0
+}
+
+// Returns the failure of a suite that took 'duration', longer than its maxTotalDurationMs, naming the
+// (up to 5) setup steps, tests and teardown steps in 'results' that took the longest
+func suiteDurationFailure(maxTotalDurationMs int, duration time.Duration, results []TestResult) TestResult {
+	sorted := append([]TestResult{}, results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Duration > sorted[j].Duration
+	})
+	contributors := make([]string, 0, 5)
+	for _, result := range sorted {
+		if len(contributors) == 5 || result.Duration == 0 {
+			break
+		}
+		contributors = append(contributors, fmt.Sprintf("%s (%s)", result.Name, result.Duration.Round(time.Millisecond)))
+	}
+	errs := []string{fmt.Sprintf("Suite took %s, exceeding its maxTotalDurationMs of %d", duration.Round(time.Millisecond), maxTotalDurationMs)}
+	if len(contributors) > 0 {
+		errs = append(errs, "Biggest contributors: "+strings.Join(contributors, ", "))
+	}
+	result := Failed("maxTotalDuration", errs, duration)
+	result.Category = FailureTimeout
+	return result
+}
+
+// Prints the slow requests (see TimeoutConfig.SlowMs) in 'results' to 'out', slowest first
+func printSlowRequests(out io.Writer, results []TestSuiteResult) {
+	type slowRequest struct {
+		test     string
+		duration time.Duration
+	}
+	slow := make([]slowRequest, 0)
+	for _, result := range results {
+		for _, tests := range [][]TestResult{result.Passed, result.Failed, result.Quarantined, result.Warnings} {
+			for _, test := range tests {
+				if test.Slow {
+					slow = append(slow, slowRequest{fmt.Sprintf("'%s' %s (%s %s)", result.TestFilename, test.Name, test.RequestMethod, test.RequestUrl), test.RequestDuration})
+				}
+			}
+		}
+	}
+	if len(slow) == 0 {
+		return
+	}
+	sort.SliceStable(slow, func(i, j int) bool {
+		return slow[i].duration > slow[j].duration
+	})
+	fmt.Fprintf(out, "\n* Slow requests:\n")
+	for _, request := range slow {
+		fmt.Fprintf(out, "\t%s %s\n", fmt.Sprintf(WarningString, request.duration.Round(time.Millisecond)), request.test)
+	}
+}
+
+// A point in time by which tests must complete
+type deadline struct {
+	at time.Time
+	// Description of the limit, e.g. "suite timeout of 30s"
+	limit string
+}
+
+// Returns the deadline of a run starting at 'start' (zero if its duration isn't limited)
+func runDeadline(config RunConfig, start time.Time) deadline {
+	if config.Timeouts == nil || config.Timeouts.RunMs <= 0 {
+		return deadline{}
+	}
+	runTimeout := time.Duration(config.Timeouts.RunMs) * time.Millisecond
+	return deadline{at: start.Add(runTimeout), limit: fmt.Sprintf("run timeout of %s", runTimeout)}
+}
+
+// Returns true if the remaining suites of a run aren't executed after a suite timed out, either because the
+// run's deadline passed too or because OnTimeout is "exit"
+func stopsAfterTimeout(config RunConfig) bool {
+	runExpired := !config.runDeadline.at.IsZero() && time.Now().After(config.runDeadline.at)
+	return runExpired || config.Timeouts.OnTimeout == "exit"
+}
+
+// Returns the deadline for a suite starting now, the earlier of the suite and run limits (if any)
+func suiteDeadline(config RunConfig) deadline {
+	res := config.runDeadline
+	if config.Timeouts != nil && config.Timeouts.SuiteMs > 0 {
+		suiteTimeout := time.Duration(config.Timeouts.SuiteMs) * time.Millisecond
+		at := time.Now().Add(suiteTimeout)
+		if res.at.IsZero() || at.Before(res.at) {
+			res = deadline{at: at, limit: fmt.Sprintf("suite timeout of %s", suiteTimeout)}
+		}
+	}
+	return res
+}
+
+// Executes 'test' like executeSpec, failing it if it doesn't complete by the suite's deadline. Returns true
+// if it timed out, in which case its request (or exec step) is aborted, it's abandoned and any template vars
+// it would have extracted are discarded.
+func (suite TestSuite) executeSpecWithDeadline(test TestSpec, extractedFields map[string]interface{}) (TestResult, bool) {
+	if suite.deadline.at.IsZero() {
+		return suite.executeSpec(test, extractedFields), false
+	}
+	start := time.Now()
+	remaining := time.Until(suite.deadline.at)
+	if remaining <= 0 {
+		return suite.hung(test, start), true
+	}
+	// Abort the test's request at the deadline ('suite' is a copy)
+	ctx, cancel := context.WithDeadline(suite.config.runContext(), suite.deadline.at)
+	defer cancel()
+	suite.config.ctx = ctx
+
+	// Execute against a copy of the template vars so an abandoned test can't write to them
+	fields := make(map[string]interface{}, len(extractedFields))
+	for k, v := range extractedFields {
+		fields[k] = v
+	}
+	done := make(chan TestResult, 1)
+	go func() {
+		done <- suite.executeSpec(test, fields)
+	}()
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		// A request aborted at the deadline may fail before the timer fires
+		if result.aborted && ctx.Err() == context.DeadlineExceeded {
+			return suite.hung(test, start), true
+		}
+		for k, v := range fields {
+			extractedFields[k] = v
+		}
+		for k := range extractedFields {
+			if _, ok := fields[k]; !ok {
+				delete(extractedFields, k)
+			}
+		}
+		return result, false
+	case <-timer.C:
+		return suite.hung(test, start), true
+	}
+}
+
+// Returns whether 'err' is the result of a request's context ending, e.g. at the suite's deadline
+func isContextError(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// Returns the failed result of a test that didn't complete by the suite's deadline
+func (suite TestSuite) hung(test TestSpec, start time.Time) TestResult {
+	elapsed := time.Since(start)
+	result := Failed(test.Name, []string{fmt.Sprintf("hung after %.1fs on %s (%s exceeded)", elapsed.Seconds(), test.Name, suite.deadline.limit)}, elapsed)
+	result.Category = FailureTimeout
+	return result
+}
@@ -0,0 +1,277 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Tracing of suites and tests with OpenTelemetry. Each suite is exported as a trace whose root span has
+// a child span per test, and each test's request carries a W3C traceparent header referencing its span
+// so that backend traces are connected to it. Spans are exported to Endpoint (an OTLP/HTTP traces
+// endpoint, e.g. http://localhost:4318/v1/traces) as JSON at the end of the run.
+type TracingConfig struct {
+	Endpoint    string            `json:"endpoint"`
+	ServiceName string            `json:"serviceName"`
+	Headers     map[string]string `json:"headers"`
+}
+
+func (config TracingConfig) validate() error {
+	if config.Endpoint == "" {
+		return errors.New("tracing.endpoint is required")
+	}
+	return nil
+}
+
+func (config TracingConfig) serviceName() string {
+	if config.ServiceName == "" {
+		return "apirunner"
+	}
+	return config.ServiceName
+}
+
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+	spanStatusOk     = 1
+	spanStatusError  = 2
+)
+
+// A span of a trace, exported once ended
+type span struct {
+	tracer       *tracer
+	traceId      [16]byte
+	spanId       [8]byte
+	parentSpanId [8]byte
+	name         string
+	kind         int
+	start        time.Time
+}
+
+// Collects ended spans for export
+type tracer struct {
+	config TracingConfig
+	mu     sync.Mutex
+	spans  []otlpSpan
+}
+
+func newTracer(config TracingConfig) *tracer {
+	return &tracer{config: config}
+}
+
+// Starts a span named 'name', as a child of 'parent' or as the root of a new trace if 'parent' is nil
+func (t *tracer) start(name string, kind int, parent *span) *span {
+	s := &span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent != nil {
+		s.traceId = parent.traceId
+		s.parentSpanId = parent.spanId
+	} else {
+		randomId(s.traceId[:])
+	}
+	randomId(s.spanId[:])
+	return s
+}
+
+// Returns the W3C traceparent header value referencing the span (always sampled)
+func (s *span) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", s.traceIdString(), hex.EncodeToString(s.spanId[:]))
+}
+
+func (s *span) traceIdString() string {
+	return hex.EncodeToString(s.traceId[:])
+}
+
+// Ends the span with 'attributes', as failed with 'errorMessage' if 'failed' is set
+func (s *span) end(attributes map[string]interface{}, failed bool, errorMessage string) {
+	exported := otlpSpan{
+		TraceId:           s.traceIdString(),
+		SpanId:            hex.EncodeToString(s.spanId[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:        otlpAttributes(attributes),
+		Status:            otlpStatus{Code: spanStatusOk},
+	}
+	if s.parentSpanId != [8]byte{} {
+		exported.ParentSpanId = hex.EncodeToString(s.parentSpanId[:])
+	}
+	if failed {
+		exported.Status = otlpStatus{Code: spanStatusError, Message: errorMessage}
+	}
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, exported)
+}
+
+// Ends a test's span with the attributes of its 'result'
+func (s *span) endTest(suiteFileName string, result TestResult) {
+	attributes := map[string]interface{}{
+		"apirunner.test.name": result.Name,
+		"apirunner.test.file": suiteFileName,
+	}
+	if result.Category != "" {
+		attributes["apirunner.failure.category"] = string(result.Category)
+	}
+	if result.RequestId != "" {
+		attributes["apirunner.request.id"] = result.RequestId
+	}
+	if status, ok := result.Response["status"].(float64); ok {
+		attributes["http.response.status_code"] = int64(status)
+	}
+	errorMessage := ""
+	if len(result.Errors) > 0 {
+		errorMessage = result.Errors[0]
+	}
+	s.end(attributes, !result.Passed, errorMessage)
+}
+
+// Exports all ended spans to the configured endpoint. Returns the number of spans exported.
+func (t *tracer) export(client *http.Client) (int, error) {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return 0, nil
+	}
+
+	request := otlpTraceRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttributes(map[string]interface{}{"service.name": t.config.serviceName()})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "apirunner"},
+			Spans: spans,
+		}},
+	}}}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return 0, errors.Wrap(err, "error encoding spans")
+	}
+	req, err := http.NewRequest(http.MethodPost, t.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("invalid tracing endpoint %s", t.config.Endpoint))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("error exporting spans to %s", t.config.Endpoint))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("error exporting spans to %s: http %d: %s", t.config.Endpoint, resp.StatusCode, string(respBody))
+	}
+	return len(spans), nil
+}
+
+// Exports the spans ended so far, printing the outcome to 'out' (or 'errOut' on error)
+func (t *tracer) flush(out io.Writer, errOut io.Writer) {
+	exported, err := t.export(&http.Client{Timeout: 10 * time.Second})
+	if err != nil {
+		fmt.Fprintf(errOut, "Error exporting traces: %v\n", err)
+	} else if exported > 0 {
+		fmt.Fprintf(out, "Exported %d span(s) to '%s'\n", exported, t.config.Endpoint)
+	}
+}
+
+// Fills 'id' with random bytes
+func randomId(id []byte) {
+	_, err := rand.Read(id)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// OTLP/HTTP JSON encoding of spans (see opentelemetry-proto's trace service)
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// Returns 'attributes' as OTLP key/values, sorted by key
+func otlpAttributes(attributes map[string]interface{}) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	encoded := make([]otlpAttribute, 0, len(keys))
+	for _, k := range keys {
+		switch v := attributes[k].(type) {
+		case int64:
+			// 64 bit integers are encoded as strings in OTLP JSON
+			encoded = append(encoded, otlpAttribute{Key: k, Value: map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}})
+		case int:
+			encoded = append(encoded, otlpAttribute{Key: k, Value: map[string]interface{}{"intValue": strconv.Itoa(v)}})
+		default:
+			encoded = append(encoded, otlpAttribute{Key: k, Value: map[string]interface{}{"stringValue": fmt.Sprint(v)}})
+		}
+	}
+	return encoded
+}
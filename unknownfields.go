@@ -0,0 +1,74 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Returns an error describing the first field of the test file 'contents' that isn't part of the
+// test file format (e.g. a typo like "expectedReponse") and where it is, or nil if there's none
+func findUnknownField(contents []byte) error {
+	err := decodeStrict(contents, &TestSuiteSpec{})
+	if err == nil || !isUnknownFieldError(err) {
+		return nil
+	}
+	// Narrow the unknown field down to the step or test it's in
+	var raw struct {
+		Setup    []json.RawMessage `json:"setup"`
+		Teardown []json.RawMessage `json:"teardown"`
+		Tests    []json.RawMessage `json:"tests"`
+	}
+	if json.Unmarshal(contents, &raw) == nil {
+		for _, steps := range []struct {
+			name  string
+			steps []json.RawMessage
+		}{{"setup", raw.Setup}, {"teardown", raw.Teardown}} {
+			for i, step := range steps.steps {
+				if stepErr := decodeStrict(step, &ExecStep{}); stepErr != nil {
+					return fmt.Errorf("%s in %s step %d", unknownFieldMessage(stepErr), steps.name, i+1)
+				}
+			}
+		}
+		for i, test := range raw.Tests {
+			if testErr := decodeStrict(test, &TestSpec{}); testErr != nil {
+				var named struct {
+					Name string `json:"name"`
+				}
+				json.Unmarshal(test, &named)
+				return fmt.Errorf("%s in test '%s' (tests[%d])", unknownFieldMessage(testErr), named.Name, i)
+			}
+		}
+	}
+	return fmt.Errorf("%s at the top level", unknownFieldMessage(err))
+}
+
+func decodeStrict(contents []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+func isUnknownFieldError(err error) bool {
+	return strings.HasPrefix(err.Error(), "json: unknown field ")
+}
+
+// Returns e.g. 'unknown field "expectedReponse"' for an unknown field error of the json package
+func unknownFieldMessage(err error) string {
+	return strings.TrimPrefix(err.Error(), "json: ")
+}
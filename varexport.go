@@ -0,0 +1,146 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Formats of variable export files
+const (
+	VariableExportFormatJson = "json"
+	VariableExportFormatEnv  = "env"
+)
+
+// Writes the template vars memoized during a run to a file at its end, for later steps of a pipeline to
+// consume, e.g. the ids of resources created by smoke tests
+type VariableExportConfig struct {
+	// File the vars are written to
+	File string `json:"file"`
+	// "json" (an object of var names to values) or "env" (KEY=value lines with names like
+	// CREATE_USER_ID, e.g. for $GITHUB_ENV). Defaults to "env" for files ending in .env, "json" otherwise.
+	Format string `json:"format"`
+	// Regex the names of exported vars must match, e.g. "^createUser\\." (all vars if empty)
+	Pattern string `json:"pattern"`
+}
+
+func (config VariableExportConfig) validate() error {
+	if config.File == "" {
+		return fmt.Errorf("variableExport requires a file")
+	}
+	if config.Format != "" && config.Format != VariableExportFormatJson && config.Format != VariableExportFormatEnv {
+		return fmt.Errorf("invalid variableExport format '%s', must be %s or %s", config.Format, VariableExportFormatJson, VariableExportFormatEnv)
+	}
+	_, err := regexp.Compile(config.Pattern)
+	if err != nil {
+		return errors.Wrap(err, "invalid variableExport pattern")
+	}
+	return nil
+}
+
+func (config VariableExportConfig) format() string {
+	if config.Format != "" {
+		return config.Format
+	}
+	if filepath.Ext(config.File) == ".env" {
+		return VariableExportFormatEnv
+	}
+	return VariableExportFormatJson
+}
+
+// Writes the vars of all suites in 'results' whose names match the config's pattern to its file (vars of
+// later suites replacing those of the same name), returning the number of vars written
+func (config VariableExportConfig) write(results []TestSuiteResult) (int, error) {
+	pattern := regexp.MustCompile(config.Pattern)
+	vars := make(map[string]interface{})
+	for _, result := range results {
+		for name, value := range result.Variables {
+			if pattern.MatchString(name) {
+				vars[name] = value
+			}
+		}
+	}
+
+	var contents []byte
+	if config.format() == VariableExportFormatEnv {
+		var sb strings.Builder
+		for _, name := range slices.Sorted(maps.Keys(vars)) {
+			value, err := envValue(vars[name])
+			if err != nil {
+				return 0, errors.Wrap(err, fmt.Sprintf("error exporting variable '%s'", name))
+			}
+			fmt.Fprintf(&sb, "%s=%s\n", envName(name), value)
+		}
+		contents = []byte(sb.String())
+	} else {
+		var err error
+		contents, err = json.MarshalIndent(vars, "", "  ")
+		if err != nil {
+			return 0, errors.Wrap(err, "error exporting variables")
+		}
+	}
+	err := os.WriteFile(config.File, contents, 0644)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("error writing variables to %s", config.File))
+	}
+	return len(vars), nil
+}
+
+// Converts the var name 'name' to an environment variable name, e.g. "createUser.id" to "CREATE_USER_ID"
+func envName(name string) string {
+	var sb strings.Builder
+	prev := rune(0)
+	for _, r := range name {
+		switch {
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+			sb.WriteRune('_')
+			sb.WriteRune(r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			sb.WriteRune(unicode.ToUpper(r))
+		default:
+			sb.WriteRune('_')
+		}
+		prev = r
+	}
+	return sb.String()
+}
+
+// Returns 'value' as written to env files: strings as-is (quoted if they'd span lines or contain quotes)
+// and anything else as JSON
+func envValue(value interface{}) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		s = string(encoded)
+	}
+	if strings.ContainsAny(s, "\n\r\"'") {
+		return strconv.Quote(s), nil
+	}
+	return s, nil
+}
@@ -0,0 +1,274 @@
+// Copyright 2024 WorkOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apirunner
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Element of a parsed XML (or HTML) document
+type xmlNode struct {
+	Name     string
+	Attrs    map[string]string
+	Children []*xmlNode
+	Text     string
+}
+
+// Parses an XML document into a tree of xmlNodes. If 'lenient' is set, HTML-style
+// documents (unclosed tags, HTML entities) are accepted.
+func parseXml(data []byte, lenient bool) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	if lenient {
+		decoder.Strict = false
+		decoder.AutoClose = xml.HTMLAutoClose
+		decoder.Entity = xml.HTMLEntity
+	}
+	root := &xmlNode{Name: "", Attrs: map[string]string{}}
+	stack := []*xmlNode{root}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{Name: t.Name.Local, Attrs: make(map[string]string)}
+			for _, attr := range t.Attr {
+				node.Attrs[attr.Name.Local] = attr.Value
+			}
+			parent.Children = append(parent.Children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.Text += string(t)
+		}
+	}
+	if len(root.Children) == 0 {
+		return nil, fmt.Errorf("no elements found")
+	}
+	return root, nil
+}
+
+// Returns true if 'contentType' is an XML media type (application/xml, text/xml or */*+xml)
+func isXmlContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// Parses an XML document into its JSON-ified form: {"root": {...}}. Attributes become "@name" fields,
+// child elements become fields named after the element (arrays if repeated) and text content of elements
+// with attributes or children becomes a "#text" field. Leaf elements become string values.
+func xmlToJson(data []byte) (interface{}, error) {
+	root, err := parseXml(data, false)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]interface{})
+	for _, child := range root.Children {
+		res[child.Name] = child.toJson()
+	}
+	return res, nil
+}
+
+func (node *xmlNode) toJson() interface{} {
+	text := strings.TrimSpace(node.Text)
+	if len(node.Children) == 0 && len(node.Attrs) == 0 {
+		return text
+	}
+	obj := make(map[string]interface{})
+	for name, val := range node.Attrs {
+		obj["@"+name] = val
+	}
+	for _, child := range node.Children {
+		childVal := child.toJson()
+		existing, ok := obj[child.Name]
+		if !ok {
+			obj[child.Name] = childVal
+			continue
+		}
+		// Child values are never arrays themselves, so an existing array holds repeated elements
+		if arr, isArr := existing.([]interface{}); isArr {
+			obj[child.Name] = append(arr, childVal)
+		} else {
+			obj[child.Name] = []interface{}{existing, childVal}
+		}
+	}
+	if text != "" {
+		obj["#text"] = text
+	}
+	return obj
+}
+
+// Returns the text content of 'node' and all its descendants
+func (node *xmlNode) textContent() string {
+	var sb strings.Builder
+	sb.WriteString(node.Text)
+	for _, child := range node.Children {
+		sb.WriteString(child.textContent())
+	}
+	return sb.String()
+}
+
+// Evaluates a subset of XPath against the document 'root' and returns the string value of the first match.
+// Supported: absolute (/a/b) and descendant (//b) steps, '*' wildcards, positional predicates ([1]),
+// attribute predicates ([@id='x']), and trailing @attr or text() steps.
+func xpathString(root *xmlNode, path string) (string, bool, error) {
+	if !strings.HasPrefix(path, "/") {
+		return "", false, fmt.Errorf("xpath must be absolute: '%s'", path)
+	}
+	nodes := []*xmlNode{root}
+	rest := path
+	for rest != "" {
+		descendant := false
+		if strings.HasPrefix(rest, "//") {
+			descendant = true
+			rest = rest[2:]
+		} else {
+			rest = rest[1:]
+		}
+		step := rest
+		if i := nextStepIndex(rest); i >= 0 {
+			step, rest = rest[:i], rest[i:]
+		} else {
+			rest = ""
+		}
+
+		// Terminal steps
+		if step == "text()" {
+			if len(nodes) == 0 {
+				return "", false, nil
+			}
+			return nodes[0].textContent(), true, nil
+		}
+		if strings.HasPrefix(step, "@") {
+			for _, node := range nodes {
+				if val, ok := node.Attrs[step[1:]]; ok {
+					return val, true, nil
+				}
+			}
+			return "", false, nil
+		}
+
+		name, predicate, err := splitPredicate(step)
+		if err != nil {
+			return "", false, err
+		}
+		matches := make([]*xmlNode, 0)
+		for _, node := range nodes {
+			var candidates []*xmlNode
+			if descendant {
+				candidates = node.descendants()
+			} else {
+				candidates = node.Children
+			}
+			stepMatches := make([]*xmlNode, 0)
+			for _, candidate := range candidates {
+				if (name == "*" || strings.EqualFold(candidate.Name, name)) && predicate.matchesAttr(candidate) {
+					stepMatches = append(stepMatches, candidate)
+				}
+			}
+			if predicate.position > 0 {
+				if predicate.position <= len(stepMatches) {
+					matches = append(matches, stepMatches[predicate.position-1])
+				}
+			} else {
+				matches = append(matches, stepMatches...)
+			}
+		}
+		nodes = matches
+	}
+	if len(nodes) == 0 {
+		return "", false, nil
+	}
+	return nodes[0].textContent(), true, nil
+}
+
+// Returns the index of the next '/' in 'path' that isn't within a predicate, or -1
+func nextStepIndex(path string) int {
+	depth := 0
+	for i, c := range path {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '/':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+type xpathPredicate struct {
+	position  int
+	attrName  string
+	attrValue string
+}
+
+func (predicate xpathPredicate) matchesAttr(node *xmlNode) bool {
+	if predicate.attrName == "" {
+		return true
+	}
+	return node.Attrs[predicate.attrName] == predicate.attrValue
+}
+
+func splitPredicate(step string) (string, xpathPredicate, error) {
+	open := strings.Index(step, "[")
+	if open < 0 {
+		return step, xpathPredicate{}, nil
+	}
+	if !strings.HasSuffix(step, "]") {
+		return "", xpathPredicate{}, fmt.Errorf("invalid xpath step '%s'", step)
+	}
+	name := step[:open]
+	expr := step[open+1 : len(step)-1]
+	if position, err := strconv.Atoi(expr); err == nil {
+		return name, xpathPredicate{position: position}, nil
+	}
+	if strings.HasPrefix(expr, "@") {
+		attrName, attrValue, found := strings.Cut(expr[1:], "=")
+		if found {
+			return name, xpathPredicate{attrName: strings.TrimSpace(attrName), attrValue: strings.Trim(strings.TrimSpace(attrValue), `'"`)}, nil
+		}
+	}
+	return "", xpathPredicate{}, fmt.Errorf("unsupported xpath predicate '[%s]'", expr)
+}
+
+func (node *xmlNode) descendants() []*xmlNode {
+	res := make([]*xmlNode, 0)
+	for _, child := range node.Children {
+		res = append(res, child)
+		res = append(res, child.descendants()...)
+	}
+	return res
+}